@@ -5,6 +5,7 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
 	"github.com/ProjectsTask/EasySwapBase/errcode"
@@ -251,6 +252,48 @@ func HistorySalesHandler(svcCtx *svc.ServerCtx) gin.HandlerFunc {
 	}
 }
 
+// FloorHistoryHandler 地板价历史曲线，duration为时间范围(24h/7d/30d)，resolution为时间粒度(5m/1h/4h/1d)
+func FloorHistoryHandler(svcCtx *svc.ServerCtx) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collectionAddr := c.Params.ByName("address")
+		if collectionAddr == "" {
+			xhttp.Error(c, errcode.ErrInvalidParams)
+			return
+		}
+
+		chainID, err := strconv.ParseInt(c.Query("chain_id"), 10, 64)
+		if err != nil {
+			xhttp.Error(c, errcode.ErrInvalidParams)
+			return
+		}
+
+		chain, ok := chainIDToChain[int(chainID)]
+		if !ok {
+			xhttp.Error(c, errcode.ErrInvalidParams)
+			return
+		}
+
+		duration := c.DefaultQuery("duration", "7d")
+		resolution := c.DefaultQuery("resolution", "1h")
+		res, err := service.GetCollectionFloorHistory(c.Request.Context(), svcCtx, chain, collectionAddr, duration, resolution)
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidFloorHistoryParams) {
+				xzap.WithContext(c).Error("floor history params error: ", zap.String("duration", duration), zap.String("resolution", resolution))
+				xhttp.Error(c, errcode.ErrInvalidParams)
+				return
+			}
+			xhttp.Error(c, errcode.NewCustomErr("get floor history error"))
+			return
+		}
+
+		xhttp.OkJson(c, struct {
+			Result interface{} `json:"result"`
+		}{
+			Result: res,
+		})
+	}
+}
+
 func ItemTraitsHandler(svcCtx *svc.ServerCtx) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		collectionAddr := c.Params.ByName("address")
@@ -10,4 +10,7 @@ var chainIDToChain = chainIDMap{
 	1:        "eth",
 	10:       "optimism",
 	11155111: "sepolia",
+	42161:    "arbitrum",
+	8453:     "base",
+	324:      "zksync-era",
 }
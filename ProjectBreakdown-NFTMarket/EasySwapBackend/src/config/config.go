@@ -3,6 +3,8 @@ package config
 import (
 	"strings"
 
+	"github.com/ProjectsTask/EasySwapBase/chain/chainclient"
+	"github.com/ProjectsTask/EasySwapBase/currency"
 	"github.com/ProjectsTask/EasySwapBase/evm/erc"
 	//"github.com/ProjectsTask/EasySwapBase/image"
 	logging "github.com/ProjectsTask/EasySwapBase/logger"
@@ -15,11 +17,13 @@ type Config struct {
 	ProjectCfg *ProjectCfg     `toml:"project_cfg" mapstructure:"project_cfg" json:"project_cfg"`
 	Log        logging.LogConf `toml:"log" json:"log"`
 	//ImageCfg       *image.Config     `toml:"image_cfg" mapstructure:"image_cfg" json:"image_cfg"`
-	DB             gdb.Config        `toml:"db" json:"db"`
-	Kv             *KvConf           `toml:"kv" json:"kv"`
-	Evm            *erc.NftErc       `toml:"evm" json:"evm"`
-	MetadataParse  *MetadataParse    `toml:"metadata_parse" mapstructure:"metadata_parse" json:"metadata_parse"`
-	ChainSupported []*ChainSupported `toml:"chain_supported" mapstructure:"chain_supported" json:"chain_supported"`
+	DB             gdb.Config                   `toml:"db" json:"db"`
+	Kv             *KvConf                      `toml:"kv" json:"kv"`
+	Evm            *erc.NftErc                  `toml:"evm" json:"evm"`
+	MetadataParse  *MetadataParse               `toml:"metadata_parse" mapstructure:"metadata_parse" json:"metadata_parse"`
+	ChainSupported []*ChainSupported            `toml:"chain_supported" mapstructure:"chain_supported" json:"chain_supported"`
+	Currencies     []*currency.Config           `toml:"currencies" mapstructure:"currencies" json:"currencies"` // 支持的ERC20支付币种，交易额及排行按汇率换算为ETH
+	RpcLimit       chainclient.MiddlewareConfig `toml:"rpc_limit" mapstructure:"rpc_limit" json:"rpc_limit"`    // 节点请求限流及重试
 }
 
 type ProjectCfg struct {
@@ -111,7 +111,7 @@ func (d *Dao) QueryMultiChainActivities(ctx context.Context, chainName []string,
 			sqlMid += "UNION ALL "
 		}
 		//为每个链构建子查询
-		sqlMid += fmt.Sprintf("(select '%s' as chain_name,id,collection_address,token_id,currency_address,activity_type,maker,taker,price,tx_hash,event_time,marketplace_id ", chain)
+		sqlMid += fmt.Sprintf("(select '%s' as chain_name,id,collection_address,token_id,currency_address,activity_type,maker,taker,price,quantity,tx_hash,event_time,marketplace_id ", chain)
 		sqlMid += fmt.Sprintf("from %s ", multi.ActivityTableName(chain))
 
 		//添加用户地址过滤条件
@@ -368,6 +368,7 @@ func (d *Dao) QueryMultiChainActivityExternalInfo(ctx context.Context, chainID [
 			TokenID:           act.TokenId,
 			Currency:          act.CurrencyAddress,
 			Price:             act.Price,
+			Quantity:          act.Quantity,
 			Maker:             act.Maker,
 			Taker:             act.Taker,
 			TxHash:            act.TxHash,
@@ -46,6 +46,36 @@ func (d *Dao) QueryHistorySalesPriceInfo(ctx context.Context, chain string, coll
 	return historySalesInfo, nil
 }
 
+// QueryCollectionFloorHistory 查询集合在[start, end]内的地板价变化事件，以及start之前最近的一次变化
+// 返回的变化事件按时间升序排列，start之前没有变化时before为nil
+func (d *Dao) QueryCollectionFloorHistory(ctx context.Context, chain string, collectionAddr string,
+	start, end int64) (before *multi.CollectionFloorChange, changes []multi.CollectionFloorChange, err error) {
+	table := multi.CollectionFloorChangeTableName(chain)
+	collectionAddr = strings.ToLower(collectionAddr)
+
+	var prev []multi.CollectionFloorChange
+	if err := d.DB.WithContext(ctx).Table(table).
+		Select("old_price", "new_price", "event_time").
+		Where("collection_address = ? and event_time < ?", collectionAddr, start).
+		Order("event_time desc, id desc").Limit(1).
+		Find(&prev).Error; err != nil {
+		return nil, nil, errors.Wrap(err, "failed on get collection floor before range")
+	}
+	if len(prev) > 0 {
+		before = &prev[0]
+	}
+
+	if err := d.DB.WithContext(ctx).Table(table).
+		Select("old_price", "new_price", "event_time").
+		Where("collection_address = ? and event_time >= ? and event_time <= ?", collectionAddr, start, end).
+		Order("event_time asc, id asc").
+		Find(&changes).Error; err != nil {
+		return nil, nil, errors.Wrap(err, "failed on get collection floor changes")
+	}
+
+	return before, changes, nil
+}
+
 // QueryAllCollectionInfo 查询指定链上的所有NFT集合信息
 func (d *Dao) QueryAllCollectionInfo(ctx context.Context, chain string) ([]multi.Collection, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
@@ -0,0 +1,27 @@
+package dao
+
+import (
+	"context"
+
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// QueryOrderBookPaused 查询订单簿合约当前是否处于暂停状态
+// 以最近一次Paused/Unpaused事件为准，没有事件时视为未暂停
+func (d *Dao) QueryOrderBookPaused(ctx context.Context, chain string) (bool, error) {
+	var event multi.ContractEvent
+	if err := d.DB.WithContext(ctx).Table(multi.ContractEventTableName(chain)).
+		Select("event_type").
+		Where("event_type in (?)", []int{multi.ContractEventPaused, multi.ContractEventUnpaused}).
+		Order("block_number desc, log_index desc").
+		First(&event).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed on query orderbook pause event")
+	}
+
+	return event.EventType == multi.ContractEventPaused, nil
+}
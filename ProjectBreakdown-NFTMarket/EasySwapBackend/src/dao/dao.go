@@ -3,6 +3,7 @@ package dao
 import (
 	"context"
 
+	"github.com/ProjectsTask/EasySwapBase/currency"
 	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
 	"gorm.io/gorm"
 )
@@ -11,14 +12,16 @@ import (
 type Dao struct {
 	ctx context.Context
 
-	DB      *gorm.DB
-	KvStore *xkv.Store
+	DB          *gorm.DB
+	KvStore     *xkv.Store
+	PriceSource currency.PriceSource // 统计交易额时将各币种价格换算为ETH
 }
 
-func New(ctx context.Context, db *gorm.DB, kvStore *xkv.Store) *Dao {
+func New(ctx context.Context, db *gorm.DB, kvStore *xkv.Store, priceSource currency.PriceSource) *Dao {
 	return &Dao{
-		ctx:     ctx,
-		DB:      db,
-		KvStore: kvStore,
+		ctx:         ctx,
+		DB:          db,
+		KvStore:     kvStore,
+		PriceSource: priceSource,
 	}
 }
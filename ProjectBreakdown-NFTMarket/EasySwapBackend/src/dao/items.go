@@ -2,10 +2,12 @@ package dao
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/ProjectsTask/EasySwapBase/ordermanager"
 	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
@@ -38,6 +40,7 @@ type CollectionItem struct {
 	ListTime       int64  `json:"list_time"`
 	ListExpireTime int64  `json:"list_expire_time"`
 	ListSalt       int64  `json:"list_salt"`
+	ListCurrency   string `json:"list_currency"`
 }
 
 // QueryCollectionBids 查询NFT集合的出价信息
@@ -387,6 +390,13 @@ func (d *Dao) QueryLastSalePrice(ctx context.Context, chain string,
 // 3. 如果指定了用户地址,则排除该用户的出价
 func (d *Dao) QueryBestBids(ctx context.Context, chain string, userAddr string,
 	collectionAddr string, tokenIds []string) ([]multi.Order, error) {
+	// 不排除用户出价时优先使用order manager发布的各NFT最高出价
+	if userAddr == "" {
+		if bestBids, ok := d.cachedItemBestBids(chain, collectionAddr, tokenIds); ok {
+			return bestBids, nil
+		}
+	}
+
 	var bestBids []multi.Order
 	var sql string
 
@@ -402,7 +412,7 @@ func (d *Dao) QueryBestBids(ctx context.Context, chain string, userAddr string,
 	//    - 如果指定用户地址,则排除该用户的出价
 	if userAddr == "" {
 		sql = fmt.Sprintf(`
-			SELECT order_id, token_id, event_time, price, salt, 
+			SELECT order_id, token_id, event_time, price, currency_address, salt, 
 				expire_time, maker, order_type, quantity_remaining, size   
 			FROM %s
 			WHERE collection_address = ?
@@ -414,7 +424,7 @@ func (d *Dao) QueryBestBids(ctx context.Context, chain string, userAddr string,
 		`, multi.OrderTableName(chain))
 	} else {
 		sql = fmt.Sprintf(`
-			SELECT order_id, token_id, event_time, price, salt, 
+			SELECT order_id, token_id, event_time, price, currency_address, salt, 
 				expire_time, maker, order_type, quantity_remaining, size   
 			FROM %s
 			WHERE collection_address = ?
@@ -462,7 +472,7 @@ func (d *Dao) QueryItemsBestBids(ctx context.Context, chain string, userAddr str
 		//   - 剩余数量大于0
 		//   - 未过期
 		sql = fmt.Sprintf(`
-SELECT order_id, token_id, event_time, price, salt, expire_time, maker, order_type, quantity_remaining, size
+SELECT order_id, token_id, event_time, price, currency_address, salt, expire_time, maker, order_type, quantity_remaining, size
     FROM %s
     WHERE (collection_address,token_id) IN (?)
       AND order_type = ?
@@ -474,7 +484,7 @@ SELECT order_id, token_id, event_time, price, salt, expire_time, maker, order_ty
 		// SQL解释:
 		// 与上面相同,但增加了排除指定用户的条件
 		sql = fmt.Sprintf(`
-SELECT order_id, token_id, event_time, price, salt, expire_time, maker, order_type, quantity_remaining,size 
+SELECT order_id, token_id, event_time, price, currency_address, salt, expire_time, maker, order_type, quantity_remaining,size 
     FROM %s
     WHERE (collection_address,token_id) IN (?)
       AND order_type = ?
@@ -504,7 +514,7 @@ func (d *Dao) QueryCollectionsBestBid(ctx context.Context, chain string, userAdd
 	// SQL解释:
 	// 1. 主查询:从订单表中查询订单详细信息
 	sql := fmt.Sprintf(`
-		SELECT collection_address, order_id, price,event_time, expire_time, salt, maker, order_type, quantity_remaining, size  
+		SELECT collection_address, order_id, price, currency_address, event_time, expire_time, salt, maker, order_type, quantity_remaining, size  
 		FROM %s `, multi.OrderTableName(chain))
 
 	// 2. 子查询:获取每个集合的最高出价
@@ -544,6 +554,12 @@ func (d *Dao) QueryCollectionsBestBid(ctx context.Context, chain string, userAdd
 // 3. 返回价格最高的一个有效订单(未过期且有剩余数量)
 func (d *Dao) QueryCollectionBestBid(ctx context.Context, chain string,
 	userAddr string, collectionAddr string) (multi.Order, error) {
+	// 优先使用order manager发布的最高出价，最高出价为用户本人的出价时查询数据库
+	if bestBid, ok := d.cachedCollectionBestBid(chain, collectionAddr); ok &&
+		(userAddr == "" || !strings.EqualFold(bestBid.Maker, userAddr)) {
+		return bestBid, nil
+	}
+
 	var bestBid multi.Order
 	var sql string
 
@@ -558,7 +574,7 @@ func (d *Dao) QueryCollectionBestBid(ctx context.Context, chain string,
 	// 3. 按价格降序排序并限制返回1条记录
 	if userAddr == "" {
 		sql = fmt.Sprintf(`
-			SELECT order_id, price, event_time, expire_time, salt, maker, 
+			SELECT order_id, price, currency_address, event_time, expire_time, salt, maker, 
 				order_type, quantity_remaining, size  
 			FROM %s
 			WHERE collection_address = ?
@@ -571,7 +587,7 @@ func (d *Dao) QueryCollectionBestBid(ctx context.Context, chain string,
 		`, multi.OrderTableName(chain))
 	} else {
 		sql = fmt.Sprintf(`
-			SELECT order_id, price, event_time, expire_time, salt, maker, 
+			SELECT order_id, price, currency_address, event_time, expire_time, salt, maker, 
 				order_type, quantity_remaining, size  
 			FROM %s
 			WHERE collection_address = ?
@@ -593,6 +609,49 @@ func (d *Dao) QueryCollectionBestBid(ctx context.Context, chain string,
 	return bestBid, nil
 }
 
+// cachedCollectionBestBid 读取缓存的collection最高集合出价，缓存不存在、无法解析或出价已过期时返回false
+func (d *Dao) cachedCollectionBestBid(chain string, collectionAddr string) (multi.Order, bool) {
+	var bestBid multi.Order
+	raw, err := d.KvStore.Get(ordermanager.GenCollectionBestBidKey(chain, collectionAddr))
+	if err != nil || raw == "" {
+		return bestBid, false
+	}
+	if err := json.Unmarshal([]byte(raw), &bestBid); err != nil {
+		return bestBid, false
+	}
+	// 没有有效出价时缓存为空订单
+	if bestBid.OrderID != "" && bestBid.ExpireTime <= time.Now().Unix() {
+		return bestBid, false
+	}
+
+	return bestBid, true
+}
+
+// cachedItemBestBids 读取缓存的各NFT最高单品出价，任一NFT的缓存不存在、无法解析或出价已过期时返回false
+func (d *Dao) cachedItemBestBids(chain string, collectionAddr string, tokenIds []string) ([]multi.Order, bool) {
+	var bestBids []multi.Order
+	for _, tokenId := range tokenIds {
+		raw, err := d.KvStore.Get(ordermanager.GenItemBestBidKey(chain, collectionAddr, tokenId))
+		if err != nil || raw == "" {
+			return nil, false
+		}
+		var bestBid multi.Order
+		if err := json.Unmarshal([]byte(raw), &bestBid); err != nil {
+			return nil, false
+		}
+		// 没有有效出价时缓存为空订单
+		if bestBid.OrderID == "" {
+			continue
+		}
+		if bestBid.ExpireTime <= time.Now().Unix() {
+			return nil, false
+		}
+		bestBids = append(bestBids, bestBid)
+	}
+
+	return bestBids, true
+}
+
 // QueryCollectionTopNBid 查询集合中前N个最高出价订单
 // 主要功能:
 // 1. 查询指定集合中的最高出价订单
@@ -615,7 +674,7 @@ func (d *Dao) QueryCollectionTopNBid(ctx context.Context, chain string,
 		//   - 未过期
 		// 3. 按价格降序排序并限制返回记录数
 		sql = fmt.Sprintf(`
-			SELECT order_id, price, event_time, expire_time, salt, maker, 
+			SELECT order_id, price, currency_address, event_time, expire_time, salt, maker, 
 				order_type, quantity_remaining, size 
 			FROM %s
 			WHERE collection_address = ?
@@ -629,7 +688,7 @@ func (d *Dao) QueryCollectionTopNBid(ctx context.Context, chain string,
 	} else {
 		// SQL与上面类似,增加了排除指定用户的条件(maker != userAddr)
 		sql = fmt.Sprintf(`
-			SELECT order_id, price, event_time, expire_time, salt, maker, 
+			SELECT order_id, price, currency_address, event_time, expire_time, salt, maker, 
 				order_type, quantity_remaining, size
 			FROM %s
 			WHERE collection_address = ?
@@ -955,7 +1014,7 @@ func (d *Dao) QueryItemListInfo(ctx context.Context, chain, collectionAddr, toke
 	// 2. 匹配NFT、卖家、状态和价格
 	var listOrder multi.Order
 	if err := d.DB.WithContext(ctx).Table(fmt.Sprintf("%s as ci", multi.OrderTableName(chain))).
-		Select("order_id, expire_time, maker, salt, event_time").
+		Select("order_id, expire_time, maker, salt, event_time, currency_address").
 		Where("collection_address=? and token_id=? and maker=? and order_status=? and price = ?",
 			collectionItem.CollectionAddress, collectionItem.TokenId,
 			collectionItem.Owner, multi.OrderStatusActive, collectionItem.ListPrice).
@@ -969,6 +1028,7 @@ func (d *Dao) QueryItemListInfo(ctx context.Context, chain, collectionAddr, toke
 	collectionItem.ListMaker = listOrder.Maker
 	collectionItem.ListSalt = listOrder.Salt
 	collectionItem.ListTime = listOrder.EventTime
+	collectionItem.ListCurrency = listOrder.CurrencyAddress
 
 	return &collectionItem, nil
 }
@@ -1000,7 +1060,7 @@ func (d *Dao) QueryListingInfo(ctx context.Context, chain string,
 	if err := d.DB.WithContext(ctx).
 		Table(multi.OrderTableName(chain)).
 		Select("collection_address,token_id,order_id,event_time,"+
-			"expire_time,salt,maker ").
+			"expire_time,salt,maker,currency_address").
 		Where("(collection_address,token_id,maker,order_status,price) in (?)",
 			conditions).
 		Scan(&orders).Error; err != nil {
@@ -1166,6 +1226,20 @@ func (d *Dao) UpdateItemOwner(ctx context.Context, chain string, collectionAddr,
 	return nil
 }
 
+// QueryItemHolders 查询Item的持有人及持有数量，按持有数量降序
+func (d *Dao) QueryItemHolders(ctx context.Context, chain string, collectionAddr, tokenID string) ([]types.ItemHolder, error) {
+	var holders []types.ItemHolder
+	if err := d.DB.WithContext(ctx).Table(multi.ItemHolderTableName(chain)).
+		Select("owner, balance").
+		Where("collection_address = ? and token_id = ? and balance > 0", collectionAddr, tokenID).
+		Order("balance desc").
+		Scan(&holders).Error; err != nil {
+		return nil, errors.Wrap(err, "failed on get item holders")
+	}
+
+	return holders, nil
+}
+
 // QueryItemBids 查询Item的出价信息
 func (d *Dao) QueryItemBids(ctx context.Context, chain string, collectionAddr, tokenID string,
 	page, pageSize int) ([]types.ItemBid, int64, error) {
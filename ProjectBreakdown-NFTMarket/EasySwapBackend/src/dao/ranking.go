@@ -8,6 +8,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 
+	"github.com/ProjectsTask/EasySwapBase/currency"
 	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
 )
 
@@ -24,39 +25,94 @@ func GenRankingKey(project, chain string, period int) string {
 	return fmt.Sprintf("cache:%s:%s:ranking:volume:%d", strings.ToLower(project), strings.ToLower(chain), period)
 }
 
-type periodEpochMap map[string]int
+// periodToWindow 返回时间段对应的统计窗口，24h与1d相同
+func periodToWindow(period string) (multi.TradeWindow, error) {
+	if period == "24h" {
+		period = "1d"
+	}
+	for _, window := range multi.TradeWindows {
+		if window.Period == period {
+			return window, nil
+		}
+	}
+
+	return multi.TradeWindow{}, errors.Errorf("invalid period: %s", period)
+}
+
+// ethPriceExpr 成交价换算为ETH的SQL表达式，交易额及地板价统一按ETH统计，未配置汇率的币种不参与统计
+func (d *Dao) ethPriceExpr() (string, []interface{}) {
+	return currency.EthPriceExpr(d.PriceSource, "price", "currency_address")
+}
+
+// changePercent 计算相对上一时段的变化百分比，上一时段为0时返回0
+func changePercent(curr, prev decimal.Decimal) int {
+	if prev.IsZero() {
+		return 0
+	}
+
+	return int(curr.Sub(prev).Div(prev).Mul(decimal.NewFromInt(100)).IntPart())
+}
+
+// tradeFromAggregate 将EasySwapSync预聚合的窗口统计转换为排行榜数据
+func tradeFromAggregate(row *multi.CollectionTrade) *CollectionTrade {
+	return &CollectionTrade{
+		ContractAddress: row.CollectionAddress,
+		ItemCount:       row.SaleCount,
+		Volume:          row.Volume,
+		VolumeChange:    changePercent(row.Volume, row.PrevVolume),
+		PreFloorPrice:   row.PrevMinPrice,
+		FloorChange:     changePercent(row.MinPrice, row.PrevMinPrice),
+	}
+}
+
+// queryAggregatedTrades 查询ob_collection_trade中指定窗口的统计，collectionAddr为空时查询窗口内有成交的所有集合
+func (d *Dao) queryAggregatedTrades(chain string, epoch int64, collectionAddr string) ([]*multi.CollectionTrade, error) {
+	var rows []*multi.CollectionTrade
+	db := d.DB.WithContext(d.ctx).Table(multi.CollectionTradeTableName(chain)).
+		Where("epoch_number = ?", epoch)
+	if collectionAddr != "" {
+		db = db.Where("collection_address = ?", strings.ToLower(collectionAddr))
+	} else {
+		db = db.Where("sale_count > 0")
+	}
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, errors.Wrap(err, "failed on query collection trade stats")
+	}
 
-var periodToEpoch = periodEpochMap{
-	"15m": 3,
-	"1h":  12,
-	"6h":  72,
-	"24h": 288,
-	"1d":  288,
-	"7d":  2016,
-	"30d": 8640,
+	return rows, nil
 }
 
-// GetTradeInfoByCollection 获取指定时间段内集合的交易统计信息
+// GetTradeInfoByCollection 获取指定时间段内集合的交易统计信息，优先读取预聚合统计，未聚合时扫描活动表
 func (d *Dao) GetTradeInfoByCollection(chain, collectionAddr, period string) (*CollectionTrade, error) {
 	// 查询当前时间段的交易信息
 	var tradeCount int64
 	var totalVolume decimal.Decimal
 	var floorPrice decimal.Decimal
 
-	// 获取时间段对应的epoch值
-	epoch, ok := periodToEpoch[period]
-	if !ok {
-		return nil, errors.Errorf("invalid period: %s", period)
+	// 获取时间段对应的统计窗口
+	window, err := periodToWindow(period)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.queryAggregatedTrades(chain, window.EpochNumber, collectionAddr)
+	if err != nil {
+		return nil, err
 	}
-	// 计算查询的时间范围
-	startTime := time.Now().Add(-time.Duration(epoch) * time.Minute)
-	endTime := time.Now()
+	if len(rows) > 0 {
+		trade := tradeFromAggregate(rows[0])
+		trade.ContractAddress = collectionAddr
+		return trade, nil
+	}
+	// 计算查询的时间范围，event_time为秒级时间戳
+	endTime := time.Now().Unix()
+	startTime := endTime - window.Seconds()
+	ethPrice, ethPriceArgs := d.ethPriceExpr()
 
 	// 统计当前时间段内的交易数量和总交易额
-	err := d.DB.WithContext(d.ctx).Table(multi.ActivityTableName(chain)).
+	err = d.DB.WithContext(d.ctx).Table(multi.ActivityTableName(chain)).
 		Where("collection_address = ? AND activity_type = ? AND event_time >= ? AND event_time <= ?",
 			collectionAddr, multi.Sale, startTime, endTime).
-		Select("COUNT(*) as trade_count, COALESCE(SUM(price), 0) as total_volume").
+		Select(fmt.Sprintf("COUNT(*) as trade_count, COALESCE(SUM((%s) * quantity), 0) as total_volume", ethPrice), ethPriceArgs...).
 		Row().Scan(&tradeCount, &totalVolume)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get trade count and volume")
@@ -66,14 +122,14 @@ func (d *Dao) GetTradeInfoByCollection(chain, collectionAddr, period string) (*C
 	err = d.DB.WithContext(d.ctx).Table(multi.ActivityTableName(chain)).
 		Where("collection_address = ? AND activity_type = ? AND event_time >= ? AND event_time <= ?",
 			collectionAddr, multi.Sale, startTime, endTime).
-		Select("COALESCE(MIN(price), 0)").
+		Select(fmt.Sprintf("COALESCE(MIN(%s), 0)", ethPrice), ethPriceArgs...).
 		Row().Scan(&floorPrice)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get floor price")
 	}
 
 	// 计算上一个时间段的时间范围
-	prevStartTime := startTime.Add(-time.Duration(epoch) * time.Minute)
+	prevStartTime := startTime - window.Seconds()
 	prevEndTime := startTime
 
 	var prevVolume decimal.Decimal
@@ -83,7 +139,7 @@ func (d *Dao) GetTradeInfoByCollection(chain, collectionAddr, period string) (*C
 	err = d.DB.WithContext(d.ctx).Table(multi.ActivityTableName(chain)).
 		Where("collection_address = ? AND activity_type = ? AND event_time >= ? AND event_time <= ?",
 			collectionAddr, multi.Sale, prevStartTime, prevEndTime).
-		Select("COALESCE(SUM(price), 0)").
+		Select(fmt.Sprintf("COALESCE(SUM((%s) * quantity), 0)", ethPrice), ethPriceArgs...).
 		Row().Scan(&prevVolume)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get previous volume")
@@ -93,53 +149,50 @@ func (d *Dao) GetTradeInfoByCollection(chain, collectionAddr, period string) (*C
 	err = d.DB.WithContext(d.ctx).Table(multi.ActivityTableName(chain)).
 		Where("collection_address = ? AND activity_type = ? AND event_time >= ? AND event_time <= ?",
 			collectionAddr, multi.Sale, prevStartTime, prevEndTime).
-		Select("COALESCE(MIN(price), 0)").
+		Select(fmt.Sprintf("COALESCE(MIN(%s), 0)", ethPrice), ethPriceArgs...).
 		Row().Scan(&prevFloorPrice)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get previous floor price")
 	}
 
-	// 计算交易额和地板价的变化百分比
-	volumeChange := 0
-	floorChange := 0
-
-	// 如果上一时段交易额不为0,计算交易额变化百分比
-	if !prevVolume.IsZero() {
-		volumeChangeDecimal := totalVolume.Sub(prevVolume).Div(prevVolume).Mul(decimal.NewFromInt(100))
-		volumeChange = int(volumeChangeDecimal.IntPart())
-	}
-	// 如果上一时段地板价不为0,计算地板价变化百分比
-	if !prevFloorPrice.IsZero() {
-		floorChangeDecimal := floorPrice.Sub(prevFloorPrice).Div(prevFloorPrice).Mul(decimal.NewFromInt(100))
-		floorChange = int(floorChangeDecimal.IntPart())
-	}
-
-	// 返回集合交易统计信息
+	// 返回集合交易统计信息，包含交易额和地板价的变化百分比
 	return &CollectionTrade{
 		ContractAddress: collectionAddr,
 		ItemCount:       tradeCount,
 		Volume:          totalVolume,
-		VolumeChange:    volumeChange,
+		VolumeChange:    changePercent(totalVolume, prevVolume),
 		PreFloorPrice:   prevFloorPrice,
-		FloorChange:     floorChange,
+		FloorChange:     changePercent(floorPrice, prevFloorPrice),
 	}, nil
 }
 
-// 根据Activity获取集合排行榜信息
+// 获取集合排行榜信息，优先读取预聚合统计，未聚合时根据Activity统计
 func (d *Dao) GetCollectionRankingByActivity(chain, period string) ([]*CollectionTrade, error) {
 	// 解析时间范围
-	// 获取时间段对应的epoch值
-	epoch, ok := periodToEpoch[period]
-	if !ok {
-		return nil, errors.Errorf("invalid period: %s", period)
+	// 获取时间段对应的统计窗口
+	window, err := periodToWindow(period)
+	if err != nil {
+		return nil, err
 	}
-	// 计算查询的时间范围
-	startTime := time.Now().Add(-time.Duration(epoch) * time.Minute)
-	endTime := time.Now()
+	rows, err := d.queryAggregatedTrades(chain, window.EpochNumber, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 {
+		result := make([]*CollectionTrade, 0, len(rows))
+		for _, row := range rows {
+			result = append(result, tradeFromAggregate(row))
+		}
+		return result, nil
+	}
+	// 计算查询的时间范围，event_time为秒级时间戳
+	endTime := time.Now().Unix()
+	startTime := endTime - window.Seconds()
 
 	// 计算上一个时间段
 	prevEndTime := startTime
-	prevStartTime := startTime.Add(-time.Duration(epoch) * time.Minute)
+	prevStartTime := startTime - window.Seconds()
+	ethPrice, ethPriceArgs := d.ethPriceExpr()
 
 	// 获取当前时间段的交易统计
 	type TradeStats struct {
@@ -150,8 +203,9 @@ func (d *Dao) GetCollectionRankingByActivity(chain, period string) ([]*Collectio
 	}
 
 	var currentStats []TradeStats
-	err := d.DB.WithContext(d.ctx).Table(multi.ActivityTableName(chain)).
-		Select("collection_address, COUNT(*) as item_count, COALESCE(SUM(price), 0) as volume, COALESCE(MIN(price), 0) as floor_price").
+	err = d.DB.WithContext(d.ctx).Table(multi.ActivityTableName(chain)).
+		Select(fmt.Sprintf("collection_address, COUNT(*) as item_count, COALESCE(SUM((%s) * quantity), 0) as volume, COALESCE(MIN(%s), 0) as floor_price",
+			ethPrice, ethPrice), append(ethPriceArgs, ethPriceArgs...)...).
 		Where("activity_type = ? AND event_time >= ? AND event_time <= ?", multi.Sale, startTime, endTime).
 		Group("collection_address").
 		Find(&currentStats).Error
@@ -162,7 +216,8 @@ func (d *Dao) GetCollectionRankingByActivity(chain, period string) ([]*Collectio
 	// 获取上一时间段的交易统计
 	var prevStats []TradeStats
 	err = d.DB.WithContext(d.ctx).Table(multi.ActivityTableName(chain)).
-		Select("collection_address, COUNT(*) as item_count, COALESCE(SUM(price), 0) as volume, COALESCE(MIN(price), 0) as floor_price").
+		Select(fmt.Sprintf("collection_address, COUNT(*) as item_count, COALESCE(SUM((%s) * quantity), 0) as volume, COALESCE(MIN(%s), 0) as floor_price",
+			ethPrice, ethPrice), append(ethPriceArgs, ethPriceArgs...)...).
 		Where("activity_type = ? AND event_time >= ? AND event_time <= ?", multi.Sale, prevStartTime, prevEndTime).
 		Group("collection_address").
 		Find(&prevStats).Error
@@ -192,15 +247,8 @@ func (d *Dao) GetCollectionRankingByActivity(chain, period string) ([]*Collectio
 		if prev, ok := prevStatsMap[curr.CollectionAddress]; ok {
 			trade.PreFloorPrice = prev.FloorPrice
 
-			if !prev.Volume.IsZero() {
-				volumeChangeDecimal := curr.Volume.Sub(prev.Volume).Div(prev.Volume).Mul(decimal.NewFromInt(100))
-				trade.VolumeChange = int(volumeChangeDecimal.IntPart())
-			}
-
-			if !prev.FloorPrice.IsZero() {
-				floorChangeDecimal := curr.FloorPrice.Sub(prev.FloorPrice).Div(prev.FloorPrice).Mul(decimal.NewFromInt(100))
-				trade.FloorChange = int(floorChangeDecimal.IntPart())
-			}
+			trade.VolumeChange = changePercent(curr.Volume, prev.Volume)
+			trade.FloorChange = changePercent(curr.FloorPrice, prev.FloorPrice)
 		}
 
 		result = append(result, trade)
@@ -212,9 +260,10 @@ func (d *Dao) GetCollectionRankingByActivity(chain, period string) ([]*Collectio
 // 获取指定COllection的交易总量
 func (d *Dao) GetCollectionVolume(chain, collectionAddr string) (decimal.Decimal, error) {
 	var volume decimal.Decimal
+	ethPrice, ethPriceArgs := d.ethPriceExpr()
 	err := d.DB.WithContext(d.ctx).Table(multi.ActivityTableName(chain)).
 		Where("collection_address = ? AND activity_type = ?", collectionAddr, multi.Sale).
-		Select("COALESCE(SUM(price), 0)").
+		Select(fmt.Sprintf("COALESCE(SUM((%s) * quantity), 0)", ethPrice), ethPriceArgs...).
 		Row().Scan(&volume)
 	if err != nil {
 		return decimal.Zero, errors.Wrap(err, "failed to get collection volume")
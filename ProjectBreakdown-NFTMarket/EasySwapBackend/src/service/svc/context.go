@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/ProjectsTask/EasySwapBase/chain/nftchainservice"
+	"github.com/ProjectsTask/EasySwapBase/currency"
 	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
 	"github.com/ProjectsTask/EasySwapBase/stores/gdb"
 	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
@@ -64,14 +65,19 @@ func NewServiceContext(c *config.Config) (*ServerCtx, error) {
 	for _, supported := range c.ChainSupported {
 		nodeSrvs[int64(supported.ChainID)], err = nftchainservice.New(context.Background(), supported.Endpoint, supported.Name, supported.ChainID,
 			c.MetadataParse.NameTags, c.MetadataParse.ImageTags, c.MetadataParse.AttributesTags,
-			c.MetadataParse.TraitNameTags, c.MetadataParse.TraitValueTags)
+			c.MetadataParse.TraitNameTags, c.MetadataParse.TraitValueTags, c.RpcLimit.Options()...)
 
 		if err != nil {
 			return nil, errors.Wrap(err, "failed on start onchain sync service")
 		}
 	}
 
-	dao := dao.New(context.Background(), db, store)
+	priceSource, err := currency.NewStaticPriceSource(c.Currencies)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed on create currency price source")
+	}
+
+	dao := dao.New(context.Background(), db, store, priceSource)
 	serverCtx := NewServerCtx(
 		WithDB(db),
 		WithKv(store),
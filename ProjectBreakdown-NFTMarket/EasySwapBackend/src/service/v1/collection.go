@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/ProjectsTask/EasySwapBase/chain/nftchainservice"
 	"github.com/ProjectsTask/EasySwapBase/errcode"
 	"github.com/ProjectsTask/EasySwapBase/evm/eip"
 	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
@@ -172,6 +174,19 @@ func GetItems(ctx context.Context, svcCtx *svc.ServerCtx, chain string, filter t
 		}
 	}()
 
+	// 3.7 查询订单簿合约是否暂停
+	var paused bool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var err error
+		paused, err = svcCtx.Dao.QueryOrderBookPaused(ctx, chain)
+		if err != nil {
+			queryErr = errors.Wrap(err, "failed on get orderbook pause status")
+			return
+		}
+	}()
+
 	// 4. 等待所有查询完成
 	wg.Wait()
 	if queryErr != nil {
@@ -198,12 +213,14 @@ func GetItems(ctx context.Context, svcCtx *svc.ServerCtx, chain string, filter t
 			BidOrderID:        collectionBestBid.OrderID,
 			BidExpireTime:     collectionBestBid.ExpireTime,
 			BidPrice:          collectionBestBid.Price,
+			BidCurrency:       collectionBestBid.CurrencyAddress,
 			BidTime:           collectionBestBid.EventTime,
 			BidSalt:           collectionBestBid.Salt,
 			BidMaker:          collectionBestBid.Maker,
 			BidType:           getBidType(collectionBestBid.OrderType),
 			BidSize:           collectionBestBid.Size,
 			BidUnfilled:       collectionBestBid.QuantityRemaining,
+			Fillable:          !paused,
 		}
 
 		// 添加订单信息
@@ -213,6 +230,7 @@ func GetItems(ctx context.Context, svcCtx *svc.ServerCtx, chain string, filter t
 			respItem.ListOrderID = listOrder.OrderID
 			respItem.ListExpireTime = listOrder.ExpireTime
 			respItem.ListSalt = listOrder.Salt
+			respItem.ListCurrency = listOrder.CurrencyAddress
 		}
 
 		// 添加最高出价信息
@@ -222,6 +240,7 @@ func GetItems(ctx context.Context, svcCtx *svc.ServerCtx, chain string, filter t
 				respItem.BidOrderID = bidOrder.OrderID
 				respItem.BidExpireTime = bidOrder.ExpireTime
 				respItem.BidPrice = bidOrder.Price
+				respItem.BidCurrency = bidOrder.CurrencyAddress
 				respItem.BidTime = bidOrder.EventTime
 				respItem.BidSalt = bidOrder.Salt
 				respItem.BidMaker = bidOrder.Maker
@@ -377,6 +396,19 @@ func GetItem(ctx context.Context, svcCtx *svc.ServerCtx, chain string, chainID i
 		collectionBestBid = bid
 	}()
 
+	// 8. 查询订单簿合约是否暂停
+	var paused bool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var err error
+		paused, err = svcCtx.Dao.QueryOrderBookPaused(ctx, chain)
+		if err != nil {
+			queryErr = errors.Wrap(err, "failed on get orderbook pause status")
+			return
+		}
+	}()
+
 	// 等待所有查询完成
 	wg.Wait()
 	if queryErr != nil {
@@ -386,6 +418,7 @@ func GetItem(ctx context.Context, svcCtx *svc.ServerCtx, chain string, chainID i
 	// 组装返回数据
 	var itemDetail types.ItemDetailInfo
 	itemDetail.ChainID = chainID
+	itemDetail.Fillable = !paused
 
 	// 设置item基本信息
 	if item != nil {
@@ -397,6 +430,7 @@ func GetItem(ctx context.Context, svcCtx *svc.ServerCtx, chain string, chainID i
 		itemDetail.BidOrderID = collectionBestBid.OrderID
 		itemDetail.BidExpireTime = collectionBestBid.ExpireTime
 		itemDetail.BidPrice = collectionBestBid.Price
+		itemDetail.BidCurrency = collectionBestBid.CurrencyAddress
 		itemDetail.BidTime = collectionBestBid.EventTime
 		itemDetail.BidSalt = collectionBestBid.Salt
 		itemDetail.BidMaker = collectionBestBid.Maker
@@ -412,6 +446,7 @@ func GetItem(ctx context.Context, svcCtx *svc.ServerCtx, chain string, chainID i
 			itemDetail.BidOrderID = bidOrder.OrderID
 			itemDetail.BidExpireTime = bidOrder.ExpireTime
 			itemDetail.BidPrice = bidOrder.Price
+			itemDetail.BidCurrency = bidOrder.CurrencyAddress
 			itemDetail.BidTime = bidOrder.EventTime
 			itemDetail.BidSalt = bidOrder.Salt
 			itemDetail.BidMaker = bidOrder.Maker
@@ -430,6 +465,7 @@ func GetItem(ctx context.Context, svcCtx *svc.ServerCtx, chain string, chainID i
 		itemDetail.ListExpireTime = itemListInfo.ListExpireTime
 		itemDetail.ListSalt = itemListInfo.ListSalt
 		itemDetail.ListMaker = itemListInfo.ListMaker
+		itemDetail.ListCurrency = itemListInfo.ListCurrency
 	}
 
 	// 设置collection信息
@@ -563,8 +599,133 @@ func GetHistorySalesPrice(ctx context.Context, svcCtx *svc.ServerCtx, chain, col
 	return res, nil
 }
 
+// FloorHistoryDurations 地板价历史支持的时间范围(秒)
+var FloorHistoryDurations = map[string]int64{
+	"24h": 24 * 60 * 60,
+	"7d":  7 * 24 * 60 * 60,
+	"30d": 30 * 24 * 60 * 60,
+}
+
+// FloorHistoryResolutions 地板价历史支持的时间粒度(秒)
+var FloorHistoryResolutions = map[string]int64{
+	"5m": 5 * 60,
+	"1h": 60 * 60,
+	"4h": 4 * 60 * 60,
+	"1d": 24 * 60 * 60,
+}
+
+// MaxFloorHistoryPoints 单次查询返回的最大时间段数量
+const MaxFloorHistoryPoints = 1000
+
+// ErrInvalidFloorHistoryParams 地板价历史的时间范围或粒度不支持
+var ErrInvalidFloorHistoryParams = errors.New("invalid floor history params")
+
+// GetCollectionFloorHistory 根据地板价变化事件生成指定时间范围和粒度的地板价历史
+func GetCollectionFloorHistory(ctx context.Context, svcCtx *svc.ServerCtx, chain, collectionAddr, duration, resolution string) ([]types.FloorHistoryPoint, error) {
+	start, end, step, err := floorHistoryRange(duration, resolution, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	before, changes, err := svcCtx.Dao.QueryCollectionFloorHistory(ctx, chain, collectionAddr, start, end)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed on get collection floor history")
+	}
+
+	floor, ok := openingFloor(before, changes)
+	if !ok { // 从未记录过变化时使用当前地板价
+		collection, err := svcCtx.Dao.QueryCollectionInfo(ctx, chain, collectionAddr)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed on get collection info")
+		}
+		floor = collection.FloorPrice
+	}
+
+	return floorHistoryPoints(start, end, step, floor, changes), nil
+}
+
+// floorHistoryRange 校验时间范围及粒度，起始时间按粒度对齐，保证相同粒度的查询返回相同的时间段
+func floorHistoryRange(duration, resolution string, now int64) (start, end, step int64, err error) {
+	seconds, ok := FloorHistoryDurations[duration]
+	if !ok {
+		return 0, 0, 0, errors.Wrap(ErrInvalidFloorHistoryParams, "only support 24h/7d/30d")
+	}
+	step, ok = FloorHistoryResolutions[resolution]
+	if !ok {
+		return 0, 0, 0, errors.Wrap(ErrInvalidFloorHistoryParams, "only support 5m/1h/4h/1d")
+	}
+	if seconds/step > MaxFloorHistoryPoints {
+		return 0, 0, 0, errors.Wrap(ErrInvalidFloorHistoryParams, "too many points, use a coarser resolution")
+	}
+
+	return (now - seconds) / step * step, now, step, nil
+}
+
+// openingFloor 起始时的地板价: start之前最近一次变化后的价格，或范围内第一次变化前的价格，都没有时返回false
+func openingFloor(before *multi.CollectionFloorChange, changes []multi.CollectionFloorChange) (decimal.Decimal, bool) {
+	if before != nil {
+		return before.NewPrice, true
+	}
+	if len(changes) > 0 {
+		return changes[0].OldPrice, true
+	}
+
+	return decimal.Zero, false
+}
+
+// floorHistoryPoints 按粒度将按时间升序的变化事件聚合为OHLC，没有变化的时间段沿用上一时段的收盘价
+func floorHistoryPoints(start, end, step int64, floor decimal.Decimal, changes []multi.CollectionFloorChange) []types.FloorHistoryPoint {
+	points := make([]types.FloorHistoryPoint, 0, (end-start)/step+1)
+	i := 0
+	for t := start; t <= end; t += step {
+		point := types.FloorHistoryPoint{TimeStamp: t, Open: floor, High: floor, Low: floor}
+		for ; i < len(changes) && changes[i].EventTime < t+step; i++ {
+			floor = changes[i].NewPrice
+			if floor.GreaterThan(point.High) {
+				point.High = floor
+			}
+			if floor.LessThan(point.Low) {
+				point.Low = floor
+			}
+		}
+		point.Close = floor
+		points = append(points, point)
+	}
+
+	return points
+}
+
 // GetItemOwner 获取NFT Item的所有者信息
 func GetItemOwner(ctx context.Context, svcCtx *svc.ServerCtx, chainID int64, chain, collectionAddr, tokenID string) (*types.ItemOwner, error) {
+	// ERC-1155的token可以有多个持有人，从同步服务维护的持有人表中查询
+	collection, err := svcCtx.Dao.QueryCollectionInfo(ctx, chain, collectionAddr)
+	if err != nil {
+		xzap.WithContext(ctx).Error("failed on get collection info", zap.Error(err))
+		return nil, errcode.ErrUnexpected
+	}
+	if collection.TokenStandard == nftchainservice.TokenStandardERC1155 {
+		holders, err := svcCtx.Dao.QueryItemHolders(ctx, chain, collectionAddr, tokenID)
+		if err != nil {
+			xzap.WithContext(ctx).Error("failed on get item holders", zap.Error(err))
+			return nil, errcode.ErrUnexpected
+		}
+		for i := range holders {
+			if holders[i].Owner, err = eip.ToCheckSumAddress(holders[i].Owner); err != nil {
+				xzap.WithContext(ctx).Error("invalid address", zap.Error(err), zap.String("address", holders[i].Owner))
+				return nil, errcode.ErrUnexpected
+			}
+		}
+
+		itemOwner := &types.ItemOwner{
+			CollectionAddress: collectionAddr,
+			TokenID:           tokenID,
+			Holders:           holders,
+		}
+		if len(holders) == 1 {
+			itemOwner.Owner = holders[0].Owner
+		}
+		return itemOwner, nil
+	}
+
 	// 从链上获取NFT所有者地址
 	address, err := svcCtx.NodeSrvs[chainID].FetchNftOwner(collectionAddr, tokenID)
 	if err != nil {
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+func TestFloorHistoryRange(t *testing.T) {
+	now := int64(1_700_000_123)
+	cases := []struct {
+		duration   string
+		resolution string
+		start      int64
+		step       int64
+		invalid    bool
+	}{
+		{duration: "24h", resolution: "5m", start: (now - 86400) / 300 * 300, step: 300},
+		{duration: "7d", resolution: "1h", start: (now - 7*86400) / 3600 * 3600, step: 3600},
+		{duration: "30d", resolution: "1d", start: (now - 30*86400) / 86400 * 86400, step: 86400},
+		{duration: "7d", resolution: "5m", invalid: true}, // 2016个时间段超过上限
+		{duration: "30d", resolution: "5m", invalid: true},
+		{duration: "1y", resolution: "1d", invalid: true},
+		{duration: "7d", resolution: "2h", invalid: true},
+	}
+	for _, c := range cases {
+		start, end, step, err := floorHistoryRange(c.duration, c.resolution, now)
+		if c.invalid {
+			if !errors.Is(err, ErrInvalidFloorHistoryParams) {
+				t.Errorf("%s/%s: expected invalid params, got %v", c.duration, c.resolution, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s/%s: unexpected error: %v", c.duration, c.resolution, err)
+			continue
+		}
+		if start != c.start || end != now || step != c.step {
+			t.Errorf("%s/%s: unexpected range [%d, %d] step %d", c.duration, c.resolution, start, end, step)
+		}
+		if start%step != 0 {
+			t.Errorf("%s/%s: start %d not aligned to %d", c.duration, c.resolution, start, step)
+		}
+	}
+}
+
+func floorChange(oldPrice, newPrice, eventTime int64) multi.CollectionFloorChange {
+	return multi.CollectionFloorChange{
+		OldPrice:  decimal.NewFromInt(oldPrice),
+		NewPrice:  decimal.NewFromInt(newPrice),
+		EventTime: eventTime,
+	}
+}
+
+func TestOpeningFloor(t *testing.T) {
+	before := floorChange(5, 7, 90)
+	cases := []struct {
+		name    string
+		before  *multi.CollectionFloorChange
+		changes []multi.CollectionFloorChange
+		floor   int64
+		ok      bool
+	}{
+		{name: "carry forward previous change", before: &before, changes: []multi.CollectionFloorChange{floorChange(7, 3, 120)}, floor: 7, ok: true},
+		{name: "first change in range", changes: []multi.CollectionFloorChange{floorChange(4, 3, 120)}, floor: 4, ok: true},
+		{name: "no change", floor: 0, ok: false},
+	}
+	for _, c := range cases {
+		floor, ok := openingFloor(c.before, c.changes)
+		if ok != c.ok || !floor.Equal(decimal.NewFromInt(c.floor)) {
+			t.Errorf("%s: got %s/%v, expected %d/%v", c.name, floor, ok, c.floor, c.ok)
+		}
+	}
+}
+
+func TestFloorHistoryPoints(t *testing.T) {
+	type ohlc [4]int64
+	cases := []struct {
+		name    string
+		floor   int64
+		changes []multi.CollectionFloorChange
+		points  []ohlc
+	}{
+		{
+			name:   "empty range keeps opening floor",
+			floor:  5,
+			points: []ohlc{{5, 5, 5, 5}, {5, 5, 5, 5}, {5, 5, 5, 5}},
+		},
+		{
+			name:  "changes aggregated per bucket",
+			floor: 5,
+			changes: []multi.CollectionFloorChange{
+				floorChange(5, 8, 100), // 时间段起点属于该时间段
+				floorChange(8, 2, 150),
+				floorChange(2, 4, 199),
+			},
+			points: []ohlc{{5, 5, 5, 5}, {5, 8, 2, 4}, {4, 4, 4, 4}},
+		},
+		{
+			name:  "change at bucket end belongs to next bucket",
+			floor: 5,
+			changes: []multi.CollectionFloorChange{
+				floorChange(5, 0, 200), // 挂单全部失效时地板价为0
+			},
+			points: []ohlc{{5, 5, 5, 5}, {5, 5, 5, 5}, {5, 5, 0, 0}},
+		},
+		{
+			name:  "carry forward across buckets",
+			floor: 0,
+			changes: []multi.CollectionFloorChange{
+				floorChange(0, 3, 10),
+			},
+			points: []ohlc{{0, 3, 0, 3}, {3, 3, 3, 3}, {3, 3, 3, 3}},
+		},
+	}
+	for _, c := range cases {
+		points := floorHistoryPoints(0, 250, 100, decimal.NewFromInt(c.floor), c.changes)
+		if len(points) != len(c.points) {
+			t.Errorf("%s: expected %d points, got %d", c.name, len(c.points), len(points))
+			continue
+		}
+		for i, p := range points {
+			expected := c.points[i]
+			if p.TimeStamp != int64(i)*100 ||
+				!p.Open.Equal(decimal.NewFromInt(expected[0])) || !p.High.Equal(decimal.NewFromInt(expected[1])) ||
+				!p.Low.Equal(decimal.NewFromInt(expected[2])) || !p.Close.Equal(decimal.NewFromInt(expected[3])) {
+				t.Errorf("%s: point %d = %d %s/%s/%s/%s, expected %v", c.name, i, p.TimeStamp, p.Open, p.High, p.Low, p.Close, expected)
+			}
+		}
+	}
+}
@@ -48,8 +48,19 @@ func GetOrderInfos(ctx context.Context, svcCtx *svc.ServerCtx, chainID int, chai
 		return nil, errors.Wrap(err, "failed on query collection best bids")
 	}
 
-	// 5. 处理并返回最终的出价信息
-	return processBids(tokenIds, itemsBestBids, collectionBids, collectionAddr), nil
+	// 5. 查询订单簿合约是否暂停，暂停期间出价不可成交
+	paused, err := svcCtx.Dao.QueryOrderBookPaused(ctx, chain)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed on query orderbook pause status")
+	}
+
+	// 6. 处理并返回最终的出价信息
+	resultBids := processBids(tokenIds, itemsBestBids, collectionBids, collectionAddr)
+	for i := range resultBids {
+		resultBids[i].Fillable = !paused
+	}
+
+	return resultBids, nil
 }
 
 // processBids 处理NFT的出价信息,返回每个NFT的最高出价
@@ -94,6 +105,7 @@ func processBids(tokenIds []string, itemsBestBids map[string]multi.Order, collec
 					EventTime:         collectionBids[cBidIndex].EventTime,
 					ExpireTime:        collectionBids[cBidIndex].ExpireTime,
 					Price:             collectionBids[cBidIndex].Price,
+					Currency:          collectionBids[cBidIndex].CurrencyAddress,
 					Salt:              collectionBids[cBidIndex].Salt,
 					BidSize:           collectionBids[cBidIndex].Size,
 					BidUnfilled:       collectionBids[cBidIndex].QuantityRemaining,
@@ -117,6 +129,7 @@ func processBids(tokenIds []string, itemsBestBids map[string]multi.Order, collec
 				EventTime:         itemBid.EventTime,
 				ExpireTime:        itemBid.ExpireTime,
 				Price:             itemBid.Price,
+				Currency:          itemBid.CurrencyAddress,
 				Salt:              itemBid.Salt,
 				BidSize:           itemBid.Size,
 				BidUnfilled:       itemBid.QuantityRemaining,
@@ -136,6 +149,7 @@ func processBids(tokenIds []string, itemsBestBids map[string]multi.Order, collec
 					EventTime:         cBid.EventTime,
 					ExpireTime:        cBid.ExpireTime,
 					Price:             cBid.Price,
+					Currency:          cBid.CurrencyAddress,
 					Salt:              cBid.Salt,
 					BidSize:           cBid.Size,
 					BidUnfilled:       cBid.QuantityRemaining,
@@ -153,6 +167,7 @@ func processBids(tokenIds []string, itemsBestBids map[string]multi.Order, collec
 					EventTime:         itemBid.EventTime,
 					ExpireTime:        itemBid.ExpireTime,
 					Price:             itemBid.Price,
+					Currency:          itemBid.CurrencyAddress,
 					Salt:              itemBid.Salt,
 					BidSize:           itemBid.Size,
 					BidUnfilled:       itemBid.QuantityRemaining,
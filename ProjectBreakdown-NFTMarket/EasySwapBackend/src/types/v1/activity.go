@@ -26,6 +26,7 @@ type ActivityInfo struct {
 	ItemName           string          `json:"item_name"`
 	Currency           string          `json:"currency"`
 	Price              decimal.Decimal `json:"price"`
+	Quantity           int64           `json:"quantity"`
 	Maker              string          `json:"maker"`
 	Taker              string          `json:"taker"`
 	TxHash             string          `json:"tx_hash"`
@@ -10,9 +10,11 @@ type ItemBid struct {
 	EventTime         int64           `json:"event_time"`
 	ExpireTime        int64           `json:"expire_time"` // in seconds
 	Price             decimal.Decimal `json:"price"`
+	Currency          string          `json:"currency"` // 支付币种，零地址为原生币
 	Salt              int64           `json:"salt"`
 	BidSize           int64           `json:"bid_size"`
 	BidUnfilled       int64           `json:"bid_unfilled"`
 	Bidder            string          `json:"bidder"`
 	OrderType         int64           `json:"order_type"`
+	Fillable          bool            `json:"fillable"` // 订单簿合约暂停时订单不可成交
 }
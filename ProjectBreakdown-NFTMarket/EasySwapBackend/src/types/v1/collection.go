@@ -39,6 +39,15 @@ type HistorySalesPriceInfo struct {
 	TimeStamp int64           `json:"time_stamp"`
 }
 
+// FloorHistoryPoint 地板价历史曲线的一个时间段，价格为0表示没有挂单
+type FloorHistoryPoint struct {
+	TimeStamp int64           `json:"time_stamp"` // 时间段开始时间
+	Open      decimal.Decimal `json:"open"`       // 时间段开始时的地板价
+	High      decimal.Decimal `json:"high"`
+	Low       decimal.Decimal `json:"low"`
+	Close     decimal.Decimal `json:"close"` // 时间段结束时的地板价
+}
+
 type TopTraitFilterParams struct {
 	TokenIds []string `json:"token_ids"`
 	ChainID  int      `json:"chain_id"`
@@ -62,6 +71,7 @@ type NFTListingInfo struct {
 	ListOrderID    string          `json:"list_order_id"`
 	ListTime       int64           `json:"list_time"`
 	ListPrice      decimal.Decimal `json:"list_price"`
+	ListCurrency   string          `json:"list_currency"`
 	ListExpireTime int64           `json:"list_expire_time"`
 	ListSalt       int64           `json:"list_salt"`
 	ListMaker      string          `json:"list_maker"`
@@ -70,13 +80,15 @@ type NFTListingInfo struct {
 	BidTime       int64           `json:"bid_time"`
 	BidExpireTime int64           `json:"bid_expire_time"`
 	BidPrice      decimal.Decimal `json:"bid_price"`
+	BidCurrency   string          `json:"bid_currency"`
 	BidSalt       int64           `json:"bid_salt"`
 	BidMaker      string          `json:"bid_maker"`
 	BidType       int64           `json:"bid_type"`
 	BidSize       int64           `json:"bid_size"`
 	BidUnfilled   int64           `json:"bid_unfilled"`
 
-	MarketID int `json:"market_id"`
+	MarketID int  `json:"market_id"`
+	Fillable bool `json:"fillable"` // 订单簿合约暂停时挂单和出价均不可成交
 
 	LastSellPrice    decimal.Decimal `json:"last_sell_price"`
 	OwnerOwnedAmount int64           `json:"owner_owned_amount"`
@@ -16,9 +16,15 @@ type ItemPriceInfo struct {
 }
 
 type ItemOwner struct {
-	CollectionAddress string `json:"collection_address"`
-	TokenID           string `json:"token_id"`
-	Owner             string `json:"owner"`
+	CollectionAddress string       `json:"collection_address"`
+	TokenID           string       `json:"token_id"`
+	Owner             string       `json:"owner"`
+	Holders           []ItemHolder `json:"holders,omitempty"` // ERC-1155的token可以有多个持有人
+}
+
+type ItemHolder struct {
+	Owner   string          `json:"owner"`
+	Balance decimal.Decimal `json:"balance"`
 }
 
 type ItemImage struct {
@@ -45,6 +51,7 @@ type ItemDetailInfo struct {
 	ListOrderID    string          `json:"list_order_id"`
 	ListTime       int64           `json:"list_time"`
 	ListPrice      decimal.Decimal `json:"list_price"`
+	ListCurrency   string          `json:"list_currency"`
 	ListExpireTime int64           `json:"list_expire_time"`
 	ListSalt       int64           `json:"list_salt"`
 	ListMaker      string          `json:"list_maker"`
@@ -53,11 +60,14 @@ type ItemDetailInfo struct {
 	BidTime       int64           `json:"bid_time"`
 	BidExpireTime int64           `json:"bid_expire_time"`
 	BidPrice      decimal.Decimal `json:"bid_price"`
+	BidCurrency   string          `json:"bid_currency"`
 	BidSalt       int64           `json:"bid_salt"`
 	BidMaker      string          `json:"bid_maker"`
 	BidType       int64           `json:"bid_type"`
 	BidSize       int64           `json:"bid_size"`
 	BidUnfilled   int64           `json:"bid_unfilled"`
+
+	Fillable bool `json:"fillable"` // 订单簿合约暂停时挂单和出价均不可成交
 }
 
 type ItemDetailInfoResp struct {
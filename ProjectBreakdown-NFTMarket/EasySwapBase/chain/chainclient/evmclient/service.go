@@ -3,27 +3,51 @@ package evmclient
 import (
 	"context"
 	"math/big"
+	"strings"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/pkg/errors"
 
+	"github.com/ProjectsTask/EasySwapBase/chain"
 	logTypes "github.com/ProjectsTask/EasySwapBase/chain/types"
+	"github.com/ProjectsTask/EasySwapBase/xhttp"
 )
 
+// 节点限制单次eth_getLogs返回的日志数量时的错误信息(zkSync Era为10000条，部分节点服务商也有类似限制)
+var tooManyLogsErrors = []string{
+	"query returned more than",
+	"exceeds max results",
+	"log response size exceeded",
+}
+
+// 单次批量请求包含的最大区块数，多数节点服务商限制批量请求不超过100个
+const maxBlockTimeBatch = 100
+
+// blockTimestamp 只解析区块头中的时间戳，避免L2自定义字段影响解析
+type blockTimestamp struct {
+	Time hexutil.Uint64 `json:"timestamp"`
+}
+
 type Service struct {
-	client *ethclient.Client
+	chainID   int
+	client    *ethclient.Client
+	rpcClient xhttp.RPCClient // 批量请求，单次请求超过maxBlockTimeBatch时自动拆分
 }
 
-func New(nodeUrl string) (*Service, error) {
+func New(chainID int, nodeUrl string) (*Service, error) {
 	client, err := ethclient.Dial(nodeUrl)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed on create client")
 	}
 
 	return &Service{
-		client: client,
+		chainID:   chainID,
+		client:    client,
+		rpcClient: xhttp.NewRPCClient(nodeUrl, xhttp.WithMaxBatchSize(maxBlockTimeBatch)),
 	}, nil
 }
 
@@ -53,7 +77,7 @@ func (s *Service) FilterLogs(ctx context.Context, q logTypes.FilterQuery) ([]int
 		Topics:    topicsHash,
 	}
 
-	logs, err := s.client.FilterLogs(ctx, queryParam)
+	logs, err := s.filterLogs(ctx, queryParam)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed on get events")
 	}
@@ -66,6 +90,43 @@ func (s *Service) FilterLogs(ctx context.Context, q logTypes.FilterQuery) ([]int
 	return logEvents, nil
 }
 
+// filterLogs 日志数量超过节点限制时将区块范围二分后分别查询
+func (s *Service) filterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	logs, err := s.client.FilterLogs(ctx, q)
+	if err == nil || !isTooManyLogsError(err) || q.FromBlock == nil || q.ToBlock == nil ||
+		q.FromBlock.Cmp(q.ToBlock) >= 0 {
+		return logs, err
+	}
+
+	mid := new(big.Int).Add(q.FromBlock, q.ToBlock)
+	mid.Rsh(mid, 1)
+	left, right := q, q
+	left.ToBlock = mid
+	right.FromBlock = new(big.Int).Add(mid, big.NewInt(1))
+
+	leftLogs, err := s.filterLogs(ctx, left)
+	if err != nil {
+		return nil, err
+	}
+	rightLogs, err := s.filterLogs(ctx, right)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(leftLogs, rightLogs...), nil
+}
+
+func isTooManyLogsError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, e := range tooManyLogsErrors {
+		if strings.Contains(msg, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (s *Service) BlockTimeByNumber(ctx context.Context, blockNum *big.Int) (uint64, error) {
 	header, err := s.client.HeaderByNumber(ctx, blockNum)
 	if err != nil {
@@ -75,6 +136,42 @@ func (s *Service) BlockTimeByNumber(ctx context.Context, blockNum *big.Int) (uin
 	return header.Time, nil
 }
 
+// BlockTimesByNumber 通过批量eth_getBlockByNumber请求获取多个区块的时间戳，结果与blockNums一一对应，blockNum为nil时为最新区块
+func (s *Service) BlockTimesByNumber(ctx context.Context, blockNums []*big.Int) ([]uint64, error) {
+	if len(blockNums) == 0 {
+		return nil, nil
+	}
+	requests := make(xhttp.RPCRequests, 0, len(blockNums))
+	for _, blockNum := range blockNums {
+		requests = append(requests, xhttp.NewRPCRequest("eth_getBlockByNumber", toBlockNumArg(blockNum), false))
+	}
+	responses, err := s.rpcClient.CallBatch(ctx, requests)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed on batch get block headers")
+	}
+
+	blockTimes := make([]uint64, len(blockNums))
+	for i, resp := range responses {
+		var header *blockTimestamp
+		if err := resp.ReadToObject(&header); err != nil {
+			return nil, errors.Wrapf(err, "failed on get block header %s", toBlockNumArg(blockNums[i]))
+		}
+		if header == nil { // 节点尚未同步到该区块
+			return nil, errors.Wrapf(ethereum.NotFound, "block %s", toBlockNumArg(blockNums[i]))
+		}
+		blockTimes[i] = uint64(header.Time)
+	}
+
+	return blockTimes, nil
+}
+
+func toBlockNumArg(number *big.Int) string {
+	if number == nil {
+		return "latest"
+	}
+	return hexutil.EncodeBig(number)
+}
+
 func (s *Service) CallContractByChain(ctx context.Context, param logTypes.CallParam) (interface{}, error) {
 	return s.CallContract(ctx, param.EVMParam, param.BlockNumber)
 }
@@ -93,7 +190,16 @@ func (s *Service) BlockNumber() (uint64, error) {
 	return blockNum, nil
 }
 
+// BlockWithTxs 获取完整区块。Arbitrum/zkSync Era的自定义交易类型无法被go-ethereum解析，此时只返回区块头
 func (s *Service) BlockWithTxs(ctx context.Context, blockNumber uint64) (interface{}, error) {
+	if chain.UnsupportedFullBlockChain(s.chainID) {
+		header, err := s.client.HeaderByNumber(ctx, big.NewInt(int64(blockNumber)))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed on get evm block header")
+		}
+		return types.NewBlockWithHeader(header), nil
+	}
+
 	blockWithTxs, err := s.client.BlockByNumber(ctx, big.NewInt(int64(blockNumber)))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed on get evm block")
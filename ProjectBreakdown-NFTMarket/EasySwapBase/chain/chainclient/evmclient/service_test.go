@@ -0,0 +1,78 @@
+package evmclient
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// newBlockStub 本地节点桩服务，区块时间戳为区块号*10，超过head的区块返回null
+func newBlockStub(t *testing.T, head uint64, batchSizes *[]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []struct {
+			ID     json.RawMessage   `json:"id"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*batchSizes = append(*batchSizes, len(reqs))
+
+		resps := make([]map[string]interface{}, 0, len(reqs))
+		for _, req := range reqs {
+			var arg string
+			assert.NoError(t, json.Unmarshal(req.Params[0], &arg))
+			var number uint64
+			if arg == "latest" {
+				number = head
+			} else {
+				var err error
+				number, err = hexutil.DecodeUint64(arg)
+				assert.NoError(t, err)
+			}
+
+			resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": nil}
+			if number <= head {
+				resp["result"] = map[string]interface{}{"timestamp": hexutil.Uint64(number * 10)}
+			}
+			resps = append(resps, resp)
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(resps))
+	}))
+}
+
+func TestBlockTimesByNumber(t *testing.T) {
+	var batchSizes []int
+	server := newBlockStub(t, 1000, &batchSizes)
+	defer server.Close()
+
+	s, err := New(1, server.URL)
+	assert.Nil(t, err)
+
+	blockNums := make([]*big.Int, 0, maxBlockTimeBatch+2)
+	for i := 0; i <= maxBlockTimeBatch; i++ {
+		blockNums = append(blockNums, big.NewInt(int64(i)))
+	}
+	blockNums = append(blockNums, nil)
+
+	blockTimes, err := s.BlockTimesByNumber(context.Background(), blockNums)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{maxBlockTimeBatch, 2}, batchSizes)
+	assert.Len(t, blockTimes, len(blockNums))
+	assert.Equal(t, uint64(70), blockTimes[7])
+	assert.Equal(t, uint64(maxBlockTimeBatch*10), blockTimes[maxBlockTimeBatch])
+	assert.Equal(t, uint64(10000), blockTimes[maxBlockTimeBatch+1])
+
+	// 节点尚未同步到的区块
+	_, err = s.BlockTimesByNumber(context.Background(), []*big.Int{big.NewInt(1), big.NewInt(1001)})
+	assert.True(t, errors.Is(err, ethereum.NotFound))
+}
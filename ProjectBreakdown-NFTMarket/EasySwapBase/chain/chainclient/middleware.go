@@ -0,0 +1,293 @@
+package chainclient
+
+import (
+	"context"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+
+	logTypes "github.com/ProjectsTask/EasySwapBase/chain/types"
+	"github.com/ProjectsTask/EasySwapBase/retry"
+)
+
+const (
+	defaultRetryAttempts  = 4
+	defaultRetryBaseDelay = 200 * time.Millisecond
+	defaultRetryMaxDelay  = 5 * time.Second
+)
+
+// 节点限流、过载或临时不可用时返回的错误信息
+var retryableMessages = []string{
+	"429",
+	"too many requests",
+	"rate limit",
+	"limit exceeded",
+	"capacity exceeded",
+	"timeout",
+	"timed out",
+	"bad gateway",
+	"service unavailable",
+	"gateway timeout",
+	"connection reset",
+	"connection refused",
+	"header not found",
+}
+
+// RateLimit 单个节点的令牌桶限流，Rate为每秒请求数
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RetryPolicy 可重试错误的重试策略，零值使用默认值
+type RetryPolicy struct {
+	MaxAttempts uint          // 包含首次请求的最大请求次数
+	BaseDelay   time.Duration // 第一次重试前的最长等待时间，之后每次翻倍
+	MaxDelay    time.Duration // 重试前的最长等待时间
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaultRetryAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultRetryBaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultRetryMaxDelay
+	}
+	return p
+}
+
+// Counters 请求计数，多个客户端可共用同一计数器
+type Counters struct {
+	Requests  atomic.Int64 // 发送到节点的请求数，包含重试
+	Retries   atomic.Int64 // 重试次数
+	Throttled atomic.Int64 // 因令牌不足而等待的请求数
+	Failures  atomic.Int64 // 重试后仍失败的调用数
+}
+
+// IsRetryable 判断错误是否可重试：节点限流、5xx、超时及连接错误可重试，合约revert、参数错误及请求取消不可重试
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if isCallError(err) {
+		return false
+	}
+
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests ||
+			httpErr.StatusCode == http.StatusRequestTimeout ||
+			httpErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.ErrorCode() {
+		case -32005, -32603: // limit exceeded, internal error
+			return true
+		}
+	}
+
+	// 节点尚未同步到请求的区块
+	if errors.Is(err, ethereum.NotFound) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range retryableMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware 包装ChainClient，请求前按令牌桶限流，可重试的错误按指数退避及随机抖动重试
+type Middleware struct {
+	next     ChainClient
+	limiter  *rate.Limiter
+	policy   *RetryPolicy
+	counters *Counters
+}
+
+// NewMiddleware limit为nil时不限流，policy为nil时不重试，counters为nil时使用独立的计数器
+func NewMiddleware(next ChainClient, limit *RateLimit, policy *RetryPolicy, counters *Counters) *Middleware {
+	m := &Middleware{next: next, counters: counters}
+	if limit != nil && limit.Rate > 0 {
+		burst := limit.Burst
+		if burst <= 0 {
+			burst = 1
+		}
+		m.limiter = rate.NewLimiter(rate.Limit(limit.Rate), burst)
+	}
+	if policy != nil {
+		p := policy.withDefaults()
+		m.policy = &p
+	}
+	if m.counters == nil {
+		m.counters = &Counters{}
+	}
+	return m
+}
+
+// Counters 返回请求计数
+func (m *Middleware) Counters() *Counters {
+	return m.counters
+}
+
+// wait 等待令牌，请求取消时返回错误
+func (m *Middleware) wait(ctx context.Context) error {
+	if m.limiter == nil {
+		return nil
+	}
+	if !m.limiter.Allow() {
+		m.counters.Throttled.Add(1)
+		if err := m.limiter.Wait(ctx); err != nil {
+			return errors.Wrap(err, "failed on wait rate limiter")
+		}
+	}
+	return nil
+}
+
+func (m *Middleware) call(ctx context.Context, fn func() error) error {
+	attempt := func(n uint) error {
+		if n > 0 {
+			m.counters.Retries.Add(1)
+		}
+		if err := m.wait(ctx); err != nil {
+			return retry.Stop(err)
+		}
+		m.counters.Requests.Add(1)
+		err := fn()
+		if err != nil && (m.policy == nil || !IsRetryable(err) || ctx.Err() != nil) {
+			return retry.Stop(err)
+		}
+		return err
+	}
+
+	var err error
+	if m.policy == nil {
+		err = retry.Retry(attempt, retry.Limit(1))
+	} else {
+		err = retry.Retry(attempt, retry.Limit(m.policy.MaxAttempts), retry.Backoff(ctx, m.policy.BaseDelay, m.policy.MaxDelay))
+	}
+	if err != nil {
+		m.counters.Failures.Add(1)
+	}
+	return err
+}
+
+func (m *Middleware) FilterLogs(ctx context.Context, q logTypes.FilterQuery) ([]interface{}, error) {
+	var logs []interface{}
+	err := m.call(ctx, func() error {
+		var err error
+		logs, err = m.next.FilterLogs(ctx, q)
+		return err
+	})
+	return logs, err
+}
+
+func (m *Middleware) BlockTimeByNumber(ctx context.Context, blockNum *big.Int) (uint64, error) {
+	var blockTime uint64
+	err := m.call(ctx, func() error {
+		var err error
+		blockTime, err = m.next.BlockTimeByNumber(ctx, blockNum)
+		return err
+	})
+	return blockTime, err
+}
+
+func (m *Middleware) BlockTimesByNumber(ctx context.Context, blockNums []*big.Int) ([]uint64, error) {
+	var blockTimes []uint64
+	err := m.call(ctx, func() error {
+		var err error
+		blockTimes, err = m.next.BlockTimesByNumber(ctx, blockNums)
+		return err
+	})
+	return blockTimes, err
+}
+
+func (m *Middleware) Client() interface{} {
+	return m.next.Client()
+}
+
+func (m *Middleware) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	var result []byte
+	err := m.call(ctx, func() error {
+		var err error
+		result, err = m.next.CallContract(ctx, msg, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+func (m *Middleware) CallContractByChain(ctx context.Context, param logTypes.CallParam) (interface{}, error) {
+	var result interface{}
+	err := m.call(ctx, func() error {
+		var err error
+		result, err = m.next.CallContractByChain(ctx, param)
+		return err
+	})
+	return result, err
+}
+
+func (m *Middleware) BlockNumber() (uint64, error) {
+	var head uint64
+	err := m.call(context.Background(), func() error {
+		var err error
+		head, err = m.next.BlockNumber()
+		return err
+	})
+	return head, err
+}
+
+func (m *Middleware) BlockWithTxs(ctx context.Context, blockNumber uint64) (interface{}, error) {
+	var block interface{}
+	err := m.call(ctx, func() error {
+		var err error
+		block, err = m.next.BlockWithTxs(ctx, blockNumber)
+		return err
+	})
+	return block, err
+}
+
+// MiddlewareConfig 配置文件中的限流及重试配置
+type MiddlewareConfig struct {
+	Rate           float64 `toml:"rate" mapstructure:"rate" json:"rate"`                                     // 每个节点每秒请求数，为0时不限流
+	Burst          int     `toml:"burst" mapstructure:"burst" json:"burst"`                                  // 令牌桶容量，为0时为1
+	RetryAttempts  uint    `toml:"retry_attempts" mapstructure:"retry_attempts" json:"retry_attempts"`       // 包含首次请求的最大请求次数，为0时不重试
+	RetryBaseDelay int64   `toml:"retry_base_delay" mapstructure:"retry_base_delay" json:"retry_base_delay"` // 第一次重试前的最长等待时间(毫秒)，为0时使用默认值
+	RetryMaxDelay  int64   `toml:"retry_max_delay" mapstructure:"retry_max_delay" json:"retry_max_delay"`    // 重试前的最长等待时间(毫秒)，为0时使用默认值
+}
+
+// Options 转换为chainclient.New的配置
+func (c MiddlewareConfig) Options() []Option {
+	var opts []Option
+	if c.Rate > 0 {
+		opts = append(opts, WithRateLimit(RateLimit{Rate: c.Rate, Burst: c.Burst}))
+	}
+	if c.RetryAttempts > 1 {
+		opts = append(opts, WithRetry(RetryPolicy{
+			MaxAttempts: c.RetryAttempts,
+			BaseDelay:   time.Duration(c.RetryBaseDelay) * time.Millisecond,
+			MaxDelay:    time.Duration(c.RetryMaxDelay) * time.Millisecond,
+		}))
+	}
+	return opts
+}
@@ -0,0 +1,88 @@
+package chainclient
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type codeError struct {
+	code int
+	msg  string
+}
+
+func (e codeError) Error() string  { return e.msg }
+func (e codeError) ErrorCode() int { return e.code }
+
+func TestIsRetryable(t *testing.T) {
+	assert.False(t, IsRetryable(nil))
+	assert.False(t, IsRetryable(context.Canceled))
+	assert.False(t, IsRetryable(errors.Wrap(context.DeadlineExceeded, "failed on get block")))
+	assert.False(t, IsRetryable(codeError{code: 3, msg: "execution reverted"}))
+	assert.False(t, IsRetryable(codeError{code: -32601, msg: "method not found"}))
+	assert.False(t, IsRetryable(rpc.HTTPError{StatusCode: http.StatusUnauthorized, Status: "401 Unauthorized"}))
+
+	assert.True(t, IsRetryable(rpc.HTTPError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"}))
+	assert.True(t, IsRetryable(errors.Wrap(rpc.HTTPError{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"}, "failed on get events")))
+	assert.True(t, IsRetryable(codeError{code: -32005, msg: "limit exceeded"}))
+	assert.True(t, IsRetryable(errors.Wrap(ethereum.NotFound, "failed on get block header")))
+	assert.True(t, IsRetryable(errors.New("dial tcp: connection refused")))
+}
+
+func TestMiddlewareRetry(t *testing.T) {
+	client := &fakeClient{name: "node", head: 100}
+	counters := &Counters{}
+	m := NewMiddleware(client, nil, &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}, counters)
+
+	// 可重试的错误重试到次数上限
+	client.setErr(rpc.HTTPError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"})
+	_, err := m.BlockNumber()
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, client.callCount())
+	assert.Equal(t, int64(2), counters.Retries.Load())
+	assert.Equal(t, int64(1), counters.Failures.Load())
+
+	// 不可重试的错误立即返回
+	client.setErr(errors.New("execution reverted"))
+	_, err = m.CallContract(context.Background(), ethereum.CallMsg{}, nil)
+	assert.EqualError(t, err, "execution reverted")
+	assert.Equal(t, 4, client.callCount())
+
+	client.setErr(nil)
+	head, err := m.BlockNumber()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), head)
+	assert.Equal(t, int64(5), counters.Requests.Load())
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	client := &fakeClient{name: "node", head: 100}
+	m := NewMiddleware(client, &RateLimit{Rate: 100, Burst: 2}, nil, nil)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err := m.BlockNumber()
+		assert.Nil(t, err)
+	}
+	// 超过突发容量的请求按速率等待令牌
+	assert.True(t, time.Since(start) >= 15*time.Millisecond)
+	assert.Equal(t, int64(2), m.Counters().Throttled.Load())
+
+	// 等待令牌时请求被取消
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		_, err := m.CallContract(ctx, ethereum.CallMsg{}, nil)
+		if err != nil {
+			assert.Equal(t, 4+i, client.callCount())
+			return
+		}
+	}
+	t.Fatal("expected canceled request to fail")
+}
@@ -0,0 +1,374 @@
+package chainclient
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+
+	"github.com/ProjectsTask/EasySwapBase/chain/chainclient/evmclient"
+	logTypes "github.com/ProjectsTask/EasySwapBase/chain/types"
+)
+
+const (
+	defaultMaxHeadLag  = 5
+	defaultHeadRefresh = 5 * time.Second
+	defaultCooldown    = 30 * time.Second
+
+	// 连续失败次数达到该值后节点进入冷却期
+	maxConsecutiveFailures = 3
+	// 延迟及错误率的指数加权移动平均系数
+	ewmaAlpha = 0.2
+	// 错误率对评分的放大倍数，错误率为10%时评分约为延迟的2倍
+	errorRateWeight = 10
+)
+
+// PoolConfig 多节点连接池配置，零值使用默认值
+type PoolConfig struct {
+	MaxHeadLag  uint64        // FilterLogs允许使用的节点最多落后最高区块的数量
+	HeadRefresh time.Duration // 各节点区块高度的刷新间隔
+	Cooldown    time.Duration // 节点连续失败后暂停使用的时长
+}
+
+func (c PoolConfig) withDefaults() PoolConfig {
+	if c.MaxHeadLag == 0 {
+		c.MaxHeadLag = defaultMaxHeadLag
+	}
+	if c.HeadRefresh <= 0 {
+		c.HeadRefresh = defaultHeadRefresh
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = defaultCooldown
+	}
+	return c
+}
+
+// endpoint 单个节点及其健康状态
+type endpoint struct {
+	index     int
+	client    ChainClient
+	latency   float64 // 成功请求的平均延迟(毫秒)
+	errRate   float64 // 请求失败率
+	failures  int     // 连续失败次数
+	downUntil time.Time
+	head      uint64 // 最近一次获取到的区块高度
+}
+
+// score 评分越低越优先
+func (e *endpoint) score() float64 {
+	return e.latency * (1 + errorRateWeight*e.errRate)
+}
+
+// EndpointStats 节点健康状态
+type EndpointStats struct {
+	Index     int
+	Latency   time.Duration
+	ErrorRate float64
+	Head      uint64
+	Available bool
+}
+
+// Pool 包装多个节点的ChainClient:
+// - 按延迟和错误率为节点评分，请求优先发送到评分最好的节点，失败时依次切换到其他节点
+// - 连续失败的节点进入冷却期，冷却期内仅在其他节点都失败时使用
+// - FilterLogs只使用区块高度不落后于最高节点MaxHeadLag个区块且已同步到查询范围的节点，避免返回不完整的日志
+type Pool struct {
+	cfg       PoolConfig
+	mu        sync.Mutex
+	endpoints []*endpoint
+	headsAt   time.Time // 最近一次刷新各节点区块高度的时间
+	refreshMu sync.Mutex
+}
+
+// NewPool 为每个节点地址创建evm客户端，nodeUrls的顺序作为评分相同时的优先级
+func NewPool(chainID int, nodeUrls []string, cfg PoolConfig) (*Pool, error) {
+	if len(nodeUrls) == 0 {
+		return nil, errors.New("no rpc endpoint")
+	}
+
+	clients := make([]ChainClient, 0, len(nodeUrls))
+	for i, url := range nodeUrls {
+		client, err := evmclient.New(chainID, url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed on create client for endpoint %d", i)
+		}
+		clients = append(clients, client)
+	}
+
+	return newPool(clients, cfg), nil
+}
+
+func newPool(clients []ChainClient, cfg PoolConfig) *Pool {
+	p := &Pool{cfg: cfg.withDefaults()}
+	for i, client := range clients {
+		p.endpoints = append(p.endpoints, &endpoint{index: i, client: client})
+	}
+	return p
+}
+
+// Stats 返回各节点的健康状态，按nodeUrls的顺序排列
+func (p *Pool) Stats() []EndpointStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]EndpointStats, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		stats = append(stats, EndpointStats{
+			Index:     e.index,
+			Latency:   time.Duration(e.latency * float64(time.Millisecond)),
+			ErrorRate: e.errRate,
+			Head:      e.head,
+			Available: !now.Before(e.downUntil),
+		})
+	}
+	return stats
+}
+
+// ranked 返回区块高度不低于minHead的节点，可用节点按评分排序在前，冷却中的节点在后
+func (p *Pool) ranked(minHead uint64) []*endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var candidates []*endpoint
+	for _, e := range p.endpoints {
+		if minHead > 0 && e.head < minHead {
+			continue
+		}
+		candidates = append(candidates, e)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		iDown, jDown := now.Before(candidates[i].downUntil), now.Before(candidates[j].downUntil)
+		if iDown != jDown {
+			return jDown
+		}
+		return candidates[i].score() < candidates[j].score()
+	})
+	return candidates
+}
+
+// record 记录一次请求的结果，更新节点的延迟、错误率及冷却状态
+func (p *Pool) record(e *endpoint, elapsed time.Duration, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if failed {
+		e.errRate = e.errRate*(1-ewmaAlpha) + ewmaAlpha
+		e.failures++
+		if e.failures >= maxConsecutiveFailures {
+			e.downUntil = time.Now().Add(p.cfg.Cooldown)
+		}
+		return
+	}
+
+	ms := float64(elapsed) / float64(time.Millisecond)
+	if e.latency == 0 {
+		e.latency = ms
+	} else {
+		e.latency = e.latency*(1-ewmaAlpha) + ms*ewmaAlpha
+	}
+	e.errRate = e.errRate * (1 - ewmaAlpha)
+	e.failures = 0
+	e.downUntil = time.Time{}
+}
+
+func (p *Pool) setHead(e *endpoint, head uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if head > e.head {
+		e.head = head
+	}
+}
+
+func (p *Pool) maxHead() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var head uint64
+	for _, e := range p.endpoints {
+		if e.head > head {
+			head = e.head
+		}
+	}
+	return head
+}
+
+// refreshHeads 并发获取各节点的区块高度，距上次刷新不足HeadRefresh且force为false时跳过
+func (p *Pool) refreshHeads(force bool) {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	p.mu.Lock()
+	fresh := time.Since(p.headsAt) < p.cfg.HeadRefresh
+	p.mu.Unlock()
+	if fresh && !force {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+			start := time.Now()
+			head, err := e.client.BlockNumber()
+			p.record(e, time.Since(start), err != nil)
+			if err == nil {
+				p.setHead(e, head)
+			}
+		}(e)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	p.headsAt = time.Now()
+	p.mu.Unlock()
+}
+
+// do 按评分依次在节点上执行fn，直到成功、调用本身出错或所有节点都失败
+func (p *Pool) do(ctx context.Context, endpoints []*endpoint, fn func(e *endpoint) error) error {
+	if len(endpoints) == 0 {
+		return errors.New("no available rpc endpoint")
+	}
+
+	var lastErr error
+	for _, e := range endpoints {
+		start := time.Now()
+		err := fn(e)
+		if err == nil {
+			p.record(e, time.Since(start), false)
+			return nil
+		}
+		// 请求被取消时不计入节点失败
+		if ctx.Err() != nil {
+			return err
+		}
+		// 节点正常响应但调用失败，换节点也会得到同样的结果
+		if isCallError(err) {
+			p.record(e, time.Since(start), false)
+			return err
+		}
+
+		p.record(e, time.Since(start), true)
+		lastErr = errors.Wrapf(err, "endpoint %d", e.index)
+	}
+
+	return errors.Wrap(lastErr, "all rpc endpoints failed")
+}
+
+// isCallError 节点返回的确定性错误，如合约执行revert或参数错误
+func isCallError(err error) bool {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.ErrorCode() {
+		case 3, -32602: // execution reverted, invalid params
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "execution reverted")
+}
+
+func (p *Pool) FilterLogs(ctx context.Context, q logTypes.FilterQuery) ([]interface{}, error) {
+	p.refreshHeads(false)
+	// 查询范围超过已知的最高区块时，各节点的区块高度可能已过时
+	if q.ToBlock != nil && q.ToBlock.IsUint64() && q.ToBlock.Uint64() > p.maxHead() {
+		p.refreshHeads(true)
+	}
+
+	var minHead uint64
+	if maxHead := p.maxHead(); maxHead > p.cfg.MaxHeadLag {
+		minHead = maxHead - p.cfg.MaxHeadLag
+	}
+	if q.ToBlock != nil && q.ToBlock.IsUint64() && q.ToBlock.Uint64() > minHead {
+		minHead = q.ToBlock.Uint64()
+	}
+
+	var logs []interface{}
+	err := p.do(ctx, p.ranked(minHead), func(e *endpoint) error {
+		var err error
+		logs, err = e.client.FilterLogs(ctx, q)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed on filter logs, min head: %d", minHead)
+	}
+	return logs, nil
+}
+
+func (p *Pool) BlockTimeByNumber(ctx context.Context, blockNum *big.Int) (uint64, error) {
+	var blockTime uint64
+	err := p.do(ctx, p.ranked(0), func(e *endpoint) error {
+		var err error
+		blockTime, err = e.client.BlockTimeByNumber(ctx, blockNum)
+		return err
+	})
+	return blockTime, err
+}
+
+func (p *Pool) BlockTimesByNumber(ctx context.Context, blockNums []*big.Int) ([]uint64, error) {
+	var blockTimes []uint64
+	err := p.do(ctx, p.ranked(0), func(e *endpoint) error {
+		var err error
+		blockTimes, err = e.client.BlockTimesByNumber(ctx, blockNums)
+		return err
+	})
+	return blockTimes, err
+}
+
+// Client 返回当前评分最好的节点的客户端
+func (p *Pool) Client() interface{} {
+	return p.ranked(0)[0].client.Client()
+}
+
+func (p *Pool) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	var result []byte
+	err := p.do(ctx, p.ranked(0), func(e *endpoint) error {
+		var err error
+		result, err = e.client.CallContract(ctx, msg, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+func (p *Pool) CallContractByChain(ctx context.Context, param logTypes.CallParam) (interface{}, error) {
+	var result interface{}
+	err := p.do(ctx, p.ranked(0), func(e *endpoint) error {
+		var err error
+		result, err = e.client.CallContractByChain(ctx, param)
+		return err
+	})
+	return result, err
+}
+
+func (p *Pool) BlockNumber() (uint64, error) {
+	var head uint64
+	err := p.do(context.Background(), p.ranked(0), func(e *endpoint) error {
+		var err error
+		head, err = e.client.BlockNumber()
+		if err == nil {
+			p.setHead(e, head)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return head, nil
+}
+
+func (p *Pool) BlockWithTxs(ctx context.Context, blockNumber uint64) (interface{}, error) {
+	var block interface{}
+	err := p.do(ctx, p.ranked(0), func(e *endpoint) error {
+		var err error
+		block, err = e.client.BlockWithTxs(ctx, blockNumber)
+		return err
+	})
+	return block, err
+}
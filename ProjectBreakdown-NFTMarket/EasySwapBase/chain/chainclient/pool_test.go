@@ -0,0 +1,157 @@
+package chainclient
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	logTypes "github.com/ProjectsTask/EasySwapBase/chain/types"
+)
+
+type fakeClient struct {
+	mu    sync.Mutex
+	name  string
+	head  uint64
+	delay time.Duration
+	err   error
+	calls int
+}
+
+func (c *fakeClient) call() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	time.Sleep(c.delay)
+	return c.err
+}
+
+func (c *fakeClient) setErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func (c *fakeClient) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func (c *fakeClient) FilterLogs(ctx context.Context, q logTypes.FilterQuery) ([]interface{}, error) {
+	if err := c.call(); err != nil {
+		return nil, err
+	}
+	return []interface{}{c.name}, nil
+}
+
+func (c *fakeClient) BlockTimeByNumber(ctx context.Context, n *big.Int) (uint64, error) {
+	return 0, c.call()
+}
+
+func (c *fakeClient) BlockTimesByNumber(ctx context.Context, n []*big.Int) ([]uint64, error) {
+	return nil, c.call()
+}
+
+func (c *fakeClient) Client() interface{} {
+	return c.name
+}
+
+func (c *fakeClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if err := c.call(); err != nil {
+		return nil, err
+	}
+	return []byte(c.name), nil
+}
+
+func (c *fakeClient) CallContractByChain(ctx context.Context, param logTypes.CallParam) (interface{}, error) {
+	return c.CallContract(ctx, param.EVMParam, param.BlockNumber)
+}
+
+func (c *fakeClient) BlockNumber() (uint64, error) {
+	if err := c.call(); err != nil {
+		return 0, err
+	}
+	return c.head, nil
+}
+
+func (c *fakeClient) BlockWithTxs(ctx context.Context, blockNumber uint64) (interface{}, error) {
+	return nil, c.call()
+}
+
+func TestPoolFailover(t *testing.T) {
+	slow := &fakeClient{name: "slow", head: 100, delay: 5 * time.Millisecond}
+	fast := &fakeClient{name: "fast", head: 100}
+	p := newPool([]ChainClient{slow, fast}, PoolConfig{Cooldown: time.Minute})
+
+	// 评分相同时按配置顺序，之后优先延迟更低的节点
+	_, err := p.BlockNumber()
+	assert.Nil(t, err)
+	res, err := p.CallContract(context.Background(), ethereum.CallMsg{}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "fast", string(res))
+
+	// 节点失败时切换到下一个节点
+	fast.setErr(errors.New("502 bad gateway"))
+	res, err = p.CallContract(context.Background(), ethereum.CallMsg{}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "slow", string(res))
+
+	// 合约revert不切换节点
+	slowCalls := slow.callCount()
+	fast.setErr(errors.New("execution reverted"))
+	_, err = p.CallContract(context.Background(), ethereum.CallMsg{}, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, slowCalls, slow.callCount())
+
+	// 连续失败后进入冷却期，冷却期内优先使用其他节点
+	fast.setErr(errors.New("connection refused"))
+	for i := 0; i < maxConsecutiveFailures; i++ {
+		_, _ = p.CallContract(context.Background(), ethereum.CallMsg{}, nil)
+	}
+	assert.False(t, p.Stats()[1].Available)
+	fast.setErr(nil)
+	fastCalls := fast.callCount()
+	res, err = p.CallContract(context.Background(), ethereum.CallMsg{}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "slow", string(res))
+	assert.Equal(t, fastCalls, fast.callCount())
+
+	// 所有节点都失败时返回错误
+	slow.setErr(errors.New("timeout"))
+	fast.setErr(errors.New("timeout"))
+	_, err = p.CallContract(context.Background(), ethereum.CallMsg{}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestPoolFilterLogsHeadLag(t *testing.T) {
+	lagging := &fakeClient{name: "lagging", head: 90}
+	synced := &fakeClient{name: "synced", head: 100, delay: 2 * time.Millisecond}
+	p := newPool([]ChainClient{lagging, synced}, PoolConfig{MaxHeadLag: 5})
+
+	// 落后过多的节点即使延迟更低也不用于查询日志
+	logs, err := p.FilterLogs(context.Background(), logTypes.FilterQuery{FromBlock: big.NewInt(80), ToBlock: big.NewInt(85)})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"synced"}, logs)
+
+	// 查询范围超过节点区块高度时不使用该节点
+	lagging.head = 98
+	p.refreshHeads(true)
+	logs, err = p.FilterLogs(context.Background(), logTypes.FilterQuery{FromBlock: big.NewInt(96), ToBlock: big.NewInt(99)})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"synced"}, logs)
+	logs, err = p.FilterLogs(context.Background(), logTypes.FilterQuery{FromBlock: big.NewInt(90), ToBlock: big.NewInt(95)})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"lagging"}, logs)
+
+	// 没有满足区块高度的节点时返回错误
+	synced.setErr(errors.New("timeout"))
+	_, err = p.FilterLogs(context.Background(), logTypes.FilterQuery{FromBlock: big.NewInt(96), ToBlock: big.NewInt(99)})
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(p.ranked(101)))
+}
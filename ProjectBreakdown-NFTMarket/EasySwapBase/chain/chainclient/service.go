@@ -15,6 +15,8 @@ import (
 type ChainClient interface {
 	FilterLogs(ctx context.Context, q logTypes.FilterQuery) ([]interface{}, error)
 	BlockTimeByNumber(context.Context, *big.Int) (uint64, error)
+	// BlockTimesByNumber 批量获取区块时间戳，结果与区块号一一对应
+	BlockTimesByNumber(context.Context, []*big.Int) ([]uint64, error)
 	Client() interface{}
 	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
 	CallContractByChain(ctx context.Context, param logTypes.CallParam) (interface{}, error)
@@ -22,11 +24,87 @@ type ChainClient interface {
 	BlockWithTxs(ctx context.Context, blockNumber uint64) (interface{}, error)
 }
 
-func New(chainID int, nodeUrl string) (ChainClient, error) {
+type options struct {
+	backupUrls []string
+	poolCfg    PoolConfig
+	rateLimit  *RateLimit
+	retry      *RetryPolicy
+	counters   *Counters
+}
+
+type Option func(*options)
+
+// WithBackupUrls 备用节点，配置后使用多节点连接池，按节点健康状态路由请求并在失败时切换
+func WithBackupUrls(urls ...string) Option {
+	return func(o *options) {
+		o.backupUrls = append(o.backupUrls, urls...)
+	}
+}
+
+// WithPoolConfig 多节点连接池配置
+func WithPoolConfig(cfg PoolConfig) Option {
+	return func(o *options) {
+		o.poolCfg = cfg
+	}
+}
+
+// WithRateLimit 每个节点独立的令牌桶限流
+func WithRateLimit(limit RateLimit) Option {
+	return func(o *options) {
+		o.rateLimit = &limit
+	}
+}
+
+// WithRetry 可重试的错误按指数退避重试，使用多节点连接池时在所有节点都失败后整体重试
+func WithRetry(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = &policy
+	}
+}
+
+// WithCounters 记录请求、重试、限流等待及失败次数
+func WithCounters(counters *Counters) Option {
+	return func(o *options) {
+		o.counters = counters
+	}
+}
+
+func New(chainID int, nodeUrl string, opts ...Option) (ChainClient, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	switch chainID {
-	case chain.EthChainID, chain.OptimismChainID, chain.SepoliaChainID:
-		return evmclient.New(nodeUrl)
+	case chain.EthChainID, chain.OptimismChainID, chain.SepoliaChainID,
+		chain.ArbitrumChainID, chain.BaseChainID, chain.ZkSyncEraChainID:
 	default:
 		return nil, errors.New("unsupported chain id")
 	}
+	if o.counters == nil {
+		o.counters = &Counters{}
+	}
+
+	nodeUrls := append([]string{nodeUrl}, o.backupUrls...)
+	clients := make([]ChainClient, 0, len(nodeUrls))
+	for i, url := range nodeUrls {
+		client, err := evmclient.New(chainID, url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed on create client for endpoint %d", i)
+		}
+		if o.rateLimit != nil {
+			clients = append(clients, NewMiddleware(client, o.rateLimit, nil, o.counters))
+			continue
+		}
+		clients = append(clients, client)
+	}
+
+	client := clients[0]
+	if len(clients) > 1 {
+		client = newPool(clients, o.poolCfg)
+	}
+	if o.retry != nil {
+		client = NewMiddleware(client, nil, o.retry, o.counters)
+	}
+	return client, nil
 }
@@ -9,17 +9,30 @@ import (
 )
 
 const (
-	Eth      = "eth"
-	Optimism = "optimism"
-	Sepolia  = "sepolia"
+	Eth       = "eth"
+	Optimism  = "optimism"
+	Sepolia   = "sepolia"
+	Arbitrum  = "arbitrum"
+	Base      = "base"
+	ZkSyncEra = "zksync-era"
 )
 
+// L2上的区块号均为L2区块号。注意Arbitrum合约中的block.number返回的是L1区块号，订单有效期等逻辑统一使用时间戳
 const (
-	EthChainID      = 1
-	OptimismChainID = 10
-	SepoliaChainID  = 11155111
+	EthChainID       = 1
+	OptimismChainID  = 10
+	SepoliaChainID   = 11155111
+	ArbitrumChainID  = 42161
+	BaseChainID      = 8453
+	ZkSyncEraChainID = 324
 )
 
+// UnsupportedFullBlockChain 链上存在go-ethereum无法解析的自定义交易类型(Arbitrum的0x64~0x6a，zkSync Era的0x71/0xff)，
+// 无法通过eth_getBlockByNumber获取完整区块
+func UnsupportedFullBlockChain(chainID int) bool {
+	return chainID == ArbitrumChainID || chainID == ZkSyncEraChainID
+}
+
 func UniformAddress(chainName string, address string) (string, error) {
 	addr, err := eip.ToCheckSumAddress(address)
 	if err != nil {
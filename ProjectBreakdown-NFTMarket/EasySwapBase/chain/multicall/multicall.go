@@ -0,0 +1,230 @@
+package multicall
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+
+	"github.com/ProjectsTask/EasySwapBase/chain"
+)
+
+// DefaultAddress Multicall3在大部分EVM链上的部署地址
+const DefaultAddress = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+// zkSync Era的合约地址由字节码决定，与其他链不同
+const zkSyncEraAddress = "0xF9cda624FBC7e059355ce98a31693d299FACd963"
+
+const (
+	DefaultMaxCalls    = 500        // 单批最大调用数
+	DefaultMaxCallData = 128 * 1024 // 单批calldata最大字节数，避免超过节点的请求体限制
+	DefaultMaxGas      = 25_000_000 // 单批预估gas上限，geth默认的eth_call gas上限为50M
+	DefaultCallGas     = 100_000    // CallMsg.Gas为0时单个调用的预估gas
+
+	// aggregate3中每个Call3编码后除callData外的字节数: 数组元素偏移、target、allowFailure、callData偏移及长度
+	call3Overhead = 5 * 32
+)
+
+const multicall3Abi = `[{"inputs":[{"components":[{"internalType":"address","name":"target","type":"address"},{"internalType":"bool","name":"allowFailure","type":"bool"},{"internalType":"bytes","name":"callData","type":"bytes"}],"internalType":"struct Multicall3.Call3[]","name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"internalType":"bool","name":"success","type":"bool"},{"internalType":"bytes","name":"returnData","type":"bytes"}],"internalType":"struct Multicall3.Result[]","name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"}]`
+
+// Abi Multicall3的aggregate3方法
+var Abi = mustParseAbi(multicall3Abi)
+
+var (
+	// ErrNotDeployed 目标地址上没有Multicall3合约
+	ErrNotDeployed = errors.New("multicall3 is not deployed")
+	// ErrReverted 单个调用执行失败
+	ErrReverted = errors.New("execution reverted")
+)
+
+// 节点因请求过大或gas不足拒绝整批调用时返回的错误信息，此时拆分批次重试
+var oversizeMessages = []string{
+	"out of gas",
+	"gas limit",
+	"gas required exceeds",
+	"request entity too large",
+	"response size exceeded",
+}
+
+// Caller 执行eth_call的客户端，chainclient.ChainClient满足该接口
+type Caller interface {
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// Call aggregate3的单个调用
+type Call struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+// Result aggregate3的单个调用结果
+type Result struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// Err 调用失败时返回错误，能解析出revert原因时包含该原因
+func (r Result) Err() error {
+	if r.Success {
+		return nil
+	}
+	if reason, err := abi.UnpackRevert(r.ReturnData); err == nil {
+		return errors.Wrap(ErrReverted, reason)
+	}
+	return ErrReverted
+}
+
+// Options 批量调用配置，零值使用默认值
+type Options struct {
+	BlockNumber *big.Int // 查询的区块，为nil时查询最新区块
+	MaxCalls    int      // 单批最大调用数
+	MaxCallData int      // 单批calldata最大字节数
+	MaxGas      uint64   // 单批预估gas上限，按各CallMsg.Gas累加，为0的按DefaultCallGas估算
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxCalls <= 0 {
+		o.MaxCalls = DefaultMaxCalls
+	}
+	if o.MaxCallData <= 0 {
+		o.MaxCallData = DefaultMaxCallData
+	}
+	if o.MaxGas == 0 {
+		o.MaxGas = DefaultMaxGas
+	}
+	return o
+}
+
+// AddressOf 返回链上Multicall3的部署地址
+func AddressOf(chainID int) common.Address {
+	if chainID == chain.ZkSyncEraChainID {
+		return common.HexToAddress(zkSyncEraAddress)
+	}
+	return common.HexToAddress(DefaultAddress)
+}
+
+func mustParseAbi(raw string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(raw))
+	if err != nil {
+		panic(err)
+	}
+
+	return parsed
+}
+
+// Aggregate3 通过Multicall3批量执行只读调用，按调用数、calldata大小及预估gas拆分批次。
+// 返回结果与msgs一一对应，单个调用失败不影响其他调用，通过Result.Err获取失败原因；整批请求失败时返回错误
+func Aggregate3(ctx context.Context, client Caller, multicallAddr common.Address, msgs []ethereum.CallMsg,
+	opts Options) ([]Result, error) {
+	opts = opts.withDefaults()
+
+	calls := make([]Call, 0, len(msgs))
+	for i, msg := range msgs {
+		if msg.To == nil {
+			return nil, errors.Errorf("call %d has no target", i)
+		}
+		calls = append(calls, Call{Target: *msg.To, AllowFailure: true, CallData: msg.Data})
+	}
+
+	results := make([]Result, 0, len(calls))
+	for _, batch := range splitBatches(msgs, opts) {
+		batchResults, err := aggregate3(ctx, client, multicallAddr, calls[batch[0]:batch[1]], opts.BlockNumber)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batchResults...)
+	}
+
+	return results, nil
+}
+
+// splitBatches 返回各批次在msgs中的[start, end)区间
+func splitBatches(msgs []ethereum.CallMsg, opts Options) [][2]int {
+	var batches [][2]int
+	start, size := 0, 0
+	var gas uint64
+	for i, msg := range msgs {
+		callSize := call3Overhead + (len(msg.Data)+31)/32*32
+		callGas := msg.Gas
+		if callGas == 0 {
+			callGas = DefaultCallGas
+		}
+		// 单个调用超过上限时独占一批
+		if i > start && (i-start >= opts.MaxCalls || size+callSize > opts.MaxCallData || gas+callGas > opts.MaxGas) {
+			batches = append(batches, [2]int{start, i})
+			start, size, gas = i, 0, 0
+		}
+		size += callSize
+		gas += callGas
+	}
+	if start < len(msgs) {
+		batches = append(batches, [2]int{start, len(msgs)})
+	}
+
+	return batches
+}
+
+// aggregate3 执行单批调用，节点因请求过大或gas不足拒绝时拆为两半分别重试
+func aggregate3(ctx context.Context, client Caller, multicallAddr common.Address, calls []Call,
+	blockNumber *big.Int) ([]Result, error) {
+	data, err := Abi.Pack("aggregate3", calls)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed on pack aggregate3")
+	}
+	respData, err := client.CallContract(ctx, ethereum.CallMsg{To: &multicallAddr, Data: data}, blockNumber)
+	if err != nil {
+		if len(calls) > 1 && ctx.Err() == nil && isOversize(err) {
+			half := len(calls) / 2
+			first, err := aggregate3(ctx, client, multicallAddr, calls[:half], blockNumber)
+			if err != nil {
+				return nil, err
+			}
+			second, err := aggregate3(ctx, client, multicallAddr, calls[half:], blockNumber)
+			if err != nil {
+				return nil, err
+			}
+			return append(first, second...), nil
+		}
+		return nil, errors.Wrap(err, "failed on call aggregate3")
+	}
+	// 向没有代码的地址发起eth_call会成功但返回空数据
+	if len(respData) == 0 {
+		return nil, errors.Wrap(ErrNotDeployed, multicallAddr.Hex())
+	}
+
+	out, err := Abi.Unpack("aggregate3", respData)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed on unpack aggregate3")
+	}
+	if len(out) == 0 {
+		return nil, errors.New("empty aggregate3 result")
+	}
+
+	results := *abi.ConvertType(out[0], new([]Result)).(*[]Result)
+	if len(results) != len(calls) {
+		return nil, errors.Errorf("aggregate3 result count mismatch, expected %d, got %d", len(calls), len(results))
+	}
+
+	return results, nil
+}
+
+func isOversize(err error) bool {
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusRequestEntityTooLarge {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range oversizeMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
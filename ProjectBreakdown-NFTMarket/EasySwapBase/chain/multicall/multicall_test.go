@@ -0,0 +1,132 @@
+package multicall
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTarget = common.HexToAddress("0x00000000000000000000000000000000000000c1")
+
+// fakeCaller 模拟Multicall3，callData以0xff开头的调用revert，其余调用原样返回callData
+type fakeCaller struct {
+	maxCalls int // 单批调用数超过该值时返回out of gas，为0时不限制
+	empty    bool
+	requests []int
+}
+
+func (c *fakeCaller) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if c.empty {
+		return nil, nil
+	}
+	method, err := Abi.MethodById(msg.Data[:4])
+	if err != nil {
+		return nil, err
+	}
+	args, err := method.Inputs.Unpack(msg.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	calls := *abi.ConvertType(args[0], new([]Call)).(*[]Call)
+	if c.maxCalls != 0 && len(calls) > c.maxCalls {
+		return nil, errors.New("execution reverted: out of gas")
+	}
+	c.requests = append(c.requests, len(calls))
+
+	results := make([]Result, 0, len(calls))
+	for _, call := range calls {
+		if len(call.CallData) > 0 && call.CallData[0] == 0xff {
+			results = append(results, Result{ReturnData: revertData("not exist")})
+			continue
+		}
+		results = append(results, Result{Success: true, ReturnData: call.CallData})
+	}
+
+	return Abi.Methods["aggregate3"].Outputs.Pack(results)
+}
+
+func revertData(reason string) []byte {
+	stringType, _ := abi.NewType("string", "", nil)
+	data, _ := abi.Arguments{{Type: stringType}}.Pack(reason)
+	return append(crypto.Keccak256([]byte("Error(string)"))[:4], data...)
+}
+
+func newMsgs(n int, gas uint64, size int) []ethereum.CallMsg {
+	msgs := make([]ethereum.CallMsg, 0, n)
+	for i := 0; i < n; i++ {
+		data := bytes.Repeat([]byte{byte(i % 0xff)}, size)
+		msgs = append(msgs, ethereum.CallMsg{To: &testTarget, Gas: gas, Data: data})
+	}
+	return msgs
+}
+
+func TestAggregate3(t *testing.T) {
+	client := &fakeCaller{}
+	msgs := newMsgs(DefaultMaxCalls+1, 1, 4)
+	msgs[1].Data = []byte{0xff}
+
+	results, err := Aggregate3(context.Background(), client, AddressOf(1), msgs, Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{DefaultMaxCalls, 1}, client.requests)
+	assert.Len(t, results, DefaultMaxCalls+1)
+	// 结果与调用一一对应，单个调用失败不影响其他调用
+	assert.Nil(t, results[0].Err())
+	assert.Equal(t, msgs[0].Data, results[0].ReturnData)
+	assert.True(t, errors.Is(results[1].Err(), ErrReverted))
+	assert.Contains(t, results[1].Err().Error(), "not exist")
+	assert.Equal(t, msgs[DefaultMaxCalls].Data, results[DefaultMaxCalls].ReturnData)
+
+	// 没有目标地址的调用
+	_, err = Aggregate3(context.Background(), client, AddressOf(1), []ethereum.CallMsg{{}}, Options{})
+	assert.NotNil(t, err)
+
+	// 目标地址上没有合约
+	_, err = Aggregate3(context.Background(), &fakeCaller{empty: true}, AddressOf(1), msgs[:1], Options{})
+	assert.True(t, errors.Is(err, ErrNotDeployed))
+}
+
+func TestSplitBatches(t *testing.T) {
+	// 按调用数拆分
+	assert.Equal(t, [][2]int{{0, 2}, {2, 4}, {4, 5}}, splitBatches(newMsgs(5, 1, 4), Options{MaxCalls: 2}.withDefaults()))
+
+	// 按预估gas拆分，未设置gas的调用按DefaultCallGas估算
+	assert.Equal(t, [][2]int{{0, 2}, {2, 3}}, splitBatches(newMsgs(3, 10_000_000, 4), Options{}.withDefaults()))
+	assert.Equal(t, [][2]int{{0, 3}, {3, 4}}, splitBatches(newMsgs(4, 0, 4), Options{MaxGas: 3 * DefaultCallGas}.withDefaults()))
+
+	// 按calldata大小拆分，超过上限的单个调用独占一批
+	msgs := newMsgs(3, 1, 1000)
+	assert.Equal(t, [][2]int{{0, 2}, {2, 3}}, splitBatches(msgs, Options{MaxCallData: 3000}.withDefaults()))
+	assert.Equal(t, [][2]int{{0, 1}, {1, 2}, {2, 3}}, splitBatches(msgs, Options{MaxCallData: 100}.withDefaults()))
+
+	assert.Empty(t, splitBatches(nil, Options{}.withDefaults()))
+}
+
+func TestAggregate3Oversize(t *testing.T) {
+	// 节点拒绝整批调用时拆为两半重试
+	client := &fakeCaller{maxCalls: 3}
+	msgs := newMsgs(10, 1, 4)
+	results, err := Aggregate3(context.Background(), client, AddressOf(1), msgs, Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 3, 2, 3}, client.requests)
+	for i := range msgs {
+		assert.Equal(t, msgs[i].Data, results[i].ReturnData)
+	}
+
+	// 单个调用仍失败时返回错误
+	client = &fakeCaller{maxCalls: -1}
+	_, err = Aggregate3(context.Background(), client, AddressOf(1), msgs[:1], Options{})
+	assert.NotNil(t, err)
+}
+
+func TestAddressOf(t *testing.T) {
+	assert.Equal(t, common.HexToAddress(DefaultAddress), AddressOf(1))
+	assert.Equal(t, common.HexToAddress(zkSyncEraAddress), AddressOf(324))
+}
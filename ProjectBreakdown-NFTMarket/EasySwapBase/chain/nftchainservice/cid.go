@@ -0,0 +1,227 @@
+package nftchainservice
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+)
+
+// multicodec编码，见https://github.com/multiformats/multicodec
+const (
+	codecIdentity = 0x00
+	codecSha256   = 0x12
+	codecRaw      = 0x55
+	codecDagPb    = 0x70
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var (
+	ErrInvalidCID  = errors.New("invalid ipfs cid")
+	ErrCIDMismatch = errors.New("content does not match ipfs cid")
+
+	base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+)
+
+// cid IPFS内容标识，CIDv0为base58btc编码的sha2-256 multihash，CIDv1为<版本><内容编码><multihash>
+type cid struct {
+	codec     uint64
+	hashCode  uint64
+	digest    []byte
+	multihash []byte
+}
+
+// parseCID 解析CIDv0及base32/base58btc/base16编码的CIDv1
+func parseCID(s string) (*cid, error) {
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		data, err := decodeBase58(s)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidCID, err.Error())
+		}
+		c, err := parseMultihash(data)
+		if err != nil || c.hashCode != codecSha256 {
+			return nil, errors.Wrapf(ErrInvalidCID, "invalid cidv0 %s", s)
+		}
+		c.codec = codecDagPb
+		return c, nil
+	}
+
+	if len(s) < 2 {
+		return nil, errors.Wrapf(ErrInvalidCID, "cid too short: %s", s)
+	}
+	var data []byte
+	var err error
+	switch s[0] {
+	case 'b':
+		data, err = base32Lower.DecodeString(s[1:])
+	case 'B':
+		data, err = base32Lower.DecodeString(strings.ToLower(s[1:]))
+	case 'z':
+		data, err = decodeBase58(s[1:])
+	case 'f', 'F':
+		data, err = hexutil.Decode("0x" + strings.ToLower(s[1:]))
+	default:
+		return nil, errors.Wrapf(ErrInvalidCID, "unsupported multibase %q", s[0])
+	}
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCID, err.Error())
+	}
+
+	version, n := binary.Uvarint(data)
+	if n <= 0 || version != 1 {
+		return nil, errors.Wrapf(ErrInvalidCID, "unsupported cid version: %s", s)
+	}
+	codec, m := binary.Uvarint(data[n:])
+	if m <= 0 {
+		return nil, errors.Wrapf(ErrInvalidCID, "invalid cid codec: %s", s)
+	}
+	c, err := parseMultihash(data[n+m:])
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidCID, "%s: %s", err.Error(), s)
+	}
+	c.codec = codec
+
+	return c, nil
+}
+
+func parseMultihash(data []byte) (*cid, error) {
+	hashCode, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, errors.New("invalid multihash code")
+	}
+	length, m := binary.Uvarint(data[n:])
+	if m <= 0 || uint64(len(data)-n-m) != length {
+		return nil, errors.New("invalid multihash length")
+	}
+	if hashCode == codecSha256 && length != sha256.Size {
+		return nil, errors.New("invalid sha2-256 digest length")
+	}
+
+	return &cid{hashCode: hashCode, digest: data[n+m:], multihash: data}, nil
+}
+
+// key 缓存键，同一内容的CIDv0与CIDv1对应同一个键
+func (c *cid) key() string {
+	return fmt.Sprintf("ipfs/%x/%x", c.codec, c.multihash)
+}
+
+// inline identity哈希的CID直接包含内容，无需请求网关
+func (c *cid) inline() bool {
+	return c.hashCode == codecIdentity && c.codec == codecRaw
+}
+
+// verifiable 是否可以通过请求原始区块校验内容
+func (c *cid) verifiable() bool {
+	return c.hashCode == codecSha256 && (c.codec == codecRaw || c.codec == codecDagPb)
+}
+
+// verify 校验区块内容的哈希与CID一致
+func (c *cid) verify(block []byte) error {
+	if c.hashCode != codecSha256 {
+		return errors.Errorf("unsupported multihash 0x%x", c.hashCode)
+	}
+	digest := sha256.Sum256(block)
+	if !bytes.Equal(digest[:], c.digest) {
+		return ErrCIDMismatch
+	}
+
+	return nil
+}
+
+// unixfsFileData 解析dag-pb区块中的UnixFS文件内容。文件超过单个区块大小时被拆分为多个子区块，chunked为true，
+// 此时返回的data不是完整内容
+func unixfsFileData(block []byte) (data []byte, chunked bool, err error) {
+	var pbData []byte
+	var hasData bool
+	err = walkProtobuf(block, func(field uint64, value []byte) {
+		switch field {
+		case 1: // PBNode.Data
+			pbData, hasData = value, true
+		case 2: // PBNode.Links
+			chunked = true
+		}
+	})
+	if err != nil {
+		return nil, false, errors.Wrap(err, "invalid dag-pb block")
+	}
+	if !hasData {
+		return nil, false, errors.New("dag-pb block is not a unixfs node")
+	}
+
+	fileType := uint64(0)
+	err = walkProtobuf(pbData, func(field uint64, value []byte) {
+		switch field {
+		case 1: // Data.Type
+			fileType, _ = binary.Uvarint(value)
+		case 2: // Data.Data
+			data = value
+		}
+	})
+	if err != nil {
+		return nil, false, errors.Wrap(err, "invalid unixfs data")
+	}
+	if fileType != 0 && fileType != 2 { // Raw, File
+		return nil, false, errors.Errorf("unixfs node type %d is not a file", fileType)
+	}
+
+	return data, chunked, nil
+}
+
+// walkProtobuf 遍历protobuf消息中的varint及length-delimited字段，varint字段的value为其原始编码
+func walkProtobuf(msg []byte, fn func(field uint64, value []byte)) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+		msg = msg[n:]
+
+		switch key & 0x7 {
+		case 0:
+			_, m := binary.Uvarint(msg)
+			if m <= 0 {
+				return errors.New("invalid varint field")
+			}
+			fn(key>>3, msg[:m])
+			msg = msg[m:]
+		case 2:
+			length, m := binary.Uvarint(msg)
+			if m <= 0 || uint64(len(msg)-m) < length {
+				return errors.New("invalid length-delimited field")
+			}
+			fn(key>>3, msg[m:m+int(length)])
+			msg = msg[m+int(length):]
+		default:
+			return errors.Errorf("unsupported wire type %d", key&0x7)
+		}
+	}
+
+	return nil
+}
+
+func decodeBase58(s string) ([]byte, error) {
+	num := new(big.Int)
+	radix := big.NewInt(58)
+	for _, r := range s {
+		idx := strings.IndexRune(base58Alphabet, r)
+		if idx < 0 {
+			return nil, errors.Errorf("invalid base58 character %q", r)
+		}
+		num.Mul(num, radix)
+		num.Add(num, big.NewInt(int64(idx)))
+	}
+
+	leadingZeros := 0
+	for leadingZeros < len(s) && s[leadingZeros] == '1' {
+		leadingZeros++
+	}
+
+	return append(make([]byte, leadingZeros), num.Bytes()...), nil
+}
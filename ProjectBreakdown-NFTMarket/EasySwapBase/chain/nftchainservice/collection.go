@@ -0,0 +1,94 @@
+package nftchainservice
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+const (
+	TokenStandardUnknown = 0
+	TokenStandardERC721  = 1
+	TokenStandardERC1155 = 2
+)
+
+var (
+	InterfaceIdERC721  = [4]byte{0x80, 0xac, 0x58, 0xcd}
+	InterfaceIdERC1155 = [4]byte{0xd9, 0xb6, 0x7a, 0x26}
+)
+
+// CollectionInfo collection的链上基础信息
+type CollectionInfo struct {
+	TokenStandard int64
+	Name          string
+	Symbol        string
+	TotalSupply   *big.Int // 未实现ERC721Enumerable时为nil
+}
+
+// FetchTokenStandard 通过ERC-165判断合约实现的NFT标准
+func (s *Service) FetchTokenStandard(collectionAddr string) (int64, error) {
+	for _, standard := range []struct {
+		interfaceId [4]byte
+		standard    int64
+	}{
+		{InterfaceIdERC721, TokenStandardERC721},
+		{InterfaceIdERC1155, TokenStandardERC1155},
+	} {
+		res, err := s.callContract(collectionAddr, "supportsInterface", standard.interfaceId)
+		if err != nil {
+			return TokenStandardUnknown, errors.Wrap(err, "failed on call supportsInterface")
+		}
+
+		if supported, ok := res[0].(bool); ok && supported {
+			return standard.standard, nil
+		}
+	}
+
+	return TokenStandardUnknown, nil
+}
+
+// FetchCollectionInfo 获取collection的标准、名称、符号和发行量，name/symbol/totalSupply是可选接口，调用失败时忽略
+func (s *Service) FetchCollectionInfo(collectionAddr string) (*CollectionInfo, error) {
+	standard, err := s.FetchTokenStandard(collectionAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed on fetch token standard")
+	}
+
+	info := CollectionInfo{TokenStandard: standard}
+	if res, err := s.callContract(collectionAddr, "name"); err == nil {
+		info.Name, _ = res[0].(string)
+	}
+	if res, err := s.callContract(collectionAddr, "symbol"); err == nil {
+		info.Symbol, _ = res[0].(string)
+	}
+	if res, err := s.callContract(collectionAddr, "totalSupply"); err == nil {
+		info.TotalSupply, _ = res[0].(*big.Int)
+	}
+
+	return &info, nil
+}
+
+func (s *Service) callContract(contractAddr string, method string, args ...interface{}) ([]interface{}, error) {
+	reqData, err := s.Abi.Pack(method, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed on pack %s", method)
+	}
+
+	to := common.HexToAddress(contractAddr)
+	respData, err := s.NodeClient.CallContract(s.ctx, ethereum.CallMsg{To: &to, Data: reqData}, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed on request %s", method)
+	}
+
+	res, err := s.Abi.Unpack(method, respData)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed on unpack %s", method)
+	}
+	if len(res) == 0 {
+		return nil, errors.Errorf("empty %s result", method)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,114 @@
+package nftchainservice
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+const erc1155Abi = `[{"inputs":[{"internalType":"uint256","name":"id","type":"uint256"}],"name":"uri","outputs":[{"internalType":"string","name":"","type":"string"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"address","name":"account","type":"address"},{"internalType":"uint256","name":"id","type":"uint256"}],"name":"balanceOf","outputs":[{"internalType":"uint256","name":"","type":"uint256"}],"stateMutability":"view","type":"function"},{"anonymous":false,"inputs":[{"indexed":true,"internalType":"address","name":"operator","type":"address"},{"indexed":true,"internalType":"address","name":"from","type":"address"},{"indexed":true,"internalType":"address","name":"to","type":"address"},{"indexed":false,"internalType":"uint256","name":"id","type":"uint256"},{"indexed":false,"internalType":"uint256","name":"value","type":"uint256"}],"name":"TransferSingle","type":"event"},{"anonymous":false,"inputs":[{"indexed":true,"internalType":"address","name":"operator","type":"address"},{"indexed":true,"internalType":"address","name":"from","type":"address"},{"indexed":true,"internalType":"address","name":"to","type":"address"},{"indexed":false,"internalType":"uint256[]","name":"ids","type":"uint256[]"},{"indexed":false,"internalType":"uint256[]","name":"values","type":"uint256[]"}],"name":"TransferBatch","type":"event"}]`
+
+const balanceOfGas = 30_000
+
+// Erc1155Abi ERC-1155的uri、balanceOf及转移事件
+var Erc1155Abi = mustParseAbi(erc1155Abi)
+
+var (
+	EVMTransferSingleTopic = Erc1155Abi.Events["TransferSingle"].ID
+	EVMTransferBatchTopic  = Erc1155Abi.Events["TransferBatch"].ID
+)
+
+// Holding 持有人及token
+type Holding struct {
+	Owner   string
+	TokenID string
+}
+
+func mustParseAbi(raw string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(raw))
+	if err != nil {
+		panic(err)
+	}
+
+	return parsed
+}
+
+// ReplaceTokenIDPlaceholder 按ERC-1155元数据规范，将uri中的{id}替换为64位小写十六进制的tokenId，不带0x前缀
+func ReplaceTokenIDPlaceholder(uri, tokenID string) string {
+	if !strings.Contains(uri, "{id}") {
+		return uri
+	}
+	tokenId, ok := new(big.Int).SetString(tokenID, 10)
+	if !ok {
+		return uri
+	}
+
+	return strings.ReplaceAll(uri, "{id}", fmt.Sprintf("%064x", tokenId))
+}
+
+// FetchNftBalances 批量查询ERC-1155持有人在blockNumber时的余额，blockNumber为nil时查询最新区块。
+// 结果与holdings一一对应，单个查询失败时errs中对应的错误不为nil
+func (s *Service) FetchNftBalances(collectionAddr string, holdings []Holding, blockNumber *big.Int) ([]*big.Int, []error, error) {
+	args := make([][]interface{}, len(holdings))
+	errs := make([]error, len(holdings))
+	for i, holding := range holdings {
+		tokenId, ok := new(big.Int).SetString(holding.TokenID, 10)
+		if !ok {
+			errs[i] = errors.Errorf("invalid token id %s", holding.TokenID)
+			continue
+		}
+		args[i] = []interface{}{common.HexToAddress(holding.Owner), tokenId}
+	}
+
+	returnData, callErrs, err := s.batchCall(collectionAddr, &Erc1155Abi, "balanceOf", balanceOfGas, args, blockNumber)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed on request token balance")
+	}
+
+	balances := make([]*big.Int, len(holdings))
+	for i := range holdings {
+		if errs[i] != nil {
+			continue
+		}
+		if callErrs[i] != nil {
+			errs[i] = callErrs[i]
+			continue
+		}
+		res, err := Erc1155Abi.Unpack("balanceOf", returnData[i])
+		if err != nil {
+			errs[i] = errors.Wrap(err, "failed on unpack token balance")
+			continue
+		}
+		balances[i], _ = res[0].(*big.Int)
+	}
+
+	return balances, errs, nil
+}
+
+// unpackErc1155Transfer 解析TransferSingle/TransferBatch的data，返回tokenId及对应的数量
+func unpackErc1155Transfer(topic common.Hash, data []byte) ([]*big.Int, []*big.Int, error) {
+	switch topic {
+	case EVMTransferSingleTopic:
+		out, err := Erc1155Abi.Events["TransferSingle"].Inputs.NonIndexed().Unpack(data)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed on unpack TransferSingle")
+		}
+		return []*big.Int{out[0].(*big.Int)}, []*big.Int{out[1].(*big.Int)}, nil
+	case EVMTransferBatchTopic:
+		out, err := Erc1155Abi.Events["TransferBatch"].Inputs.NonIndexed().Unpack(data)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed on unpack TransferBatch")
+		}
+		ids, values := out[0].([]*big.Int), out[1].([]*big.Int)
+		if len(ids) != len(values) {
+			return nil, nil, errors.Errorf("TransferBatch ids and values length mismatch: %d, %d", len(ids), len(values))
+		}
+		return ids, values, nil
+	default:
+		return nil, nil, errors.Errorf("unknown erc1155 transfer topic %s", topic.Hex())
+	}
+}
@@ -0,0 +1,77 @@
+package nftchainservice
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	evmTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/ProjectsTask/EasySwapBase/chain"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+)
+
+func TestReplaceTokenIDPlaceholder(t *testing.T) {
+	assert.Equal(t, "https://token-cdn-domain/000000000000000000000000000000000000000000000000000000000004cce0.json",
+		ReplaceTokenIDPlaceholder("https://token-cdn-domain/{id}.json", "314592"))
+	assert.Equal(t, "ipfs://cid/1", ReplaceTokenIDPlaceholder("ipfs://cid/1", "1"))
+	assert.Equal(t, "https://a/{id}", ReplaceTokenIDPlaceholder("https://a/{id}", "abc"))
+}
+
+func erc1155Log(block uint64, index uint, topic common.Hash, from, to common.Address, data []byte) evmTypes.Log {
+	return evmTypes.Log{
+		Address: common.HexToAddress("0x1"),
+		Topics: []common.Hash{
+			topic,
+			common.HexToHash("0xf"), // operator
+			common.BytesToHash(from.Bytes()),
+			common.BytesToHash(to.Bytes()),
+		},
+		Data:        data,
+		BlockNumber: block,
+		Index:       index,
+	}
+}
+
+func TestGetNFTTransferEventErc1155(t *testing.T) {
+	alice, bob := common.HexToAddress("0xa1"), common.HexToAddress("0xb2")
+	single, err := Erc1155Abi.Events["TransferSingle"].Inputs.NonIndexed().Pack(big.NewInt(7), big.NewInt(5))
+	assert.NoError(t, err)
+	batch, err := Erc1155Abi.Events["TransferBatch"].Inputs.NonIndexed().Pack(
+		[]*big.Int{big.NewInt(7), big.NewInt(8)}, []*big.Int{big.NewInt(2), big.NewInt(3)})
+	assert.NoError(t, err)
+
+	erc20 := transferLog(100, 3, 1)
+	erc20.Topics = erc20.Topics[:3]
+	logs := []evmTypes.Log{
+		erc1155Log(100, 0, EVMTransferSingleTopic, common.Address{}, alice, single),
+		erc1155Log(100, 1, EVMTransferBatchTopic, alice, bob, batch),
+		transferLog(100, 2, 9),
+		erc20,
+		erc1155Log(100, 4, EVMTransferSingleTopic, alice, bob, []byte{0x1}), // 无法解析的日志被跳过
+	}
+	s := &Service{ctx: context.Background(), NodeClient: &fakeNodeClient{logs: logs}, ChainName: chain.Sepolia}
+
+	result, err := s.getNFTTransferEvent(xzap.ToContext(context.Background(), zap.NewNop()), nil, 100, 100)
+	assert.NoError(t, err)
+	assert.Len(t, result, 4)
+
+	assert.True(t, result[0].IsErc1155)
+	assert.Equal(t, common.Address{}.String(), result[0].From)
+	assert.Equal(t, alice.String(), result[0].To)
+	assert.Equal(t, "7", result[0].TokenID)
+	assert.Equal(t, "5", result[0].Amount)
+
+	// TransferBatch按ids拆分，保持原有顺序
+	assert.Equal(t, []string{"7", "8"}, []string{result[1].TokenID, result[2].TokenID})
+	assert.Equal(t, []string{"2", "3"}, []string{result[1].Amount, result[2].Amount})
+	assert.Equal(t, alice.String(), result[1].From)
+	assert.Equal(t, bob.String(), result[2].To)
+
+	assert.False(t, result[3].IsErc1155)
+	assert.Equal(t, "9", result[3].TokenID)
+	assert.Equal(t, "1", result[3].Amount)
+}
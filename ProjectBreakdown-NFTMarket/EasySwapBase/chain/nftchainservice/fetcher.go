@@ -0,0 +1,468 @@
+package nftchainservice
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultHedgeDelay       = 500 * time.Millisecond
+	defaultFetchTimeout     = 30 * time.Second
+	defaultMaxContentSize   = 10 << 20 // tokenURI可能直接指向图片
+	defaultContentCacheSize = 64 << 20
+
+	rawBlockContentType = "application/vnd.ipld.raw"
+)
+
+var (
+	DefaultIpfsGateways    = []string{"https://ipfs.io", "https://dweb.link", "https://cf-ipfs.com", "https://infura-ipfs.io"}
+	DefaultArweaveGateways = []string{"https://arweave.net", "https://ar-io.net"}
+
+	ErrUnsupportedURI     = errors.New("unsupported token uri")
+	ErrContentTooLarge    = errors.New("content too large")
+	ErrUnsupportedContent = errors.New("unsupported content type")
+)
+
+// FetcherConfig 元数据拉取配置，零值字段使用默认值
+type FetcherConfig struct {
+	IpfsGateways    []string      // IPFS网关，按顺序对冲请求，可带或不带/ipfs/后缀
+	ArweaveGateways []string      // Arweave网关，按顺序对冲请求
+	HedgeDelay      time.Duration // 前一个网关未返回时，间隔多久向下一个网关发起请求
+	Timeout         time.Duration // 单个tokenURI的总超时
+	MaxSize         int64         // 内容的最大字节数
+	CacheSize       uint64        // 不可变内容缓存的最大字节数
+}
+
+func (c FetcherConfig) withDefaults() FetcherConfig {
+	if len(c.IpfsGateways) == 0 {
+		c.IpfsGateways = DefaultIpfsGateways
+	}
+	if len(c.ArweaveGateways) == 0 {
+		c.ArweaveGateways = DefaultArweaveGateways
+	}
+	if c.HedgeDelay <= 0 {
+		c.HedgeDelay = defaultHedgeDelay
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultFetchTimeout
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = defaultMaxContentSize
+	}
+	if c.CacheSize == 0 {
+		c.CacheSize = defaultContentCacheSize
+	}
+
+	return c
+}
+
+// Fetcher 读取tokenURI指向的内容，支持http、ipfs、ar及data URI。
+// ipfs与ar内容不可变，向多个网关对冲请求，取最先成功的结果并按内容哈希缓存。
+// ipfs内容只有在本地校验过哈希时才缓存，带子路径或被拆分为多个区块的文件无法校验，每次都从网关读取
+type Fetcher struct {
+	cfg             FetcherConfig
+	client          *http.Client
+	ipfsGateways    []string
+	arweaveGateways []string
+	cache           *lru.SizeConstrainedCache[string, []byte]
+}
+
+func NewFetcher(client *http.Client, cfg FetcherConfig) *Fetcher {
+	cfg = cfg.withDefaults()
+	return &Fetcher{
+		cfg:             cfg,
+		client:          client,
+		ipfsGateways:    normalizeGateways(cfg.IpfsGateways, "/ipfs"),
+		arweaveGateways: normalizeGateways(cfg.ArweaveGateways, ""),
+		cache:           lru.NewSizeConstrainedCache[string, []byte](cfg.CacheSize),
+	}
+}
+
+func normalizeGateways(gateways []string, suffix string) []string {
+	var result []string
+	seen := make(map[string]struct{})
+	for _, gateway := range gateways {
+		gateway = strings.TrimRight(strings.TrimSpace(gateway), "/")
+		gateway = strings.TrimSuffix(gateway, suffix)
+		if _, ok := seen[gateway]; ok || gateway == "" {
+			continue
+		}
+		seen[gateway] = struct{}{}
+		result = append(result, gateway)
+	}
+
+	return result
+}
+
+type resourceKind int
+
+const (
+	resourceHTTP resourceKind = iota
+	resourceData
+	resourceIpfs
+	resourceArweave
+)
+
+// resource 规范化后的tokenURI
+type resource struct {
+	kind   resourceKind
+	url    string // http地址
+	data   []byte // data URI解码后的内容
+	cid    *cid
+	id     string // ipfs的cid或arweave的交易id
+	path   string // id之后的路径，为空或以/开头
+	origin string // tokenURI本身指向的网关，优先请求
+}
+
+func (r *resource) cacheKey() string {
+	if r.kind == resourceIpfs {
+		return r.cid.key() + r.path
+	}
+	return "ar/" + r.id + r.path
+}
+
+// parseResource 规范化tokenURI：
+//   - ipfs://<cid>/path、ipfs://ipfs/<cid>/path、/ipfs/<cid>/path、裸cid及http网关地址统一为ipfs资源
+//   - ar://<id>/path及已配置的Arweave网关地址统一为arweave资源
+//   - data URI按RFC 2397解码
+func (f *Fetcher) parseResource(uri string) (*resource, error) {
+	uri = strings.TrimSpace(uri)
+	lower := strings.ToLower(uri)
+
+	switch {
+	case strings.HasPrefix(lower, "data:"):
+		data, err := decodeDataURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		return &resource{kind: resourceData, data: data}, nil
+	case strings.HasPrefix(lower, "ipfs://"):
+		rest := uri[len("ipfs://"):]
+		for strings.HasPrefix(strings.ToLower(rest), "ipfs/") {
+			rest = rest[len("ipfs/"):]
+		}
+		return ipfsResource(rest, "")
+	case strings.HasPrefix(lower, "/ipfs/"):
+		return ipfsResource(uri[len("/ipfs/"):], "")
+	case strings.HasPrefix(lower, "ar://"):
+		return arweaveResource(uri[len("ar://"):], "")
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"):
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, errors.Wrapf(ErrUnsupportedURI, "invalid url %s", uri)
+		}
+		origin := u.Scheme + "://" + u.Host
+		if strings.HasPrefix(u.Path, "/ipfs/") {
+			if res, err := ipfsResource(u.Path[len("/ipfs/"):], origin); err == nil {
+				return res, nil
+			}
+		}
+		if host := strings.SplitN(u.Host, ".", 3); len(host) == 3 && host[1] == "ipfs" { // 子域名网关<cid>.ipfs.<domain>
+			if res, err := ipfsResource(host[0]+u.Path, ""); err == nil {
+				return res, nil
+			}
+		}
+		for _, gateway := range f.arweaveGateways {
+			if origin == gateway {
+				if res, err := arweaveResource(strings.TrimPrefix(u.Path, "/"), origin); err == nil {
+					return res, nil
+				}
+			}
+		}
+		return &resource{kind: resourceHTTP, url: uri}, nil
+	}
+
+	if !strings.Contains(uri, "://") {
+		if res, err := ipfsResource(uri, ""); err == nil {
+			return res, nil
+		}
+	}
+
+	return nil, errors.Wrapf(ErrUnsupportedURI, "%s", uri)
+}
+
+func splitID(rest string) (string, string) {
+	if i := strings.IndexAny(rest, "?#"); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[:i], strings.TrimRight(rest[i:], "/")
+	}
+	return rest, ""
+}
+
+func ipfsResource(rest, origin string) (*resource, error) {
+	id, path := splitID(rest)
+	c, err := parseCID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resource{kind: resourceIpfs, cid: c, id: id, path: path, origin: origin}, nil
+}
+
+func arweaveResource(rest, origin string) (*resource, error) {
+	id, path := splitID(rest)
+	if txID, err := base64.RawURLEncoding.DecodeString(id); err != nil || len(txID) != 32 {
+		return nil, errors.Wrapf(ErrUnsupportedURI, "invalid arweave id %s", id)
+	}
+
+	return &resource{kind: resourceArweave, id: id, path: path, origin: origin}, nil
+}
+
+// decodeDataURI 解码data:[<mediatype>][;base64],<data>，非base64内容按百分号编码解码
+func decodeDataURI(uri string) ([]byte, error) {
+	comma := strings.Index(uri, ",")
+	if comma < 0 {
+		return nil, errors.Wrap(ErrUnsupportedURI, "data uri without comma")
+	}
+	params, payload := strings.ToLower(uri[len("data:"):comma]), uri[comma+1:]
+
+	if strings.HasSuffix(params, ";base64") {
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			// 部分合约输出的base64不带padding
+			if data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "=")); err != nil {
+				return nil, errors.Wrap(err, "failed on decode base64 data uri")
+			}
+		}
+		return data, nil
+	}
+
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		// 部分合约直接拼接JSON，未做百分号编码
+		return []byte(payload), nil
+	}
+
+	return []byte(data), nil
+}
+
+// Fetch 读取tokenURI指向的内容，内容须为文本(如JSON)或图片
+func (f *Fetcher) Fetch(ctx context.Context, uri string) ([]byte, error) {
+	res, err := f.parseResource(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.kind {
+	case resourceData:
+		if err := f.checkContent(res.data); err != nil {
+			return nil, err
+		}
+		return res.data, nil
+	case resourceHTTP:
+		ctx, cancel := context.WithTimeout(ctx, f.cfg.Timeout)
+		defer cancel()
+		_, body, err := f.get(ctx, res.url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := f.checkContent(body); err != nil {
+			return nil, err
+		}
+		return body, nil
+	}
+
+	key := res.cacheKey()
+	if body, ok := f.cache.Get(key); ok {
+		return body, nil
+	}
+
+	var c *content
+	switch {
+	case res.kind == resourceIpfs && res.cid.inline() && res.path == "":
+		if err := f.checkContent(res.cid.digest); err != nil {
+			return nil, err
+		}
+		c = &content{body: res.cid.digest, cacheable: true}
+	case res.kind == resourceIpfs:
+		c, err = f.hedge(ctx, withOrigin(f.ipfsGateways, res.origin), func(ctx context.Context, gateway string) (*content, error) {
+			return f.fetchIpfs(ctx, gateway, res)
+		})
+	default:
+		// arweave交易id为签名的哈希，无法按id在本地校验网关返回的内容，不缓存
+		c, err = f.hedge(ctx, withOrigin(f.arweaveGateways, res.origin), func(ctx context.Context, gateway string) (*content, error) {
+			_, body, err := f.get(ctx, gateway+"/"+res.id+res.path, nil)
+			if err != nil {
+				return nil, err
+			}
+			return &content{body: body}, f.checkContent(body)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cacheable {
+		f.cache.Add(key, c.body)
+	}
+	return c.body, nil
+}
+
+// content 网关返回的内容，cacheable为false时内容未经校验，不按内容哈希缓存
+type content struct {
+	body      []byte
+	cacheable bool
+}
+
+func withOrigin(gateways []string, origin string) []string {
+	if origin == "" {
+		return gateways
+	}
+	for _, gateway := range gateways {
+		if gateway == origin {
+			return gateways
+		}
+	}
+
+	return append([]string{origin}, gateways...)
+}
+
+// fetchIpfs 从单个网关读取ipfs内容。没有子路径时请求原始区块并校验哈希，网关不支持原始区块时跳过该网关。
+// 带子路径、CID无法校验或文件被拆分为多个区块时，使用网关返回的文件内容，此时内容未经校验
+func (f *Fetcher) fetchIpfs(ctx context.Context, gateway string, res *resource) (*content, error) {
+	target := gateway + "/ipfs/" + res.id + res.path
+	if res.path != "" || !res.cid.verifiable() {
+		_, body, err := f.get(ctx, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &content{body: body}, f.checkContent(body)
+	}
+
+	resp, block, err := f.get(ctx, target+"?format=raw", map[string]string{"Accept": rawBlockContentType})
+	if err != nil {
+		return nil, err
+	}
+	// raw编码的区块即文件内容，可直接校验；dag-pb编码须由网关返回原始区块
+	if contentType := resp.Header.Get("Content-Type"); res.cid.codec != codecRaw &&
+		!strings.HasPrefix(contentType, rawBlockContentType) {
+		return nil, errors.Errorf("gateway returned non-raw block: %s", contentType)
+	}
+	if err := res.cid.verify(block); err != nil {
+		return nil, err
+	}
+
+	c := &content{body: block, cacheable: true}
+	if res.cid.codec == codecDagPb {
+		var chunked bool
+		if c.body, chunked, err = unixfsFileData(block); err != nil {
+			return nil, err
+		}
+		if chunked {
+			if _, c.body, err = f.get(ctx, target, nil); err != nil {
+				return nil, err
+			}
+			c.cacheable = false
+		}
+	}
+
+	return c, f.checkContent(c.body)
+}
+
+// hedge 按顺序向网关发起请求：前一个请求失败时立即请求下一个网关，超过HedgeDelay未返回时同时请求下一个网关，
+// 返回最先成功的结果并取消其余请求
+func (f *Fetcher) hedge(ctx context.Context, gateways []string, fetch func(ctx context.Context, gateway string) (*content, error)) (*content, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.cfg.Timeout)
+	defer cancel()
+
+	type result struct {
+		gateway string
+		content *content
+		err     error
+	}
+	results := make(chan result, len(gateways))
+	next, pending := 0, 0
+	start := func() {
+		gateway := gateways[next]
+		next++
+		pending++
+		go func() {
+			c, err := fetch(ctx, gateway)
+			results <- result{gateway: gateway, content: c, err: err}
+		}()
+	}
+
+	start()
+	ticker := time.NewTicker(f.cfg.HedgeDelay)
+	defer ticker.Stop()
+
+	var errs []string
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.content, nil
+			}
+			errs = append(errs, r.gateway+": "+r.err.Error())
+			if next < len(gateways) {
+				start()
+			}
+		case <-ticker.C:
+			if next < len(gateways) {
+				start()
+			}
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "request gateways timeout: %s", strings.Join(errs, "; "))
+		}
+	}
+
+	return nil, errors.Errorf("all gateways failed: %s", strings.Join(errs, "; "))
+}
+
+func (f *Fetcher) get(ctx context.Context, target string, header map[string]string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed on create request")
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed on request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	if resp.ContentLength > f.cfg.MaxSize {
+		return nil, nil, errors.Wrapf(ErrContentTooLarge, "content length %d", resp.ContentLength)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.cfg.MaxSize+1))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed on read resp body")
+	}
+	if int64(len(body)) > f.cfg.MaxSize {
+		return nil, nil, errors.Wrapf(ErrContentTooLarge, "exceeds %d bytes", f.cfg.MaxSize)
+	}
+
+	return resp, body, nil
+}
+
+// checkContent 元数据须为文本(JSON、SVG等)或图片
+func (f *Fetcher) checkContent(body []byte) error {
+	if len(body) == 0 {
+		return errors.New("empty content")
+	}
+	if int64(len(body)) > f.cfg.MaxSize {
+		return errors.Wrapf(ErrContentTooLarge, "exceeds %d bytes", f.cfg.MaxSize)
+	}
+	if !isTextFile(body) && !isImageFile(body) {
+		return errors.Wrap(ErrUnsupportedContent, http.DetectContentType(body))
+	}
+
+	return nil
+}
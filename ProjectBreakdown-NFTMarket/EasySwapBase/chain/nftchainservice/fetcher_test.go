@@ -0,0 +1,302 @@
+package nftchainservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	enchex "encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMetadata = `{"name":"token #1","image":"ipfs://image"}`
+
+// cidV1 按内容编码及区块内容生成base32编码的CIDv1
+func cidV1(codec byte, block []byte) string {
+	digest := sha256.Sum256(block)
+	return "b" + base32Lower.EncodeToString(append([]byte{1, codec, codecSha256, sha256.Size}, digest[:]...))
+}
+
+func protoBytes(field uint64, value []byte) []byte {
+	buf := binary.AppendUvarint(nil, field<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// unixfsBlock 单个区块的UnixFS文件
+func unixfsBlock(content []byte) []byte {
+	data := append([]byte{0x08, 0x02}, protoBytes(2, content)...) // Type: File
+	return protoBytes(1, data)
+}
+
+// gateway 本地网关，记录收到的请求数
+type gateway struct {
+	*httptest.Server
+	requests atomic.Int32
+}
+
+func newGateway(handler http.HandlerFunc) *gateway {
+	g := &gateway{}
+	g.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.requests.Add(1)
+		handler(w, r)
+	}))
+	return g
+}
+
+// serve 按路径返回contents中的内容，请求原始区块时返回contentType
+func serve(contents map[string][]byte, contentType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, ok := contents[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if contentType != "" && r.URL.Query().Get("format") == "raw" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		_, _ = w.Write(body)
+	}
+}
+
+func TestParseResource(t *testing.T) {
+	f := NewFetcher(http.DefaultClient, FetcherConfig{ArweaveGateways: []string{"https://arweave.net/"}})
+	rawCid := cidV1(codecRaw, []byte(testMetadata))
+	arID := "bNbA3TEQVL60xlgCcqdz4ZPHFZ711cZ3hmkpGttDt_U"
+
+	cases := []struct {
+		uri    string
+		kind   resourceKind
+		id     string
+		path   string
+		origin string
+	}{
+		{uri: "ipfs://" + rawCid, kind: resourceIpfs, id: rawCid},
+		{uri: " ipfs://ipfs/" + rawCid + "/1.json ", kind: resourceIpfs, id: rawCid, path: "/1.json"},
+		{uri: "ipfs://QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG/readme", kind: resourceIpfs,
+			id: "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG", path: "/readme"},
+		{uri: "/ipfs/" + rawCid + "/", kind: resourceIpfs, id: rawCid},
+		{uri: rawCid, kind: resourceIpfs, id: rawCid},
+		{uri: "https://gateway.pinata.cloud/ipfs/" + rawCid + "/2?x=1", kind: resourceIpfs, id: rawCid, path: "/2",
+			origin: "https://gateway.pinata.cloud"},
+		{uri: "https://" + rawCid + ".ipfs.dweb.link/3", kind: resourceIpfs, id: rawCid, path: "/3"},
+		{uri: "ar://" + arID + "/1", kind: resourceArweave, id: arID, path: "/1"},
+		{uri: "https://arweave.net/" + arID, kind: resourceArweave, id: arID, origin: "https://arweave.net"},
+		{uri: "https://example.com/ipfs/not-a-cid", kind: resourceHTTP},
+		{uri: "https://example.com/" + arID, kind: resourceHTTP},
+	}
+	for _, c := range cases {
+		res, err := f.parseResource(c.uri)
+		if !assert.NoError(t, err, c.uri) {
+			continue
+		}
+		assert.Equal(t, c.kind, res.kind, c.uri)
+		assert.Equal(t, c.id, res.id, c.uri)
+		assert.Equal(t, c.path, res.path, c.uri)
+		assert.Equal(t, c.origin, res.origin, c.uri)
+	}
+
+	_, err := f.parseResource("ipfs://QmInvalid")
+	assert.True(t, errors.Is(err, ErrInvalidCID))
+	_, err = f.parseResource("ftp://example.com/1.json")
+	assert.True(t, errors.Is(err, ErrUnsupportedURI))
+	_, err = f.parseResource("ar://short")
+	assert.True(t, errors.Is(err, ErrUnsupportedURI))
+
+	// CIDv0与相同multihash的CIDv1为同一内容
+	v0, err := parseCID("QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG")
+	assert.NoError(t, err)
+	v1, err := parseCID("b" + base32Lower.EncodeToString(append([]byte{1, codecDagPb}, v0.multihash...)))
+	assert.NoError(t, err)
+	assert.Equal(t, v0.key(), v1.key())
+}
+
+func TestDecodeDataURI(t *testing.T) {
+	f := NewFetcher(http.DefaultClient, FetcherConfig{})
+	for _, uri := range []string{
+		"data:application/json;base64,eyJuYW1lIjoiMSJ9",
+		"data:application/json;base64,eyJuYW1lIjoiMSJ9==",
+		"data:application/json;utf8,%7B%22name%22%3A%221%22%7D",
+		`data:application/json,{"name":"1"}`,
+	} {
+		body, err := f.Fetch(context.Background(), uri)
+		assert.NoError(t, err, uri)
+		assert.Equal(t, `{"name":"1"}`, string(body), uri)
+	}
+
+	// identity哈希的CID直接包含内容
+	inline := "b" + base32Lower.EncodeToString(append([]byte{1, codecRaw, codecIdentity, byte(len(testMetadata))}, testMetadata...))
+	body, err := f.Fetch(context.Background(), "ipfs://"+inline)
+	assert.NoError(t, err)
+	assert.Equal(t, testMetadata, string(body))
+}
+
+func TestFetcherHedge(t *testing.T) {
+	content := []byte(testMetadata)
+	rawCid := cidV1(codecRaw, content)
+	hanging := newGateway(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	defer hanging.Close()
+	failing := newGateway(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	defer failing.Close()
+	healthy := newGateway(serve(map[string][]byte{"/ipfs/" + rawCid: content}, ""))
+	defer healthy.Close()
+
+	f := NewFetcher(http.DefaultClient, FetcherConfig{
+		IpfsGateways: []string{hanging.URL + "/ipfs/", failing.URL, healthy.URL},
+		HedgeDelay:   50 * time.Millisecond,
+		Timeout:      5 * time.Second,
+	})
+	begin := time.Now()
+	body, err := f.Fetch(context.Background(), "ipfs://"+rawCid)
+	assert.NoError(t, err)
+	assert.Equal(t, content, body)
+	// 第一个网关未返回时对冲请求后续网关，第二个网关失败后立即请求第三个
+	assert.Less(t, time.Since(begin), time.Second)
+	assert.Equal(t, []int32{1, 1, 1}, []int32{hanging.requests.Load(), failing.requests.Load(), healthy.requests.Load()})
+
+	// 不可变内容按内容哈希缓存，CIDv1的base16编码与base32编码为同一内容
+	digest := sha256.Sum256(content)
+	cached := "f" + enchex.EncodeToString(append([]byte{1, codecRaw, codecSha256, sha256.Size}, digest[:]...))
+	body, err = f.Fetch(context.Background(), "https://other.gateway/ipfs/"+cached)
+	assert.NoError(t, err)
+	assert.Equal(t, content, body)
+	assert.Equal(t, int32(1), healthy.requests.Load())
+
+	// 所有网关均失败
+	f = NewFetcher(http.DefaultClient, FetcherConfig{IpfsGateways: []string{failing.URL}, HedgeDelay: time.Millisecond})
+	_, err = f.Fetch(context.Background(), "ipfs://"+cidV1(codecRaw, []byte("missing")))
+	assert.ErrorContains(t, err, "all gateways failed")
+
+	// 总超时
+	f = NewFetcher(http.DefaultClient, FetcherConfig{IpfsGateways: []string{hanging.URL}, Timeout: 100 * time.Millisecond})
+	_, err = f.Fetch(context.Background(), "ipfs://"+cidV1(codecRaw, []byte("timeout")))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestFetcherVerifyCID(t *testing.T) {
+	content := []byte(testMetadata)
+	rawCid := cidV1(codecRaw, content)
+	block := unixfsBlock(content)
+	dagCid := cidV1(codecDagPb, block)
+
+	tampered := newGateway(serve(map[string][]byte{
+		"/ipfs/" + rawCid:             []byte(`{"name":"fake"}`),
+		"/ipfs/" + dagCid:             unixfsBlock([]byte(`{"name":"fake"}`)),
+		"/ipfs/" + dagCid + "/1.json": content,
+	}, rawBlockContentType))
+	defer tampered.Close()
+	trustless := newGateway(serve(map[string][]byte{"/ipfs/" + rawCid: content, "/ipfs/" + dagCid: block}, rawBlockContentType))
+	defer trustless.Close()
+
+	// 内容与CID不一致的网关被跳过
+	f := NewFetcher(http.DefaultClient, FetcherConfig{
+		IpfsGateways: []string{tampered.URL, trustless.URL},
+		HedgeDelay:   time.Second,
+	})
+	for _, id := range []string{rawCid, dagCid} {
+		body, err := f.Fetch(context.Background(), "ipfs://"+id)
+		assert.NoError(t, err, id)
+		assert.Equal(t, content, body, id)
+	}
+
+	f = NewFetcher(http.DefaultClient, FetcherConfig{IpfsGateways: []string{tampered.URL}})
+	_, err := f.Fetch(context.Background(), "ipfs://"+dagCid)
+	assert.ErrorContains(t, err, ErrCIDMismatch.Error())
+
+	// 带子路径的内容无法在本地校验，使用网关返回的文件，且不缓存
+	requests := tampered.requests.Load()
+	for i := 0; i < 2; i++ {
+		body, err := f.Fetch(context.Background(), "ipfs://"+dagCid+"/1.json")
+		assert.NoError(t, err)
+		assert.Equal(t, content, body)
+	}
+	assert.Equal(t, requests+2, tampered.requests.Load())
+}
+
+func TestFetcherRejectNonRawBlock(t *testing.T) {
+	content := []byte(testMetadata)
+	block := unixfsBlock(content)
+	dagCid := cidV1(codecDagPb, block)
+
+	// 不支持原始区块的网关直接返回文件，内容被篡改时无法校验
+	tampered := newGateway(serve(map[string][]byte{"/ipfs/" + dagCid: []byte(`{"name":"fake"}`)}, "application/json"))
+	defer tampered.Close()
+	trustless := newGateway(serve(map[string][]byte{"/ipfs/" + dagCid: block}, rawBlockContentType))
+	defer trustless.Close()
+
+	f := NewFetcher(http.DefaultClient, FetcherConfig{
+		IpfsGateways: []string{tampered.URL, trustless.URL},
+		HedgeDelay:   time.Second,
+	})
+	body, err := f.Fetch(context.Background(), "ipfs://"+dagCid)
+	assert.NoError(t, err)
+	assert.Equal(t, content, body)
+	assert.Equal(t, []int32{1, 1}, []int32{tampered.requests.Load(), trustless.requests.Load()})
+
+	f = NewFetcher(http.DefaultClient, FetcherConfig{IpfsGateways: []string{tampered.URL}})
+	_, err = f.Fetch(context.Background(), "ipfs://"+dagCid)
+	assert.ErrorContains(t, err, "non-raw block")
+}
+
+func TestFetcherArweaveNotCached(t *testing.T) {
+	arID := "bNbA3TEQVL60xlgCcqdz4ZPHFZ711cZ3hmkpGttDt_U"
+	arweave := newGateway(serve(map[string][]byte{"/" + arID: []byte(testMetadata)}, ""))
+	defer arweave.Close()
+
+	// arweave内容无法在本地校验，每次都从网关读取
+	f := NewFetcher(http.DefaultClient, FetcherConfig{ArweaveGateways: []string{arweave.URL}})
+	for i := 0; i < 2; i++ {
+		body, err := f.Fetch(context.Background(), "ar://"+arID)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(testMetadata), body)
+	}
+	assert.Equal(t, int32(2), arweave.requests.Load())
+}
+
+func TestFetcherLimits(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 16)...)
+	mp4 := append([]byte("\x00\x00\x00\x18ftypmp42"), make([]byte, 16)...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			_, _ = w.Write([]byte(strings.Repeat(" ", 2048)))
+		case "/image":
+			_, _ = w.Write(png)
+		case "/video":
+			_, _ = w.Write(mp4)
+		case "/empty":
+		default:
+			_, _ = w.Write([]byte(testMetadata))
+		}
+	}))
+	defer server.Close()
+
+	f := NewFetcher(http.DefaultClient, FetcherConfig{MaxSize: 1024})
+	body, err := f.Fetch(context.Background(), server.URL+"/1.json")
+	assert.NoError(t, err)
+	assert.Equal(t, testMetadata, string(body))
+
+	body, err = f.Fetch(context.Background(), server.URL+"/image")
+	assert.NoError(t, err)
+	assert.Equal(t, png, body)
+
+	_, err = f.Fetch(context.Background(), server.URL+"/large")
+	assert.True(t, errors.Is(err, ErrContentTooLarge))
+	_, err = f.Fetch(context.Background(), server.URL+"/video")
+	assert.True(t, errors.Is(err, ErrUnsupportedContent))
+	_, err = f.Fetch(context.Background(), server.URL+"/empty")
+	assert.Error(t, err)
+	_, err = f.Fetch(context.Background(), "data:application/json;base64,"+strings.Repeat("IC", 1024))
+	assert.True(t, errors.Is(err, ErrContentTooLarge))
+}
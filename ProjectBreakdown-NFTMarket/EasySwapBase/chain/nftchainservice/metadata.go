@@ -2,11 +2,8 @@ package nftchainservice
 
 import (
 	"bytes"
-	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"math/big"
 	"net/http"
 	"net/url"
@@ -23,12 +20,6 @@ import (
 	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
 )
 
-const fetchIPFSTimeout = 30 * time.Second
-
-var (
-	hosts = []string{"https://ipfs.io/ipfs/", "https://cf-ipfs.com/ipfs/", "https://infura-ipfs.io/ipfs/", "https://cloudflare-ipfs.com/ipfs/"}
-)
-
 type nftInfoSimple struct {
 	Name         string `json:"name"`
 	Image        string `json:"image"`
@@ -46,52 +37,36 @@ func (s *Service) fetchNftMetadata(collectionAddr string, tokenID string) ([]byt
 		xzap.WithContext(s.ctx).Info("fetch nft metadata end", zap.String("collection_addr", collectionAddr), zap.String("token_id", tokenID), zap.Float64("take", time.Now().Sub(beginTime).Seconds()))
 	}()
 
-	tokenId, _ := big.NewInt(0).SetString(tokenID, 10)
-	tokenURIReqData, err := s.Abi.Pack("tokenURI", tokenId)
+	uris, errs, err := s.FetchTokenURIs(collectionAddr, []string{tokenID})
 	if err != nil {
-		return nil, "", errors.Wrap(err, fmt.Sprintf("failed on pack token uri %s", tokenID))
+		return nil, "", err
 	}
-
-	to := common.HexToAddress(collectionAddr)
-	respData, err := s.NodeClient.CallContract(s.ctx, ethereum.CallMsg{To: &to, Data: tokenURIReqData}, nil)
-	if err != nil {
-		return nil, "", errors.Wrap(err, "failed on request token uri")
+	if errs[0] != nil {
+		return nil, "", errors.Wrap(errs[0], fmt.Sprintf("failed on fetch token uri %s", tokenID))
 	}
 
-	res, err := s.Abi.Unpack("tokenURI", respData)
+	body, err := s.fetchTokenURIData(uris[0])
 	if err != nil {
-		return nil, "", errors.Wrap(err, "failed on unpack token uri")
+		return nil, "", err
 	}
 
-	tokenUri := res[0].(string)
-	var body []byte
-	if len(tokenUri) > 29 && tokenUri[0:29] == "data:application/json;base64," {
-		body, err = base64.StdEncoding.DecodeString(tokenUri[29:])
-		if err != nil {
-			return nil, "", errors.Wrap(err, fmt.Sprintf("failed on decode token uri: %s", tokenUri))
-		}
-	} else if len(tokenUri) > 5 && tokenUri[0:5] == "ipfs:" {
-		body, err = s.fetchIpfsData(tokenUri)
-		if err != nil {
-			return nil, "", errors.Wrap(err, fmt.Sprintf("failed on fetch token uri: %s", tokenUri))
-		}
-	} else if len(tokenUri) > 5 && tokenUri[0:4] != "http" {
-		return nil, "", errors.New(fmt.Sprintf("invalid url %s", tokenUri))
-	}
+	return body, uris[0], nil
+}
 
-	if len(tokenUri) > 5 && tokenUri[0:4] == "http" {
-		body, err = s.fetchJsonData(tokenUri)
-		if err != nil {
-			return nil, "", errors.Wrap(err, fmt.Sprintf("failed on fetch metadata. uri:%s", tokenUri))
-		}
+// fetchTokenURIData 读取tokenURI指向的元数据，支持http、ipfs、ar及data URI
+func (s *Service) fetchTokenURIData(tokenUri string) ([]byte, error) {
+	body, err := s.Fetcher.Fetch(s.ctx, tokenUri)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed on fetch token uri: %s", tokenUri))
 	}
 
-	if body != nil {
-		body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
-		return body, tokenUri, nil
+	if strings.Contains(tokenUri, "squid-app-o5c27.ondigitalocean") {
+		if body, err = unwrapSquidMetadata(body); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed on fetch metadata. uri:%s", tokenUri))
+		}
 	}
 
-	return nil, "", errors.New("empty metadata")
+	return bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")), nil
 }
 
 func (s *Service) FetchNftOwner(collectionAddr string, tokenID string) (common.Address, error) {
@@ -103,115 +78,74 @@ func (s *Service) FetchNftOwner(collectionAddr string, tokenID string) (common.A
 		xzap.WithContext(s.ctx).Info("fetch nft owner end", zap.String("collection_addr", collectionAddr), zap.String("token_id", tokenID), zap.Float64("take", time.Now().Sub(beginTime).Seconds()))
 	}()
 
-	tokenId, _ := big.NewInt(0).SetString(tokenID, 10)
+	if s.tokenStandard(collectionAddr) == TokenStandardERC1155 {
+		return common.Address{}, ErrMultiHolder
+	}
+
+	tokenId, ok := big.NewInt(0).SetString(tokenID, 10)
+	if !ok {
+		return common.Address{}, errors.Errorf("invalid token id %s", tokenID)
+	}
 	tokenOwnerReqData, err := s.Abi.Pack("ownerOf", tokenId)
 	if err != nil {
-		return common.Address{}, errors.Wrap(err, fmt.Sprintf("failed on pack token uri %s", tokenID))
+		return common.Address{}, errors.Wrap(err, fmt.Sprintf("failed on pack token owner %s", tokenID))
 	}
 
 	to := common.HexToAddress(collectionAddr)
 	respData, err := s.NodeClient.CallContract(s.ctx, ethereum.CallMsg{To: &to, Data: tokenOwnerReqData}, nil)
 	if err != nil {
-		return common.Address{}, errors.Wrap(err, "failed on request token uri")
+		return common.Address{}, errors.Wrap(err, "failed on request token owner")
 	}
 
 	res, err := s.Abi.Unpack("ownerOf", respData)
 	if err != nil {
-		return common.Address{}, errors.Wrap(err, "failed on unpack token uri")
+		return common.Address{}, errors.Wrap(err, "failed on unpack token owner")
 	}
 
-	address := *abi.ConvertType(res[0], new(common.Address)).(*common.Address)
-	return address, nil
+	return *abi.ConvertType(res[0], new(common.Address)).(*common.Address), nil
 }
 
-func (s *Service) fetchIpfsData(tokenUri string) ([]byte, error) {
-	finished := make(chan []byte)
-	ticker := time.NewTicker(fetchIPFSTimeout)
-	var cancelFns []context.CancelFunc
-	for i := range hosts {
-		host := hosts[i]
-		fullUrl := strings.Replace(tokenUri, "ipfs://", host, 1)
-		ctx, cancel := context.WithCancel(context.Background())
-		cancelFns = append(cancelFns, cancel)
-		go func() {
-			req, err := http.NewRequestWithContext(ctx, "GET", fullUrl, nil)
-			if err != nil {
-				xzap.WithContext(s.ctx).Error("failed on create fetch ipfs data req",
-					zap.String("token_uri", tokenUri), zap.Error(err))
-				return
-			}
-
-			resp, err := s.HttpClient.Do(req)
-			if err != nil {
-				xzap.WithContext(s.ctx).Error("failed on fetch metadata from ipfs",
-					zap.String("token_uri", tokenUri), zap.Error(err))
-				return
-			}
-
-			if resp.StatusCode == http.StatusOK {
-				body, err := io.ReadAll(resp.Body)
-				if err != nil {
-					xzap.WithContext(s.ctx).Error("failed on read ipfs req resp body",
-						zap.String("token_uri", tokenUri), zap.Error(err))
-					return
-				}
-
-				finished <- body
-			}
-		}()
+// unwrapSquidMetadata 该服务将元数据包装在data字段中返回
+func unwrapSquidMetadata(body []byte) ([]byte, error) {
+	tmpData := struct {
+		Msg  string        `json:"msg"`
+		Data nftInfoSimple `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &tmpData); err != nil {
+		return nil, errors.Wrap(err, "failed on unmarshal raw metadata")
 	}
 
-	select {
-	case data := <-finished:
-		for i := range cancelFns {
-			cancelFns[i]()
+	if tmpData.Data.Name != "" {
+		data, err := json.Marshal(tmpData.Data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed on marshal raw metadata")
 		}
-
 		return data, nil
-	case <-ticker.C:
-		return nil, errors.New("request metadata timeout: " + tokenUri)
 	}
+
+	return body, nil
 }
 
-func (s *Service) fetchJsonData(tokenUri string) (body []byte, err error) {
-	resp, err := s.HttpClient.Get(tokenUri)
+func (s *Service) FetchOnChainMetadata(collectionAddr string, tokenID string) (*JsonMetadata, error) {
+	rawData, tokenUri, err := s.fetchNftMetadata(collectionAddr, tokenID)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed on get metadata from http")
-	}
-
-	if resp.StatusCode == http.StatusOK {
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed on read resp body")
-		}
-
-		if strings.Contains(tokenUri, "squid-app-o5c27.ondigitalocean") {
-			tmpData := struct {
-				Msg  string        `json:"msg"`
-				Data nftInfoSimple `json:"data"`
-			}{}
-			if err := json.Unmarshal(body, &tmpData); err != nil {
-				return nil, errors.Wrap(err, "failed on unmarshal raw metadata")
-			}
-
-			if tmpData.Data.Name != "" {
-				body, err = json.Marshal(tmpData.Data)
-				if err != nil {
-					return nil, errors.Wrap(err, "failed on marshal raw metadata")
-				}
-			}
-		}
+		return nil, errors.Wrap(err, "failed on fetch nft metadata")
 	}
 
-	return body, err
+	return s.decodeMetadata(rawData, tokenUri)
 }
 
-func (s *Service) FetchOnChainMetadata(collectionAddr string, tokenID string) (*JsonMetadata, error) {
-	rawData, tokenUri, err := s.fetchNftMetadata(collectionAddr, tokenID)
+// FetchMetadataByTokenURI 按已查询到的tokenURI拉取并解析元数据，用于配合FetchTokenURIs批量查询
+func (s *Service) FetchMetadataByTokenURI(tokenUri string) (*JsonMetadata, error) {
+	rawData, err := s.fetchTokenURIData(tokenUri)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed on fetch nft metadata")
 	}
 
+	return s.decodeMetadata(rawData, tokenUri)
+}
+
+func (s *Service) decodeMetadata(rawData []byte, tokenUri string) (*JsonMetadata, error) {
 	if len(rawData) == 0 {
 		return nil, errors.New("metadata length is zero")
 	}
@@ -0,0 +1,129 @@
+package nftchainservice
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	"github.com/ProjectsTask/EasySwapBase/chain/multicall"
+)
+
+const tokenURIGas = 200_000 // 部分合约在链上拼接或生成元数据，tokenURI的gas消耗较高
+
+// ErrMultiHolder ERC-1155的token可以有多个持有人，需通过FetchNftBalances查询持有数量
+var ErrMultiHolder = errors.New("erc1155 token has no single owner")
+
+// FetchTokenURIs 批量查询token的元数据地址，ERC-721调用tokenURI，ERC-1155调用uri并替换{id}。
+// 结果与tokenIDs一一对应，单个token查询失败时errs中对应的错误不为nil
+func (s *Service) FetchTokenURIs(collectionAddr string, tokenIDs []string) ([]string, []error, error) {
+	contractAbi, method, gas := s.Abi, "tokenURI", uint64(tokenURIGas)
+	isErc1155 := s.tokenStandard(collectionAddr) == TokenStandardERC1155
+	if isErc1155 {
+		contractAbi, method = &Erc1155Abi, "uri"
+	}
+
+	args, errs := tokenIDArgs(tokenIDs)
+	returnData, callErrs, err := s.batchCall(collectionAddr, contractAbi, method, gas, args, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed on request token uri")
+	}
+
+	uris := make([]string, len(tokenIDs))
+	for i := range tokenIDs {
+		if errs[i] != nil {
+			continue
+		}
+		if callErrs[i] != nil {
+			errs[i] = callErrs[i]
+			continue
+		}
+		res, err := contractAbi.Unpack(method, returnData[i])
+		if err != nil {
+			errs[i] = errors.Wrap(err, "failed on unpack token uri")
+			continue
+		}
+		uris[i], _ = res[0].(string)
+		if isErc1155 {
+			uris[i] = ReplaceTokenIDPlaceholder(uris[i], tokenIDs[i])
+		}
+	}
+
+	return uris, errs, nil
+}
+
+// tokenStandard 返回collection的NFT标准并缓存，未实现ERC-165的早期合约按ERC-721处理
+func (s *Service) tokenStandard(collectionAddr string) int64 {
+	key := strings.ToLower(collectionAddr)
+	if standard, ok := s.standards.Load(key); ok {
+		return standard.(int64)
+	}
+
+	standard, err := s.FetchTokenStandard(collectionAddr)
+	if err != nil || standard == TokenStandardUnknown {
+		return TokenStandardERC721
+	}
+	s.standards.Store(key, standard)
+	return standard
+}
+
+func tokenIDArgs(tokenIDs []string) ([][]interface{}, []error) {
+	args := make([][]interface{}, len(tokenIDs))
+	errs := make([]error, len(tokenIDs))
+	for i, tokenID := range tokenIDs {
+		tokenId, ok := new(big.Int).SetString(tokenID, 10)
+		if !ok {
+			errs[i] = errors.Errorf("invalid token id %s", tokenID)
+			continue
+		}
+		args[i] = []interface{}{tokenId}
+	}
+	return args, errs
+}
+
+// batchCall 以args中的每组参数调用collection的method，通过Multicall3批量请求，链上未部署Multicall3时逐个请求。
+// blockNumber为nil时查询最新区块。args为nil的调用跳过，returnData与errs均与args一一对应
+func (s *Service) batchCall(collectionAddr string, contractAbi *abi.ABI, method string, gas uint64,
+	args [][]interface{}, blockNumber *big.Int) ([][]byte, []error, error) {
+	to := common.HexToAddress(collectionAddr)
+	errs := make([]error, len(args))
+	msgs := make([]ethereum.CallMsg, 0, len(args))
+	index := make([]int, 0, len(args)) // msgs对应的args下标
+	for i := range args {
+		if args[i] == nil {
+			continue
+		}
+		data, err := contractAbi.Pack(method, args[i]...)
+		if err != nil {
+			errs[i] = errors.Wrapf(err, "failed on pack %s", method)
+			continue
+		}
+		msgs = append(msgs, ethereum.CallMsg{To: &to, Gas: gas, Data: data})
+		index = append(index, i)
+	}
+
+	returnData := make([][]byte, len(args))
+	if len(msgs) == 0 {
+		return returnData, errs, nil
+	}
+
+	results, err := multicall.Aggregate3(s.ctx, s.NodeClient, s.MulticallAddress, msgs, multicall.Options{BlockNumber: blockNumber})
+	if errors.Is(err, multicall.ErrNotDeployed) {
+		for j, msg := range msgs {
+			returnData[index[j]], errs[index[j]] = s.NodeClient.CallContract(s.ctx, msg, blockNumber)
+		}
+		return returnData, errs, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for j, result := range results {
+		returnData[index[j]], errs[index[j]] = result.ReturnData, result.Err()
+	}
+
+	return returnData, errs, nil
+}
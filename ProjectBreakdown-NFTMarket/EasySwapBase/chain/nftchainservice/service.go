@@ -2,6 +2,7 @@ package nftchainservice
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
@@ -9,42 +10,49 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/ProjectsTask/EasySwapBase/chain/chainclient"
+	"github.com/ProjectsTask/EasySwapBase/chain/multicall"
 	"github.com/ProjectsTask/EasySwapBase/xhttp"
 )
 
 const defaultTimeout = 10 //uint s
 
 type NodeService interface {
-	FetchOnChainMetadata(chainID int64, collectionAddr string, tokenID string) (*JsonMetadata, error)
-	FetchNftOwner(chainID int64, collectionAddr string, tokenID string) (common.Address, error)
-	GetNFTTransferEvent(chainName string, fromBlock, toBlock uint64) ([]*TransferLog, error)
-	GetNFTTransferEventGoroutine(chainName string, fromBlock, toBlock, blockSize, channelSize uint64) ([]*TransferLog, error)
+	FetchOnChainMetadata(collectionAddr string, tokenID string) (*JsonMetadata, error)
+	FetchNftOwner(collectionAddr string, tokenID string) (common.Address, error)
+	GetNFTTransferEvent(fromBlock, toBlock uint64) ([]*TransferLog, error)
+	GetNFTTransferEventGoroutine(fromBlock, toBlock, blockSize, channelSize uint64) ([]*TransferLog, error)
 }
 
+var _ NodeService = (*Service)(nil)
+
 type Service struct {
-	ctx context.Context
+	ctx       context.Context
+	standards sync.Map // collection地址 -> NFT标准
 
-	Abi            *abi.ABI
-	HttpClient     *xhttp.Client
-	NodeClient     chainclient.ChainClient
-	ChainName      string
-	NodeName       string
-	NameTags       []string
-	ImageTags      []string
-	AttributesTags []string
-	TraitNameTags  []string
-	TraitValueTags []string
+	Abi              *abi.ABI
+	HttpClient       *xhttp.Client
+	Fetcher          *Fetcher // 元数据拉取，可替换为自定义网关等配置
+	NodeClient       chainclient.ChainClient
+	MulticallAddress common.Address
+	ChainName        string
+	NodeName         string
+	NameTags         []string
+	ImageTags        []string
+	AttributesTags   []string
+	TraitNameTags    []string
+	TraitValueTags   []string
 }
 
+// New opts为节点客户端的配置，如备用节点
 func New(ctx context.Context, endpoint, chainName string, chainID int, nameTags, imageTags, attributesTags,
-	traitNameTags, traitValueTags []string) (*Service, error) {
+	traitNameTags, traitValueTags []string, opts ...chainclient.Option) (*Service, error) {
 	conf := xhttp.GetDefaultConfig()
 	conf.ForceAttemptHTTP2 = false
 	conf.HTTPTimeout = time.Duration(defaultTimeout) * time.Second
 	conf.DialTimeout = time.Duration(defaultTimeout-5) * time.Second
 	conf.DialKeepAlive = time.Duration(defaultTimeout+10) * time.Second
 
-	nodeClient, err := chainclient.New(chainID, endpoint)
+	nodeClient, err := chainclient.New(chainID, endpoint, opts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed on create node client")
 	}
@@ -54,16 +62,19 @@ func New(ctx context.Context, endpoint, chainName string, chainID int, nameTags,
 		return nil, errors.Wrap(err, "failed on get contract abi")
 	}
 
+	httpClient := xhttp.NewClient(conf)
 	return &Service{
-		ctx:            ctx,
-		Abi:            abi,
-		HttpClient:     xhttp.NewClient(conf),
-		NodeClient:     nodeClient,
-		ChainName:      chainName,
-		NameTags:       nameTags,
-		ImageTags:      imageTags,
-		AttributesTags: attributesTags,
-		TraitNameTags:  traitNameTags,
-		TraitValueTags: traitValueTags,
+		ctx:              ctx,
+		Abi:              abi,
+		HttpClient:       httpClient,
+		Fetcher:          NewFetcher(httpClient.Client, FetcherConfig{}),
+		NodeClient:       nodeClient,
+		MulticallAddress: multicall.AddressOf(chainID),
+		ChainName:        chainName,
+		NameTags:         nameTags,
+		ImageTags:        imageTags,
+		AttributesTags:   attributesTags,
+		TraitNameTags:    traitNameTags,
+		TraitValueTags:   traitValueTags,
 	}, nil
 }
@@ -8,20 +8,30 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	evmTypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 
 	"github.com/ProjectsTask/EasySwapBase/chain"
 	logTypes "github.com/ProjectsTask/EasySwapBase/chain/types"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
 )
 
 const hex = 16
 
+// maxFilterAddresses 单次eth_getLogs请求的最大合约地址数，部分节点服务商限制了请求体大小
+const maxFilterAddresses = 500
+
 var EVMTransferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
 var TokenIdExp = new(big.Int).Exp(big.NewInt(2), big.NewInt(128), nil)
 
+// BlockTimeGap 出块间隔(秒)，用于根据起始区块时间推算日志的区块时间。
+// 为0表示出块间隔不固定(Arbitrum亚秒级出块，zkSync Era出块间隔随负载变化)，需查询日志所在区块的时间
 var BlockTimeGap = map[string]int{
-	chain.Eth:      12,
-	chain.Optimism: 2,
-	chain.Sepolia:  12,
+	chain.Eth:       12,
+	chain.Optimism:  2,
+	chain.Sepolia:   12,
+	chain.Arbitrum:  0,
+	chain.Base:      2,
+	chain.ZkSyncEra: 0,
 }
 
 type TransferLog struct {
@@ -36,18 +46,51 @@ type TransferLog struct {
 	From            string        `json:"topic1"`
 	To              string        `json:"topic2"`
 	TokenID         string        `json:"topic3"`
+	Amount          string        `json:"amount"` // 转移数量，ERC-721为1
+	IsErc1155       bool          `json:"isErc1155"`
 	TxIndex         uint          `json:"transactionIndex"`
 	Index           uint          `json:"logIndex"`
 	Removed         bool          `json:"removed"`
 }
 
 func (s *Service) GetNFTTransferEvent(fromBlock, toBlock uint64) ([]*TransferLog, error) {
+	return s.getNFTTransferEvent(context.Background(), nil, fromBlock, toBlock)
+}
+
+// GetCollectionsTransferEvent 获取多个collection的Transfer事件，地址较多时分批查询后按区块和日志顺序合并
+func (s *Service) GetCollectionsTransferEvent(collectionAddrs []string, fromBlock, toBlock uint64) ([]*TransferLog, error) {
+	if len(collectionAddrs) == 0 {
+		return nil, nil
+	}
+
+	var transferLogs []*TransferLog
+	for start := 0; start < len(collectionAddrs); start += maxFilterAddresses {
+		end := start + maxFilterAddresses
+		if end > len(collectionAddrs) {
+			end = len(collectionAddrs)
+		}
+		logs, err := s.getNFTTransferEvent(context.Background(), collectionAddrs[start:end], fromBlock, toBlock)
+		if err != nil {
+			return nil, err
+		}
+		transferLogs = append(transferLogs, logs...)
+	}
+	sortTransferLogs(transferLogs)
+
+	return transferLogs, nil
+}
+
+// GetCollectionTransferEvent 获取指定collection的Transfer事件
+func (s *Service) GetCollectionTransferEvent(collectionAddr string, fromBlock, toBlock uint64) ([]*TransferLog, error) {
+	return s.getNFTTransferEvent(context.Background(), []string{collectionAddr}, fromBlock, toBlock)
+}
+
+func (s *Service) getNFTTransferEvent(ctx context.Context, addresses []string, fromBlock, toBlock uint64) ([]*TransferLog, error) {
 	// get block time
 	var startBlockTime uint64
-	var err error
 	switch s.ChainName {
-	case chain.Eth, chain.Optimism, chain.Sepolia:
-		blockTimestamp, err := s.NodeClient.BlockTimeByNumber(context.Background(), big.NewInt(int64(fromBlock)))
+	case chain.Eth, chain.Optimism, chain.Sepolia, chain.Arbitrum, chain.Base, chain.ZkSyncEra:
+		blockTimestamp, err := s.NodeClient.BlockTimeByNumber(ctx, big.NewInt(int64(fromBlock)))
 		if err != nil {
 			return nil, errors.Wrap(err, "failed on get block time")
 		}
@@ -56,70 +99,132 @@ func (s *Service) GetNFTTransferEvent(fromBlock, toBlock uint64) ([]*TransferLog
 
 	var transferTopic string
 	switch s.ChainName {
-	case chain.Eth, chain.Optimism, chain.Sepolia:
+	case chain.Eth, chain.Optimism, chain.Sepolia, chain.Arbitrum, chain.Base, chain.ZkSyncEra:
 		transferTopic = EVMTransferTopic.String()
 	default:
-		return nil, errors.Wrap(err, "unsupported chain")
+		return nil, errors.New("unsupported chain")
 	}
 
 	logFilter := logTypes.FilterQuery{
 		FromBlock: new(big.Int).SetUint64(fromBlock),
 		ToBlock:   new(big.Int).SetUint64(toBlock),
+		Addresses: addresses,
 		Topics: [][]string{
-			{transferTopic},
+			{transferTopic, EVMTransferSingleTopic.String(), EVMTransferBatchTopic.String()},
 		},
 	}
 
 	//var logs []goEthereumTypes.Log
-	logs, err := s.NodeClient.FilterLogs(context.Background(), logFilter)
+	logs, err := s.NodeClient.FilterLogs(ctx, logFilter)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed on filter logs")
 	}
 
-	var transferLogs []*TransferLog
+	var evmLogs []evmTypes.Log
+	var blockNumbers []uint64
 	for _, log := range logs {
-		var evmLog evmTypes.Log
-		var ok bool
-		evmLog, ok = log.(evmTypes.Log)
-		if ok {
-			var topics [4]string
-			for i := range evmLog.Topics {
-				topics[i] = evmLog.Topics[i].Hex()
-			}
-			if topics[3] == "" {
-				continue
-			}
+		evmLog, ok := log.(evmTypes.Log)
+		if !ok || len(evmLog.Topics) < 4 { // ERC-20的Transfer事件只有3个topic
+			continue
+		}
+		evmLogs = append(evmLogs, evmLog)
+		blockNumbers = append(blockNumbers, evmLog.BlockNumber)
+	}
 
-			tokenId := new(big.Int).SetBytes(evmLog.Topics[3][:])
-			transferLog := &TransferLog{
+	blockTimes, err := s.logBlockTimes(ctx, blockNumbers, startBlockTime, fromBlock)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed on get block time")
+	}
+
+	var transferLogs []*TransferLog
+	for _, evmLog := range evmLogs {
+		blockTime := blockTimes[evmLog.BlockNumber]
+		newTransferLog := func(from, to common.Hash, tokenId, amount *big.Int, isErc1155 bool) *TransferLog {
+			return &TransferLog{
 				Address:         evmLog.Address.String(),
 				TransactionHash: evmLog.TxHash.String(),
 				BlockNumber:     evmLog.BlockNumber,
-				BlockTime:       startBlockTime + (evmLog.BlockNumber-fromBlock)*uint64(BlockTimeGap[s.ChainName]),
+				BlockTime:       blockTime,
 				BlockHash:       evmLog.BlockHash.String(),
 				Data:            evmLog.Data,
 				Topics:          evmLog.Topics,
-				Topic0:          topics[0],
-				From:            common.HexToAddress(topics[1]).String(),
-				To:              common.HexToAddress(topics[2]).String(),
+				Topic0:          evmLog.Topics[0].Hex(),
+				From:            common.BytesToAddress(from.Bytes()).String(),
+				To:              common.BytesToAddress(to.Bytes()).String(),
 				TokenID:         tokenId.String(),
+				Amount:          amount.String(),
+				IsErc1155:       isErc1155,
 				TxIndex:         evmLog.TxIndex,
 				Index:           evmLog.Index,
 				Removed:         evmLog.Removed,
 			}
-			transferLogs = append(transferLogs, transferLog)
+		}
+
+		if evmLog.Topics[0] == EVMTransferTopic {
+			tokenId := new(big.Int).SetBytes(evmLog.Topics[3][:])
+			transferLogs = append(transferLogs, newTransferLog(evmLog.Topics[1], evmLog.Topics[2], tokenId, big.NewInt(1), false))
+			continue
+		}
+
+		// ERC-1155的topic依次为operator、from、to，TransferBatch按ids拆分为多条记录
+		ids, values, err := unpackErc1155Transfer(evmLog.Topics[0], evmLog.Data)
+		if err != nil { // 不符合标准的合约可能发出无法解析的同名事件，跳过以免阻塞同步
+			xzap.WithContext(ctx).Warn("skip undecodable erc1155 transfer", zap.String("address", evmLog.Address.String()),
+				zap.String("tx_hash", evmLog.TxHash.String()), zap.Uint("log_index", evmLog.Index), zap.Error(err))
 			continue
 		}
+		for i := range ids {
+			transferLogs = append(transferLogs, newTransferLog(evmLog.Topics[2], evmLog.Topics[3], ids[i], values[i], true))
+		}
 	}
 
-	// Sort transferLogs by block number
-	sort.Slice(transferLogs, func(i, j int) bool {
-		return transferLogs[i].BlockNumber < transferLogs[j].BlockNumber
-	})
+	sortTransferLogs(transferLogs)
 
 	return transferLogs, nil
 }
 
+// sortTransferLogs 按区块和日志顺序排序，TransferBatch拆分出的记录保持原有顺序
+func sortTransferLogs(transferLogs []*TransferLog) {
+	sort.SliceStable(transferLogs, func(i, j int) bool {
+		if transferLogs[i].BlockNumber != transferLogs[j].BlockNumber {
+			return transferLogs[i].BlockNumber < transferLogs[j].BlockNumber
+		}
+		return transferLogs[i].Index < transferLogs[j].Index
+	})
+}
+
+// logBlockTimes 出块间隔固定的链按间隔推算区块时间，否则批量查询日志所在区块的时间
+func (s *Service) logBlockTimes(ctx context.Context, blockNumbers []uint64, startBlockTime,
+	fromBlock uint64) (map[uint64]uint64, error) {
+	blockTimes := map[uint64]uint64{fromBlock: startBlockTime}
+	gap := BlockTimeGap[s.ChainName]
+
+	var queryBlocks []*big.Int
+	for _, blockNumber := range blockNumbers {
+		if _, ok := blockTimes[blockNumber]; ok {
+			continue
+		}
+		if gap > 0 {
+			blockTimes[blockNumber] = startBlockTime + (blockNumber-fromBlock)*uint64(gap)
+			continue
+		}
+		blockTimes[blockNumber] = 0 // 占位去重，查询后填充
+		queryBlocks = append(queryBlocks, new(big.Int).SetUint64(blockNumber))
+	}
+	if len(queryBlocks) == 0 {
+		return blockTimes, nil
+	}
+
+	times, err := s.NodeClient.BlockTimesByNumber(ctx, queryBlocks)
+	if err != nil {
+		return nil, err
+	}
+	for i, blockNumber := range queryBlocks {
+		blockTimes[blockNumber.Uint64()] = times[i]
+	}
+	return blockTimes, nil
+}
+
 func (s *Service) isInSlice(str string, slice []string) bool {
 	addr, err := chain.UniformAddress(s.ChainName, str)
 	if err != nil {
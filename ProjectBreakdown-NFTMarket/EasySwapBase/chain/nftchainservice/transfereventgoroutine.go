@@ -0,0 +1,160 @@
+package nftchainservice
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ProjectsTask/EasySwapBase/retry"
+)
+
+const (
+	transferChunkRetryLimit = 3
+)
+
+var transferChunkRetryWait = []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second}
+
+type blockRange struct {
+	index     int
+	fromBlock uint64
+	toBlock   uint64
+}
+
+type blockRangeResult struct {
+	index int
+	logs  []*TransferLog
+	err   error
+}
+
+// GetNFTTransferEventGoroutine 将[fromBlock, toBlock]按blockSize切分，由channelSize个协程并发拉取Transfer事件，
+// 结果按区块和日志顺序合并。单个区间失败会重试，重试后仍失败则取消其余区间并返回错误。
+func (s *Service) GetNFTTransferEventGoroutine(fromBlock, toBlock, blockSize, channelSize uint64) ([]*TransferLog, error) {
+	return s.getNFTTransferEventGoroutine(nil, fromBlock, toBlock, blockSize, channelSize)
+}
+
+// GetCollectionTransferEventGoroutine 并发获取指定collection的Transfer事件，用于导入collection的历史数据
+func (s *Service) GetCollectionTransferEventGoroutine(collectionAddr string, fromBlock, toBlock, blockSize, channelSize uint64) ([]*TransferLog, error) {
+	return s.getNFTTransferEventGoroutine([]string{collectionAddr}, fromBlock, toBlock, blockSize, channelSize)
+}
+
+func (s *Service) getNFTTransferEventGoroutine(addresses []string, fromBlock, toBlock, blockSize, channelSize uint64) ([]*TransferLog, error) {
+	if blockSize == 0 {
+		return nil, errors.New("block size must be greater than 0")
+	}
+	if fromBlock > toBlock {
+		return nil, nil
+	}
+	if channelSize == 0 {
+		channelSize = 1
+	}
+
+	parent := s.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	ranges := splitBlockRange(fromBlock, toBlock, blockSize)
+	workers := int(channelSize)
+	if workers > len(ranges) {
+		workers = len(ranges)
+	}
+
+	rangeCh := make(chan blockRange, channelSize)
+	resultCh := make(chan blockRangeResult, channelSize)
+
+	// 生产区间
+	go func() {
+		defer close(rangeCh)
+		for _, r := range ranges {
+			select {
+			case rangeCh <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// 并发拉取
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range rangeCh {
+				result := blockRangeResult{index: r.index}
+				result.err = retry.Retry(func(attempt uint) error {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					logs, err := s.getNFTTransferEvent(ctx, addresses, r.fromBlock, r.toBlock)
+					if err != nil {
+						return err
+					}
+					result.logs = logs
+					return nil
+				}, retry.Limit(transferChunkRetryLimit), retry.Wait(transferChunkRetryWait...))
+				if result.err != nil {
+					result.err = errors.Wrapf(result.err, "failed on get transfer event from %d to %d", r.fromBlock, r.toBlock)
+				}
+
+				select {
+				case resultCh <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	// 收集结果
+	chunks := make([][]*TransferLog, len(ranges))
+	received := 0
+	for result := range resultCh {
+		if result.err != nil {
+			cancel()
+			return nil, result.err
+		}
+		chunks[result.index] = result.logs
+		received++
+	}
+
+	if received != len(ranges) {
+		if err := parent.Err(); err != nil {
+			return nil, errors.Wrap(err, "transfer event fetching canceled")
+		}
+		return nil, errors.New("transfer event fetching incomplete")
+	}
+
+	// 区间有序且区间内已按区块和日志排序，按区间顺序拼接即可
+	var transferLogs []*TransferLog
+	for _, logs := range chunks {
+		transferLogs = append(transferLogs, logs...)
+	}
+
+	return transferLogs, nil
+}
+
+func splitBlockRange(fromBlock, toBlock, blockSize uint64) []blockRange {
+	var ranges []blockRange
+	for start := fromBlock; start <= toBlock; start += blockSize {
+		end := start + blockSize - 1
+		if end > toBlock || end < start { // 防止溢出
+			end = toBlock
+		}
+		ranges = append(ranges, blockRange{index: len(ranges), fromBlock: start, toBlock: end})
+		if end == toBlock {
+			break
+		}
+	}
+
+	return ranges
+}
@@ -0,0 +1,183 @@
+package nftchainservice
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	evmTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ProjectsTask/EasySwapBase/chain"
+	logTypes "github.com/ProjectsTask/EasySwapBase/chain/types"
+)
+
+type fakeNodeClient struct {
+	mu       sync.Mutex
+	logs     []evmTypes.Log
+	failOnce map[uint64]bool // fromBlock -> 第一次请求失败
+	calls    int
+	batches  [][]uint64 // 每次批量查询区块时间的区块号
+}
+
+func (c *fakeNodeClient) FilterLogs(ctx context.Context, q logTypes.FilterQuery) ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	from := q.FromBlock.Uint64()
+	if c.failOnce[from] {
+		c.failOnce[from] = false
+		return nil, errors.New("node unavailable")
+	}
+
+	addresses := make(map[common.Address]bool)
+	for _, addr := range q.Addresses {
+		addresses[common.HexToAddress(addr)] = true
+	}
+
+	var result []interface{}
+	// 倒序返回，验证合并结果的顺序
+	for i := len(c.logs) - 1; i >= 0; i-- {
+		if len(addresses) > 0 && !addresses[c.logs[i].Address] {
+			continue
+		}
+		if c.logs[i].BlockNumber >= from && c.logs[i].BlockNumber <= q.ToBlock.Uint64() {
+			result = append(result, c.logs[i])
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeNodeClient) BlockTimeByNumber(ctx context.Context, number *big.Int) (uint64, error) {
+	return number.Uint64() * 12, nil
+}
+
+func (c *fakeNodeClient) BlockTimesByNumber(ctx context.Context, numbers []*big.Int) ([]uint64, error) {
+	blockTimes := make([]uint64, len(numbers))
+	batch := make([]uint64, len(numbers))
+	for i, number := range numbers {
+		blockTimes[i] = number.Uint64() * 12
+		batch[i] = number.Uint64()
+	}
+	c.mu.Lock()
+	c.batches = append(c.batches, batch)
+	c.mu.Unlock()
+	return blockTimes, nil
+}
+
+func (c *fakeNodeClient) Client() interface{} { return nil }
+
+func (c *fakeNodeClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return nil, nil
+}
+
+func (c *fakeNodeClient) CallContractByChain(ctx context.Context, param logTypes.CallParam) (interface{}, error) {
+	return nil, nil
+}
+
+func (c *fakeNodeClient) BlockNumber() (uint64, error) { return 0, nil }
+
+func (c *fakeNodeClient) BlockWithTxs(ctx context.Context, blockNumber uint64) (interface{}, error) {
+	return nil, nil
+}
+
+func transferLog(block uint64, index uint, tokenID int64) evmTypes.Log {
+	return evmTypes.Log{
+		Address: common.HexToAddress("0x1"),
+		Topics: []common.Hash{
+			EVMTransferTopic,
+			common.HexToHash("0x0"),
+			common.HexToHash("0x2"),
+			common.BigToHash(big.NewInt(tokenID)),
+		},
+		BlockNumber: block,
+		Index:       index,
+	}
+}
+
+func TestGetNFTTransferEventGoroutine(t *testing.T) {
+	var logs []evmTypes.Log
+	for block := uint64(100); block < 150; block++ {
+		logs = append(logs, transferLog(block, 0, int64(block)), transferLog(block, 1, int64(block)+1000))
+	}
+	client := &fakeNodeClient{logs: logs, failOnce: map[uint64]bool{107: true}}
+	s := &Service{ctx: context.Background(), NodeClient: client, ChainName: chain.Sepolia}
+
+	result, err := s.GetNFTTransferEventGoroutine(100, 149, 7, 3)
+	assert.NoError(t, err)
+	assert.Len(t, result, len(logs))
+	for i := 1; i < len(result); i++ {
+		prev, cur := result[i-1], result[i]
+		assert.True(t, prev.BlockNumber < cur.BlockNumber ||
+			(prev.BlockNumber == cur.BlockNumber && prev.Index < cur.Index))
+	}
+	assert.Equal(t, uint64(100*12), result[0].BlockTime)
+	assert.Equal(t, "100", result[0].TokenID)
+	// 8个区间 + 1次失败重试
+	assert.Equal(t, 9, client.calls)
+}
+
+func TestGetNFTTransferEventGoroutineCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := &Service{ctx: ctx, NodeClient: &fakeNodeClient{}, ChainName: chain.Sepolia}
+
+	_, err := s.GetNFTTransferEventGoroutine(100, 149, 7, 3)
+	assert.Error(t, err)
+}
+
+func TestSplitBlockRange(t *testing.T) {
+	ranges := splitBlockRange(10, 20, 5)
+	assert.Equal(t, []blockRange{
+		{index: 0, fromBlock: 10, toBlock: 14},
+		{index: 1, fromBlock: 15, toBlock: 19},
+		{index: 2, fromBlock: 20, toBlock: 20},
+	}, ranges)
+	assert.Len(t, splitBlockRange(0, ^uint64(0), ^uint64(0)), 2)
+}
+
+func TestGetNFTTransferEventPerBlockTime(t *testing.T) {
+	logs := []evmTypes.Log{transferLog(100, 0, 1), transferLog(103, 0, 2), transferLog(103, 1, 3)}
+	client := &fakeNodeClient{logs: logs}
+	s := &Service{ctx: context.Background(), NodeClient: client, ChainName: chain.Arbitrum}
+
+	result, err := s.GetNFTTransferEvent(100, 110)
+	assert.NoError(t, err)
+	assert.Len(t, result, 3)
+	// 出块间隔不固定的链批量查询日志所在区块的时间，起始区块及重复区块不重复查询
+	assert.Equal(t, [][]uint64{{103}}, client.batches)
+	assert.Equal(t, uint64(100*12), result[0].BlockTime)
+	assert.Equal(t, uint64(103*12), result[1].BlockTime)
+	assert.Equal(t, uint64(103*12), result[2].BlockTime)
+}
+
+func TestGetCollectionsTransferEvent(t *testing.T) {
+	other := transferLog(101, 0, 7)
+	other.Address = common.HexToAddress("0x3")
+	client := &fakeNodeClient{logs: []evmTypes.Log{transferLog(100, 0, 1), other, transferLog(102, 0, 2)}}
+	s := &Service{ctx: context.Background(), NodeClient: client, ChainName: chain.Sepolia}
+
+	// 没有collection时不查询
+	result, err := s.GetCollectionsTransferEvent(nil, 100, 110)
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+	assert.Equal(t, 0, client.calls)
+
+	addrs := make([]string, 0, maxFilterAddresses+1)
+	for i := 0; i < maxFilterAddresses; i++ {
+		addrs = append(addrs, common.BigToAddress(big.NewInt(int64(i+1000))).Hex())
+	}
+	addrs = append(addrs, common.HexToAddress("0x1").Hex(), common.HexToAddress("0x3").Hex())
+	result, err = s.GetCollectionsTransferEvent(addrs, 100, 110)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, client.calls)
+	assert.Equal(t, []string{"1", "7", "2"}, []string{result[0].TokenID, result[1].TokenID, result[2].TokenID})
+
+	result, err = s.GetCollectionsTransferEvent([]string{common.HexToAddress("0x3").Hex()}, 100, 110)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+}
@@ -0,0 +1,105 @@
+package currency
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// NativeAddress 订单簿合约使用零地址表示链的原生币(ETH)
+const NativeAddress = "0x0000000000000000000000000000000000000000"
+
+const nativeDecimals = 18
+
+// Config 支付币种配置，EthRate为1个该币种可兑换的ETH数量
+type Config struct {
+	Address  string  `toml:"address" mapstructure:"address" json:"address"`
+	Symbol   string  `toml:"symbol" mapstructure:"symbol" json:"symbol"`
+	Decimals int32   `toml:"decimals" mapstructure:"decimals" json:"decimals"`
+	EthRate  float64 `toml:"eth_rate" mapstructure:"eth_rate" json:"eth_rate"`
+}
+
+// PriceSource 将各币种的价格换算为ETH(wei)
+type PriceSource interface {
+	// Multiplier 返回该币种最小单位价格换算为wei的乘数，不支持的币种返回false
+	Multiplier(currency string) (decimal.Decimal, bool)
+	// Currencies 返回支持换算的所有币种地址(小写)
+	Currencies() []string
+}
+
+// IsNative 空地址按原生币处理，兼容未记录币种的历史数据
+func IsNative(currency string) bool {
+	return currency == "" || strings.EqualFold(currency, NativeAddress)
+}
+
+// StaticPriceSource 使用配置中固定汇率的价格源
+type StaticPriceSource struct {
+	multipliers map[string]decimal.Decimal
+	currencies  []string
+}
+
+// NewStaticPriceSource 原生币默认支持，汇率为1
+func NewStaticPriceSource(cfgs []*Config) (*StaticPriceSource, error) {
+	s := &StaticPriceSource{
+		multipliers: map[string]decimal.Decimal{NativeAddress: decimal.NewFromInt(1)},
+		currencies:  []string{NativeAddress},
+	}
+	for _, cfg := range cfgs {
+		addr := strings.ToLower(cfg.Address)
+		if addr == "" {
+			return nil, errors.Errorf("empty address of currency %s", cfg.Symbol)
+		}
+		if _, ok := s.multipliers[addr]; ok {
+			return nil, errors.Errorf("duplicate currency %s", cfg.Address)
+		}
+		if cfg.EthRate <= 0 {
+			return nil, errors.Errorf("invalid eth rate of currency %s", cfg.Address)
+		}
+
+		// price / 10^decimals * ethRate * 10^18
+		s.multipliers[addr] = decimal.NewFromFloat(cfg.EthRate).Shift(nativeDecimals - cfg.Decimals)
+		s.currencies = append(s.currencies, addr)
+	}
+
+	return s, nil
+}
+
+func (s *StaticPriceSource) Multiplier(currency string) (decimal.Decimal, bool) {
+	if IsNative(currency) {
+		return decimal.NewFromInt(1), true
+	}
+	m, ok := s.multipliers[strings.ToLower(currency)]
+	return m, ok
+}
+
+func (s *StaticPriceSource) Currencies() []string {
+	return s.currencies
+}
+
+// ToEth 将价格换算为wei，不支持的币种返回false
+func ToEth(src PriceSource, currency string, price decimal.Decimal) (decimal.Decimal, bool) {
+	m, ok := src.Multiplier(currency)
+	if !ok {
+		return decimal.Zero, false
+	}
+
+	return price.Mul(m).Truncate(0), true
+}
+
+// EthPriceExpr 生成将价格列换算为wei的SQL表达式，不支持的币种结果为NULL，不参与SUM/MIN等聚合。
+// 历史数据中currency_address为空时按原生币处理
+func EthPriceExpr(src PriceSource, priceColumn, currencyColumn string) (string, []interface{}) {
+	var b strings.Builder
+	var args []interface{}
+	b.WriteString(fmt.Sprintf("CASE WHEN %s = '' THEN %s", currencyColumn, priceColumn))
+	for _, c := range src.Currencies() {
+		m, _ := src.Multiplier(c)
+		b.WriteString(fmt.Sprintf(" WHEN LOWER(%s) = ? THEN %s * ?", currencyColumn, priceColumn))
+		args = append(args, c, m)
+	}
+	b.WriteString(" ELSE NULL END")
+
+	return b.String(), args
+}
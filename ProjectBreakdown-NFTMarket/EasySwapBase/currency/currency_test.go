@@ -0,0 +1,50 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+const usdc = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+
+func TestStaticPriceSource(t *testing.T) {
+	src, err := NewStaticPriceSource([]*Config{
+		{Address: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", Symbol: "USDC", Decimals: 6, EthRate: 0.0004},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{NativeAddress, usdc}, src.Currencies())
+
+	// 2500 USDC = 1 ETH
+	price, ok := ToEth(src, usdc, decimal.NewFromInt(2500_000000))
+	assert.True(t, ok)
+	assert.Equal(t, "1000000000000000000", price.String())
+
+	price, ok = ToEth(src, "", decimal.NewFromInt(5))
+	assert.True(t, ok)
+	assert.Equal(t, "5", price.String())
+
+	_, ok = ToEth(src, "0x1", decimal.NewFromInt(1))
+	assert.False(t, ok)
+}
+
+func TestStaticPriceSourceInvalid(t *testing.T) {
+	_, err := NewStaticPriceSource([]*Config{{Address: usdc, Decimals: 6}})
+	assert.Error(t, err)
+
+	_, err = NewStaticPriceSource([]*Config{{Address: NativeAddress, Decimals: 18, EthRate: 1}})
+	assert.Error(t, err)
+}
+
+func TestEthPriceExpr(t *testing.T) {
+	src, err := NewStaticPriceSource([]*Config{{Address: usdc, Decimals: 6, EthRate: 0.0004}})
+	assert.NoError(t, err)
+
+	expr, args := EthPriceExpr(src, "price", "currency_address")
+	assert.Equal(t, "CASE WHEN currency_address = '' THEN price"+
+		" WHEN LOWER(currency_address) = ? THEN price * ?"+
+		" WHEN LOWER(currency_address) = ? THEN price * ? ELSE NULL END", expr)
+	assert.Len(t, args, 4)
+	assert.Equal(t, usdc, args[2])
+}
@@ -0,0 +1,305 @@
+package ordermanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+)
+
+// GenCollectionBestBidKey collection最高集合出价的缓存，值为订单json，没有出价时为空订单
+func GenCollectionBestBidKey(chain, address string) string {
+	return fmt.Sprintf("cache:es:%s:collection:best_bid:%s", strings.ToLower(chain), strings.ToLower(address))
+}
+
+// GenItemBestBidKey NFT最高单品出价的缓存，值为订单json，出价失效后没有其他出价时为空订单
+func GenItemBestBidKey(chain, address, tokenID string) string {
+	return fmt.Sprintf("cache:es:%s:item:best_bid:%s:%s", strings.ToLower(chain), strings.ToLower(address), tokenID)
+}
+
+// itemBestBid NFT当前发布的最高单品出价
+type itemBestBid struct {
+	orderID string
+	price   decimal.Decimal
+}
+
+// 出价队列中保存价格的相反数，复用PriorityQueueMap的升序及长度限制，保留价格最高的出价
+func addBid(bids *PriorityQueueMap, orderID string, price decimal.Decimal, maker string) {
+	bids.Add(orderID, price.Neg(), maker, "")
+}
+
+// getBestBid 返回价格最高的出价
+func getBestBid(bids *PriorityQueueMap) (string, decimal.Decimal) {
+	orderID, price := bids.GetMin()
+	return orderID, price.Neg()
+}
+
+// handleBidEvent 根据交易事件维护collection的最高集合出价:
+// - 新的集合出价: 高于队列中最低出价或队列未满时加入队列
+// - 取消、过期、失效或成交: 出价在队列中时从数据库重新加载，部分成交的出价仍然有效
+func (om *OrderManager) handleBidEvent(event *TradeEvent, tradeInfo *collectionTradeInfo) {
+	om.handleItemBidEvent(event, tradeInfo)
+
+	// 最高出价部分成交时剩余数量变化，需要重新发布
+	force := event.EventType == BidFilled && event.OrderId == tradeInfo.bestBidOrderID
+	switch event.EventType {
+	case CollectionBid:
+		_, lowest := tradeInfo.bids.GetMax()
+		if tradeInfo.bids.Len() < maxQueueLength || lowest.GreaterThan(event.Price.Neg()) {
+			addBid(tradeInfo.bids, event.OrderId, event.Price, event.From)
+		}
+	case Cancel, Expired, Inactive, BidFilled:
+		if !tradeInfo.bids.Contains(event.OrderId) {
+			return
+		}
+		if err := om.reloadCollectionBids(event.CollectionAddr); err != nil {
+			xzap.WithContext(om.Ctx).Error("failed on reload collection bids",
+				zap.Int("event_type", int(event.EventType)), zap.String("order_id", event.OrderId),
+				zap.String("collection_addr", event.CollectionAddr), zap.Error(err))
+			return
+		}
+	default:
+		return
+	}
+
+	if err := om.checkAndUpdateBestBid(event.CollectionAddr, force); err != nil {
+		xzap.WithContext(om.Ctx).Error("failed on update collection best bid",
+			zap.Int("event_type", int(event.EventType)), zap.String("order_id", event.OrderId),
+			zap.String("collection_addr", event.CollectionAddr), zap.Error(err))
+	}
+}
+
+// loadCollectionBids 启动时加载所有collection的有效集合出价，并更新及发布最高出价
+func (om *OrderManager) loadCollectionBids() error {
+	var id int64
+	for {
+		var orders []*multi.Order
+		if err := om.DB.WithContext(om.Ctx).Table(gdb.GetMultiProjectOrderTableName(om.project, om.chain)).
+			Select("id, order_id, collection_address, price, maker").
+			Where("order_type = ? and order_status = ? and quantity_remaining > 0 and expire_time > ? and id > ?",
+				multi.CollectionBidOrder, multi.OrderStatusActive, time.Now().Unix(), id).
+			Where("currency_address in (?)", nativeCurrencies).
+			Order("id asc").Limit(1000).
+			Scan(&orders).Error; err != nil {
+			return errors.Wrap(err, "failed on get collection bids")
+		}
+		for _, order := range orders {
+			tradeInfo, ok := om.collectionOrders[strings.ToLower(order.CollectionAddress)]
+			if !ok {
+				continue
+			}
+			addBid(tradeInfo.bids, order.OrderID, order.Price, order.Maker)
+		}
+		if len(orders) < 1000 {
+			break
+		}
+
+		id = orders[len(orders)-1].ID
+	}
+
+	// 停机期间缓存可能已过时，启动时全部重新发布
+	for addr := range om.collectionOrders {
+		if err := om.checkAndUpdateBestBid(addr, true); err != nil {
+			xzap.WithContext(om.Ctx).Warn("failed on update collection best bid",
+				zap.String("collection_addr", addr), zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// reloadCollectionBids 从数据库重新加载collection价格最高的100个有效集合出价
+func (om *OrderManager) reloadCollectionBids(address string) error {
+	tradeInfo, ok := om.collectionOrders[strings.ToLower(address)]
+	if !ok {
+		return errors.New("untracked collection")
+	}
+
+	var orders []*multi.Order
+	if err := om.DB.WithContext(om.writeCtx).Table(gdb.GetMultiProjectOrderTableName(om.project, om.chain)).
+		Select("order_id, price, maker").
+		Where("collection_address = ? and order_type = ? and order_status = ? and quantity_remaining > 0 and expire_time > ?",
+			address, multi.CollectionBidOrder, multi.OrderStatusActive, time.Now().Unix()).
+		Where("currency_address in (?)", nativeCurrencies).
+		Order("price desc").Limit(maxQueueLength).
+		Scan(&orders).Error; err != nil {
+		return errors.Wrap(err, "failed on get collection highest bids")
+	}
+
+	tradeInfo.bids = NewPriorityQueueMap(maxQueueLength)
+	for _, order := range orders {
+		addBid(tradeInfo.bids, order.OrderID, order.Price, order.Maker)
+	}
+	return nil
+}
+
+// checkAndUpdateBestBid 最高出价变化时更新ob_collection.sale_price，并发布最高出价订单到缓存，force为true时总是发布
+func (om *OrderManager) checkAndUpdateBestBid(address string, force bool) error {
+	tradeInfo, ok := om.collectionOrders[strings.ToLower(address)]
+	if !ok {
+		return errors.New("untracked collection")
+	}
+
+	orderID, price := getBestBid(tradeInfo.bids)
+	if !force && orderID == tradeInfo.bestBidOrderID && price.Equal(tradeInfo.bestBid) {
+		return nil
+	}
+
+	if !price.Equal(tradeInfo.bestBid) {
+		if err := om.DB.WithContext(om.writeCtx).Table(gdb.GetMultiProjectCollectionTableName(om.project, om.chain)).
+			Where("address = ?", address).Update("sale_price", price).Error; err != nil {
+			return errors.Wrap(err, "failed on update collection best bid")
+		}
+	}
+
+	if err := om.cacheBestBid(GenCollectionBestBidKey(om.chain, address), orderID); err != nil {
+		return errors.Wrap(err, "failed on cache collection best bid")
+	}
+
+	tradeInfo.bestBid = price
+	tradeInfo.bestBidOrderID = orderID
+	xzap.WithContext(om.Ctx).Info("update collection best bid",
+		zap.String("collection_addr", address), zap.String("order_id", orderID), zap.String("best_bid", price.String()))
+	return nil
+}
+
+// cacheBestBid 将出价订单发布到缓存，orderID为空时发布空订单
+func (om *OrderManager) cacheBestBid(key, orderID string) error {
+	var bestBid multi.Order
+	if orderID != "" {
+		if err := om.DB.WithContext(om.writeCtx).Table(gdb.GetMultiProjectOrderTableName(om.project, om.chain)).
+			Select("order_id, token_id, price, currency_address, event_time, expire_time, salt, maker, order_type, quantity_remaining, size").
+			Where("order_id = ?", orderID).
+			Scan(&bestBid).Error; err != nil {
+			return errors.Wrap(err, "failed on get best bid order")
+		}
+	}
+	raw, err := json.Marshal(bestBid)
+	if err != nil {
+		return errors.Wrap(err, "failed on marshal best bid")
+	}
+
+	return om.Xkv.SetString(key, string(raw))
+}
+
+// handleItemBidEvent 根据交易事件维护NFT的最高单品出价:
+// - 新的单品出价: 高于NFT当前最高出价时发布
+// - 取消、过期、失效或成交: 为NFT当前最高出价时从数据库重新查询并发布，部分成交的出价剩余数量变化也需要重新发布
+func (om *OrderManager) handleItemBidEvent(event *TradeEvent, tradeInfo *collectionTradeInfo) {
+	var err error
+	switch event.EventType {
+	case ItemBid:
+		if best, ok := tradeInfo.itemBids[event.TokenID]; ok && !event.Price.GreaterThan(best.price) {
+			return
+		}
+		err = om.updateItemBestBid(event.CollectionAddr, event.TokenID, tradeInfo,
+			&itemBestBid{orderID: event.OrderId, price: event.Price})
+	case Cancel, Expired, Inactive, BidFilled:
+		// 过期事件不包含tokenID，通过订单id查找出价的NFT
+		tokenID, ok := tradeInfo.itemBidTokens[event.OrderId]
+		if !ok {
+			return
+		}
+		err = om.reloadItemBestBid(event.CollectionAddr, tokenID, tradeInfo)
+	default:
+		return
+	}
+	if err != nil {
+		xzap.WithContext(om.Ctx).Error("failed on update item best bid",
+			zap.Int("event_type", int(event.EventType)), zap.String("order_id", event.OrderId),
+			zap.String("collection_addr", event.CollectionAddr), zap.String("token_id", event.TokenID), zap.Error(err))
+	}
+}
+
+// loadItemBids 启动时加载所有有效的单品出价，并发布每个NFT的最高出价
+func (om *OrderManager) loadItemBids() error {
+	var id int64
+	for {
+		var orders []*multi.Order
+		if err := om.DB.WithContext(om.Ctx).Table(gdb.GetMultiProjectOrderTableName(om.project, om.chain)).
+			Select("id, order_id, collection_address, token_id, price").
+			Where("order_type = ? and order_status = ? and quantity_remaining > 0 and expire_time > ? and id > ?",
+				multi.ItemBidOrder, multi.OrderStatusActive, time.Now().Unix(), id).
+			Where("currency_address in (?)", nativeCurrencies).
+			Order("id asc").Limit(1000).
+			Scan(&orders).Error; err != nil {
+			return errors.Wrap(err, "failed on get item bids")
+		}
+		for _, order := range orders {
+			tradeInfo, ok := om.collectionOrders[strings.ToLower(order.CollectionAddress)]
+			if !ok {
+				continue
+			}
+			if best, ok := tradeInfo.itemBids[order.TokenId]; ok && !order.Price.GreaterThan(best.price) {
+				continue
+			}
+			tradeInfo.itemBids[order.TokenId] = &itemBestBid{orderID: order.OrderID, price: order.Price}
+		}
+		if len(orders) < 1000 {
+			break
+		}
+
+		id = orders[len(orders)-1].ID
+	}
+
+	for addr, tradeInfo := range om.collectionOrders {
+		for tokenID, best := range tradeInfo.itemBids {
+			if err := om.updateItemBestBid(addr, tokenID, tradeInfo, best); err != nil {
+				xzap.WithContext(om.Ctx).Warn("failed on update item best bid",
+					zap.String("collection_addr", addr), zap.String("token_id", tokenID), zap.Error(err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// reloadItemBestBid 从数据库重新查询NFT价格最高的有效单品出价并发布
+func (om *OrderManager) reloadItemBestBid(address, tokenID string, tradeInfo *collectionTradeInfo) error {
+	var orders []*multi.Order
+	if err := om.DB.WithContext(om.writeCtx).Table(gdb.GetMultiProjectOrderTableName(om.project, om.chain)).
+		Select("order_id, price").
+		Where("collection_address = ? and token_id = ? and order_type = ? and order_status = ? and quantity_remaining > 0 and expire_time > ?",
+			address, tokenID, multi.ItemBidOrder, multi.OrderStatusActive, time.Now().Unix()).
+		Where("currency_address in (?)", nativeCurrencies).
+		Order("price desc").Limit(1).
+		Scan(&orders).Error; err != nil {
+		return errors.Wrap(err, "failed on get item highest bid")
+	}
+
+	var best *itemBestBid
+	if len(orders) > 0 {
+		best = &itemBestBid{orderID: orders[0].OrderID, price: orders[0].Price}
+	}
+	return om.updateItemBestBid(address, tokenID, tradeInfo, best)
+}
+
+// updateItemBestBid 发布NFT的最高单品出价，best为nil时发布空订单并不再跟踪该NFT
+func (om *OrderManager) updateItemBestBid(address, tokenID string, tradeInfo *collectionTradeInfo, best *itemBestBid) error {
+	orderID := ""
+	if best != nil {
+		orderID = best.orderID
+	}
+	if err := om.cacheBestBid(GenItemBestBidKey(om.chain, address, tokenID), orderID); err != nil {
+		return errors.Wrap(err, "failed on cache item best bid")
+	}
+
+	if prev, ok := tradeInfo.itemBids[tokenID]; ok {
+		delete(tradeInfo.itemBidTokens, prev.orderID)
+	}
+	if best == nil {
+		delete(tradeInfo.itemBids, tokenID)
+		return nil
+	}
+	tradeInfo.itemBids[tokenID] = best
+	tradeInfo.itemBidTokens[best.orderID] = tokenID
+	return nil
+}
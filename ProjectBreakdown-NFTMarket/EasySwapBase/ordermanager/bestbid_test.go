@@ -0,0 +1,225 @@
+package ordermanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/zeromicro/go-zero/core/stores/cache"
+	"github.com/zeromicro/go-zero/core/stores/kv"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
+)
+
+func TestBestBid(t *testing.T) {
+	bids := NewPriorityQueueMap(maxQueueLength)
+	id, price := getBestBid(bids)
+	assert.Equal(t, "", id)
+	assert.True(t, price.IsZero())
+
+	addBid(bids, "101", decimal.NewFromFloat(1.2), "a")
+	addBid(bids, "102", decimal.NewFromFloat(1.5), "b")
+	addBid(bids, "103", decimal.NewFromFloat(0.8), "c")
+
+	id, price = getBestBid(bids)
+	assert.Equal(t, "102", id)
+	assert.True(t, price.Equal(decimal.NewFromFloat(1.5)))
+	assert.True(t, bids.Contains("103"))
+
+	// 最高出价取消后次高出价成为最高出价
+	bids.Remove("102")
+	assert.False(t, bids.Contains("102"))
+	id, price = getBestBid(bids)
+	assert.Equal(t, "101", id)
+	assert.True(t, price.Equal(decimal.NewFromFloat(1.2)))
+
+	// 队列中保留的最低出价
+	id, price = bids.GetMax()
+	assert.Equal(t, "103", id)
+	assert.True(t, price.Neg().Equal(decimal.NewFromFloat(0.8)))
+}
+
+const testBidCollection = "0x00000000000000000000000000000000000000c0"
+
+func newTestOrderManager(t *testing.T) *OrderManager {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存数据库的每个连接相互独立，处理流程与测试共用同一个连接
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	tables := map[string]interface{}{
+		multi.OrderTableName("sepolia"):                 &multi.Order{},
+		multi.ItemTableName("sepolia"):                  &multi.Item{},
+		multi.CollectionTableName("sepolia"):            &multi.Collection{},
+		multi.CollectionFloorChangeTableName("sepolia"): &multi.CollectionFloorChange{},
+	}
+	for table, model := range tables {
+		// sqlite不识别MySQL风格的AUTO_INCREMENT标签，建表前将自增主键设为sqlite的自增主键类型
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.ParseWithSpecialTableName(model, table); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range stmt.Schema.PrimaryFields {
+			if field.AutoIncrement {
+				field.DataType = "integer PRIMARY KEY AUTOINCREMENT"
+			}
+		}
+		if err := db.Table(table).AutoMigrate(model); err != nil {
+			t.Fatalf("failed on migrate %s: %v", table, err)
+		}
+	}
+	// 与EasySwapSync/db/migrations中的item表结构一致，挂单查询按该列过滤
+	if err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN is_opensea_banned boolean NOT NULL DEFAULT false",
+		multi.ItemTableName("sepolia"))).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Table(multi.CollectionTableName("sepolia")).Create(&multi.Collection{Address: testBidCollection}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	mr := miniredis.RunT(t)
+	store := &xkv.Store{
+		Store: kv.NewStore(kv.KvConf{cache.NodeConf{RedisConf: redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType}, Weight: 100}}),
+		Redis: redis.MustNewRedis(redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType}),
+	}
+
+	return New(xzap.ToContext(context.Background(), zap.NewNop()), db, store, "sepolia", gdb.OrderBookDexProject)
+}
+
+func createBid(t *testing.T, om *OrderManager, orderID string, orderType int64, tokenID string, price float64) {
+	if err := om.DB.Table(multi.OrderTableName("sepolia")).Create(&multi.Order{
+		OrderID:           orderID,
+		OrderType:         orderType,
+		CollectionAddress: testBidCollection,
+		TokenId:           tokenID,
+		Price:             decimal.NewFromFloat(price),
+		Maker:             "0xa",
+		ExpireTime:        time.Now().Add(time.Hour).Unix(),
+		QuantityRemaining: 2,
+		Size:              2,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func updateBid(t *testing.T, om *OrderManager, orderID string, updates map[string]interface{}) {
+	if err := om.DB.Table(multi.OrderTableName("sepolia")).Where("order_id = ?", orderID).Updates(updates).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cachedBestBid 读取缓存的最高出价，缓存不存在时返回nil
+func cachedBestBid(om *OrderManager, key string) *multi.Order {
+	raw, err := om.Xkv.Get(key)
+	if err != nil || raw == "" {
+		return nil
+	}
+	var order multi.Order
+	if err := json.Unmarshal([]byte(raw), &order); err != nil {
+		return nil
+	}
+	return &order
+}
+
+func assertBestBid(t *testing.T, om *OrderManager, key, orderID string, quantity int64) {
+	assert.Eventually(t, func() bool {
+		order := cachedBestBid(om, key)
+		return order != nil && order.OrderID == orderID && order.QuantityRemaining == quantity
+	}, 2*time.Second, 10*time.Millisecond, "expected best bid %s in %s", orderID, key)
+}
+
+func TestFloorPriceProcessBestBid(t *testing.T) {
+	om := newTestOrderManager(t)
+	collectionKey := GenCollectionBestBidKey("sepolia", testBidCollection)
+	itemKey := GenItemBestBidKey("sepolia", testBidCollection, "1")
+	createBid(t, om, "c1", multi.CollectionBidOrder, "", 1)
+	createBid(t, om, "i1", multi.ItemBidOrder, "1", 0.5)
+
+	om.goSafe(om.floorPriceProcess)
+	defer func() {
+		assert.Nil(t, om.Stop(time.Second))
+	}()
+	// 启动时发布已有的最高出价
+	assertBestBid(t, om, collectionKey, "c1", 2)
+	assertBestBid(t, om, itemKey, "i1", 2)
+
+	push := func(event *TradeEvent) {
+		event.CollectionAddr = testBidCollection
+		assert.Nil(t, om.addUpdateFloorPriceEvent(event))
+	}
+
+	// 更高的集合出价成为最高出价
+	createBid(t, om, "c2", multi.CollectionBidOrder, "", 2)
+	push(&TradeEvent{EventType: CollectionBid, OrderId: "c2", Price: decimal.NewFromInt(2)})
+	assertBestBid(t, om, collectionKey, "c2", 2)
+
+	// 最高出价部分成交后重新发布剩余数量
+	updateBid(t, om, "c2", map[string]interface{}{"quantity_remaining": 1})
+	push(&TradeEvent{EventType: BidFilled, OrderId: "c2", TokenID: "7"})
+	assertBestBid(t, om, collectionKey, "c2", 1)
+
+	// 最高出价取消后次高出价成为最高出价
+	updateBid(t, om, "c2", map[string]interface{}{"order_status": multi.OrderStatusCancelled})
+	push(&TradeEvent{EventType: Cancel, OrderId: "c2"})
+	assertBestBid(t, om, collectionKey, "c1", 2)
+
+	// 单品出价按NFT分别维护，较低的出价不影响最高出价
+	createBid(t, om, "i2", multi.ItemBidOrder, "1", 0.8)
+	push(&TradeEvent{EventType: ItemBid, OrderId: "i2", TokenID: "1", Price: decimal.NewFromFloat(0.8)})
+	assertBestBid(t, om, itemKey, "i2", 2)
+	createBid(t, om, "i3", multi.ItemBidOrder, "1", 0.6)
+	push(&TradeEvent{EventType: ItemBid, OrderId: "i3", TokenID: "1", Price: decimal.NewFromFloat(0.6)})
+	createBid(t, om, "i4", multi.ItemBidOrder, "2", 0.1)
+	push(&TradeEvent{EventType: ItemBid, OrderId: "i4", TokenID: "2", Price: decimal.NewFromFloat(0.1)})
+	assertBestBid(t, om, GenItemBestBidKey("sepolia", testBidCollection, "2"), "i4", 2)
+	assertBestBid(t, om, itemKey, "i2", 2)
+
+	// 最高单品出价成交后从数据库重新查询
+	updateBid(t, om, "i2", map[string]interface{}{"quantity_remaining": 0, "order_status": multi.OrderStatusFilled})
+	push(&TradeEvent{EventType: BidFilled, OrderId: "i2", TokenID: "1"})
+	assertBestBid(t, om, itemKey, "i3", 2)
+
+	// 过期事件不包含tokenID，所有出价失效后发布空订单
+	updateBid(t, om, "i3", map[string]interface{}{"order_status": multi.OrderStatusExpired})
+	updateBid(t, om, "i1", map[string]interface{}{"order_status": multi.OrderStatusCancelled})
+	push(&TradeEvent{EventType: Expired, OrderId: "i3"})
+	assertBestBid(t, om, itemKey, "", 0)
+	assertBestBid(t, om, collectionKey, "c1", 2)
+}
+
+func TestFloorPriceProcessKeepsQueuedEvents(t *testing.T) {
+	om := newTestOrderManager(t)
+	createBid(t, om, "c1", multi.CollectionBidOrder, "", 1)
+	// 启动前进入队列的事件在加载数据库后继续处理
+	assert.Nil(t, om.addUpdateFloorPriceEvent(&TradeEvent{EventType: CollectionBid, OrderId: "c1",
+		CollectionAddr: testBidCollection, Price: decimal.NewFromInt(1)}))
+
+	om.goSafe(om.floorPriceProcess)
+	defer func() {
+		assert.Nil(t, om.Stop(time.Second))
+	}()
+	assert.Eventually(t, func() bool {
+		return len(om.collectionListedCh) == 1
+	}, 2*time.Second, 10*time.Millisecond, "expected queued event to be processed")
+	length, err := om.Xkv.Llen(genTradeEventsCacheKey("sepolia"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, length)
+}
@@ -0,0 +1,253 @@
+package ordermanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+const (
+	ExpirySchedulerMemory = "memory"
+	ExpirySchedulerRedis  = "redis"
+
+	// DefaultClaimLease : 任务被领取后未确认的租约时长(秒)，超时后其他实例可重新领取
+	DefaultClaimLease   = 60
+	CacheOrderExpiryPre = "cache:es:order_expiry:{%s}"
+)
+
+func GenOrderExpiryKey(chain string) string {
+	return fmt.Sprintf(CacheOrderExpiryPre, chain)
+}
+
+// ExpiryTask 订单过期任务
+type ExpiryTask struct {
+	OrderID        string
+	CollectionAddr string
+	ExpireAt       int64
+	// 领取时的租约截止时间，Ack时用于确认任务未被其他实例重新领取
+	lease int64
+}
+
+// ExpiryScheduler 订单过期调度器
+// Claim领取到期的任务，处理成功后Ack确认，未确认的任务由调度器决定是否重新投递
+type ExpiryScheduler interface {
+	// Schedule 添加订单过期任务，同一订单重复添加不会改变已有任务
+	Schedule(task ExpiryTask) error
+	// Claim 领取截至now已到期的任务，最多limit个
+	Claim(now int64, limit int) ([]ExpiryTask, error)
+	// Ack 确认任务已处理完成
+	Ack(task ExpiryTask) error
+	// Shared 调度任务是否持久化并在多个实例间共享
+	Shared() bool
+}
+
+// WithExpiryScheduler 指定订单过期调度器，多个同步实例部署时使用共享的RedisExpiryScheduler
+func WithExpiryScheduler(scheduler ExpiryScheduler) Option {
+	return func(om *OrderManager) {
+		om.expiryScheduler = scheduler
+	}
+}
+
+// TimeWheelScheduler 进程内的时间轮调度器，任务随进程重启丢失，启动时需从数据库重新加载
+type TimeWheelScheduler struct {
+	mu sync.Mutex
+	// cycle time wheel
+	timeWheel [WheelSize]wheel
+	// current time wheel index
+	currentIndex int64
+	// 时间轮已推进到的时间
+	lastTick int64
+	// 已到期但未被领取的任务
+	ready []ExpiryTask
+}
+
+func NewTimeWheelScheduler() *TimeWheelScheduler {
+	return newTimeWheelScheduler(time.Now().Unix())
+}
+
+func newTimeWheelScheduler(now int64) *TimeWheelScheduler {
+	return &TimeWheelScheduler{lastTick: now}
+}
+
+// Schedule 根据过期时间计算订单在时间轮上的位置，并插入到对应位置的链表头部
+func (s *TimeWheelScheduler) Schedule(task ExpiryTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 第k次推进处理的位置为currentIndex+k-1，已过期的任务在下一次推进时处理
+	delaySeconds := task.ExpireAt - s.lastTick
+	if delaySeconds < 1 {
+		delaySeconds = 1
+	}
+	index := (s.currentIndex + delaySeconds - 1) % WheelSize
+	orderActivity := &Order{
+		orderID:        task.OrderID,
+		CollectionAddr: task.CollectionAddr,
+		ExpireAt:       task.ExpireAt,
+		CycleCount:     (delaySeconds - 1) / WheelSize,
+		WheelPosition:  index,
+		Next:           s.timeWheel[index].NotifyActivities,
+	}
+	s.timeWheel[index].NotifyActivities = orderActivity
+	return nil
+}
+
+// Claim 将时间轮推进到now，返回到期的任务
+func (s *TimeWheelScheduler) Claim(now int64, limit int) ([]ExpiryTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ; s.lastTick < now; s.lastTick++ {
+		s.tick()
+	}
+
+	if limit <= 0 || limit > len(s.ready) {
+		limit = len(s.ready)
+	}
+	tasks := s.ready[:limit:limit]
+	s.ready = s.ready[limit:]
+	return tasks, nil
+}
+
+// tick 处理当前时间槽：圈数为0的任务到期，其余任务圈数减1
+func (s *TimeWheelScheduler) tick() {
+	prev := (*Order)(nil)
+	for p := s.timeWheel[s.currentIndex].NotifyActivities; p != nil; p = p.Next {
+		if p.CycleCount > 0 {
+			p.CycleCount--
+			prev = p
+			continue
+		}
+
+		s.ready = append(s.ready, ExpiryTask{OrderID: p.orderID, CollectionAddr: p.CollectionAddr, ExpireAt: p.ExpireAt})
+		// 从链表中删除该节点
+		if prev == nil {
+			s.timeWheel[s.currentIndex].NotifyActivities = p.Next
+		} else {
+			prev.Next = p.Next
+		}
+	}
+	s.currentIndex = (s.currentIndex + 1) % WheelSize
+}
+
+// Ack 时间轮中的任务领取时已删除，无需确认
+func (s *TimeWheelScheduler) Ack(task ExpiryTask) error {
+	return nil
+}
+
+func (s *TimeWheelScheduler) Shared() bool {
+	return false
+}
+
+// GetWheelTaskQuantity :check tasks number in the time wheel at the moment
+func (s *TimeWheelScheduler) GetWheelTaskQuantity(index int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var orderNum int64
+	for p := s.timeWheel[index].NotifyActivities; p != nil; p = p.Next {
+		orderNum++
+	}
+	return orderNum
+}
+
+const (
+	// scheduleScript 仅在任务不存在时添加，避免重新加载订单时覆盖已被领取任务的租约
+	scheduleScript = `return redis.call('ZADD', KEYS[1], 'NX', ARGV[1], ARGV[2])`
+	// claimScript 领取到期任务，并将其score设置为租约截止时间，租约到期未确认的任务可被重新领取
+	claimScript = `local tasks = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, member in ipairs(tasks) do
+    redis.call('ZADD', KEYS[1], ARGV[3], member)
+end
+return tasks`
+	// ackScript 仅当任务仍处于本次领取的租约中时删除
+	ackScript = `local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score and tonumber(score) == tonumber(ARGV[2]) then
+    return redis.call('ZREM', KEYS[1], ARGV[1])
+end
+return 0`
+)
+
+// RedisExpiryScheduler 基于Redis有序集合的调度器，score为到期时间，多个实例共享同一集合
+// 任务领取后score改为租约截止时间，实例崩溃或处理失败未确认的任务在租约到期后重新投递
+type RedisExpiryScheduler struct {
+	rds   *redis.Redis
+	key   string
+	lease int64
+}
+
+// NewRedisExpiryScheduler lease为领取后的租约时长(秒)，为0时使用默认值
+func NewRedisExpiryScheduler(rds *redis.Redis, chain string, lease int64) *RedisExpiryScheduler {
+	if lease <= 0 {
+		lease = DefaultClaimLease
+	}
+	return &RedisExpiryScheduler{
+		rds:   rds,
+		key:   GenOrderExpiryKey(chain),
+		lease: lease,
+	}
+}
+
+// member 格式为 order_id:collection_address:expire_at，订单的过期时间不可修改，同一订单对应同一成员
+func (s *RedisExpiryScheduler) member(task ExpiryTask) string {
+	return fmt.Sprintf("%s:%s:%d", task.OrderID, task.CollectionAddr, task.ExpireAt)
+}
+
+func (s *RedisExpiryScheduler) parseMember(member string) (ExpiryTask, error) {
+	parts := strings.Split(member, ":")
+	if len(parts) != 3 {
+		return ExpiryTask{}, errors.Errorf("invalid expiry task: %s", member)
+	}
+	expireAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return ExpiryTask{}, errors.Wrapf(err, "invalid expiry task: %s", member)
+	}
+	return ExpiryTask{OrderID: parts[0], CollectionAddr: parts[1], ExpireAt: expireAt}, nil
+}
+
+func (s *RedisExpiryScheduler) Schedule(task ExpiryTask) error {
+	if _, err := s.rds.Eval(scheduleScript, []string{s.key}, task.ExpireAt, s.member(task)); err != nil {
+		return errors.Wrap(err, "failed on schedule order expiry")
+	}
+	return nil
+}
+
+func (s *RedisExpiryScheduler) Claim(now int64, limit int) ([]ExpiryTask, error) {
+	lease := now + s.lease
+	resp, err := s.rds.Eval(claimScript, []string{s.key}, now, limit, lease)
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrap(err, "failed on claim expired orders")
+	}
+
+	members, _ := resp.([]interface{})
+	tasks := make([]ExpiryTask, 0, len(members))
+	for _, m := range members {
+		member, _ := m.(string)
+		task, err := s.parseMember(member)
+		if err != nil { // 无法解析的任务直接删除
+			if _, err := s.rds.Zrem(s.key, member); err != nil {
+				return nil, errors.Wrap(err, "failed on remove invalid expiry task")
+			}
+			continue
+		}
+		task.lease = lease
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func (s *RedisExpiryScheduler) Ack(task ExpiryTask) error {
+	if _, err := s.rds.Eval(ackScript, []string{s.key}, s.member(task), task.lease); err != nil && err != redis.Nil {
+		return errors.Wrap(err, "failed on ack order expiry")
+	}
+	return nil
+}
+
+func (s *RedisExpiryScheduler) Shared() bool {
+	return true
+}
@@ -0,0 +1,158 @@
+package ordermanager
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+func taskIDs(tasks []ExpiryTask) []string {
+	var ids []string
+	for _, task := range tasks {
+		ids = append(ids, task.OrderID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestTimeWheelSchedulerClaim(t *testing.T) {
+	now := int64(10000)
+	s := newTimeWheelScheduler(now)
+	assert.Nil(t, s.Schedule(ExpiryTask{OrderID: "a", CollectionAddr: "0xc1", ExpireAt: now + 2}))
+	assert.Nil(t, s.Schedule(ExpiryTask{OrderID: "b", CollectionAddr: "0xc1", ExpireAt: now + WheelSize}))
+	assert.Nil(t, s.Schedule(ExpiryTask{OrderID: "c", CollectionAddr: "0xc1", ExpireAt: now + WheelSize + 5}))
+	assert.Nil(t, s.Schedule(ExpiryTask{OrderID: "d", CollectionAddr: "0xc1", ExpireAt: now - 5}))
+
+	tasks, err := s.Claim(now+1, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d"}, taskIDs(tasks))
+
+	tasks, _ = s.Claim(now+2, 10)
+	assert.Equal(t, []string{"a"}, taskIDs(tasks))
+	assert.Equal(t, "0xc1", tasks[0].CollectionAddr)
+
+	tasks, _ = s.Claim(now+WheelSize-1, 10)
+	assert.Empty(t, tasks)
+	tasks, _ = s.Claim(now+WheelSize, 10)
+	assert.Equal(t, []string{"b"}, taskIDs(tasks))
+	tasks, _ = s.Claim(now+WheelSize+4, 10)
+	assert.Empty(t, tasks)
+
+	// 超过limit的到期任务留待下次领取
+	assert.Nil(t, s.Schedule(ExpiryTask{OrderID: "e", ExpireAt: now + WheelSize + 5}))
+	tasks, _ = s.Claim(now+WheelSize+5, 1)
+	assert.Len(t, tasks, 1)
+	tasks, _ = s.Claim(now+WheelSize+5, 1)
+	assert.Len(t, tasks, 1)
+	tasks, _ = s.Claim(now+WheelSize+5, 1)
+	assert.Empty(t, tasks)
+}
+
+func TestTimeWheelSchedulerRestart(t *testing.T) {
+	now := int64(10000)
+	active := []ExpiryTask{
+		{OrderID: "a", ExpireAt: now + 10},
+		{OrderID: "b", ExpireAt: now + 100},
+		{OrderID: "c", ExpireAt: now + 200},
+	}
+	s := newTimeWheelScheduler(now)
+	for _, task := range active {
+		assert.Nil(t, s.Schedule(task))
+	}
+	tasks, _ := s.Claim(now+10, 10)
+	assert.Equal(t, []string{"a"}, taskIDs(tasks))
+
+	// 进程在now+50停止，now+150重启：时间轮为空，从数据库重新加载仍有效的订单
+	restarted := newTimeWheelScheduler(now + 150)
+	assert.False(t, restarted.Shared())
+	tasks, _ = restarted.Claim(now+151, 10)
+	assert.Empty(t, tasks)
+	for _, task := range active[1:] {
+		assert.Nil(t, restarted.Schedule(task))
+	}
+
+	// 停机期间到期的订单在重启后立即到期，其余订单按原过期时间到期
+	tasks, _ = restarted.Claim(now+152, 10)
+	assert.Equal(t, []string{"b"}, taskIDs(tasks))
+	tasks, _ = restarted.Claim(now+199, 10)
+	assert.Empty(t, tasks)
+	tasks, _ = restarted.Claim(now+200, 10)
+	assert.Equal(t, []string{"c"}, taskIDs(tasks))
+}
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Redis) {
+	mr := miniredis.RunT(t)
+	return mr, redis.MustNewRedis(redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType})
+}
+
+func TestRedisExpirySchedulerClaimAck(t *testing.T) {
+	_, rds := newTestRedis(t)
+	now := int64(10000)
+	s1 := NewRedisExpiryScheduler(rds, "sepolia", 30)
+	s2 := NewRedisExpiryScheduler(rds, "sepolia", 30)
+	assert.True(t, s1.Shared())
+
+	assert.Nil(t, s1.Schedule(ExpiryTask{OrderID: "a", CollectionAddr: "0xc1", ExpireAt: now - 1}))
+	assert.Nil(t, s2.Schedule(ExpiryTask{OrderID: "b", CollectionAddr: "0xc2", ExpireAt: now}))
+	assert.Nil(t, s1.Schedule(ExpiryTask{OrderID: "c", CollectionAddr: "0xc1", ExpireAt: now + 10}))
+
+	// 两个实例共享任务，同一任务只被领取一次
+	tasks, err := s1.Claim(now, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ExpiryTask{{OrderID: "a", CollectionAddr: "0xc1", ExpireAt: now - 1, lease: now + 30}}, tasks)
+	claimed, err := s2.Claim(now, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b"}, taskIDs(claimed))
+	tasks, _ = s1.Claim(now, 10)
+	assert.Empty(t, tasks)
+
+	// 确认后任务删除，租约到期后也不会重新投递
+	assert.Nil(t, s2.Ack(claimed[0]))
+	tasks, _ = s1.Claim(now+10, 10)
+	assert.Equal(t, []string{"c"}, taskIDs(tasks))
+	tasks, _ = s1.Claim(now+30, 10)
+	assert.Equal(t, []string{"a"}, taskIDs(tasks))
+}
+
+func TestRedisExpirySchedulerRestart(t *testing.T) {
+	mr, rds := newTestRedis(t)
+	now := int64(10000)
+	s := NewRedisExpiryScheduler(rds, "sepolia", 30)
+	for _, task := range []ExpiryTask{
+		{OrderID: "a", CollectionAddr: "0xc1", ExpireAt: now + 10},
+		{OrderID: "b", CollectionAddr: "0xc1", ExpireAt: now + 100},
+		{OrderID: "c", CollectionAddr: "0xc1", ExpireAt: now + 200},
+	} {
+		assert.Nil(t, s.Schedule(task))
+	}
+	// 领取后未确认即崩溃
+	tasks, _ := s.Claim(now+10, 10)
+	assert.Equal(t, []string{"a"}, taskIDs(tasks))
+	stale := tasks[0]
+
+	// 新实例在now+150启动，任务仍保存在Redis中，重新加载订单不影响已有任务
+	restarted := NewRedisExpiryScheduler(redis.MustNewRedis(redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType}), "sepolia", 30)
+	for _, task := range []ExpiryTask{
+		{OrderID: "a", CollectionAddr: "0xc1", ExpireAt: now + 10},
+		{OrderID: "c", CollectionAddr: "0xc1", ExpireAt: now + 200},
+	} {
+		assert.Nil(t, restarted.Schedule(task))
+	}
+	tasks, _ = restarted.Claim(now+150, 10)
+	assert.Equal(t, []string{"a", "b"}, taskIDs(tasks))
+	assert.Nil(t, restarted.Ack(tasks[1]))
+
+	// 过期租约的确认不会删除已被重新领取的任务，未确认的任务在租约到期后再次投递
+	assert.Nil(t, s.Ack(stale))
+	tasks, _ = restarted.Claim(now+180, 10)
+	assert.Equal(t, []string{"a"}, taskIDs(tasks))
+	assert.Nil(t, restarted.Ack(tasks[0]))
+	tasks, _ = restarted.Claim(now+200, 10)
+	assert.Equal(t, []string{"c"}, taskIDs(tasks))
+	assert.Nil(t, restarted.Ack(tasks[0]))
+	tasks, _ = restarted.Claim(now+1000, 10)
+	assert.Empty(t, tasks)
+}
@@ -10,9 +10,11 @@ import (
 	"github.com/shopspring/decimal"
 	"github.com/zeromicro/go-zero/core/stores/redis"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 
 	"github.com/ProjectsTask/EasySwapBase/stores/gdb"
 
+	"github.com/ProjectsTask/EasySwapBase/currency"
 	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
 	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
 	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
@@ -29,6 +31,10 @@ const (
 	Expired          EventType = 9
 	ImportCollection EventType = 10
 	UpdateCollection EventType = 11
+	Inactive         EventType = 12 // 订单链上校验不通过，暂时不可成交
+	CollectionBid    EventType = 13 // 新的集合出价
+	BidFilled        EventType = 14 // 出价成交，可能部分成交
+	ItemBid          EventType = 15 // 新的单个NFT出价
 )
 
 const (
@@ -38,9 +44,21 @@ const (
 
 const CacheTradeEventsQueuePre = "cache:es:trade:events:%s"
 
+// 地板价只统计原生币计价的挂单，历史数据中currency_address可能为空
+var nativeCurrencies = []string{"", currency.NativeAddress}
+
 type collectionTradeInfo struct {
 	floorPrice decimal.Decimal
 	orders     *PriorityQueueMap // 优先级队列
+
+	// 最高的原生币集合出价
+	bestBid        decimal.Decimal
+	bestBidOrderID string
+	bids           *PriorityQueueMap
+
+	// 各NFT已发布的最高原生币单品出价，tokenID -> 出价，及订单id -> tokenID
+	itemBids      map[string]*itemBestBid
+	itemBidTokens map[string]string
 }
 
 type TradeEvent struct {
@@ -56,27 +74,30 @@ type TradeEvent struct {
 }
 
 func (om *OrderManager) floorPriceProcess() {
-	// 清空缓存中的剩余事件
 	key := genTradeEventsCacheKey(om.chain)
-	if err := om.Xkv.Redis.Ltrim(key, 1, 0); err != nil { // clear all value
-		xzap.WithContext(om.Ctx).Error("failed on flush remaining trade events", zap.Error(err))
-	}
 
-	// 从数据库加载订单并更新地板价
+	// 从数据库加载订单并更新地板价，上次停止时未处理的事件保留在队列中，加载后按顺序继续处理
 	if err := om.loadCollectionTradeInfo(); err != nil {
 		xzap.WithContext(om.Ctx).Error("[Order Manage] load orders to queue", zap.Error(err))
 		return
 	}
 
-	// 持续监听并处理交易事件
+	// 持续监听并处理交易事件，停止时未处理的事件保留在队列中
 	for {
+		select {
+		case <-om.Ctx.Done():
+			xzap.WithContext(om.Ctx).Info("floorPriceProcess stopped due to context cancellation")
+			return
+		default:
+		}
+
 		// 从缓存中获取交易事件
 		result, err := om.Xkv.Lpop(key)
 		if err != nil || result == "" {
 			if err != nil && err != redis.Nil {
 				xzap.WithContext(om.Ctx).Warn("failed on get trade events from cache", zap.Error(err), zap.String("result", result))
 			}
-			time.Sleep(1 * time.Second)
+			om.sleep(1 * time.Second)
 			continue
 		}
 
@@ -95,9 +116,17 @@ func (om *OrderManager) floorPriceProcess() {
 			continue
 		}
 
-		// 通知collection状态更新
+		// 通知collection状态更新，listCountProcess已退出时不再阻塞
 		if event.CollectionAddr != "" {
-			om.collectionListedCh <- event.CollectionAddr
+			select {
+			case om.collectionListedCh <- event.CollectionAddr:
+			case <-om.Ctx.Done():
+			}
+		}
+
+		// 更新最高集合出价
+		if tradeInfo != nil {
+			om.handleBidEvent(&event, tradeInfo)
 		}
 
 		// 根据不同事件类型处理
@@ -109,14 +138,14 @@ func (om *OrderManager) floorPriceProcess() {
 				tradeInfo.orders.Add(event.OrderId, event.Price, event.From, event.TokenID)
 			}
 			// 更新地板价
-			if err := om.checkAndUpdateFloorPrice(event.CollectionAddr); err != nil {
+			if err := om.checkAndUpdateFloorPrice(&event); err != nil {
 				xzap.WithContext(om.Ctx).Error("failed on update collection floor price",
 					zap.Int("event_type", int(event.EventType)), zap.String("order_id", event.OrderId),
 					zap.String("collection_addr", event.CollectionAddr), zap.String("floor_price", event.Price.String()),
 					zap.Error(err))
 			}
 
-		case Cancel, Expired: // 取消或过期事件
+		case Cancel, Expired, Inactive: // 取消、过期或失效事件
 			// 从队列中删除订单
 			tradeInfo.orders.Remove(event.OrderId)
 			if tradeInfo.orders.Len() == 0 {
@@ -129,7 +158,7 @@ func (om *OrderManager) floorPriceProcess() {
 				}
 			}
 			// 更新地板价
-			if err := om.checkAndUpdateFloorPrice(event.CollectionAddr); err != nil {
+			if err := om.checkAndUpdateFloorPrice(&event); err != nil {
 				xzap.WithContext(om.Ctx).Error("failed on update collection floor price",
 					zap.Int("event_type", int(event.EventType)), zap.String("order_id", event.OrderId),
 					zap.String("collection_addr", event.CollectionAddr),
@@ -186,7 +215,7 @@ func (om *OrderManager) floorPriceProcess() {
 			}
 
 			// 更新地板价
-			if err := om.checkAndUpdateFloorPrice(event.CollectionAddr); err != nil {
+			if err := om.checkAndUpdateFloorPrice(&event); err != nil {
 				xzap.WithContext(om.Ctx).Error("failed on update collection floor price",
 					zap.Int("event_type", int(event.EventType)), zap.String("order_id", event.OrderId),
 					zap.String("collection_addr", event.CollectionAddr), zap.String("floor_price", event.Price.String()),
@@ -203,11 +232,23 @@ func (om *OrderManager) floorPriceProcess() {
 
 			// 初始化新的Collection信息
 			om.collectionOrders[strings.ToLower(event.CollectionAddr)] = &collectionTradeInfo{
-				floorPrice: decimal.Zero,
-				orders:     NewPriorityQueueMap(maxQueueLength),
+				floorPrice:    decimal.Zero,
+				orders:        NewPriorityQueueMap(maxQueueLength),
+				bids:          NewPriorityQueueMap(maxQueueLength),
+				itemBids:      make(map[string]*itemBestBid),
+				itemBidTokens: make(map[string]string),
 			}
 
 		case UpdateCollection: // 更新Collection事件
+			// 重新加载集合出价，数据库中的订单被修复或重建后出价可能变化
+			if err := om.reloadCollectionBids(event.CollectionAddr); err != nil {
+				xzap.WithContext(om.Ctx).Error("failed on reload collection bids",
+					zap.String("collection_addr", event.CollectionAddr), zap.Error(err))
+			} else if err := om.checkAndUpdateBestBid(event.CollectionAddr, false); err != nil {
+				xzap.WithContext(om.Ctx).Error("failed on update collection best bid",
+					zap.String("collection_addr", event.CollectionAddr), zap.Error(err))
+			}
+
 			// 检查地板价是否变化
 			_, floorPrice := tradeInfo.orders.GetMin()
 			if floorPrice.Equal(event.Price) {
@@ -221,13 +262,15 @@ func (om *OrderManager) floorPriceProcess() {
 					zap.String("collection_addr", event.CollectionAddr), zap.Error(err))
 				continue
 			}
-			if err := om.checkAndUpdateFloorPrice(event.CollectionAddr); err != nil {
+			if err := om.checkAndUpdateFloorPrice(&event); err != nil {
 				xzap.WithContext(om.Ctx).Error("failed on update collection floor price",
 					zap.Int("event_type", int(event.EventType)), zap.String("order_id", event.OrderId),
 					zap.String("collection_addr", event.CollectionAddr), zap.String("floor_price", event.Price.String()),
 					zap.Error(err))
 			}
 
+		case CollectionBid, ItemBid, BidFilled: // 出价事件不影响地板价
+
 		default:
 			xzap.WithContext(om.Ctx).Error("unsupported event type", zap.String("event content", result))
 		}
@@ -239,7 +282,7 @@ func (om *OrderManager) loadCollectionTradeInfo() error {
 	// 1. 从数据库加载所有集合信息
 	var collections []*multi.Collection
 	if err := om.DB.WithContext(om.Ctx).Table(gdb.GetMultiProjectCollectionTableName(om.project, om.chain)).
-		Select("id, address, floor_price").Where("1=1").Scan(&collections).Error; err != nil {
+		Select("id, address, floor_price, sale_price").Where("1=1").Scan(&collections).Error; err != nil {
 		return errors.Wrap(err, "failed on get collection floor price")
 	}
 	var collectionAddrs []string
@@ -249,6 +292,11 @@ func (om *OrderManager) loadCollectionTradeInfo() error {
 		om.collectionOrders[strings.ToLower(collection.Address)] = &collectionTradeInfo{
 			floorPrice: collection.FloorPrice,
 			orders:     NewPriorityQueueMap(maxQueueLength), // 优先级队列,限制最大长度
+			bestBid:    collection.SalePrice,
+			bids:       NewPriorityQueueMap(maxQueueLength), // 集合出价队列,保留价格最高的出价
+
+			itemBids:      make(map[string]*itemBestBid),
+			itemBidTokens: make(map[string]string),
 		}
 	}
 
@@ -266,6 +314,7 @@ func (om *OrderManager) loadCollectionTradeInfo() error {
 			Select("co.id as id ,co.order_id as order_id, co.collection_address as collection_address, co.price as price,co.maker as maker,co.token_id as token_id").
 			Joins(fmt.Sprintf("join %s ci on co.collection_address = ci.collection_address and co.token_id = ci.token_id", gdb.GetMultiProjectItemTableName(om.project, om.chain))).
 			Where("co.order_type=? and co.order_status = ? and co.maker = ci.owner  and (ci.is_opensea_banned,co.marketplace_id)!=(true,1) and co.id > ?", multi.ListingType, multi.OrderStatusActive, id).
+			Where("co.currency_address in (?)", nativeCurrencies).
 			Order("co.id asc").Limit(1000).
 			Scan(&orders).Error; err != nil {
 			return errors.Wrap(err, "failed on get collection orders")
@@ -288,38 +337,46 @@ func (om *OrderManager) loadCollectionTradeInfo() error {
 		ordersQueue.orders.Add(order.OrderID, order.Price, order.Maker, order.TokenId)
 	}
 
-	// 4. 检查并更新每个集合的地板价
-	for addr, tradeInfo := range om.collectionOrders {
-		_, floorPrice := tradeInfo.orders.GetMin()
-		if !floorPrice.Equal(tradeInfo.floorPrice) {
-			om.collectionOrders[strings.ToLower(addr)].floorPrice = floorPrice
-			if err := om.updateFloorPrice(addr, floorPrice); err != nil {
-				xzap.WithContext(om.Ctx).Warn("failed on update collection floor price",
-					zap.String("collection_addr", addr), zap.Error(err))
-			}
+	// 4. 检查并更新每个集合的地板价，停机期间的变化记录为无触发订单的变化事件
+	for addr := range om.collectionOrders {
+		if err := om.checkAndUpdateFloorPrice(&TradeEvent{CollectionAddr: addr}); err != nil {
+			xzap.WithContext(om.Ctx).Warn("failed on update collection floor price",
+				zap.String("collection_addr", addr), zap.Error(err))
 		}
 	}
 
-	return nil
+	// 5. 加载集合出价及单品出价并更新最高出价
+	if err := om.loadCollectionBids(); err != nil {
+		return err
+	}
+	return om.loadItemBids()
 }
 
-func (om *OrderManager) updateFloorPrice(collectionAddr string, price decimal.Decimal) error {
-	if err := om.DB.WithContext(om.Ctx).Table(gdb.GetMultiProjectCollectionTableName(om.project, om.chain)).
-		Where("address=?", collectionAddr).Update("floor_price", price).Error; err != nil {
-		return errors.Wrap(err, "failed on update collection floor price")
-	}
-	return nil
+func (om *OrderManager) updateFloorPrice(collectionAddr string, change *multi.CollectionFloorChange) error {
+	return om.DB.WithContext(om.writeCtx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table(gdb.GetMultiProjectCollectionTableName(om.project, om.chain)).
+			Where("address=?", collectionAddr).Update("floor_price", change.NewPrice).Error; err != nil {
+			return errors.Wrap(err, "failed on update collection floor price")
+		}
+		// 记录地板价变化事件，用于地板价历史曲线
+		if err := tx.Table(gdb.GetMultiProjectCollectionFloorChangeTableName(om.project, om.chain)).
+			Create(change).Error; err != nil {
+			return errors.Wrap(err, "failed on create collection floor change")
+		}
+		return nil
+	})
 }
 
 // checkAndUpdateFloorPrice 函数用于检查和更新NFT集合的地板价
 // 主要功能包括:
 // 1. 检查集合是否被跟踪
 // 2. 获取集合当前最低价格
-// 3. 如果最低价格发生变化,则更新缓存和数据库中的地板价
+// 3. 如果最低价格发生变化,则更新数据库中的地板价并记录变化事件,成功后更新缓存
 // 参数说明:
-// - address: NFT集合地址
-func (om *OrderManager) checkAndUpdateFloorPrice(address string) error {
+// - event: 触发地板价检查的交易事件
+func (om *OrderManager) checkAndUpdateFloorPrice(event *TradeEvent) error {
 	// 1. 检查集合是否被跟踪
+	address := event.CollectionAddr
 	tradeInfo, ok := om.collectionOrders[strings.ToLower(address)]
 	if !ok {
 		xzap.WithContext(om.Ctx).Warn("untracked collection", zap.String("collection_addr", address))
@@ -331,15 +388,21 @@ func (om *OrderManager) checkAndUpdateFloorPrice(address string) error {
 
 	// 3. 如果最低价格发生变化,则更新地板价
 	if !newFloorPrice.Equal(tradeInfo.floorPrice) {
-		// 更新内存缓存中的地板价
-		tradeInfo.floorPrice = newFloorPrice
-		om.collectionOrders[strings.ToLower(address)] = tradeInfo
-
-		// 更新数据库中的地板价
-		if err := om.updateFloorPrice(address, newFloorPrice); err != nil {
+		// 更新数据库中的地板价并记录变化事件
+		if err := om.updateFloorPrice(address, &multi.CollectionFloorChange{
+			CollectionAddress: strings.ToLower(address),
+			OldPrice:          tradeInfo.floorPrice,
+			NewPrice:          newFloorPrice,
+			OrderId:           event.OrderId,
+			EventType:         int(event.EventType),
+			EventTime:         time.Now().Unix(),
+		}); err != nil {
 			return errors.Wrap(err, "failed on update collection floor price")
 		}
 
+		// 更新内存缓存中的地板价
+		tradeInfo.floorPrice = newFloorPrice
+
 		// 记录地板价更新日志
 		xzap.WithContext(om.Ctx).Info("update collection floor price",
 			zap.String("collection_addr", address), zap.String("floor_price", newFloorPrice.String()))
@@ -364,10 +427,11 @@ func (om *OrderManager) checkAndUpdateFloorPrice(address string) error {
 // - error: 错误信息
 func (om *OrderManager) getLowestPrice100Orders(address string) ([]*multi.Order, error) {
 	var orders []*multi.Order
-	if err := om.DB.WithContext(om.Ctx).Table(fmt.Sprintf("%s as co", gdb.GetMultiProjectOrderTableName(om.project, om.chain))).
+	if err := om.DB.WithContext(om.writeCtx).Table(fmt.Sprintf("%s as co", gdb.GetMultiProjectOrderTableName(om.project, om.chain))).
 		Select("co.id,co.order_id as order_id, co.collection_address, co.price, co.maker,co.token_id").
 		Joins(fmt.Sprintf("join %s ci on co.collection_address = ci.collection_address and co.token_id = ci.token_id", gdb.GetMultiProjectItemTableName(om.project, om.chain))).
 		Where("co.order_type=? and co.order_status = ? and co.maker = ci.owner  and (ci.is_opensea_banned,co.marketplace_id)!=(true,1)", multi.ListingType, multi.OrderStatusActive).
+		Where("co.currency_address in (?)", nativeCurrencies).
 		Where("co.collection_address = ?", address).Order("co.price asc").Limit(100).
 		Scan(&orders).Error; err != nil {
 		return nil, errors.Wrap(err, "failed on get collection lowest price orders")
@@ -433,10 +497,11 @@ type ValidOrder struct {
 // - error: 错误信息
 func (om *OrderManager) getUserValidOrders(address, tokenID, maker string) ([]*ValidOrder, error) {
 	var orders []*ValidOrder
-	if err := om.DB.WithContext(om.Ctx).Table(fmt.Sprintf("%s as co", gdb.GetMultiProjectOrderTableName(om.project, om.chain))).
+	if err := om.DB.WithContext(om.writeCtx).Table(fmt.Sprintf("%s as co", gdb.GetMultiProjectOrderTableName(om.project, om.chain))).
 		Select("co.id as id,co.order_id as order_id, co.maker as maker,ci.is_opensea_banned as is_opensea_banned, co.collection_address as collection_address, co.price as price,co.token_id as token_id").
 		Joins(fmt.Sprintf("join %s ci on co.collection_address = ci.collection_address and co.token_id = ci.token_id", gdb.GetMultiProjectItemTableName(om.project, om.chain))).
 		Where("co.order_type=? and co.order_status = ? and co.maker = ci.owner  and (ci.is_opensea_banned,co.marketplace_id)!=(true,1)", multi.ListingType, multi.OrderStatusActive).
+		Where("co.currency_address in (?)", nativeCurrencies).
 		Where("co.collection_address = ? and co.token_id=?"+
 			" and co.maker = ?", address, tokenID, maker).Order("co.price asc").Limit(100).
 		Scan(&orders).Error; err != nil {
@@ -463,8 +528,8 @@ func (om *OrderManager) addUpdateFloorPriceEvent(event *TradeEvent) error {
 	if event.EventType != Transfer && event.EventType != ImportCollection && event.EventType != UpdateCollection && event.OrderId == "" {
 		return errors.New("invalid update collection floor price. event order id is null")
 	}
-	// 如果是Listing事件,价格不能为0
-	if event.EventType == Listing && event.Price.IsZero() {
+	// 如果是Listing或出价事件,价格不能为0
+	if (event.EventType == Listing || event.EventType == CollectionBid || event.EventType == ItemBid) && event.Price.IsZero() {
 		return errors.New("invalid update collection floor price. price is 0")
 	}
 
@@ -506,8 +571,8 @@ func AddUpdatePriceEvent(kv *xkv.Store, event *TradeEvent, chain string) error {
 	if event.EventType != Transfer && event.EventType != ImportCollection && event.EventType != UpdateCollection && event.OrderId == "" {
 		return errors.New("invalid update collection floor price. event order id is null")
 	}
-	// 如果是Listing事件,价格不能为0
-	if event.EventType == Listing && event.Price.IsZero() {
+	// 如果是Listing或出价事件,价格不能为0
+	if (event.EventType == Listing || event.EventType == CollectionBid || event.EventType == ItemBid) && event.Price.IsZero() {
 		return errors.New("invalid update collection floor price. price is 0")
 	}
 	// 如果是Listing事件,必须有TokenID
@@ -0,0 +1,68 @@
+package ordermanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+)
+
+func TestCheckAndUpdateFloorPriceChanges(t *testing.T) {
+	om := newTestOrderManager(t)
+	tradeInfo := &collectionTradeInfo{
+		floorPrice: decimal.NewFromInt(5),
+		orders:     NewPriorityQueueMap(maxQueueLength),
+		bids:       NewPriorityQueueMap(maxQueueLength),
+	}
+	om.collectionOrders[testBidCollection] = tradeInfo
+
+	changes := func() []multi.CollectionFloorChange {
+		var changes []multi.CollectionFloorChange
+		assert.Nil(t, om.DB.Table(multi.CollectionFloorChangeTableName("sepolia")).Order("id asc").Find(&changes).Error)
+		return changes
+	}
+	floorPrice := func() decimal.Decimal {
+		var collection multi.Collection
+		assert.Nil(t, om.DB.Table(multi.CollectionTableName("sepolia")).Where("address = ?", testBidCollection).Take(&collection).Error)
+		return collection.FloorPrice
+	}
+
+	// 更低的挂单使地板价下降，记录触发变化的订单及事件
+	begin := time.Now().Unix()
+	tradeInfo.orders.Add("l1", decimal.NewFromInt(3), "0xa", "1")
+	assert.Nil(t, om.checkAndUpdateFloorPrice(&TradeEvent{EventType: Listing, CollectionAddr: testBidCollection, OrderId: "l1"}))
+	recorded := changes()
+	if assert.Len(t, recorded, 1) {
+		assert.Equal(t, testBidCollection, recorded[0].CollectionAddress)
+		assert.True(t, recorded[0].OldPrice.Equal(decimal.NewFromInt(5)))
+		assert.True(t, recorded[0].NewPrice.Equal(decimal.NewFromInt(3)))
+		assert.Equal(t, "l1", recorded[0].OrderId)
+		assert.Equal(t, int(Listing), recorded[0].EventType)
+		assert.GreaterOrEqual(t, recorded[0].EventTime, begin)
+	}
+	assert.True(t, floorPrice().Equal(decimal.NewFromInt(3)))
+	assert.True(t, tradeInfo.floorPrice.Equal(decimal.NewFromInt(3)))
+
+	// 地板价不变时不记录
+	tradeInfo.orders.Add("l2", decimal.NewFromInt(4), "0xa", "2")
+	assert.Nil(t, om.checkAndUpdateFloorPrice(&TradeEvent{EventType: Listing, CollectionAddr: testBidCollection, OrderId: "l2"}))
+	assert.Len(t, changes(), 1)
+
+	// 所有挂单失效后地板价为0
+	tradeInfo.orders.Remove("l1")
+	tradeInfo.orders.Remove("l2")
+	assert.Nil(t, om.checkAndUpdateFloorPrice(&TradeEvent{EventType: Cancel, CollectionAddr: testBidCollection, OrderId: "l2"}))
+	recorded = changes()
+	if assert.Len(t, recorded, 2) {
+		assert.True(t, recorded[1].OldPrice.Equal(decimal.NewFromInt(3)))
+		assert.True(t, recorded[1].NewPrice.IsZero())
+		assert.Equal(t, int(Cancel), recorded[1].EventType)
+	}
+	assert.True(t, floorPrice().IsZero())
+
+	// 未跟踪的collection
+	assert.NotNil(t, om.checkAndUpdateFloorPrice(&TradeEvent{EventType: Listing, CollectionAddr: "0xunknown"}))
+}
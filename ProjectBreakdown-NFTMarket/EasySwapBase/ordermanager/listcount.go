@@ -52,38 +52,54 @@ func (om *OrderManager) listCountProcess() {
 	for {
 		select {
 		case <-ticker.C: // 定时器触发
-			// 将map中记录的集合地址转换为切片
-			var cs []string
-			for c := range collections {
-				cs = append(cs, c)
-			}
-			// 如果有需要更新的集合
-			if len(cs) > 0 {
-				// 重新统计这些集合的上架数量
-				collectionsListed, err := om.countCollectionListed(cs)
-				if err != nil {
-					xzap.WithContext(om.Ctx).Error("failed on count collection listed",
-						zap.Error(err))
-				}
-
-				// 更新缓存
-				if err := om.cacheCollectionListCount(collectionsListed); err != nil {
-					xzap.WithContext(om.Ctx).Error("failed on cache collection listed count",
-						zap.Error(err))
-				}
-				// 清空记录
-				collections = make(map[string]bool)
-			}
+			om.refreshCollectionListCount(collections)
+			// 清空记录
+			collections = make(map[string]bool)
 		case addr := <-om.collectionListedCh: // 接收到集合状态变更通知
 			collections[strings.ToLower(addr)] = true // 记录需要更新的集合地址
-		case <-om.Ctx.Done(): // 上下文取消时退出
-			xzap.WithContext(om.Ctx).Info("collection list count process exit",
-				zap.Error(err))
+		case <-om.Ctx.Done(): // 上下文取消时，统计已记录及通道中剩余的集合后退出
+		drain:
+			for {
+				select {
+				case addr := <-om.collectionListedCh:
+					collections[strings.ToLower(addr)] = true
+				default:
+					break drain
+				}
+			}
+			om.refreshCollectionListCount(collections)
+			xzap.WithContext(om.Ctx).Info("collection list count process exit")
 			return
 		}
 	}
 }
 
+// refreshCollectionListCount 重新统计有变动的集合的上架数量并更新缓存
+func (om *OrderManager) refreshCollectionListCount(collections map[string]bool) {
+	// 将map中记录的集合地址转换为切片
+	var cs []string
+	for c := range collections {
+		cs = append(cs, c)
+	}
+	// 如果没有需要更新的集合
+	if len(cs) == 0 {
+		return
+	}
+
+	// 重新统计这些集合的上架数量
+	collectionsListed, err := om.countCollectionListed(cs)
+	if err != nil {
+		xzap.WithContext(om.Ctx).Error("failed on count collection listed",
+			zap.Error(err))
+	}
+
+	// 更新缓存
+	if err := om.cacheCollectionListCount(collectionsListed); err != nil {
+		xzap.WithContext(om.Ctx).Error("failed on cache collection listed count",
+			zap.Error(err))
+	}
+}
+
 // countCollectionListed 函数用于统计NFT集合的上架数量
 // 参数 cs []string: 需要统计的集合地址列表,如果为空则统计所有集合
 // 返回 []CollectionListed: 包含集合地址和对应上架数量的结构体切片
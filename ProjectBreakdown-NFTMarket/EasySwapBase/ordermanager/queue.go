@@ -43,6 +43,11 @@ func (pqm *PriorityQueueMap) Len() int {
 	return len(pqm.orders)
 }
 
+func (pqm *PriorityQueueMap) Contains(orderID string) bool {
+	_, ok := pqm.orders[orderID]
+	return ok
+}
+
 func (pqm *PriorityQueueMap) Add(orderID string, price decimal.Decimal, maker, tokenID string) {
 	if len(pqm.pq) > pqm.maxLen {
 		delete(pqm.orders, pqm.pq[len(pqm.pq)-1].orderID)
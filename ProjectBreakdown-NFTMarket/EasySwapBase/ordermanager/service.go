@@ -14,6 +14,8 @@ import (
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"github.com/ProjectsTask/EasySwapBase/chain/chainclient"
+	"github.com/ProjectsTask/EasySwapBase/currency"
 	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
 	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
 	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
@@ -35,9 +37,9 @@ type Order struct {
 	// order Id
 	orderID        string
 	CollectionAddr string
-	// chain suffix name: ethw/bsc
-	ChainSuffix string
-	// expireIn - createIn (unit: s)
+	// order expire time (unit: s)
+	ExpireAt int64
+	// remaining cycles of the time wheel before expiry
 	CycleCount int64
 	// position of the task on the time wheel
 	WheelPosition int64
@@ -53,45 +55,101 @@ type wheel struct {
 type OrderManager struct {
 	chain string
 
-	// cycle time wheel
-	TimeWheel [WheelSize]wheel
-	// current time wheel index
-	CurrentIndex int64
+	// 订单过期调度器，默认为进程内时间轮
+	expiryScheduler ExpiryScheduler
 
 	collectionOrders map[string]*collectionTradeInfo
 
 	collectionListedCh chan string
 	project            string
 
+	// 订单链上有效性校验，未配置时不启用
+	chainClient  chainclient.ChainClient
+	validatorCfg *ValidatorCfg
+
 	Xkv *xkv.Store
 	DB  *gorm.DB
 	Ctx context.Context
 	Mux *sync.RWMutex
+
+	// Stop时取消Ctx，各处理流程退出后Stop返回
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// 处理中的数据库写入不随Ctx取消而中断，保证单个订单或事件处理完整
+	writeCtx context.Context
 }
 
 // NewDelayQueue : create func instance entrance
-func New(ctx context.Context, db *gorm.DB, xkv *xkv.Store, chain string, project string) *OrderManager {
-	return &OrderManager{
+func New(ctx context.Context, db *gorm.DB, xkv *xkv.Store, chain string, project string, opts ...Option) *OrderManager {
+	runCtx, cancel := context.WithCancel(ctx)
+	om := &OrderManager{
 		chain:              chain,
 		Xkv:                xkv,
 		DB:                 db,
-		Ctx:                ctx,
+		Ctx:                runCtx,
+		cancel:             cancel,
+		writeCtx:           context.WithoutCancel(ctx),
 		Mux:                new(sync.RWMutex),
 		collectionOrders:   make(map[string]*collectionTradeInfo),
 		collectionListedCh: make(chan string, 1000),
 		project:            project,
 	}
+	for _, opt := range opts {
+		opt(om)
+	}
+	if om.expiryScheduler == nil {
+		om.expiryScheduler = NewTimeWheelScheduler()
+	}
+
+	return om
 }
 
 func (om *OrderManager) Start() {
 	// listen redis cache
-	threading.GoSafe(om.ListenNewListingLoop) // 处理新订单
-	threading.GoSafe(om.orderExpiryProcess)   // 处理订单过期状态
-	threading.GoSafe(om.floorPriceProcess)    // 处理floorprice更新
-	threading.GoSafe(om.listCountProcess)     // 处理listCount更新
+	om.goSafe(om.ListenNewListingLoop) // 处理新订单
+	om.goSafe(om.orderExpiryProcess)   // 处理订单过期状态
+	om.goSafe(om.floorPriceProcess)    // 处理floorprice更新
+	om.goSafe(om.listCountProcess)     // 处理listCount更新
+	if om.validatorCfg != nil {
+		om.goSafe(om.orderValidateProcess) // 校验订单链上有效性
+	}
 }
 
-func (om *OrderManager) Stop() {
+// goSafe 启动处理流程并计入wg，Stop时等待其退出
+func (om *OrderManager) goSafe(fn func()) {
+	om.wg.Add(1)
+	threading.GoSafe(func() {
+		defer om.wg.Done()
+		fn()
+	})
+}
+
+// Stop 通知所有处理流程退出，并等待正在处理的订单及事件完成，超时返回错误。
+// 未处理的新订单和交易事件保留在redis队列中，未确认的过期任务由调度器重新投递，待统计的collection在退出前写入缓存
+func (om *OrderManager) Stop(timeout time.Duration) error {
+	om.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		om.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return errors.Errorf("order manager stop timeout after %s", timeout)
+	}
+}
+
+// sleep 等待d或Ctx取消，Ctx取消时返回false
+func (om *OrderManager) sleep(d time.Duration) bool {
+	select {
+	case <-om.Ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
 }
 
 type ListingInfo struct {
@@ -101,17 +159,26 @@ type ListingInfo struct {
 	TokenID        string          `json:"token_id"`
 	Price          decimal.Decimal `json:"price"`
 	Maker          string          `json:"maker"`
+	Currency       string          `json:"currency"`
+	OrderType      int64           `json:"order_type"` // 为0时为挂单，兼容队列中的历史数据
 }
 
 func (om *OrderManager) ListenNewListingLoop() {
 	key := GenOrdersCacheKey(om.chain)
 	for {
+		select {
+		case <-om.Ctx.Done():
+			xzap.WithContext(om.Ctx).Info("ListenNewListingLoop stopped due to context cancellation")
+			return
+		default:
+		}
+
 		result, err := om.Xkv.Lpop(key)
 		if err != nil || result == "" {
 			if err != nil && err != redis.Nil {
 				xzap.WithContext(context.Background()).Warn("failed on get order from cache", zap.Error(err), zap.String("result", result))
 			}
-			time.Sleep(1 * time.Second)
+			om.sleep(1 * time.Second)
 			continue
 		}
 
@@ -146,23 +213,19 @@ func (om *OrderManager) ListenNewListingLoop() {
 			}
 			continue
 		} else { // 订单未过期
-			if err := om.addUpdateFloorPriceEvent(&TradeEvent{ // 添加更新floorprice事件
-				EventType:      Listing,
-				CollectionAddr: listing.CollectionAddr,
-				TokenID:        listing.TokenID,
-				OrderId:        listing.OrderId,
-				Price:          listing.Price,
-				From:           listing.Maker,
-			}); err != nil {
-				xzap.WithContext(om.Ctx).Error("failed on push order to update price queue", zap.Error(err), zap.String("order_id", listing.OrderId),
+			if err := om.addNewOrderPriceEvent(&listing); err != nil {
+				xzap.WithContext(om.Ctx).Error("failed on push order to update price queue", zap.Error(err),
 					zap.String("order_id", listing.OrderId),
 					zap.String("price", listing.Price.String()),
 					zap.String("chain", om.chain))
 			}
 
 			// 添加到订单过期检查队列
-			delaySeconds := listing.ExpireIn - time.Now().Unix()
-			if err := om.addToOrderExpiryCheckQueue(delaySeconds, om.chain, listing.OrderId, listing.CollectionAddr); err != nil {
+			if err := om.expiryScheduler.Schedule(ExpiryTask{
+				OrderID:        listing.OrderId,
+				CollectionAddr: listing.CollectionAddr,
+				ExpireAt:       listing.ExpireIn,
+			}); err != nil {
 				xzap.WithContext(om.Ctx).Error("failed on push order to expired check queue", zap.Error(err), zap.String("order_id", listing.OrderId),
 					zap.String("chain", om.chain))
 			}
@@ -170,6 +233,34 @@ func (om *OrderManager) ListenNewListingLoop() {
 	}
 }
 
+// addNewOrderPriceEvent 原生币计价的挂单更新地板价，原生币计价的集合出价及单品出价分别更新collection及NFT的最高出价
+func (om *OrderManager) addNewOrderPriceEvent(listing *ListingInfo) error {
+	if !currency.IsNative(listing.Currency) { // 地板价及最高出价只统计原生币计价的订单
+		xzap.WithContext(om.Ctx).Info("skip price event of non-native currency order",
+			zap.String("order_id", listing.OrderId), zap.String("currency", listing.Currency))
+		return nil
+	}
+
+	event := &TradeEvent{
+		EventType:      Listing,
+		CollectionAddr: listing.CollectionAddr,
+		TokenID:        listing.TokenID,
+		OrderId:        listing.OrderId,
+		Price:          listing.Price,
+		From:           listing.Maker,
+	}
+	switch listing.OrderType {
+	case 0, multi.ListingOrder:
+	case multi.CollectionBidOrder:
+		event.EventType = CollectionBid
+	case multi.ItemBidOrder:
+		event.EventType = ItemBid
+	default:
+		return nil
+	}
+	return om.addUpdateFloorPriceEvent(event)
+}
+
 func (om *OrderManager) AddToOrderManagerQueue(order *multi.Order) error {
 	if order.TokenId == "" {
 		return errors.New("order manger need token id")
@@ -181,6 +272,8 @@ func (om *OrderManager) AddToOrderManagerQueue(order *multi.Order) error {
 		TokenID:        order.TokenId,
 		Price:          order.Price,
 		Maker:          order.Maker,
+		Currency:       order.CurrencyAddress,
+		OrderType:      order.OrderType,
 	})
 	if err != nil {
 		return errors.Wrap(err, "failed on marshal listing info")
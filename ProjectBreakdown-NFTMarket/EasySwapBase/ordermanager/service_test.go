@@ -0,0 +1,42 @@
+package ordermanager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStop(t *testing.T) {
+	om := New(context.Background(), nil, nil, "sepolia", "orderbook")
+	var finished bool
+	om.goSafe(func() {
+		<-om.Ctx.Done()
+		time.Sleep(10 * time.Millisecond) // 模拟处理中的写入
+		finished = true
+	})
+	assert.Nil(t, om.Stop(time.Second))
+	assert.True(t, finished)
+	assert.Nil(t, om.writeCtx.Err())
+
+	blocked := make(chan struct{})
+	defer close(blocked)
+	om = New(context.Background(), nil, nil, "sepolia", "orderbook")
+	om.goSafe(func() {
+		<-blocked
+	})
+	assert.NotNil(t, om.Stop(10*time.Millisecond))
+}
+
+func TestStopWithParentCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	om := New(ctx, nil, nil, "sepolia", "orderbook")
+	om.goSafe(func() {
+		<-om.Ctx.Done()
+	})
+	cancel()
+	assert.False(t, om.sleep(time.Second))
+	assert.Nil(t, om.writeCtx.Err())
+	assert.Nil(t, om.Stop(time.Second))
+}
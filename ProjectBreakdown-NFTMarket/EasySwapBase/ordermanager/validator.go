@@ -0,0 +1,352 @@
+package ordermanager
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/ProjectsTask/EasySwapBase/chain/chainclient"
+	"github.com/ProjectsTask/EasySwapBase/chain/multicall"
+	"github.com/ProjectsTask/EasySwapBase/currency"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+)
+
+const (
+	DefaultValidateInterval = 300 // in seconds
+	validateBatchSize       = 1000
+	validateCallGas         = 30_000 // ownerOf/isApprovedForAll/balanceOf/allowance的预估gas，用于multicall拆分批次
+)
+
+const validatorAbi = `[{"inputs":[{"internalType":"uint256","name":"tokenId","type":"uint256"}],"name":"ownerOf","outputs":[{"internalType":"address","name":"","type":"address"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"address","name":"owner","type":"address"},{"internalType":"address","name":"operator","type":"address"}],"name":"isApprovedForAll","outputs":[{"internalType":"bool","name":"","type":"bool"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"address","name":"account","type":"address"}],"name":"balanceOf","outputs":[{"internalType":"uint256","name":"","type":"uint256"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"address","name":"owner","type":"address"},{"internalType":"address","name":"spender","type":"address"}],"name":"allowance","outputs":[{"internalType":"uint256","name":"","type":"uint256"}],"stateMutability":"view","type":"function"}]`
+
+var validatorParsedAbi = mustParseAbi(validatorAbi)
+
+// ValidatorCfg 订单链上有效性校验配置
+type ValidatorCfg struct {
+	// VaultAddress 订单簿的资产托管合约，挂单时NFT及出价的ETH转入该合约，也是NFT及ERC20的授权对象
+	VaultAddress string
+	// MulticallAddress 为空时使用multicall.DefaultAddress
+	MulticallAddress string
+	// Interval 校验间隔(秒)，为0时使用DefaultValidateInterval
+	Interval int64
+}
+
+type Option func(om *OrderManager)
+
+func mustParseAbi(raw string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(raw))
+	if err != nil {
+		panic(err)
+	}
+
+	return parsed
+}
+
+// WithOrderValidator 启用订单有效性校验，定期检查挂单的NFT所有权及授权、ERC20出价的余额及授权
+func WithOrderValidator(client chainclient.ChainClient, cfg ValidatorCfg) Option {
+	return func(om *OrderManager) {
+		if cfg.MulticallAddress == "" {
+			cfg.MulticallAddress = multicall.DefaultAddress
+		}
+		if cfg.Interval <= 0 {
+			cfg.Interval = DefaultValidateInterval
+		}
+		om.chainClient = client
+		om.validatorCfg = &cfg
+	}
+}
+
+// orderValidateProcess 定期校验有效及失效的订单，不满足成交条件的订单置为失效，重新满足条件后恢复为有效
+func (om *OrderManager) orderValidateProcess() {
+	ticker := time.NewTicker(time.Duration(om.validatorCfg.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := om.validateOrders(); err != nil {
+			xzap.WithContext(om.Ctx).Error("failed on validate orders", zap.String("chain", om.chain), zap.Error(err))
+		}
+
+		select {
+		case <-om.Ctx.Done():
+			xzap.WithContext(om.Ctx).Info("orderValidateProcess stopped due to context cancellation")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (om *OrderManager) validateOrders() error {
+	var id int64
+	for {
+		if om.Ctx.Err() != nil { // 停止时不再校验后续批次
+			return nil
+		}
+
+		var orders []*multi.Order
+		if err := om.DB.WithContext(om.Ctx).Table(gdb.GetMultiProjectOrderTableName(om.project, om.chain)).
+			Select("id, order_id, collection_address, token_id, maker, price, currency_address, quantity_remaining, order_type, order_status").
+			Where("order_status in (?) and expire_time > ? and order_type in (?) and id > ?",
+				[]int{multi.OrderStatusActive, multi.OrderStatusInactive}, time.Now().Unix(),
+				[]int{multi.ListingOrder, multi.CollectionBidOrder, multi.ItemBidOrder}, id).
+			Order("id asc").Limit(validateBatchSize).
+			Scan(&orders).Error; err != nil {
+			return errors.Wrap(err, "failed on get orders to validate")
+		}
+		if len(orders) == 0 {
+			return nil
+		}
+
+		validity, err := om.checkOrdersValidity(orders)
+		if err != nil {
+			return errors.Wrap(err, "failed on check orders validity")
+		}
+		if err := om.applyOrdersValidity(orders, validity); err != nil {
+			return errors.Wrap(err, "failed on apply orders validity")
+		}
+
+		if len(orders) < validateBatchSize {
+			return nil
+		}
+		id = orders[len(orders)-1].ID
+	}
+}
+
+// orderCheck 单个订单依赖的multicall调用下标，-1表示无需该调用
+type orderCheck struct {
+	owner    int // 挂单: ownerOf
+	approval int // 挂单: isApprovedForAll(maker, vault)
+	balance  int // ERC20出价: balanceOf(maker)
+	allow    int // ERC20出价: allowance(maker, vault)
+}
+
+// checkOrdersValidity 批量查询链上状态，返回订单是否满足成交条件，调用失败无法判断的订单不在结果中
+func (om *OrderManager) checkOrdersValidity(orders []*multi.Order) (map[string]bool, error) {
+	vault := common.HexToAddress(om.validatorCfg.VaultAddress)
+	validity := make(map[string]bool)
+
+	var calls []ethereum.CallMsg
+	callIndex := make(map[string]int) // 相同调用只请求一次
+	addCall := func(target common.Address, method string, args ...interface{}) (int, error) {
+		key := fmt.Sprintf("%s:%s:%v", strings.ToLower(target.String()), method, args)
+		if idx, ok := callIndex[key]; ok {
+			return idx, nil
+		}
+		data, err := validatorParsedAbi.Pack(method, args...)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed on pack %s", method)
+		}
+		calls = append(calls, ethereum.CallMsg{To: &target, Gas: validateCallGas, Data: data})
+		callIndex[key] = len(calls) - 1
+		return len(calls) - 1, nil
+	}
+
+	checks := make(map[string]orderCheck)
+	for _, order := range orders {
+		check := orderCheck{owner: -1, approval: -1, balance: -1, allow: -1}
+		maker := common.HexToAddress(order.Maker)
+		var err error
+		switch order.OrderType {
+		case multi.ListingOrder:
+			tokenId, ok := new(big.Int).SetString(order.TokenId, 10)
+			if !ok {
+				continue
+			}
+			collection := common.HexToAddress(order.CollectionAddress)
+			if check.owner, err = addCall(collection, "ownerOf", tokenId); err != nil {
+				return nil, err
+			}
+			if check.approval, err = addCall(collection, "isApprovedForAll", maker, vault); err != nil {
+				return nil, err
+			}
+		default:
+			if currency.IsNative(order.CurrencyAddress) { // 原生币出价的资金在挂单时已转入托管合约
+				validity[order.OrderID] = true
+				continue
+			}
+			token := common.HexToAddress(order.CurrencyAddress)
+			if check.balance, err = addCall(token, "balanceOf", maker); err != nil {
+				return nil, err
+			}
+			if check.allow, err = addCall(token, "allowance", maker, vault); err != nil {
+				return nil, err
+			}
+		}
+		checks[order.OrderID] = check
+	}
+	if len(calls) == 0 {
+		return validity, nil
+	}
+
+	results, err := multicall.Aggregate3(om.Ctx, om.chainClient, common.HexToAddress(om.validatorCfg.MulticallAddress),
+		calls, multicall.Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, order := range orders {
+		check, ok := checks[order.OrderID]
+		if !ok {
+			continue
+		}
+		var valid, determined bool
+		if order.OrderType == multi.ListingOrder {
+			valid, determined = listingValidity(results[check.owner], results[check.approval], common.HexToAddress(order.Maker), vault)
+		} else {
+			required := order.Price.Mul(decimal.NewFromInt(order.QuantityRemaining)).BigInt()
+			valid, determined = bidValidity(results[check.balance], results[check.allow], required)
+		}
+		if determined {
+			validity[order.OrderID] = valid
+		}
+	}
+
+	return validity, nil
+}
+
+// listingValidity NFT已托管在vault中，或maker持有NFT且已授权vault时挂单有效。ownerOf失败说明NFT已销毁
+func listingValidity(owner, approval multicall.Result, maker, vault common.Address) (valid, determined bool) {
+	if !owner.Success {
+		return false, true
+	}
+	out, err := validatorParsedAbi.Unpack("ownerOf", owner.ReturnData)
+	if err != nil || len(out) == 0 {
+		return false, false
+	}
+	ownerAddr, ok := out[0].(common.Address)
+	if !ok {
+		return false, false
+	}
+	if ownerAddr == vault {
+		return true, true
+	}
+	if ownerAddr != maker {
+		return false, true
+	}
+
+	if !approval.Success {
+		return false, false
+	}
+	out, err = validatorParsedAbi.Unpack("isApprovedForAll", approval.ReturnData)
+	if err != nil || len(out) == 0 {
+		return false, false
+	}
+	approved, ok := out[0].(bool)
+	return approved, ok
+}
+
+// bidValidity ERC20出价要求maker的余额及对vault的授权额度均不低于出价总额
+func bidValidity(balance, allowance multicall.Result, required *big.Int) (valid, determined bool) {
+	if !balance.Success || !allowance.Success {
+		return false, false
+	}
+	balanceOut, err := validatorParsedAbi.Unpack("balanceOf", balance.ReturnData)
+	if err != nil || len(balanceOut) == 0 {
+		return false, false
+	}
+	allowanceOut, err := validatorParsedAbi.Unpack("allowance", allowance.ReturnData)
+	if err != nil || len(allowanceOut) == 0 {
+		return false, false
+	}
+	balanceAmount, ok1 := balanceOut[0].(*big.Int)
+	allowanceAmount, ok2 := allowanceOut[0].(*big.Int)
+	if !ok1 || !ok2 {
+		return false, false
+	}
+
+	return balanceAmount.Cmp(required) >= 0 && allowanceAmount.Cmp(required) >= 0, true
+}
+
+// applyOrdersValidity 更新状态发生变化的订单，并为原生币挂单发送地板价更新事件
+func (om *OrderManager) applyOrdersValidity(orders []*multi.Order, validity map[string]bool) error {
+	var deactivated, reactivated []*multi.Order
+	for _, order := range orders {
+		valid, ok := validity[order.OrderID]
+		if !ok {
+			continue
+		}
+		if !valid && order.OrderStatus == multi.OrderStatusActive {
+			deactivated = append(deactivated, order)
+		} else if valid && order.OrderStatus == multi.OrderStatusInactive {
+			reactivated = append(reactivated, order)
+		}
+	}
+
+	if err := om.switchOrdersStatus(deactivated, multi.OrderStatusActive, multi.OrderStatusInactive); err != nil {
+		return err
+	}
+	if err := om.switchOrdersStatus(reactivated, multi.OrderStatusInactive, multi.OrderStatusActive); err != nil {
+		return err
+	}
+
+	for _, order := range deactivated {
+		om.addValidityFloorPriceEvent(order, Inactive)
+	}
+	for _, order := range reactivated {
+		om.addValidityFloorPriceEvent(order, Listing)
+	}
+	if len(deactivated) > 0 || len(reactivated) > 0 {
+		xzap.WithContext(om.Ctx).Info("orders validity changed", zap.String("chain", om.chain),
+			zap.Int("deactivated", len(deactivated)), zap.Int("reactivated", len(reactivated)))
+	}
+
+	return nil
+}
+
+// switchOrdersStatus 仅更新仍处于from状态的订单，避免覆盖校验期间成交、取消的订单状态
+func (om *OrderManager) switchOrdersStatus(orders []*multi.Order, from, to int) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	orderIds := make([]string, 0, len(orders))
+	for _, order := range orders {
+		orderIds = append(orderIds, order.OrderID)
+	}
+
+	if err := om.DB.WithContext(om.writeCtx).Table(gdb.GetMultiProjectOrderTableName(om.project, om.chain)).
+		Where("order_id in (?) and order_status = ?", orderIds, from).
+		Update("order_status", to).Error; err != nil {
+		return errors.Wrap(err, "failed on update orders status")
+	}
+
+	return nil
+}
+
+func (om *OrderManager) addValidityFloorPriceEvent(order *multi.Order, eventType EventType) {
+	if !currency.IsNative(order.CurrencyAddress) {
+		return
+	}
+	switch order.OrderType {
+	case multi.ListingOrder:
+	case multi.CollectionBidOrder: // 集合出价影响最高出价
+		if eventType == Listing {
+			eventType = CollectionBid
+		}
+	case multi.ItemBidOrder: // 单品出价影响NFT的最高出价
+		if eventType == Listing {
+			eventType = ItemBid
+		}
+	default:
+		return
+	}
+	if err := om.addUpdateFloorPriceEvent(&TradeEvent{
+		EventType:      eventType,
+		CollectionAddr: order.CollectionAddress,
+		TokenID:        order.TokenId,
+		OrderId:        order.OrderID,
+		Price:          order.Price,
+		From:           order.Maker,
+	}); err != nil {
+		xzap.WithContext(om.Ctx).Error("failed on add update floor price event",
+			zap.String("order_id", order.OrderID), zap.Error(err))
+	}
+}
@@ -0,0 +1,207 @@
+package ordermanager
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ProjectsTask/EasySwapBase/chain/multicall"
+	logTypes "github.com/ProjectsTask/EasySwapBase/chain/types"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+)
+
+var (
+	testVault      = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	testCollection = common.HexToAddress("0x00000000000000000000000000000000000000c1")
+	testToken      = common.HexToAddress("0x00000000000000000000000000000000000000e2")
+	testAlice      = common.HexToAddress("0x0000000000000000000000000000000000000a01")
+	testBob        = common.HexToAddress("0x0000000000000000000000000000000000000b02")
+)
+
+// fakeMulticallClient 模拟Multicall3，按方法名应答ownerOf/isApprovedForAll/balanceOf/allowance
+type fakeMulticallClient struct {
+	owners    map[int64]common.Address // tokenId不在map中时ownerOf调用失败
+	approvals map[common.Address]bool
+	balances  map[common.Address]*big.Int
+	allowance map[common.Address]*big.Int
+	requests  int
+	calls     int
+}
+
+func (c *fakeMulticallClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	c.requests++
+	method, err := multicall.Abi.MethodById(msg.Data[:4])
+	if err != nil {
+		return nil, err
+	}
+	args, err := method.Inputs.Unpack(msg.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	calls := *abi.ConvertType(args[0], new([]multicall.Call)).(*[]multicall.Call)
+
+	results := make([]multicall.Result, 0, len(calls))
+	for _, call := range calls {
+		c.calls++
+		results = append(results, c.answer(call))
+	}
+
+	return multicall.Abi.Methods["aggregate3"].Outputs.Pack(results)
+}
+
+func (c *fakeMulticallClient) answer(call multicall.Call) multicall.Result {
+	method, err := validatorParsedAbi.MethodById(call.CallData[:4])
+	if err != nil {
+		return multicall.Result{}
+	}
+	args, err := method.Inputs.Unpack(call.CallData[4:])
+	if err != nil {
+		return multicall.Result{}
+	}
+
+	var out []byte
+	switch method.Name {
+	case "ownerOf":
+		owner, ok := c.owners[args[0].(*big.Int).Int64()]
+		if !ok {
+			return multicall.Result{}
+		}
+		out, err = method.Outputs.Pack(owner)
+	case "isApprovedForAll":
+		out, err = method.Outputs.Pack(c.approvals[args[0].(common.Address)])
+	case "balanceOf":
+		out, err = method.Outputs.Pack(amountOf(c.balances, args[0].(common.Address)))
+	case "allowance":
+		out, err = method.Outputs.Pack(amountOf(c.allowance, args[0].(common.Address)))
+	}
+	if err != nil {
+		return multicall.Result{}
+	}
+
+	return multicall.Result{Success: true, ReturnData: out}
+}
+
+func amountOf(m map[common.Address]*big.Int, addr common.Address) *big.Int {
+	if v, ok := m[addr]; ok {
+		return v
+	}
+	return big.NewInt(0)
+}
+
+func (c *fakeMulticallClient) FilterLogs(ctx context.Context, q logTypes.FilterQuery) ([]interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeMulticallClient) BlockTimeByNumber(context.Context, *big.Int) (uint64, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (c *fakeMulticallClient) BlockTimesByNumber(context.Context, []*big.Int) ([]uint64, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeMulticallClient) Client() interface{} {
+	return nil
+}
+
+func (c *fakeMulticallClient) CallContractByChain(ctx context.Context, param logTypes.CallParam) (interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeMulticallClient) BlockNumber() (uint64, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (c *fakeMulticallClient) BlockWithTxs(ctx context.Context, blockNumber uint64) (interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+func newTestValidator(client *fakeMulticallClient) *OrderManager {
+	return New(context.Background(), nil, nil, "sepolia", "easyswap",
+		WithOrderValidator(client, ValidatorCfg{VaultAddress: testVault.String()}))
+}
+
+func TestCheckOrdersValidity(t *testing.T) {
+	client := &fakeMulticallClient{
+		owners: map[int64]common.Address{
+			1: testAlice, // maker持有且已授权
+			2: testVault, // 已托管
+			3: testBob,   // 已转给他人
+			4: testBob,   // maker持有但未授权
+		},
+		approvals: map[common.Address]bool{testAlice: true},
+		balances:  map[common.Address]*big.Int{testAlice: big.NewInt(300), testBob: big.NewInt(50)},
+		allowance: map[common.Address]*big.Int{testAlice: big.NewInt(300), testBob: big.NewInt(1000)},
+	}
+	om := newTestValidator(client)
+
+	orders := []*multi.Order{
+		{OrderID: "l1", OrderType: multi.ListingOrder, CollectionAddress: testCollection.Hex(), TokenId: "1", Maker: testAlice.Hex()},
+		{OrderID: "l2", OrderType: multi.ListingOrder, CollectionAddress: testCollection.Hex(), TokenId: "2", Maker: testAlice.Hex()},
+		{OrderID: "l3", OrderType: multi.ListingOrder, CollectionAddress: testCollection.Hex(), TokenId: "3", Maker: testAlice.Hex()},
+		{OrderID: "l4", OrderType: multi.ListingOrder, CollectionAddress: testCollection.Hex(), TokenId: "4", Maker: testBob.Hex()},
+		{OrderID: "l5", OrderType: multi.ListingOrder, CollectionAddress: testCollection.Hex(), TokenId: "5", Maker: testBob.Hex()}, // 已销毁
+		{OrderID: "b1", OrderType: multi.ItemBidOrder, Maker: testBob.Hex(), Price: decimal.NewFromInt(100), QuantityRemaining: 1},
+		{OrderID: "b2", OrderType: multi.CollectionBidOrder, Maker: testAlice.Hex(), CurrencyAddress: testToken.Hex(),
+			Price: decimal.NewFromInt(100), QuantityRemaining: 3},
+		{OrderID: "b3", OrderType: multi.CollectionBidOrder, Maker: testAlice.Hex(), CurrencyAddress: testToken.Hex(),
+			Price: decimal.NewFromInt(100), QuantityRemaining: 4},
+		{OrderID: "b4", OrderType: multi.ItemBidOrder, Maker: testBob.Hex(), CurrencyAddress: testToken.Hex(),
+			Price: decimal.NewFromInt(100), QuantityRemaining: 1},
+	}
+
+	validity, err := om.checkOrdersValidity(orders)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{
+		"l1": true,
+		"l2": true,
+		"l3": false,
+		"l4": false,
+		"l5": false,
+		"b1": true,
+		"b2": true,
+		"b3": false,
+		"b4": false,
+	}, validity)
+	assert.Equal(t, 1, client.requests)
+	// 相同的isApprovedForAll及balanceOf/allowance调用只请求一次
+	assert.Equal(t, 11, client.calls)
+}
+
+func TestListingValidityUndetermined(t *testing.T) {
+	owner, err := validatorParsedAbi.Methods["ownerOf"].Outputs.Pack(testAlice)
+	assert.Nil(t, err)
+
+	valid, determined := listingValidity(multicall.Result{Success: true, ReturnData: owner}, multicall.Result{}, testAlice, testVault)
+	assert.False(t, valid)
+	assert.False(t, determined)
+
+	valid, determined = bidValidity(multicall.Result{}, multicall.Result{}, big.NewInt(1))
+	assert.False(t, valid)
+	assert.False(t, determined)
+}
+
+func TestAggregate3Batches(t *testing.T) {
+	client := &fakeMulticallClient{owners: map[int64]common.Address{}}
+	calls := make([]ethereum.CallMsg, 0, multicall.DefaultMaxCalls+1)
+	for i := 0; i < multicall.DefaultMaxCalls+1; i++ {
+		data, err := validatorParsedAbi.Pack("ownerOf", big.NewInt(int64(i)))
+		assert.Nil(t, err)
+		calls = append(calls, ethereum.CallMsg{To: &testCollection, Gas: 30_000, Data: data})
+	}
+	client.owners[int64(multicall.DefaultMaxCalls)] = testAlice
+
+	results, err := multicall.Aggregate3(context.Background(), client, multicall.AddressOf(11155111), calls, multicall.Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, client.requests)
+	assert.Len(t, results, multicall.DefaultMaxCalls+1)
+	assert.False(t, results[0].Success)
+	assert.True(t, results[multicall.DefaultMaxCalls].Success)
+}
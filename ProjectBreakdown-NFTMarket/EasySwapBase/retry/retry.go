@@ -1,5 +1,7 @@
 package retry
 
+import "errors"
+
 // Action 具体的行为函数
 type Action func(attempt uint) error
 
@@ -11,11 +13,37 @@ func Retry(action Action, strategies ...Strategy) error {
 	for attempt := uint(0); (attempt == 0 || err != nil) &&
 		shouldAttempt(attempt, strategies...); attempt++ {
 		err = action(attempt)
+
+		var stop *stopError
+		if errors.As(err, &stop) {
+			return stop.err
+		}
 	}
 
 	return err
 }
 
+// stopError 不可重试的错误
+type stopError struct {
+	err error
+}
+
+func (e *stopError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stopError) Unwrap() error {
+	return e.err
+}
+
+// Stop 标记错误不可重试，Retry遇到该错误时立即终止尝试并返回原始错误
+func Stop(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &stopError{err: err}
+}
+
 // MustRetry 根据尝试策略执行具体的行为函数，
 // 当执行成功时，会终止尝试，或因为尝试策略结果影响提早终止尝试
 func MustRetry(action Action, strategies ...Strategy) {
@@ -65,3 +65,22 @@ func TestShouldAttemptWithMultiStrategies(t *testing.T) {
 	should = shouldAttempt(1, trueStrategy, trueStrategy, falseStrategy)
 	assert.False(t, should)
 }
+
+func TestRetryStop(t *testing.T) {
+	stopErr := errors.New("stop")
+	var attemptsMade uint
+
+	action := func(attempt uint) error {
+		attemptsMade++
+		if attempt == 1 {
+			return Stop(stopErr)
+		}
+
+		return errors.New("error")
+	}
+
+	err := Retry(action, Limit(5))
+	assert.Equal(t, stopErr, err)
+	assert.Equal(t, uint(2), attemptsMade)
+	assert.Nil(t, Stop(nil))
+}
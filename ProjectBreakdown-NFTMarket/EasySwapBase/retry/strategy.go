@@ -1,6 +1,8 @@
 package retry
 
 import (
+	"context"
+	"math/rand"
 	"time"
 
 	"github.com/zeromicro/go-zero/core/mathx"
@@ -51,6 +53,35 @@ func Wait(durations ...time.Duration) Strategy {
 	}
 }
 
+// Backoff 指数退避尝试策略，第n次重试前等待[0, base*2^(n-1)]内的随机时长，最长不超过max，
+// ctx取消时立即结束等待并不再重试，第一次尝试总会进行
+func Backoff(ctx context.Context, base, max time.Duration) Strategy {
+	return func(attempt uint) bool {
+		if attempt == 0 {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		if base > 0 {
+			duration := max
+			if shift := attempt - 1; shift < 32 && base<<shift > 0 && base<<shift < max {
+				duration = base << shift
+			}
+
+			timer := time.NewTimer(time.Duration(rand.Int63n(int64(duration) + 1)))
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return false
+			case <-timer.C:
+			}
+		}
+
+		return true
+	}
+}
+
 // FailLimit 失败尝试策略，达到一定尝试次数执行预先指定的失败方法并退出
 func FailLimit(attemptLimit uint, failAction Action) Strategy {
 	return func(attempt uint) bool {
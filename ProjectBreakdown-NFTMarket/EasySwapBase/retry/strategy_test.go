@@ -1,6 +1,8 @@
 package retry
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -222,3 +224,41 @@ func TestFailLimit(t *testing.T) {
 		})
 	}
 }
+
+func TestBackoff(t *testing.T) {
+	const base = 2 * time.Millisecond
+	const max = 5 * time.Millisecond
+
+	strategy := Backoff(context.Background(), base, max)
+
+	now := time.Now()
+	assert.True(t, strategy(0) && time.Millisecond >= time.Since(now))
+
+	for attempt := uint(1); attempt < 40; attempt++ {
+		now = time.Now()
+		assert.True(t, strategy(attempt))
+		assert.True(t, time.Since(now) < max+5*time.Millisecond)
+	}
+}
+
+func TestBackoffCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	strategy := Backoff(ctx, time.Hour, time.Hour)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	// 取消后立即结束等待并停止尝试
+	now := time.Now()
+	assert.False(t, strategy(1))
+	assert.True(t, time.Since(now) < time.Second)
+	assert.False(t, strategy(2))
+
+	// 第一次尝试不受取消影响，之后不再重试
+
+	attempts := 0
+	err := Retry(func(attempt uint) error {
+		attempts++
+		return errors.New("failed")
+	}, Limit(3), Backoff(ctx, time.Hour, time.Hour))
+	assert.EqualError(t, err, "failed")
+	assert.Equal(t, 1, attempts)
+}
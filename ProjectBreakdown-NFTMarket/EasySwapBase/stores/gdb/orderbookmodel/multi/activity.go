@@ -44,6 +44,7 @@ type Activity struct {
 	Price             decimal.Decimal `gorm:"column:price" json:"price"`
 	SellPrice         decimal.Decimal `json:"sell_price" gorm:"column:sell_price;type:decimal(30);not null;default:0"`
 	BuyPrice          decimal.Decimal `json:"buy_price" gorm:"column:buy_price;type:decimal(30);not null;default:0"`
+	Quantity          int64           `json:"quantity" gorm:"column:quantity;type:bigint(20);not null;default:1;comment:成交/挂单数量"`
 	BlockNumber       int64           `json:"block_number" gorm:"column:block_number;type:bigint(20);not null"`
 	TxHash            string          `json:"tx_hash" gorm:"column:tx_hash;type:varchar(255);not null"`
 	EventTime         int64           `json:"event_time" gorm:"column:event_time;type:bigint(20);default:0;comment:链上事件发生的时间"`
@@ -46,7 +46,7 @@ type Collection struct {
 	Discord          string          `gorm:"column:discord" json:"discord"`                              // 项目 discord 地址
 	Instagram        string          `gorm:"column:instagram" json:"instagram"`                          // 项目 instagram 地址
 	FloorPrice       decimal.Decimal `gorm:"column:floor_price" json:"floor_price"`
-	SalePrice        decimal.Decimal `gorm:"column:sale_price" json:"sale_price"`                       // 整个collection中item的最低的listing价格
+	SalePrice        decimal.Decimal `gorm:"column:sale_price" json:"sale_price"`                       // 整个collection中价格最高的有效集合出价
 	VolumeTotal      decimal.Decimal `gorm:"column:volume_total" json:"volume_total"`                   // 总交易量
 	ImageUri         string          `gorm:"column:image_uri" json:"image_uri"`                         // 项目封面图的链接
 	BannerUri        string          `gorm:"column:banner_uri" json:"banner_uri"`                       // banner image uri
@@ -0,0 +1,24 @@
+package multi
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// CollectionFloorChange 地板价变化事件，order manager每次地板价实际变化时写入一行
+type CollectionFloorChange struct {
+	Id                int64           `gorm:"column:id;AUTO_INCREMENT;primary_key" json:"id"`                                          // 主键
+	CollectionAddress string          `gorm:"column:collection_address;NOT NULL" json:"collection_address"`                            // 链上合约地址
+	OldPrice          decimal.Decimal `gorm:"column:old_price;type:decimal(30);default:0;NOT NULL" json:"old_price"`                   // 变化前的地板价，0表示没有挂单
+	NewPrice          decimal.Decimal `gorm:"column:new_price;type:decimal(30);default:0;NOT NULL" json:"new_price"`                   // 变化后的地板价，0表示没有挂单
+	OrderId           string          `gorm:"column:order_id" json:"order_id"`                                                         // 触发变化的订单，重新加载或导入时为空
+	EventType         int             `gorm:"column:event_type;default:0" json:"event_type"`                                           // 触发变化的事件类型
+	EventTime         int64           `gorm:"column:event_time" json:"event_time"`                                                     // 变化时间
+	CreateTime        int64           `json:"create_time" gorm:"column:create_time;type:bigint(20);autoCreateTime:milli;comment:创建时间"` // 创建时间
+	UpdateTime        int64           `json:"update_time" gorm:"column:update_time;type:bigint(20);autoUpdateTime:milli;comment:更新时间"` // 更新时间
+}
+
+func CollectionFloorChangeTableName(chainName string) string {
+	return fmt.Sprintf("ob_collection_floor_change_%s", chainName)
+}
@@ -6,6 +6,31 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// TradeEpochSeconds 统计周期的单位，epoch_number为窗口包含的周期数
+const TradeEpochSeconds = 300
+
+// TradeWindow 滚动统计窗口
+type TradeWindow struct {
+	Period      string
+	EpochNumber int64
+}
+
+// Seconds 窗口时长(秒)
+func (w TradeWindow) Seconds() int64 {
+	return w.EpochNumber * TradeEpochSeconds
+}
+
+// TradeWindows ob_collection_trade中维护的统计窗口
+var TradeWindows = []TradeWindow{
+	{Period: "15m", EpochNumber: 3},
+	{Period: "1h", EpochNumber: 12},
+	{Period: "6h", EpochNumber: 72},
+	{Period: "1d", EpochNumber: 288},
+	{Period: "7d", EpochNumber: 2016},
+	{Period: "30d", EpochNumber: 8640},
+}
+
+// CollectionTrade 每个collection在每个统计窗口一行，价格均已换算为ETH(wei)
 type CollectionTrade struct {
 	Id                int64           `gorm:"column:id;AUTO_INCREMENT;primary_key" json:"id"`    // 主键
 	EpochNumber       int64           `gorm:"column:epoch_number;default:0" json:"epoch_number"` // 数据同步周期
@@ -16,6 +41,14 @@ type CollectionTrade struct {
 	BenchmarkPrice    decimal.Decimal `gorm:"column:benchmark_price;default:0;NOT NULL" json:"benchmark_price"`                        // 池子相关数据,基准价格
 	SellPrice         decimal.Decimal `gorm:"column:sell_price;default:0;NOT NULL" json:"sell_price"`                                  // 池子相关数据,出售价格
 	BuyPrice          decimal.Decimal `gorm:"column:buy_price;default:0;NOT NULL" json:"buy_price"`                                    // 池子相关数据,购买价格
+	SaleCount         int64           `gorm:"column:sale_count;default:0" json:"sale_count"`                                           // 单周期内的成交笔数
+	BuyerCount        int64           `gorm:"column:buyer_count;default:0" json:"buyer_count"`                                         // 单周期内的买家数(去重)
+	SellerCount       int64           `gorm:"column:seller_count;default:0" json:"seller_count"`                                       // 单周期内的卖家数(去重)
+	AvgPrice          decimal.Decimal `gorm:"column:avg_price;default:0;NOT NULL" json:"avg_price"`                                    // 单周期内的平均成交价
+	MinPrice          decimal.Decimal `gorm:"column:min_price;default:0;NOT NULL" json:"min_price"`                                    // 单周期内的最低成交价
+	MaxPrice          decimal.Decimal `gorm:"column:max_price;default:0;NOT NULL" json:"max_price"`                                    // 单周期内的最高成交价
+	PrevVolume        decimal.Decimal `gorm:"column:prev_volume;default:0;NOT NULL" json:"prev_volume"`                                // 上一周期的成交量
+	PrevMinPrice      decimal.Decimal `gorm:"column:prev_min_price;default:0;NOT NULL" json:"prev_min_price"`                          // 上一周期的最低成交价
 	CreateTime        int64           `json:"create_time" gorm:"column:create_time;type:bigint(20);autoCreateTime:milli;comment:创建时间"` // 创建时间
 	UpdateTime        int64           `json:"update_time" gorm:"column:update_time;type:bigint(20);autoUpdateTime:milli;comment:更新时间"` // 更新时间
 }
@@ -0,0 +1,38 @@
+package multi
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// (1:Skip Order,2:Withdraw ETH,3:Updated Protocol Share,4:Batch Match Inner Error,5:Paused,6:Unpaused)
+const (
+	ContractEventSkipOrder            = 1
+	ContractEventWithdrawETH          = 2
+	ContractEventUpdatedProtocolShare = 3
+	ContractEventBatchMatchInnerError = 4
+	ContractEventPaused               = 5
+	ContractEventUnpaused             = 6
+)
+
+// ContractEvent 订单簿合约中与挂单/成交无关的事件，用于运维排查及手续费对账
+type ContractEvent struct {
+	Id int64 `json:"id" gorm:"primaryKey;autoIncrement;column:id;not null"`
+	//1:Skip Order,2:Withdraw ETH,3:Updated Protocol Share,4:Batch Match Inner Error,5:Paused,6:Unpaused
+	EventType   int             `json:"event_type" gorm:"column:event_type;type:tinyint(1);not null"`
+	OrderId     string          `json:"order_id" gorm:"column:order_id;type:varchar(66);not null;default:'';comment:被跳过的订单id(LogSkipOrder)"`
+	Address     string          `json:"address" gorm:"column:address;type:varchar(42);not null;default:'';comment:提现接收地址(LogWithdrawETH)或操作者地址(Paused/Unpaused)"`
+	Amount      decimal.Decimal `json:"amount" gorm:"column:amount;type:decimal(30);not null;default:0;comment:提现金额(LogWithdrawETH)、新的协议分成(LogUpdatedProtocolShare)或出错的订单下标(BatchMatchInnerError)"`
+	Data        string          `json:"data" gorm:"column:data;type:text;comment:附加数据(hex)，如BatchMatchInnerError的错误信息"`
+	BlockNumber int64           `json:"block_number" gorm:"column:block_number;type:bigint(20);not null"`
+	TxHash      string          `json:"tx_hash" gorm:"column:tx_hash;type:varchar(66);not null"`
+	LogIndex    int64           `json:"log_index" gorm:"column:log_index;type:int;not null"`
+	EventTime   int64           `json:"event_time" gorm:"column:event_time;type:bigint(20);default:0;comment:链上事件发生的时间"`
+	CreateTime  int64           `json:"create_time" gorm:"column:create_time;type:bigint(20);autoCreateTime:milli;comment:创建时间"` // 创建时间
+	UpdateTime  int64           `json:"update_time" gorm:"column:update_time;type:bigint(20);autoUpdateTime:milli;comment:更新时间"` // 更新时间
+}
+
+func ContractEventTableName(chainName string) string {
+	return fmt.Sprintf("ob_contract_event_%s", chainName)
+}
@@ -0,0 +1,25 @@
+package multi
+
+import (
+	"fmt"
+)
+
+// RawLog 从链上拉取的订单簿合约原始日志，用于不访问节点重放事件、重建订单及活动数据
+type RawLog struct {
+	Id          int64  `json:"id" gorm:"primaryKey;autoIncrement;column:id;not null"`
+	BlockNumber int64  `json:"block_number" gorm:"column:block_number;type:bigint(20);not null"`
+	BlockHash   string `json:"block_hash" gorm:"column:block_hash;type:varchar(66);not null;default:''"`
+	BlockTime   int64  `json:"block_time" gorm:"column:block_time;type:bigint(20);not null;default:0;comment:区块时间，重放时不再请求节点"`
+	TxHash      string `json:"tx_hash" gorm:"column:tx_hash;type:varchar(66);not null"`
+	TxIndex     int64  `json:"tx_index" gorm:"column:tx_index;type:int;not null;default:0"`
+	LogIndex    int64  `json:"log_index" gorm:"column:log_index;type:int;not null"`
+	Address     string `json:"address" gorm:"column:address;type:varchar(42);not null"`
+	Topics      string `json:"topics" gorm:"column:topics;type:varchar(300);not null;default:'';comment:以逗号分隔的topic列表"`
+	Data        string `json:"data" gorm:"column:data;type:mediumtext;comment:日志数据(hex)"`
+	CreateTime  int64  `json:"create_time" gorm:"column:create_time;type:bigint(20);autoCreateTime:milli;comment:创建时间"` // 创建时间
+	UpdateTime  int64  `json:"update_time" gorm:"column:update_time;type:bigint(20);autoUpdateTime:milli;comment:更新时间"` // 更新时间
+}
+
+func RawLogTableName(chainName string) string {
+	return fmt.Sprintf("ob_raw_log_%s", chainName)
+}
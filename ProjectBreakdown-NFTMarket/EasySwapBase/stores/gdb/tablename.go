@@ -53,6 +53,14 @@ func GetMultiProjectItemExternalTableName(project string, chain string) string {
 	}
 }
 
+func GetMultiProjectItemHolderTableName(project string, chain string) string {
+	if project == OrderBookDexProject {
+		return multi.ItemHolderTableName(chain)
+	} else {
+		return ""
+	}
+}
+
 func GetMultiProjectItemTraitTableName(project string, chain string) string {
 	if project == OrderBookDexProject {
 		return multi.ItemTraitTableName(chain)
@@ -68,3 +76,11 @@ func GetMultiProjectCollectionTradeTableName(project string, chain string) strin
 		return ""
 	}
 }
+
+func GetMultiProjectCollectionFloorChangeTableName(project string, chain string) string {
+	if project == OrderBookDexProject {
+		return multi.CollectionFloorChangeTableName(chain)
+	} else {
+		return ""
+	}
+}
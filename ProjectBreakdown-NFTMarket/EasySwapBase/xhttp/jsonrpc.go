@@ -2,7 +2,9 @@ package xhttp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"reflect"
 
@@ -12,6 +14,8 @@ import (
 const (
 	// jsonrpcVersion 默认 JSON-RPC 默认版本
 	jsonrpcVersion = "2.0"
+	// defaultMaxBatchSize 默认单次批量请求包含的最大请求数，多数节点服务商限制为 100
+	defaultMaxBatchSize = 100
 )
 
 // RPCClient 通用 JSON-RPC 客户端接口
@@ -22,6 +26,8 @@ type RPCClient interface {
 	CallRaw(request *RPCRequest) (*RPCResponse, error)
 	// CallFor 进行 JSON-RPC 调用并将响应结果反序列化到所给类型对象中
 	CallFor(out interface{}, method string, params ...interface{}) error
+	// CallBatch 进行批量 JSON-RPC 调用，响应与请求按顺序一一对应，ctx 取消时中止请求
+	CallBatch(ctx context.Context, requests RPCRequests) (RPCResponses, error)
 }
 
 // RPCOption JSON-RPC 客户端可选配置
@@ -44,6 +50,13 @@ func WithCustomHeaders(m map[string]string) RPCOption {
 	}
 }
 
+// WithMaxBatchSize 单次批量请求包含的最大请求数，超过时拆分为多次请求
+func WithMaxBatchSize(size int) RPCOption {
+	return func(c *rpcClient) {
+		c.maxBatchSize = size
+	}
+}
+
 // NewRPCClient 新建通用 JSON-RPC 客户端
 func NewRPCClient(endpoint string, opts ...RPCOption) RPCClient {
 	c := &rpcClient{endpoint: endpoint}
@@ -55,6 +68,9 @@ func NewRPCClient(endpoint string, opts ...RPCOption) RPCClient {
 	if c.httpClient == nil {
 		c.httpClient = NewDefaultHTTPClient()
 	}
+	if c.maxBatchSize <= 0 {
+		c.maxBatchSize = defaultMaxBatchSize
+	}
 
 	return c
 }
@@ -64,17 +80,18 @@ type rpcClient struct {
 	endpoint      string
 	httpClient    *http.Client
 	customHeaders map[string]string
+	maxBatchSize  int
 }
 
 // newRequest 新建 HTTP 请求体
-func (c *rpcClient) newRequest(req interface{}) (*http.Request, error) {
+func (c *rpcClient) newRequest(ctx context.Context, req interface{}) (*http.Request, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "json marshal %v err", req)
 	}
 	// fmt.Println(string(body))
 
-	request, err := http.NewRequest(http.MethodPost, c.endpoint, bytes.NewReader(body))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
 	if err != nil {
 		return nil, errors.WithMessage(err, "new http request err")
 	}
@@ -91,7 +108,7 @@ func (c *rpcClient) newRequest(req interface{}) (*http.Request, error) {
 
 // doCall 执行 JSON-RPC 调用
 func (c *rpcClient) doCall(req *RPCRequest) (*RPCResponse, error) {
-	httpReq, err := c.newRequest(req)
+	httpReq, err := c.newRequest(context.Background(), req)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "call %s method on %s err",
 			req.Method, c.endpoint)
@@ -148,6 +165,108 @@ func (c *rpcClient) CallFor(out interface{}, method string, params ...interface{
 	return rpcResp.ReadToObject(out)
 }
 
+// CallBatch 进行批量 JSON-RPC 调用，超过最大批量时拆分为多次请求。
+// 请求 ID 按在 requests 中的下标重新分配，响应按 ID 匹配回对应位置，与请求一一对应；
+// 单个请求的错误记录在对应响应的 Error 中，节点未返回的响应同样以 Error 表示
+func (c *rpcClient) CallBatch(ctx context.Context, requests RPCRequests) (RPCResponses, error) {
+	responses := make(RPCResponses, len(requests))
+	for start := 0; start < len(requests); start += c.maxBatchSize {
+		end := start + c.maxBatchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		batch := make(RPCRequests, 0, end-start)
+		for i := start; i < end; i++ {
+			req := *requests[i]
+			req.ID = i
+			if req.JSONRPC == "" {
+				req.JSONRPC = jsonrpcVersion
+			}
+			batch = append(batch, &req)
+		}
+
+		batchResps, err := c.doBatchCall(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, resp := range batchResps {
+			if resp.ID < start || resp.ID >= end || responses[resp.ID] != nil {
+				continue
+			}
+			responses[resp.ID] = resp
+		}
+		for i := start; i < end; i++ {
+			if responses[i] == nil {
+				responses[i] = &RPCResponse{
+					JSONRPC: jsonrpcVersion,
+					ID:      i,
+					Error:   fmt.Sprintf("missing response for %s request %d", requests[i].Method, i),
+				}
+			}
+		}
+	}
+
+	return responses, nil
+}
+
+// doBatchCall 执行单次批量 JSON-RPC 调用，节点拒绝整个批量请求时返回单个错误响应，此时返回错误
+func (c *rpcClient) doBatchCall(ctx context.Context, batch RPCRequests) (RPCResponses, error) {
+	httpReq, err := c.newRequest(ctx, batch)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "call batch of %d requests on %s err", len(batch), c.endpoint)
+	}
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "call batch of %d requests on %s err", len(batch), httpReq.URL.String())
+	}
+	defer httpResp.Body.Close()
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(httpResp.Body).Decode(&raw); err != nil {
+		return nil, errors.WithMessagef(err, "call batch on %s status code: %d, decode body err",
+			httpReq.URL.String(), httpResp.StatusCode)
+	}
+
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.DisallowUnknownFields()
+	d.UseNumber()
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		var rpcResp RPCResponse
+		if err := d.Decode(&rpcResp); err != nil {
+			return nil, errors.WithMessagef(err, "call batch on %s status code: %d, decode body err",
+				httpReq.URL.String(), httpResp.StatusCode)
+		}
+		return nil, errors.Errorf("call batch on %s status code: %d, rpc err: %v",
+			httpReq.URL.String(), httpResp.StatusCode, rpcResp.Error)
+	}
+
+	var batchResps []batchRPCResponse
+	if err := d.Decode(&batchResps); err != nil {
+		return nil, errors.WithMessagef(err, "call batch on %s status code: %d, decode body err",
+			httpReq.URL.String(), httpResp.StatusCode)
+	}
+
+	rpcResps := make(RPCResponses, 0, len(batchResps))
+	for i := range batchResps {
+		if batchResps[i].ID == nil { // 无法解析的请求，ID 为 null 时无法对应到具体请求
+			continue
+		}
+		resp := batchResps[i].RPCResponse
+		resp.ID = *batchResps[i].ID
+		rpcResps = append(rpcResps, &resp)
+	}
+
+	return rpcResps, nil
+}
+
+// batchRPCResponse 批量响应中的单个响应，ID 为 null 时无法对应到请求
+type batchRPCResponse struct {
+	RPCResponse
+	ID *int `json:"id"`
+}
+
 // RPCRequest 通用 JSON-RPC 请求体
 type RPCRequest struct {
 	JSONRPC string      `json:"jsonrpc"`
@@ -156,6 +275,9 @@ type RPCRequest struct {
 	Params  interface{} `json:"params,omitempty"`
 }
 
+// RPCRequests 批量 JSON-RPC 请求体
+type RPCRequests []*RPCRequest
+
 // NewRPCRequest 新建通用 JSON-RPC 请求体
 func NewRPCRequest(method string, params ...interface{}) *RPCRequest {
 	req := &RPCRequest{
@@ -215,6 +337,9 @@ type RPCResponse struct {
 	Error   interface{} `json:"error,omitempty"`
 }
 
+// RPCResponses 批量 JSON-RPC 响应体
+type RPCResponses []*RPCResponse
+
 // GetInt64 获取响应结果的 int64 类型值
 func (resp *RPCResponse) GetInt64() (int64, error) {
 	if resp.Error != nil {
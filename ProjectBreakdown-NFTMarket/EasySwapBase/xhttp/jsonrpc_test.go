@@ -1,8 +1,12 @@
 package xhttp
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -95,3 +99,120 @@ func TestRpcClient_CallFor(t *testing.T) {
 		t.Logf("%+v", result)
 	}
 }
+
+// newBatchStub 本地 JSON-RPC 桩服务，逆序返回批量响应：echo 返回参数，fail 返回错误，drop 不返回响应
+func newBatchStub(t *testing.T, batchSizes *[]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []*RPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*batchSizes = append(*batchSizes, len(reqs))
+
+		resps := make([]map[string]interface{}, 0, len(reqs))
+		for i := len(reqs) - 1; i >= 0; i-- {
+			resp := map[string]interface{}{"jsonrpc": "2.0", "id": reqs[i].ID}
+			switch reqs[i].Method {
+			case "echo":
+				resp["result"] = reqs[i].Params
+			case "fail":
+				resp["error"] = map[string]interface{}{"code": -32000, "message": "boom"}
+			case "drop":
+				continue
+			}
+			resps = append(resps, resp)
+		}
+		// 无法解析的请求返回 id 为 null 的错误
+		resps = append(resps, map[string]interface{}{"jsonrpc": "2.0", "id": nil,
+			"error": map[string]interface{}{"code": -32600, "message": "invalid request"}})
+		assert.NoError(t, json.NewEncoder(w).Encode(resps))
+	}))
+}
+
+func TestRPCClient_CallBatch(t *testing.T) {
+	var batchSizes []int
+	server := newBatchStub(t, &batchSizes)
+	defer server.Close()
+
+	c := NewRPCClient(server.URL, WithMaxBatchSize(2))
+	requests := RPCRequests{
+		NewRPCRequest("echo", "a"),
+		NewRPCRequest("fail"),
+		NewRPCRequest("echo", "c"),
+		NewRPCRequest("drop"),
+		NewRPCRequest("echo", "e"),
+	}
+	resps, err := c.CallBatch(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 2, 1}, batchSizes)
+	assert.Len(t, resps, len(requests))
+
+	// 逆序返回的响应按 ID 匹配回请求的位置
+	for i, want := range map[int]string{0: "a", 2: "c", 4: "e"} {
+		var got []string
+		assert.NoError(t, resps[i].ReadToObject(&got))
+		assert.Equal(t, []string{want}, got)
+		assert.Equal(t, i, resps[i].ID)
+	}
+
+	// 单个请求的错误不影响其他请求
+	assert.NotNil(t, resps[1].Error)
+	assert.Error(t, resps[1].ReadToObject(new(interface{})))
+	assert.NotNil(t, resps[3].Error)
+	assert.Contains(t, resps[3].Error, "missing response")
+
+	// 请求 ID 重新分配，不修改调用方的请求
+	for _, req := range requests {
+		assert.Equal(t, 0, req.ID)
+	}
+
+	resps, err = c.CallBatch(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, resps)
+}
+
+func TestRPCClient_CallBatchDefaultSize(t *testing.T) {
+	var batchSizes []int
+	server := newBatchStub(t, &batchSizes)
+	defer server.Close()
+
+	requests := make(RPCRequests, 0, defaultMaxBatchSize+1)
+	for i := 0; i < defaultMaxBatchSize+1; i++ {
+		requests = append(requests, NewRPCRequest("echo", i))
+	}
+	resps, err := NewRPCClient(server.URL).CallBatch(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{defaultMaxBatchSize, 1}, batchSizes)
+
+	var got []int
+	assert.NoError(t, resps[defaultMaxBatchSize].ReadToObject(&got))
+	assert.Equal(t, []int{defaultMaxBatchSize}, got)
+}
+
+func TestRPCClient_CallBatchRejected(t *testing.T) {
+	// 节点拒绝整个批量请求时返回单个错误响应
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32005,"message":"batch limit exceeded"}}`))
+	}))
+	defer server.Close()
+
+	_, err := NewRPCClient(server.URL).CallBatch(context.Background(), RPCRequests{NewRPCRequest("echo", "a")})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "batch limit exceeded")
+}
+
+func TestRPCClient_CallBatchCancel(t *testing.T) {
+	// ctx 取消时中止等待中的批量请求
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NewRPCClient(server.URL).CallBatch(ctx, RPCRequests{NewRPCRequest("echo", "a")})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
@@ -9,8 +10,10 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
@@ -27,9 +30,12 @@ var DaemonCmd = &cobra.Command{
 		wg.Add(1)
 		ctx := context.Background()
 		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
 
 		// rpc退出信号通知chan
 		onSyncExit := make(chan error, 1)
+		// 启动成功的服务，退出时等待其停止
+		onSyncStart := make(chan *service.Service, 1)
 
 		go func() {
 			defer wg.Done()
@@ -62,14 +68,27 @@ var DaemonCmd = &cobra.Command{
 				onSyncExit <- err
 				return
 			}
+			onSyncStart <- s
+
+			if cfg.Monitor.StatusPort > 0 { // 同步状态及监控指标使用独立端口，不依赖pprof开关
+				mux := http.NewServeMux()
+				// 各条链的同步状态
+				mux.HandleFunc("/chains/status", func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					_ = json.NewEncoder(w).Encode(s.ChainStatuses())
+				})
+				// 订单簿对账差异等监控指标
+				mux.Handle("/metrics", promhttp.Handler())
+				go http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", cfg.Monitor.StatusPort), mux)
+			}
 
 			if cfg.Monitor.PprofEnable { // 开启pprof，用于性能监控
-				http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", cfg.Monitor.PprofPort), nil)
+				go http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", cfg.Monitor.PprofPort), nil)
 			}
 		}()
 
 		// 信号通知chan
-		onSignal := make(chan os.Signal)
+		onSignal := make(chan os.Signal, 1)
 		// 优雅退出
 		signal.Notify(onSignal, syscall.SIGINT, syscall.SIGTERM)
 		select {
@@ -84,6 +103,17 @@ var DaemonCmd = &cobra.Command{
 			xzap.WithContext(ctx).Error("Exit by error", zap.Error(err))
 		}
 		wg.Wait()
+
+		// 等待处理中的订单、事件写入完成
+		select {
+		case s := <-onSyncStart:
+			if err := s.Stop(service.StopTimeout * time.Second); err != nil {
+				xzap.WithContext(ctx).Error("Failed to stop sync server", zap.Error(err))
+			} else {
+				xzap.WithContext(ctx).Info("sync server stopped")
+			}
+		default:
+		}
 	},
 }
 
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ProjectsTask/EasySwapSync/service"
+	"github.com/ProjectsTask/EasySwapSync/service/collectionimporter"
+	"github.com/ProjectsTask/EasySwapSync/service/config"
+)
+
+var (
+	importChain          string
+	importCollectionAddr string
+	importFromBlock      uint64
+)
+
+var ImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "import a collection.",
+	Long:  "add a collection to the import queue, the running sync daemon imports its items, owners and metadata.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.UnmarshalCmdConfig() // 读取和解析配置文件
+		if err != nil {
+			return err
+		}
+		if cfg, err = cfg.ChainConfig(importChain); err != nil {
+			return err
+		}
+
+		kvStore := service.NewKvStore(cfg)
+		if err := collectionimporter.AddToImportQueue(kvStore, cfg.ChainCfg.Name, &collectionimporter.ImportTask{
+			CollectionAddr: importCollectionAddr,
+			FromBlock:      importFromBlock,
+		}); err != nil {
+			return err
+		}
+
+		fmt.Println("collection added to import queue:", importCollectionAddr)
+		return nil
+	},
+}
+
+func init() {
+	flags := ImportCmd.Flags()
+	flags.StringVar(&importChain, "chain", "", "chain name, required when multiple chains are configured")
+	flags.StringVarP(&importCollectionAddr, "address", "a", "", "collection contract address")
+	flags.Uint64Var(&importFromBlock, "from-block", 0, "block to start scanning transfer events from, usually the contract deploy block")
+	_ = ImportCmd.MarkFlagRequired("address")
+
+	rootCmd.AddCommand(ImportCmd)
+}
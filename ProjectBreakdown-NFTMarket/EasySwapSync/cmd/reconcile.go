@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ProjectsTask/EasySwapBase/chain/chainclient"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/ProjectsTask/EasySwapSync/model"
+	"github.com/ProjectsTask/EasySwapSync/service/config"
+	"github.com/ProjectsTask/EasySwapSync/service/orderbookindexer"
+)
+
+var (
+	reconcileChain  string
+	reconcileRepair bool
+)
+
+var ReconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "diff the indexed order book against on-chain order book state.",
+	Long: "walk the on-chain price trees of every collection at the last indexed block and compare them with the active orders " +
+		"in ob_order. print missing, extra and mispriced orders, repair them with --repair. side effect events are written to " +
+		"the outbox and delivered by the running sync daemon.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.UnmarshalCmdConfig() // 读取和解析配置文件
+		if err != nil {
+			return err
+		}
+		if cfg, err = cfg.ChainConfig(reconcileChain); err != nil {
+			return err
+		}
+		if _, err := xzap.SetUp(*cfg.Log); err != nil {
+			return errors.Wrap(err, "failed on set up logger")
+		}
+
+		ctx := context.Background()
+		db := model.NewDB(cfg.DB)
+		chainClient, err := chainclient.New(int(cfg.ChainCfg.ID), cfg.NodeUrl(), cfg.ChainClientOptions()...)
+		if err != nil {
+			return errors.Wrap(err, "failed on create evm client")
+		}
+		indexer, err := orderbookindexer.New(ctx, cfg, db, nil, chainClient, cfg.ChainCfg.ID, cfg.ChainCfg.Name, nil, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		report, err := indexer.Reconcile(reconcileRepair)
+		if err != nil {
+			return err
+		}
+
+		raw, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "failed on marshal reconcile report")
+		}
+		fmt.Println(string(raw))
+		return nil
+	},
+}
+
+func init() {
+	flags := ReconcileCmd.Flags()
+	flags.StringVar(&reconcileChain, "chain", "", "chain name, required when multiple chains are configured")
+	flags.BoolVar(&reconcileRepair, "repair", false, "repair the indexed order book to match chain state")
+
+	rootCmd.AddCommand(ReconcileCmd)
+}
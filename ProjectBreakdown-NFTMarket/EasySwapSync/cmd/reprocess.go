@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ProjectsTask/EasySwapBase/currency"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/ProjectsTask/EasySwapSync/model"
+	"github.com/ProjectsTask/EasySwapSync/service/config"
+	"github.com/ProjectsTask/EasySwapSync/service/orderbookindexer"
+	"github.com/ProjectsTask/EasySwapSync/service/tradestats"
+)
+
+var (
+	reprocessChain     string
+	reprocessFromBlock uint64
+	reprocessToBlock   uint64
+)
+
+var ReprocessCmd = &cobra.Command{
+	Use:   "reprocess",
+	Short: "rebuild orders and activities from archived logs.",
+	Long: "replay the orderbook logs archived in ob_raw_log between --from and --to, rebuild ob_order and ob_activity " +
+		"for the range without any rpc requests. replayed events are not re-queued, one collection reload event per touched collection " +
+		"is written to the outbox and delivered by the running sync daemon.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if reprocessFromBlock > reprocessToBlock {
+			return errors.New("from block must not be greater than to block")
+		}
+
+		cfg, err := config.UnmarshalCmdConfig() // 读取和解析配置文件
+		if err != nil {
+			return err
+		}
+		if cfg, err = cfg.ChainConfig(reprocessChain); err != nil {
+			return err
+		}
+		if _, err := xzap.SetUp(*cfg.Log); err != nil {
+			return errors.Wrap(err, "failed on set up logger")
+		}
+
+		ctx := context.Background()
+		db := model.NewDB(cfg.DB)
+		priceSource, err := currency.NewStaticPriceSource(cfg.Currencies)
+		if err != nil {
+			return errors.Wrap(err, "failed on create price source")
+		}
+		tradeStats := tradestats.New(ctx, db, cfg.ChainCfg.Name, priceSource)
+		// 不传入节点客户端，区块时间等信息均从归档日志中读取
+		indexer, err := orderbookindexer.New(ctx, cfg, db, nil, nil, cfg.ChainCfg.ID, cfg.ChainCfg.Name, nil, nil, nil, tradeStats)
+		if err != nil {
+			return err
+		}
+		if err := indexer.ReprocessBlockRange(reprocessFromBlock, reprocessToBlock); err != nil {
+			return err
+		}
+
+		fmt.Printf("reprocessed block %d to %d\n", reprocessFromBlock, reprocessToBlock)
+		return nil
+	},
+}
+
+func init() {
+	flags := ReprocessCmd.Flags()
+	flags.StringVar(&reprocessChain, "chain", "", "chain name, required when multiple chains are configured")
+	flags.Uint64Var(&reprocessFromBlock, "from", 0, "first block to reprocess")
+	flags.Uint64Var(&reprocessToBlock, "to", 0, "last block to reprocess")
+	_ = ReprocessCmd.MarkFlagRequired("from")
+	_ = ReprocessCmd.MarkFlagRequired("to")
+
+	rootCmd.AddCommand(ReprocessCmd)
+}
@@ -0,0 +1,10 @@
+create table ob_indexer_outbox_sepolia
+(
+    id          bigint auto_increment comment '主键'
+        primary key,
+    event_type  tinyint not null comment '(1:order manager queue,2:price update event)',
+    payload     text    not null comment '事件内容(json)',
+    create_time bigint  null comment '创建时间',
+    update_time bigint  null comment '更新时间'
+)
+    collate = utf8mb4_general_ci;
@@ -0,0 +1,2 @@
+alter table ob_activity_sepolia
+    add quantity bigint default 1 not null comment '成交/挂单数量' after buy_price;
@@ -0,0 +1,22 @@
+create table ob_contract_event_sepolia
+(
+    id           bigint auto_increment comment '主键'
+        primary key,
+    event_type   tinyint                not null comment '(1:Skip Order,2:Withdraw ETH,3:Updated Protocol Share,4:Batch Match Inner Error,5:Paused,6:Unpaused)',
+    order_id     varchar(66) default '' not null comment '被跳过的订单id(LogSkipOrder)',
+    address      varchar(42) default '' not null comment '提现接收地址(LogWithdrawETH)或操作者地址(Paused/Unpaused)',
+    amount       decimal(30) default 0  not null comment '提现金额(LogWithdrawETH)、新的协议分成(LogUpdatedProtocolShare)或出错的订单下标(BatchMatchInnerError)',
+    data         text                   null comment '附加数据(hex)，如BatchMatchInnerError的错误信息',
+    block_number bigint      default 0  not null comment '区块号',
+    tx_hash      varchar(66)            not null comment '交易事务hash',
+    log_index    int                    not null comment '日志在区块中的下标',
+    event_time   bigint                 null comment '链上事件发生的时间',
+    create_time  bigint                 null comment '创建时间',
+    update_time  bigint                 null comment '更新时间',
+    constraint index_tx_log
+        unique (tx_hash, log_index)
+)
+    collate = utf8mb4_general_ci;
+
+create index index_type_block
+    on ob_contract_event_sepolia (event_type, block_number);
@@ -0,0 +1,19 @@
+create table ob_raw_log_sepolia
+(
+    id           bigint auto_increment comment '主键'
+        primary key,
+    block_number bigint                  not null comment '区块号',
+    block_hash   varchar(66)  default '' not null comment '区块hash',
+    block_time   bigint       default 0  not null comment '区块时间，重放时不再请求节点',
+    tx_hash      varchar(66)             not null comment '交易事务hash',
+    tx_index     int          default 0  not null comment '交易在区块中的下标',
+    log_index    int                     not null comment '日志在区块中的下标',
+    address      varchar(42)             not null comment '合约地址',
+    topics       varchar(300) default '' not null comment '以逗号分隔的topic列表',
+    data         mediumtext              null comment '日志数据(hex)',
+    create_time  bigint                  null comment '创建时间',
+    update_time  bigint                  null comment '更新时间',
+    constraint index_block_tx_log
+        unique (block_number, tx_hash, log_index)
+)
+    collate = utf8mb4_general_ci;
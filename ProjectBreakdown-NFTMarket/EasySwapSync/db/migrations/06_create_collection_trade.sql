@@ -0,0 +1,32 @@
+create table ob_collection_trade_sepolia
+(
+    id                 bigint auto_increment comment '主键'
+        primary key,
+    epoch_number       bigint      default 0 not null comment '统计窗口包含的周期数，每个周期5分钟(3:15m,12:1h,72:6h,288:1d,2016:7d,8640:30d)',
+    collection_address varchar(42)           not null comment '链上合约地址',
+    item_count         bigint      default 0 not null comment '窗口内成交的nft数量',
+    volume             decimal(40) default 0 not null comment '窗口内的成交额(wei)',
+    floor_price        decimal(30) default 0 not null comment '地板价',
+    benchmark_price    decimal(30) default 0 not null comment '池子相关数据,基准价格',
+    sell_price         decimal(30) default 0 not null comment '池子相关数据,出售价格',
+    buy_price          decimal(30) default 0 not null comment '池子相关数据,购买价格',
+    sale_count         bigint      default 0 not null comment '窗口内的成交笔数',
+    buyer_count        bigint      default 0 not null comment '窗口内的买家数(去重)',
+    seller_count       bigint      default 0 not null comment '窗口内的卖家数(去重)',
+    avg_price          decimal(30) default 0 not null comment '窗口内的平均成交价(wei)',
+    min_price          decimal(30) default 0 not null comment '窗口内的最低成交价(wei)',
+    max_price          decimal(30) default 0 not null comment '窗口内的最高成交价(wei)',
+    prev_volume        decimal(40) default 0 not null comment '上一窗口的成交额(wei)',
+    prev_min_price     decimal(30) default 0 not null comment '上一窗口的最低成交价(wei)',
+    create_time        bigint                null comment '创建时间',
+    update_time        bigint                null comment '更新时间',
+    constraint index_collection_epoch
+        unique (collection_address, epoch_number)
+)
+    collate = utf8mb4_general_ci;
+
+create index index_epoch_volume
+    on ob_collection_trade_sepolia (epoch_number, volume);
+
+create index index_collection_type_time
+    on ob_activity_sepolia (collection_address, activity_type, event_time);
@@ -0,0 +1,20 @@
+create table ob_collection_floor_change_sepolia
+(
+    id                 bigint auto_increment comment '主键'
+        primary key,
+    collection_address varchar(42)           not null comment '链上合约地址',
+    old_price          decimal(30) default 0 not null comment '变化前的地板价，0表示没有挂单',
+    new_price          decimal(30) default 0 not null comment '变化后的地板价，0表示没有挂单',
+    order_id           varchar(66)           null comment '触发变化的订单',
+    event_type         int         default 0 not null comment '触发变化的事件类型',
+    event_time         bigint                not null comment '变化时间',
+    create_time        bigint                null comment '创建时间',
+    update_time        bigint                null comment '更新时间'
+)
+    collate = utf8mb4_general_ci;
+
+create index index_collection_time
+    on ob_collection_floor_change_sepolia (collection_address, event_time);
+
+create index index_event_time
+    on ob_collection_floor_change_sepolia (event_time);
@@ -0,0 +1,17 @@
+create table ob_item_holder_sepolia
+(
+    id                 bigint auto_increment comment '主键'
+        primary key,
+    collection_address varchar(42)           not null comment '链上合约地址',
+    token_id           varchar(128)          not null comment 'token_id',
+    owner              varchar(42)           not null comment '持有人',
+    balance            decimal(65) default 0 not null comment '持有数量',
+    create_time        bigint                null comment '创建时间',
+    update_time        bigint                null comment '更新时间',
+    constraint index_collection_token_owner
+        unique (collection_address, token_id, owner)
+)
+    collate = utf8mb4_general_ci;
+
+create index index_owner_collection
+    on ob_item_holder_sepolia (owner, collection_address);
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ProjectsTask/EasySwapBase/chain"
+	"github.com/ProjectsTask/EasySwapBase/chain/chainclient"
+	"github.com/ProjectsTask/EasySwapBase/chain/multicall"
+	"github.com/ProjectsTask/EasySwapBase/chain/nftchainservice"
+	"github.com/ProjectsTask/EasySwapBase/currency"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/ordermanager"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/base"
+	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/ProjectsTask/EasySwapSync/service/collectionfilter"
+	"github.com/ProjectsTask/EasySwapSync/service/collectionimporter"
+	"github.com/ProjectsTask/EasySwapSync/service/config"
+	"github.com/ProjectsTask/EasySwapSync/service/metadatarefresher"
+	"github.com/ProjectsTask/EasySwapSync/service/orderbookindexer"
+	"github.com/ProjectsTask/EasySwapSync/service/tradestats"
+)
+
+const (
+	ChainStateStarting = "starting"
+	ChainStateRunning  = "running"
+	ChainStateFailed   = "failed"
+)
+
+const chainRetryInterval = 30 // in seconds
+
+// ChainStatus 单条链的同步状态
+type ChainStatus struct {
+	Name                 string `json:"name"`
+	ID                   int64  `json:"id"`
+	State                string `json:"state"`
+	Error                string `json:"error,omitempty"`
+	HeadBlock            uint64 `json:"head_block"`
+	IndexedBlock         uint64 `json:"indexed_block"`          // 订单簿事件已同步的区块
+	TransferIndexedBlock uint64 `json:"transfer_indexed_block"` // NFT转移事件已同步的区块
+}
+
+// chainService 单条链的索引及订单管理流程，初始化或启动失败时只影响本链，并定期重试
+type chainService struct {
+	ctx     context.Context
+	cfg     *config.Config
+	db      *gorm.DB
+	kvStore *xkv.Store
+
+	chainClient        chainclient.ChainClient
+	collectionFilter   *collectionfilter.Filter
+	orderbookIndexer   *orderbookindexer.Service
+	orderManager       *ordermanager.OrderManager
+	metadataRefresher  *metadatarefresher.Service
+	collectionImporter *collectionimporter.Service
+	tradeStats         *tradestats.Aggregator
+
+	mu    sync.RWMutex
+	state string
+	err   error
+}
+
+func newChainService(ctx context.Context, cfg *config.Config, db *gorm.DB, kvStore *xkv.Store) *chainService {
+	return &chainService{
+		ctx:     ctx,
+		cfg:     cfg,
+		db:      db,
+		kvStore: kvStore,
+		state:   ChainStateStarting,
+	}
+}
+
+// run 初始化并启动本链的各个流程，失败时记录状态并在间隔后重试
+func (c *chainService) run() {
+	for {
+		err := c.init()
+		if err == nil {
+			err = c.start()
+		}
+		if err == nil {
+			c.setState(ChainStateRunning, nil)
+			xzap.WithContext(c.ctx).Info("chain sync started", zap.String("chain", c.cfg.ChainCfg.Name))
+			return
+		}
+
+		c.setState(ChainStateFailed, err)
+		xzap.WithContext(c.ctx).Error("failed on start chain sync, retry later",
+			zap.String("chain", c.cfg.ChainCfg.Name), zap.Error(err))
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(chainRetryInterval * time.Second):
+		}
+	}
+}
+
+func (c *chainService) init() error {
+	if c.orderbookIndexer != nil { // 已初始化，仅需重试启动
+		return nil
+	}
+
+	cfg := c.cfg
+	chainClient, err := chainclient.New(int(cfg.ChainCfg.ID), cfg.NodeUrl(), cfg.ChainClientOptions()...)
+	if err != nil {
+		return errors.Wrap(err, "failed on create evm client")
+	}
+
+	metadataParse := cfg.MetadataParse
+	if metadataParse == nil {
+		metadataParse = &config.MetadataParse{}
+	}
+	nodeSrv, err := nftchainservice.New(c.ctx, cfg.NodeUrl(), cfg.ChainCfg.Name, int(cfg.ChainCfg.ID),
+		metadataParse.NameTags, metadataParse.ImageTags, metadataParse.AttributesTags,
+		metadataParse.TraitNameTags, metadataParse.TraitValueTags, cfg.ChainClientOptions()...)
+	if err != nil {
+		return errors.Wrap(err, "failed on create nft chain service")
+	}
+	nodeSrv.Fetcher = nftchainservice.NewFetcher(nodeSrv.HttpClient.Client, cfg.FetcherConfig())
+
+	collectionFilter := collectionfilter.New(c.ctx, c.db, cfg.ChainCfg.Name, cfg.ProjectCfg.Name)
+	var orderManagerOpts []ordermanager.Option
+	if cfg.ContractCfg.VaultAddress != "" {
+		orderManagerOpts = append(orderManagerOpts, ordermanager.WithOrderValidator(chainClient, ordermanager.ValidatorCfg{
+			VaultAddress:     cfg.ContractCfg.VaultAddress,
+			MulticallAddress: multicall.AddressOf(int(cfg.ChainCfg.ID)).Hex(),
+			Interval:         cfg.ContractCfg.ValidateInterval,
+		}))
+	}
+	switch cfg.OrderExpiryCfg.Scheduler {
+	case "", ordermanager.ExpirySchedulerMemory:
+	case ordermanager.ExpirySchedulerRedis:
+		orderManagerOpts = append(orderManagerOpts, ordermanager.WithExpiryScheduler(
+			ordermanager.NewRedisExpiryScheduler(c.kvStore.Redis, cfg.ChainCfg.Name, cfg.OrderExpiryCfg.ClaimLease)))
+	default:
+		return errors.Errorf("unsupported order expiry scheduler: %s", cfg.OrderExpiryCfg.Scheduler)
+	}
+	orderManager := ordermanager.New(c.ctx, c.db, c.kvStore, cfg.ChainCfg.Name, cfg.ProjectCfg.Name, orderManagerOpts...)
+	priceSource, err := currency.NewStaticPriceSource(cfg.Currencies)
+	if err != nil {
+		return errors.Wrap(err, "failed on create price source")
+	}
+	tradeStats := tradestats.New(c.ctx, c.db, cfg.ChainCfg.Name, priceSource)
+	var orderbookSyncer *orderbookindexer.Service
+	switch cfg.ChainCfg.ID {
+	case chain.EthChainID, chain.OptimismChainID, chain.SepoliaChainID,
+		chain.ArbitrumChainID, chain.BaseChainID, chain.ZkSyncEraChainID:
+		orderbookSyncer, err = orderbookindexer.New(c.ctx, cfg, c.db, c.kvStore, chainClient, cfg.ChainCfg.ID, cfg.ChainCfg.Name, orderManager,
+			nodeSrv, collectionFilter, tradeStats)
+		if err != nil {
+			return errors.Wrap(err, "failed on create trade info server")
+		}
+	default:
+		return errors.Errorf("unsupported chain id: %d", cfg.ChainCfg.ID)
+	}
+	metadataRefresher := metadatarefresher.New(c.ctx, c.db, c.kvStore, nodeSrv, cfg.ChainCfg.Name, cfg.ChainCfg.ID,
+		cfg.ProjectCfg.Name, cfg.MetadataRefreshCfg)
+	collectionImporter := collectionimporter.New(c.ctx, c.db, c.kvStore, nodeSrv, metadataRefresher, collectionFilter,
+		cfg.ChainCfg.Name, cfg.ChainCfg.ID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.chainClient = chainClient
+	c.collectionFilter = collectionFilter
+	c.orderManager = orderManager
+	c.metadataRefresher = metadataRefresher
+	c.collectionImporter = collectionImporter
+	c.tradeStats = tradeStats
+	c.orderbookIndexer = orderbookSyncer
+	return nil
+}
+
+func (c *chainService) start() error {
+	// 不要移动位置
+	if err := c.collectionFilter.PreloadCollections(); err != nil {
+		return errors.Wrap(err, "failed on preload collection to filter")
+	}
+
+	c.orderbookIndexer.Start()
+	c.orderManager.Start()
+	c.metadataRefresher.Start()
+	c.collectionImporter.Start()
+	c.tradeStats.Start()
+	return nil
+}
+
+// stop 先停止索引，避免向已停止的订单管理投递事件，再停止订单管理，两者共用timeout
+func (c *chainService) stop(timeout time.Duration) error {
+	c.mu.RLock()
+	orderbookIndexer, orderManager := c.orderbookIndexer, c.orderManager
+	c.mu.RUnlock()
+	if orderbookIndexer == nil { // 未初始化成功
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	if err := orderbookIndexer.Stop(timeout); err != nil {
+		return errors.Wrap(err, "failed on stop orderbook indexer")
+	}
+	if err := orderManager.Stop(time.Until(deadline)); err != nil {
+		return errors.Wrap(err, "failed on stop order manager")
+	}
+	return nil
+}
+
+func (c *chainService) setState(state string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+	c.err = err
+}
+
+// Status 返回本链的运行状态、最新区块及已同步的区块
+func (c *chainService) Status() ChainStatus {
+	c.mu.RLock()
+	status := ChainStatus{
+		Name:  c.cfg.ChainCfg.Name,
+		ID:    c.cfg.ChainCfg.ID,
+		State: c.state,
+	}
+	if c.err != nil {
+		status.Error = c.err.Error()
+	}
+	running := c.state == ChainStateRunning
+	c.mu.RUnlock()
+
+	if !running {
+		return status
+	}
+
+	if headBlock, err := c.chainClient.BlockNumber(); err != nil {
+		status.Error = errors.Wrap(err, "failed on get current block number").Error()
+	} else {
+		status.HeadBlock = headBlock
+	}
+
+	var indexedStatus []base.IndexedStatus
+	if err := c.db.WithContext(c.ctx).Table(base.IndexedStatusTableName()).
+		Select("index_type, last_indexed_block").
+		Where("chain_id = ? and index_type in (?)", c.cfg.ChainCfg.ID,
+			[]int{orderbookindexer.EventIndexType, orderbookindexer.TransferIndexType}).
+		Find(&indexedStatus).Error; err != nil {
+		status.Error = errors.Wrap(err, "failed on get indexed status").Error()
+		return status
+	}
+	for _, s := range indexedStatus {
+		switch s.IndexType {
+		case orderbookindexer.EventIndexType:
+			status.IndexedBlock = uint64(s.LastIndexedBlock)
+		case orderbookindexer.TransferIndexType:
+			status.TransferIndexedBlock = uint64(s.LastIndexedBlock)
+		}
+	}
+
+	return status
+}
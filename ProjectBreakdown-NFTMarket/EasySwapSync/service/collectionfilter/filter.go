@@ -15,23 +15,25 @@ import (
 
 // Filter is a thread-safe structure to store a set of strings.
 type Filter struct {
-	ctx     context.Context
-	db      *gorm.DB
-	chain   string
-	set     map[string]bool // Set of strings
-	lock    *sync.RWMutex   // Read/Write mutex for thread safety
-	project string
+	ctx      context.Context
+	db       *gorm.DB
+	chain    string
+	set      map[string]bool // Set of strings
+	lock     *sync.RWMutex   // Read/Write mutex for thread safety
+	syncLock *sync.Mutex     // Held while a batch of transfer events is synced
+	project  string
 }
 
 // NewFilter creates a new Filter and returns its pointer.
 func New(ctx context.Context, db *gorm.DB, chain string, project string) *Filter {
 	return &Filter{
-		ctx:     ctx,
-		db:      db,
-		chain:   chain,
-		set:     make(map[string]bool),
-		lock:    &sync.RWMutex{},
-		project: project,
+		ctx:      ctx,
+		db:       db,
+		chain:    chain,
+		set:      make(map[string]bool),
+		lock:     &sync.RWMutex{},
+		syncLock: &sync.Mutex{},
+		project:  project,
 	}
 }
 
@@ -50,6 +52,18 @@ func (f *Filter) Remove(element string) {
 	delete(f.set, strings.ToLower(element))
 }
 
+// LockSync blocks until no batch of transfer events is being synced.
+// The transfer indexer holds it from reading Elements until the batch is committed, so a collection
+// added while holding it is picked up exactly from the next unsynced block.
+func (f *Filter) LockSync() {
+	f.syncLock.Lock()
+}
+
+// UnlockSync releases the lock acquired by LockSync.
+func (f *Filter) UnlockSync() {
+	f.syncLock.Unlock()
+}
+
 // Contains checks whether the Filter contains a specific element.
 // The element is transformed to lowercase before checking.
 func (f *Filter) Contains(element string) bool {
@@ -59,6 +73,17 @@ func (f *Filter) Contains(element string) bool {
 	return exists
 }
 
+// Elements returns all elements in the Filter.
+func (f *Filter) Elements() []string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	elements := make([]string, 0, len(f.set))
+	for element := range f.set {
+		elements = append(elements, element)
+	}
+	return elements
+}
+
 func (f *Filter) PreloadCollections() error {
 	var addresses []string
 	var err error
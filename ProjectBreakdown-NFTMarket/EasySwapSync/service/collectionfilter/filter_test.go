@@ -28,6 +28,13 @@ func TestFilter(t *testing.T) {
 		t.Error("Expected Filter to contain 'test'")
 	}
 
+	// Test Elements.
+	filter.Add("Other")
+	if elements := filter.Elements(); len(elements) != 2 {
+		t.Errorf("Expected 2 elements, got %v", elements)
+	}
+	filter.Remove("Other")
+
 	// Test Remove.
 	filter.Remove("Test")
 	if filter.Contains("Test") {
@@ -0,0 +1,534 @@
+package collectionimporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ProjectsTask/EasySwapBase/chain/nftchainservice"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/ordermanager"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/base"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/zeromicro/go-zero/core/threading"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ProjectsTask/EasySwapSync/service/collectionfilter"
+	"github.com/ProjectsTask/EasySwapSync/service/comm"
+	"github.com/ProjectsTask/EasySwapSync/service/metadatarefresher"
+)
+
+const CacheCollectionImportQueuePre = "cache:es:collection:import:%s"
+
+const (
+	ImportBlockSize   = 2000 // 拉取历史Transfer事件时每个区间的区块数
+	ImportConcurrency = 8
+	metadataBatchSize = 500  // 每批查询tokenURI的token数
+	IdleInterval      = 10   // in seconds
+	maxRecordMsgLen   = 1600 // 与ob_collection_import_record.msg字段长度一致
+
+	zeroAddress = "0x0000000000000000000000000000000000000000"
+)
+
+// ImportTask 导入队列中的任务
+type ImportTask struct {
+	CollectionAddr string `json:"collection_addr"`
+	FromBlock      uint64 `json:"from_block"` // 从该区块开始扫描历史Transfer事件，通常为合约部署区块
+}
+
+func GenImportQueueKey(chain string) string {
+	return fmt.Sprintf(CacheCollectionImportQueuePre, chain)
+}
+
+// AddToImportQueue 将collection加入导入队列，由sync daemon异步导入
+func AddToImportQueue(kv *xkv.Store, chain string, task *ImportTask) error {
+	if !common.IsHexAddress(task.CollectionAddr) {
+		return errors.New("invalid collection address")
+	}
+
+	rawTask, err := json.Marshal(task)
+	if err != nil {
+		return errors.Wrap(err, "failed on marshal import task")
+	}
+
+	if _, err := kv.Rpush(GenImportQueueKey(chain), string(rawTask)); err != nil {
+		return errors.Wrap(err, "failed on push import task to queue")
+	}
+
+	return nil
+}
+
+// Service 导入collection：识别合约标准，读取基础信息，通过历史Transfer事件恢复item及owner，拉取元数据，
+// 最后加入collection过滤器并标记地板价已导入
+type Service struct {
+	ctx              context.Context
+	db               *gorm.DB
+	kv               *xkv.Store
+	nodeSrv          *nftchainservice.Service
+	refresher        *metadatarefresher.Service
+	collectionFilter *collectionfilter.Filter
+	chain            string
+	chainId          int64
+}
+
+func New(ctx context.Context, db *gorm.DB, kv *xkv.Store, nodeSrv *nftchainservice.Service, refresher *metadatarefresher.Service,
+	collectionFilter *collectionfilter.Filter, chain string, chainId int64) *Service {
+	return &Service{
+		ctx:              ctx,
+		db:               db,
+		kv:               kv,
+		nodeSrv:          nodeSrv,
+		refresher:        refresher,
+		collectionFilter: collectionFilter,
+		chain:            chain,
+		chainId:          chainId,
+	}
+}
+
+func (s *Service) Start() {
+	threading.GoSafe(s.ImportCollectionLoop)
+}
+
+// sleep 等待d或ctx取消，ctx取消时返回false
+func (s *Service) sleep(d time.Duration) bool {
+	select {
+	case <-s.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// ImportCollectionLoop 依次处理导入队列中的任务。任务在导入结束后才从队列移除，
+// 导入过程中进程退出或ctx取消时任务保留在队首，重启后重新导入
+func (s *Service) ImportCollectionLoop() {
+	key := GenImportQueueKey(s.chain)
+	for {
+		select {
+		case <-s.ctx.Done():
+			xzap.WithContext(s.ctx).Info("ImportCollectionLoop stopped due to context cancellation")
+			return
+		default:
+		}
+
+		raw, err := s.kv.Lindex(key, 0)
+		if err != nil {
+			xzap.WithContext(s.ctx).Error("failed on get collection import task", zap.Error(err))
+			if !s.sleep(IdleInterval * time.Second) {
+				xzap.WithContext(s.ctx).Info("ImportCollectionLoop stopped due to context cancellation")
+				return
+			}
+			continue
+		}
+		if raw == "" {
+			if !s.sleep(IdleInterval * time.Second) {
+				xzap.WithContext(s.ctx).Info("ImportCollectionLoop stopped due to context cancellation")
+				return
+			}
+			continue
+		}
+
+		var task ImportTask
+		if err := json.Unmarshal([]byte(raw), &task); err != nil {
+			xzap.WithContext(s.ctx).Error("failed on unmarshal import task", zap.String("task", raw), zap.Error(err))
+		} else if err := s.ImportCollection(&task); err != nil {
+			if s.ctx.Err() != nil { // 导入被中断，保留任务
+				xzap.WithContext(s.ctx).Info("ImportCollectionLoop stopped due to context cancellation",
+					zap.String("collection_addr", task.CollectionAddr))
+				return
+			}
+			xzap.WithContext(s.ctx).Error("failed on import collection",
+				zap.String("collection_addr", task.CollectionAddr), zap.Error(err))
+		}
+
+		if _, err := s.kv.Lrem(key, 1, raw); err != nil {
+			xzap.WithContext(s.ctx).Error("failed on remove collection import task", zap.String("task", raw), zap.Error(err))
+		}
+	}
+}
+
+// ImportCollection 导入单个collection，导入进度及错误信息记录在ob_collection_import_record中
+func (s *Service) ImportCollection(task *ImportTask) error {
+	collectionAddr := strings.ToLower(task.CollectionAddr)
+	record := multi.CollectionImportRecord{
+		CollectionAddress: collectionAddr,
+		FinishedStage:     multi.ImportStageQueued,
+	}
+	if err := s.db.WithContext(s.ctx).Table(multi.CollectionImportRecordTableName(s.chain)).
+		Create(&record).Error; err != nil {
+		return errors.Wrap(err, "failed on create import record")
+	}
+
+	msg, err := s.importCollection(record.Id, collectionAddr, task.FromBlock)
+	if err != nil {
+		msg = err.Error()
+	}
+	if len(msg) > maxRecordMsgLen {
+		msg = msg[:maxRecordMsgLen]
+	}
+	if err := s.db.WithContext(s.ctx).Table(multi.CollectionImportRecordTableName(s.chain)).
+		Where("id = ?", record.Id).
+		Update("msg", msg).Error; err != nil {
+		xzap.WithContext(s.ctx).Error("failed on update import record msg", zap.Error(err))
+	}
+
+	return err
+}
+
+func (s *Service) importCollection(recordId int64, collectionAddr string, fromBlock uint64) (string, error) {
+	info, err := s.nodeSrv.FetchCollectionInfo(collectionAddr)
+	if err != nil {
+		return "", errors.Wrap(err, "failed on fetch collection info")
+	}
+	switch info.TokenStandard {
+	case nftchainservice.TokenStandardERC721, nftchainservice.TokenStandardERC1155:
+	default:
+		return "", errors.New("contract is neither erc721 nor erc1155")
+	}
+
+	collection := multi.Collection{
+		Address:          collectionAddr,
+		ChainId:          int(s.chainId),
+		Name:             info.Name,
+		Symbol:           info.Symbol,
+		TokenStandard:    info.TokenStandard,
+		IsSyncing:        1,
+		FloorPriceStatus: comm.CollectionFloorPriceNotImport,
+	}
+	if info.TotalSupply != nil {
+		collection.ItemAmount = info.TotalSupply.Int64()
+	}
+	if err := s.db.WithContext(s.ctx).Table(multi.CollectionTableName(s.chain)).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "address"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "symbol", "token_standard", "is_syncing", "update_time"}),
+	}).Create(&collection).Error; err != nil {
+		return "", errors.Wrap(err, "failed on create collection")
+	}
+	if err := s.updateRecordStage(recordId, multi.ImportStageCollection); err != nil {
+		return "", err
+	}
+
+	// 只导入transfer索引已同步的区块，之后的区块由transfer索引处理，不读取未确认的区块
+	indexedBlock, err := s.transferIndexedBlock()
+	if err != nil {
+		return "", err
+	}
+	holders := make(tokenHolders)
+	if err := s.importItems(collectionAddr, info.TokenStandard, holders, fromBlock, indexedBlock); err != nil {
+		return "", errors.Wrap(err, "failed on import items")
+	}
+
+	// 拉取元数据，tokenURI按批通过multicall查询，失败的item在item_external中标记为FetchMetadataFailed，可通过刷新接口重试
+	tokenIDs := make([]string, 0, len(holders))
+	for tokenID := range holders {
+		tokenIDs = append(tokenIDs, tokenID)
+	}
+	var failedCount int
+	for start := 0; start < len(tokenIDs); start += metadataBatchSize {
+		end := start + metadataBatchSize
+		if end > len(tokenIDs) {
+			end = len(tokenIDs)
+		}
+		for tokenID, err := range s.refresher.RefreshItemsMetadata(collectionAddr, tokenIDs[start:end]) {
+			failedCount++
+			xzap.WithContext(s.ctx).Warn("failed on fetch item metadata",
+				zap.String("collection_addr", collectionAddr), zap.String("token_id", tokenID), zap.Error(err))
+		}
+	}
+
+	if err := s.handOver(collectionAddr, info.TokenStandard, holders, fromBlock, indexedBlock); err != nil {
+		return "", err
+	}
+
+	ownerSet := make(map[string]struct{})
+	for _, tokenHolder := range holders {
+		for owner := range tokenHolder {
+			ownerSet[owner] = struct{}{}
+		}
+	}
+	if err := s.db.WithContext(s.ctx).Table(multi.CollectionTableName(s.chain)).
+		Where("address = ?", collectionAddr).
+		Updates(map[string]interface{}{
+			"item_amount":        len(holders),
+			"owner_amount":       len(ownerSet),
+			"is_syncing":         0,
+			"floor_price_status": comm.CollectionFloorPriceImported,
+		}).Error; err != nil {
+		return "", errors.Wrap(err, "failed on update collection import status")
+	}
+
+	if err := ordermanager.AddUpdatePriceEvent(s.kv, &ordermanager.TradeEvent{
+		EventType:      ordermanager.ImportCollection,
+		CollectionAddr: collectionAddr,
+	}, s.chain); err != nil {
+		xzap.WithContext(s.ctx).Error("failed on add import collection event", zap.Error(err))
+	}
+
+	if err := s.updateRecordStage(recordId, multi.ImportStageFinished); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("imported %d items, %d metadata failed", len(holders), failedCount), nil
+}
+
+// transferIndexedBlock 返回transfer索引下一个待同步的区块，此前的区块均已确认且已处理
+func (s *Service) transferIndexedBlock() (uint64, error) {
+	var indexedStatus base.IndexedStatus
+	if err := s.db.WithContext(s.ctx).Table(base.IndexedStatusTableName()).
+		Where("chain_id = ? and index_type = ?", s.chainId, base.TypeNftTransferIndex).
+		First(&indexedStatus).Error; err != nil {
+		return 0, errors.Wrap(err, "failed on get transfer index status")
+	}
+
+	return uint64(indexedStatus.LastIndexedBlock), nil
+}
+
+// handOver 补齐导入过程中transfer索引推进的区块，然后将collection加入过滤器交由transfer索引接管。
+// 期间持有过滤器的同步锁，transfer索引从加入后的下一批区块开始处理该collection，每个区块只处理一次
+func (s *Service) handOver(collectionAddr string, standard int64, holders tokenHolders, fromBlock, indexedBlock uint64) error {
+	s.collectionFilter.LockSync()
+	defer s.collectionFilter.UnlockSync()
+
+	latestBlock, err := s.transferIndexedBlock()
+	if err != nil {
+		return err
+	}
+	if indexedBlock > fromBlock {
+		fromBlock = indexedBlock
+	}
+	if err := s.importItems(collectionAddr, standard, holders, fromBlock, latestBlock); err != nil {
+		return errors.Wrap(err, "failed on import latest items")
+	}
+	s.collectionFilter.Add(collectionAddr)
+
+	return nil
+}
+
+// tokenHolders token -> 持有人 -> 持有数量
+type tokenHolders map[string]map[string]decimal.Decimal
+
+// importItems 按区块顺序将[fromBlock, endBlock)内的转移事件回放到holders上，写入涉及的item及其持有人
+func (s *Service) importItems(collectionAddr string, standard int64, holders tokenHolders, fromBlock, endBlock uint64) error {
+	if fromBlock >= endBlock {
+		return nil
+	}
+	toBlock := endBlock - 1
+	transferLogs, err := s.nodeSrv.GetCollectionTransferEventGoroutine(collectionAddr, fromBlock, toBlock,
+		ImportBlockSize, ImportConcurrency)
+	if err != nil {
+		return errors.Wrap(err, "failed on get collection transfer event")
+	}
+
+	var creators map[string]string
+	if standard == nftchainservice.TokenStandardERC1155 {
+		if creators, err = replayErc1155Transfers(holders, transferLogs); err != nil {
+			return errors.Wrap(err, "failed on replay erc1155 transfers")
+		}
+		// 回放的起始区块晚于部署区块时余额可能不准确，以回放截止区块的链上balanceOf为准
+		s.syncErc1155Balances(collectionAddr, holders, transferLogs, toBlock)
+	} else {
+		var owners map[string]string
+		owners, creators = replayTransfers(transferLogs)
+		for _, transferLog := range transferLogs {
+			if _, ok := owners[transferLog.TokenID]; !ok { // 已销毁
+				delete(holders, transferLog.TokenID)
+			}
+		}
+		for tokenID, owner := range owners {
+			holders[tokenID] = map[string]decimal.Decimal{owner: decimal.NewFromInt(1)}
+		}
+	}
+
+	touched := make(map[string]struct{})
+	var items []multi.Item
+	var tokenIDs []string
+	var itemHolders []multi.ItemHolder
+	for _, transferLog := range transferLogs {
+		tokenID := transferLog.TokenID
+		if _, ok := touched[tokenID]; ok {
+			continue
+		}
+		touched[tokenID] = struct{}{}
+		tokenIDs = append(tokenIDs, tokenID)
+
+		tokenHolder, ok := holders[tokenID]
+		if !ok {
+			continue
+		}
+		item := multi.Item{
+			ChainId:           int(s.chainId),
+			CollectionAddress: collectionAddr,
+			TokenId:           tokenID,
+			Creator:           creators[tokenID],
+		}
+		supply := decimal.Zero
+		for owner, balance := range tokenHolder {
+			supply = supply.Add(balance)
+			itemHolders = append(itemHolders, multi.ItemHolder{
+				CollectionAddress: collectionAddr,
+				TokenId:           tokenID,
+				Owner:             owner,
+				Balance:           balance,
+			})
+			if len(tokenHolder) == 1 { // 多个持有人的ERC-1155 token不设置owner
+				item.Owner = owner
+			}
+		}
+		item.Supply = supply.IntPart()
+		items = append(items, item)
+	}
+
+	return s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(items); start += comm.DBBatchSizeLimit {
+			end := start + comm.DBBatchSizeLimit
+			if end > len(items) {
+				end = len(items)
+			}
+			if err := tx.Table(multi.ItemTableName(s.chain)).Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "collection_address"}, {Name: "token_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"owner", "supply", "update_time"}),
+			}).Create(items[start:end]).Error; err != nil {
+				return errors.Wrap(err, "failed on create items")
+			}
+		}
+
+		// 涉及的token以回放结果覆盖持有人
+		for start := 0; start < len(tokenIDs); start += comm.DBBatchSizeLimit {
+			end := start + comm.DBBatchSizeLimit
+			if end > len(tokenIDs) {
+				end = len(tokenIDs)
+			}
+			if err := tx.Table(multi.ItemHolderTableName(s.chain)).
+				Where("collection_address = ? and token_id in (?)", collectionAddr, tokenIDs[start:end]).
+				Delete(&multi.ItemHolder{}).Error; err != nil {
+				return errors.Wrap(err, "failed on delete item holders")
+			}
+		}
+		for start := 0; start < len(itemHolders); start += comm.DBBatchSizeLimit {
+			end := start + comm.DBBatchSizeLimit
+			if end > len(itemHolders) {
+				end = len(itemHolders)
+			}
+			if err := tx.Table(multi.ItemHolderTableName(s.chain)).Create(itemHolders[start:end]).Error; err != nil {
+				return errors.Wrap(err, "failed on create item holders")
+			}
+		}
+
+		return nil
+	})
+}
+
+// syncErc1155Balances 通过balanceOf查询转移事件涉及的持有人在blockNumber时的余额并更新holders，查询失败时保留回放结果
+func (s *Service) syncErc1155Balances(collectionAddr string, holders tokenHolders, transferLogs []*nftchainservice.TransferLog,
+	blockNumber uint64) {
+	seen := make(map[nftchainservice.Holding]struct{})
+	var holdings []nftchainservice.Holding
+	for _, transferLog := range transferLogs {
+		for _, addr := range []string{transferLog.From, transferLog.To} {
+			owner := strings.ToLower(addr)
+			holding := nftchainservice.Holding{Owner: owner, TokenID: transferLog.TokenID}
+			if _, ok := seen[holding]; ok || owner == zeroAddress {
+				continue
+			}
+			seen[holding] = struct{}{}
+			holdings = append(holdings, holding)
+		}
+	}
+	if len(holdings) == 0 {
+		return
+	}
+
+	balances, errs, err := s.nodeSrv.FetchNftBalances(collectionAddr, holdings, new(big.Int).SetUint64(blockNumber))
+	if err != nil {
+		xzap.WithContext(s.ctx).Warn("failed on fetch erc1155 balances, use replayed balances",
+			zap.String("collection_addr", collectionAddr), zap.Error(err))
+		return
+	}
+	for i, holding := range holdings {
+		if errs[i] != nil || balances[i] == nil {
+			continue
+		}
+		setBalance(holders, holding.TokenID, holding.Owner, decimal.NewFromBigInt(balances[i], 0))
+	}
+}
+
+// replayErc1155Transfers 按顺序回放ERC-1155转移事件更新holders，返回本次回放中铸造的token的铸造者
+func replayErc1155Transfers(holders tokenHolders, transferLogs []*nftchainservice.TransferLog) (map[string]string, error) {
+	creators := make(map[string]string)
+	for _, transferLog := range transferLogs {
+		amount, err := decimal.NewFromString(transferLog.Amount)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid transfer amount %s", transferLog.Amount)
+		}
+		from, to := strings.ToLower(transferLog.From), strings.ToLower(transferLog.To)
+		tokenID := transferLog.TokenID
+		if from == zeroAddress {
+			if _, ok := creators[tokenID]; !ok {
+				creators[tokenID] = to
+			}
+		} else {
+			setBalance(holders, tokenID, from, holders[tokenID][from].Sub(amount))
+		}
+		if to != zeroAddress {
+			setBalance(holders, tokenID, to, holders[tokenID][to].Add(amount))
+		}
+	}
+
+	return creators, nil
+}
+
+// setBalance 更新持有数量，数量不大于0时移除该持有人，token没有持有人时移除该token
+func setBalance(holders tokenHolders, tokenID, owner string, balance decimal.Decimal) {
+	if !balance.IsPositive() {
+		if tokenHolder, ok := holders[tokenID]; ok {
+			delete(tokenHolder, owner)
+			if len(tokenHolder) == 0 {
+				delete(holders, tokenID)
+			}
+		}
+		return
+	}
+
+	if _, ok := holders[tokenID]; !ok {
+		holders[tokenID] = make(map[string]decimal.Decimal)
+	}
+	holders[tokenID][owner] = balance
+}
+
+// replayTransfers 回放有序的Transfer事件，得到每个token的最终owner及铸造者，已销毁的token不返回
+func replayTransfers(transferLogs []*nftchainservice.TransferLog) (map[string]string, map[string]string) {
+	owners := make(map[string]string)
+	creators := make(map[string]string)
+	for _, transferLog := range transferLogs {
+		to := strings.ToLower(transferLog.To)
+		if strings.ToLower(transferLog.From) == zeroAddress {
+			creators[transferLog.TokenID] = to
+		}
+		if to == zeroAddress {
+			delete(owners, transferLog.TokenID)
+			continue
+		}
+		owners[transferLog.TokenID] = to
+	}
+
+	return owners, creators
+}
+
+func (s *Service) updateRecordStage(recordId int64, stage int32) error {
+	if err := s.db.WithContext(s.ctx).Table(multi.CollectionImportRecordTableName(s.chain)).
+		Where("id = ?", recordId).
+		Update("finished_stage", stage).Error; err != nil {
+		return errors.Wrap(err, "failed on update import record stage")
+	}
+
+	return nil
+}
@@ -0,0 +1,366 @@
+package collectionimporter
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProjectsTask/EasySwapBase/chain/nftchainservice"
+	logTypes "github.com/ProjectsTask/EasySwapBase/chain/types"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/base"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethereumTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/zeromicro/go-zero/core/stores/cache"
+	"github.com/zeromicro/go-zero/core/stores/kv"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/ProjectsTask/EasySwapSync/service/collectionfilter"
+)
+
+func TestReplayTransfers(t *testing.T) {
+	alice := "0x00000000000000000000000000000000000000A1"
+	bob := "0x00000000000000000000000000000000000000b2"
+	logs := []*nftchainservice.TransferLog{
+		{From: zeroAddress, To: alice, TokenID: "1"},
+		{From: zeroAddress, To: alice, TokenID: "2"},
+		{From: zeroAddress, To: bob, TokenID: "3"},
+		{From: alice, To: bob, TokenID: "1"},
+		{From: bob, To: zeroAddress, TokenID: "3"}, // 销毁
+	}
+
+	owners, creators := replayTransfers(logs)
+	if len(owners) != 2 {
+		t.Fatalf("Unexpected owner count: expected 2, got %d", len(owners))
+	}
+	if owners["1"] != "0x00000000000000000000000000000000000000b2" {
+		t.Errorf("Unexpected owner of token 1: %s", owners["1"])
+	}
+	if owners["2"] != "0x00000000000000000000000000000000000000a1" {
+		t.Errorf("Unexpected owner of token 2: %s", owners["2"])
+	}
+	if _, ok := owners["3"]; ok {
+		t.Errorf("Burned token 3 should not have owner")
+	}
+	if creators["1"] != "0x00000000000000000000000000000000000000a1" {
+		t.Errorf("Unexpected creator of token 1: %s", creators["1"])
+	}
+}
+
+func TestReplayErc1155Transfers(t *testing.T) {
+	alice := "0x00000000000000000000000000000000000000A1"
+	bob := "0x00000000000000000000000000000000000000b2"
+	logs := []*nftchainservice.TransferLog{
+		{From: zeroAddress, To: alice, TokenID: "1", Amount: "10", IsErc1155: true},
+		{From: zeroAddress, To: bob, TokenID: "2", Amount: "1", IsErc1155: true},
+		{From: alice, To: bob, TokenID: "1", Amount: "4", IsErc1155: true},
+		{From: bob, To: zeroAddress, TokenID: "2", Amount: "1", IsErc1155: true}, // 销毁
+	}
+
+	holders := make(tokenHolders)
+	creators, err := replayErc1155Transfers(holders, logs)
+	if err != nil {
+		t.Fatalf("Failed on replay erc1155 transfers: %v", err)
+	}
+	if len(holders) != 1 {
+		t.Fatalf("Unexpected token count: expected 1, got %d", len(holders))
+	}
+	if balance := holders["1"]["0x00000000000000000000000000000000000000a1"]; balance.String() != "6" {
+		t.Errorf("Unexpected balance of alice: %s", balance)
+	}
+	if balance := holders["1"]["0x00000000000000000000000000000000000000b2"]; balance.String() != "4" {
+		t.Errorf("Unexpected balance of bob: %s", balance)
+	}
+	if creators["2"] != "0x00000000000000000000000000000000000000b2" {
+		t.Errorf("Unexpected creator of token 2: %s", creators["2"])
+	}
+
+	// 转出全部数量后移除持有人
+	logs = []*nftchainservice.TransferLog{{From: bob, To: alice, TokenID: "1", Amount: "4", IsErc1155: true}}
+	if _, err := replayErc1155Transfers(holders, logs); err != nil {
+		t.Fatalf("Failed on replay erc1155 transfers: %v", err)
+	}
+	if len(holders["1"]) != 1 || holders["1"]["0x00000000000000000000000000000000000000a1"].String() != "10" {
+		t.Errorf("Unexpected holders of token 1: %v", holders["1"])
+	}
+
+	logs = []*nftchainservice.TransferLog{{From: alice, To: bob, TokenID: "1", Amount: "x", IsErc1155: true}}
+	if _, err := replayErc1155Transfers(holders, logs); err == nil {
+		t.Errorf("Expected error on invalid amount")
+	}
+}
+
+func TestImportCollectionLoop(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(xzap.ToContext(context.Background(), zap.NewNop()))
+	defer cancel()
+	s := &Service{
+		ctx: ctx,
+		kv: &xkv.Store{
+			Store: kv.NewStore(kv.KvConf{cache.NodeConf{RedisConf: redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType}, Weight: 100}}),
+			Redis: redis.MustNewRedis(redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType}),
+		},
+		chain: "sepolia",
+	}
+	key := GenImportQueueKey(s.chain)
+	if _, err := mr.Push(key, "invalid task"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.ImportCollectionLoop()
+		close(done)
+	}()
+
+	// 处理结束的任务从队列移除
+	deadline := time.Now().Add(5 * time.Second)
+	for mr.Exists(key) {
+		if time.Now().After(deadline) {
+			t.Fatal("task not removed from import queue")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 空闲等待中ctx取消时立即退出
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ImportCollectionLoop not stopped after context cancellation")
+	}
+}
+
+// fakeNodeClient 以固定的ERC-1155转移日志模拟节点，记录查询过的区块
+type fakeNodeClient struct {
+	mu             sync.Mutex
+	collection     common.Address
+	logs           []ethereumTypes.Log
+	maxFilterBlock uint64   // FilterLogs查询过的最大区块
+	balanceBlocks  []uint64 // balanceOf查询的区块
+}
+
+func (c *fakeNodeClient) FilterLogs(ctx context.Context, q logTypes.FilterQuery) ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if to := q.ToBlock.Uint64(); to > c.maxFilterBlock {
+		c.maxFilterBlock = to
+	}
+	var result []interface{}
+	for _, log := range c.logs {
+		if log.BlockNumber >= q.FromBlock.Uint64() && log.BlockNumber <= q.ToBlock.Uint64() {
+			result = append(result, log)
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeNodeClient) BlockTimeByNumber(ctx context.Context, number *big.Int) (uint64, error) {
+	return number.Uint64() * 12, nil
+}
+
+func (c *fakeNodeClient) BlockTimesByNumber(ctx context.Context, numbers []*big.Int) ([]uint64, error) {
+	blockTimes := make([]uint64, len(numbers))
+	for i, number := range numbers {
+		blockTimes[i] = number.Uint64() * 12
+	}
+	return blockTimes, nil
+}
+
+func (c *fakeNodeClient) Client() interface{} { return nil }
+
+// CallContract 按blockNumber及之前的日志计算balanceOf，对Multicall3的调用返回空数据表示未部署
+func (c *fakeNodeClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if *msg.To != c.collection {
+		return nil, nil
+	}
+	args, err := nftchainservice.Erc1155Abi.Methods["balanceOf"].Inputs.Unpack(msg.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.balanceBlocks = append(c.balanceBlocks, blockNumber.Uint64())
+	c.mu.Unlock()
+
+	owner, tokenId := common.BytesToHash(args[0].(common.Address).Bytes()), args[1].(*big.Int)
+	balance := new(big.Int)
+	for _, log := range c.logs {
+		if log.BlockNumber > blockNumber.Uint64() {
+			continue
+		}
+		out, err := nftchainservice.Erc1155Abi.Events["TransferSingle"].Inputs.NonIndexed().Unpack(log.Data)
+		if err != nil {
+			return nil, err
+		}
+		if out[0].(*big.Int).Cmp(tokenId) != 0 {
+			continue
+		}
+		if log.Topics[2] == owner {
+			balance.Sub(balance, out[1].(*big.Int))
+		}
+		if log.Topics[3] == owner {
+			balance.Add(balance, out[1].(*big.Int))
+		}
+	}
+	return nftchainservice.Erc1155Abi.Methods["balanceOf"].Outputs.Pack(balance)
+}
+
+func (c *fakeNodeClient) CallContractByChain(ctx context.Context, param logTypes.CallParam) (interface{}, error) {
+	return nil, nil
+}
+
+func (c *fakeNodeClient) BlockNumber() (uint64, error) {
+	return 120, nil
+}
+
+func (c *fakeNodeClient) BlockWithTxs(ctx context.Context, blockNumber uint64) (interface{}, error) {
+	return nil, nil
+}
+
+func TestImportWhileTransferIndexBehind(t *testing.T) {
+	chain, collection := "sepolia", "0x00000000000000000000000000000000000000c1"
+	alice := common.HexToHash("0x00000000000000000000000000000000000000a1")
+	bob := common.HexToHash("0x00000000000000000000000000000000000000b2")
+	transfer := func(block uint64, from, to common.Hash, amount int64) ethereumTypes.Log {
+		data, err := nftchainservice.Erc1155Abi.Events["TransferSingle"].Inputs.NonIndexed().Pack(big.NewInt(1), big.NewInt(amount))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ethereumTypes.Log{
+			Address:     common.HexToAddress(collection),
+			Topics:      []common.Hash{nftchainservice.EVMTransferSingleTopic, alice, from, to},
+			Data:        data,
+			BlockNumber: block,
+		}
+	}
+	client := &fakeNodeClient{
+		collection: common.HexToAddress(collection),
+		logs: []ethereumTypes.Log{
+			transfer(50, common.Hash{}, alice, 10), // 铸造
+			transfer(100, alice, bob, 4),           // 导入期间transfer索引推进的区块
+			transfer(115, alice, bob, 1),           // 由transfer索引接管
+		},
+	}
+
+	db := newTestDB(t, chain)
+	if err := db.Table(base.IndexedStatusTableName()).Create(&base.IndexedStatus{
+		ChainId: 11155111, IndexType: base.TypeNftTransferIndex, LastIndexedBlock: 100,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	ctx := xzap.ToContext(context.Background(), zap.NewNop())
+	s := &Service{
+		ctx:              ctx,
+		db:               db,
+		nodeSrv:          &nftchainservice.Service{NodeClient: client, ChainName: chain},
+		collectionFilter: collectionfilter.New(ctx, db, chain, "OrderBookDex"),
+		chain:            chain,
+		chainId:          11155111,
+	}
+
+	// 只导入transfer索引已同步的区块
+	indexedBlock, err := s.transferIndexedBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	holders := make(tokenHolders)
+	if err := s.importItems(collection, nftchainservice.TokenStandardERC1155, holders, 1, indexedBlock); err != nil {
+		t.Fatalf("failed on import items: %v", err)
+	}
+
+	// transfer索引处理一批区块期间开始交接，交接等待该批提交后补齐索引推进的区块
+	s.collectionFilter.LockSync()
+	done := make(chan error)
+	go func() {
+		done <- s.handOver(collection, nftchainservice.TokenStandardERC1155, holders, 1, indexedBlock)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if s.collectionFilter.Contains(collection) {
+		t.Fatal("collection added while transfer batch in progress")
+	}
+	if err := db.Table(base.IndexedStatusTableName()).Where("index_type = ?", base.TypeNftTransferIndex).
+		Update("last_indexed_block", 110).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.collectionFilter.UnlockSync()
+	if err := <-done; err != nil {
+		t.Fatalf("failed on hand over: %v", err)
+	}
+	if !s.collectionFilter.Contains(collection) {
+		t.Error("expected collection added to filter")
+	}
+
+	// 区块110之后由transfer索引处理，导入不读取这些区块，余额按各段的截止区块查询
+	if client.maxFilterBlock != 109 {
+		t.Errorf("expected logs fetched up to block 109, got %d", client.maxFilterBlock)
+	}
+	if len(client.balanceBlocks) == 0 {
+		t.Error("expected balances queried")
+	}
+	for _, block := range client.balanceBlocks {
+		if block != 99 && block != 109 {
+			t.Errorf("unexpected balance query at block %d", block)
+		}
+	}
+	var item multi.Item
+	if err := db.Table(multi.ItemTableName(chain)).Where("collection_address = ? and token_id = ?", collection, "1").
+		Take(&item).Error; err != nil {
+		t.Fatal(err)
+	}
+	if item.Supply != 10 {
+		t.Errorf("expected supply 10, got %d", item.Supply)
+	}
+	var itemHolders []multi.ItemHolder
+	if err := db.Table(multi.ItemHolderTableName(chain)).Order("owner asc").Find(&itemHolders).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(itemHolders) != 2 || itemHolders[0].Balance.String() != "6" || itemHolders[1].Balance.String() != "4" {
+		t.Errorf("unexpected item holders: %+v", itemHolders)
+	}
+}
+
+// newTestDB 内存sqlite数据库，创建测试用到的数据表
+func newTestDB(t *testing.T, chain string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := map[string]interface{}{
+		multi.ItemTableName(chain):       &multi.Item{},
+		multi.ItemHolderTableName(chain): &multi.ItemHolder{},
+		base.IndexedStatusTableName():    &base.IndexedStatus{},
+	}
+	for table, model := range tables {
+		// sqlite不识别MySQL风格的AUTO_INCREMENT标签，建表前将自增主键设为sqlite的自增主键类型
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.ParseWithSpecialTableName(model, table); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range stmt.Schema.PrimaryFields {
+			if field.AutoIncrement {
+				field.DataType = "integer PRIMARY KEY AUTOINCREMENT"
+			}
+		}
+		if err := db.Table(table).AutoMigrate(model); err != nil {
+			t.Fatalf("failed on migrate %s: %v", table, err)
+		}
+	}
+	// 与db/migrations中的唯一索引一致，供upsert使用
+	if err := db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX index_collection_token ON %s (collection_address, token_id)",
+		multi.ItemTableName(chain))).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
@@ -2,9 +2,14 @@ package config
 
 import (
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 
+	"github.com/ProjectsTask/EasySwapBase/chain/chainclient"
+	"github.com/ProjectsTask/EasySwapBase/chain/nftchainservice"
+	"github.com/ProjectsTask/EasySwapBase/currency"
 	logging "github.com/ProjectsTask/EasySwapBase/logger"
 	"github.com/ProjectsTask/EasySwapBase/stores/gdb"
 )
@@ -18,22 +23,50 @@ type Config struct {
 	ChainCfg    ChainCfg         `toml:"chain_cfg" mapstructure:"chain_cfg" json:"chain_cfg"`
 	ContractCfg ContractCfg      `toml:"contract_cfg" mapstructure:"contract_cfg" json:"contract_cfg"`
 	ProjectCfg  ProjectCfg       `toml:"project_cfg" mapstructure:"project_cfg" json:"project_cfg"`
+	Chains      []*ChainSyncCfg  `toml:"chains" mapstructure:"chains" json:"chains"` // 多链配置，为空时使用chain_cfg/ankr_cfg/contract_cfg同步单条链
+
+	MetadataParse      *MetadataParse               `toml:"metadata_parse" mapstructure:"metadata_parse" json:"metadata_parse"`
+	MetadataRefreshCfg MetadataRefreshCfg           `toml:"metadata_refresh_cfg" mapstructure:"metadata_refresh_cfg" json:"metadata_refresh_cfg"`
+	MetadataFetchCfg   MetadataFetchCfg             `toml:"metadata_fetch_cfg" mapstructure:"metadata_fetch_cfg" json:"metadata_fetch_cfg"`
+	ReconcileCfg       ReconcileCfg                 `toml:"reconcile_cfg" mapstructure:"reconcile_cfg" json:"reconcile_cfg"`
+	OrderExpiryCfg     OrderExpiryCfg               `toml:"order_expiry_cfg" mapstructure:"order_expiry_cfg" json:"order_expiry_cfg"`
+	FloorPriceCfg      FloorPriceCfg                `toml:"floor_price_cfg" mapstructure:"floor_price_cfg" json:"floor_price_cfg"`
+	RpcPoolCfg         RpcPoolCfg                   `toml:"rpc_pool_cfg" mapstructure:"rpc_pool_cfg" json:"rpc_pool_cfg"`
+	RpcLimitCfg        chainclient.MiddlewareConfig `toml:"rpc_limit_cfg" mapstructure:"rpc_limit_cfg" json:"rpc_limit_cfg"` // 节点请求限流及重试
+	Currencies         []*currency.Config           `toml:"currencies" mapstructure:"currencies" json:"currencies"`          // ERC20支付币种，成交统计按汇率换算为ETH
 }
 
 type ChainCfg struct {
-	Name string `toml:"name" mapstructure:"name" json:"name"`
-	ID   int64  `toml:"id" mapstructure:"id" json:"id"`
+	Name          string `toml:"name" mapstructure:"name" json:"name"`
+	ID            int64  `toml:"id" mapstructure:"id" json:"id"`
+	Confirmations uint64 `toml:"confirmations" mapstructure:"confirmations" json:"confirmations"` // 确认区块数，为0时使用链的默认值
+}
+
+// ChainSyncCfg 单条链的同步配置，daemon为每条链运行独立的索引及订单管理流程
+type ChainSyncCfg struct {
+	Name          string      `toml:"name" mapstructure:"name" json:"name"`
+	ID            int64       `toml:"id" mapstructure:"id" json:"id"`
+	RpcUrls       []string    `toml:"rpc_urls" mapstructure:"rpc_urls" json:"rpc_urls"`
+	Confirmations uint64      `toml:"confirmations" mapstructure:"confirmations" json:"confirmations"`
+	ContractCfg   ContractCfg `toml:"contract_cfg" mapstructure:"contract_cfg" json:"contract_cfg"`
 }
 
 type ContractCfg struct {
 	EthAddress  string `toml:"eth_address" mapstructure:"eth_address" json:"eth_address"`
 	WethAddress string `toml:"weth_address" mapstructure:"weth_address" json:"weth_address"`
 	DexAddress  string `toml:"dex_address" mapstructure:"dex_address" json:"dex_address"`
+	// CurrencyAddress 订单簿合约的结算币种，合约事件中不包含币种，为空时为原生币(eth_address)
+	CurrencyAddress string `toml:"currency_address" mapstructure:"currency_address" json:"currency_address"`
+	// VaultAddress 订单簿资产托管合约，配置后定期校验订单的链上有效性
+	VaultAddress string `toml:"vault_address" mapstructure:"vault_address" json:"vault_address"`
+	// ValidateInterval 订单有效性校验间隔(秒)，为0时使用默认值
+	ValidateInterval int64 `toml:"validate_interval" mapstructure:"validate_interval" json:"validate_interval"`
 }
 
 type Monitor struct {
 	PprofEnable bool  `toml:"pprof_enable" mapstructure:"pprof_enable" json:"pprof_enable"`
 	PprofPort   int64 `toml:"pprof_port" mapstructure:"pprof_port" json:"pprof_port"`
+	StatusPort  int64 `toml:"status_port" mapstructure:"status_port" json:"status_port"` // /chains/status及/metrics的端口，为0时不开启
 }
 
 type AnkrCfg struct {
@@ -41,12 +74,62 @@ type AnkrCfg struct {
 	HttpsUrl     string `toml:"https_url" mapstructure:"https_url" json:"https_url"`
 	WebsocketUrl string `toml:"websocket_url" mapstructure:"websocket_url" json:"websocket_url"`
 	EnableWss    bool   `toml:"enable_wss" mapstructure:"enable_wss" json:"enable_wss"`
+	// 备用节点的完整地址，配置后按节点健康状态路由请求并在失败时切换
+	BackupUrls []string `toml:"backup_urls" mapstructure:"backup_urls" json:"backup_urls"`
 }
 
 type ProjectCfg struct {
 	Name string `toml:"name" mapstructure:"name" json:"name"`
 }
 
+type MetadataParse struct {
+	NameTags       []string `toml:"name_tags" mapstructure:"name_tags" json:"name_tags"`
+	ImageTags      []string `toml:"image_tags" mapstructure:"image_tags" json:"image_tags"`
+	AttributesTags []string `toml:"attributes_tags" mapstructure:"attributes_tags" json:"attributes_tags"`
+	TraitNameTags  []string `toml:"trait_name_tags" mapstructure:"trait_name_tags" json:"trait_name_tags"`
+	TraitValueTags []string `toml:"trait_value_tags" mapstructure:"trait_value_tags" json:"trait_value_tags"`
+}
+
+type MetadataRefreshCfg struct {
+	Workers             int `toml:"workers" mapstructure:"workers" json:"workers"`                                           // 并发刷新协程数
+	CollectionRateLimit int `toml:"collection_rate_limit" mapstructure:"collection_rate_limit" json:"collection_rate_limit"` // 每个collection每秒最多请求次数
+}
+
+// MetadataFetchCfg 元数据拉取的网关及限制，为空或为0时使用默认值
+type MetadataFetchCfg struct {
+	IpfsGateways    []string `toml:"ipfs_gateways" mapstructure:"ipfs_gateways" json:"ipfs_gateways"`          // IPFS网关，按顺序对冲请求
+	ArweaveGateways []string `toml:"arweave_gateways" mapstructure:"arweave_gateways" json:"arweave_gateways"` // Arweave网关，按顺序对冲请求
+	HedgeDelay      int64    `toml:"hedge_delay" mapstructure:"hedge_delay" json:"hedge_delay"`                // 网关未返回时向下一个网关发起请求的间隔(毫秒)
+	Timeout         int64    `toml:"timeout" mapstructure:"timeout" json:"timeout"`                            // 单个tokenURI的总超时(秒)
+	MaxSize         int64    `toml:"max_size" mapstructure:"max_size" json:"max_size"`                         // 内容的最大字节数
+	CacheSize       uint64   `toml:"cache_size" mapstructure:"cache_size" json:"cache_size"`                   // ipfs/ar内容缓存的最大字节数
+}
+
+// ReconcileCfg 数据库订单簿与链上订单簿的定期对账
+type ReconcileCfg struct {
+	Interval int64 `toml:"interval" mapstructure:"interval" json:"interval"` // 对账间隔(秒)，为0时不启用
+	Repair   bool  `toml:"repair" mapstructure:"repair" json:"repair"`       // 是否以链上状态为准自动修复
+}
+
+// OrderExpiryCfg 订单过期调度，多实例部署时使用redis共享过期任务
+type OrderExpiryCfg struct {
+	Scheduler  string `toml:"scheduler" mapstructure:"scheduler" json:"scheduler"`       // memory或redis，为空时使用memory
+	ClaimLease int64  `toml:"claim_lease" mapstructure:"claim_lease" json:"claim_lease"` // 任务领取后未确认的租约时长(秒)，为0时使用默认值
+}
+
+// FloorPriceCfg 地板价历史数据的保留时长
+type FloorPriceCfg struct {
+	SnapshotRetention int64 `toml:"snapshot_retention" mapstructure:"snapshot_retention" json:"snapshot_retention"` // 定时快照的保留时长(秒)，为0时保留60天
+	ChangeRetention   int64 `toml:"change_retention" mapstructure:"change_retention" json:"change_retention"`       // 地板价变化事件的保留时长(秒)，为0时保留60天
+}
+
+// RpcPoolCfg 多节点连接池配置，只配置一个节点时不生效
+type RpcPoolCfg struct {
+	MaxHeadLag  uint64 `toml:"max_head_lag" mapstructure:"max_head_lag" json:"max_head_lag"` // 查询日志时节点最多落后的区块数，为0时使用默认值
+	HeadRefresh int64  `toml:"head_refresh" mapstructure:"head_refresh" json:"head_refresh"` // 节点区块高度的刷新间隔(秒)，为0时使用默认值
+	Cooldown    int64  `toml:"cooldown" mapstructure:"cooldown" json:"cooldown"`             // 节点连续失败后暂停使用的时长(秒)，为0时使用默认值
+}
+
 type KvConf struct {
 	Redis []*Redis `toml:"redis" json:"redis"`
 }
@@ -63,6 +146,98 @@ type LogLevel struct {
 	Utils    string `toml:"utils" json:"utils"`
 }
 
+// NodeUrl 主节点地址
+func (c *Config) NodeUrl() string {
+	return c.AnkrCfg.HttpsUrl + c.AnkrCfg.ApiKey
+}
+
+// ChainClientOptions 创建链客户端的配置，包含备用节点、连接池及限流重试配置
+func (c *Config) ChainClientOptions() []chainclient.Option {
+	opts := c.RpcLimitCfg.Options()
+	if len(c.AnkrCfg.BackupUrls) == 0 {
+		return opts
+	}
+	return append(opts,
+		chainclient.WithBackupUrls(c.AnkrCfg.BackupUrls...),
+		chainclient.WithPoolConfig(chainclient.PoolConfig{
+			MaxHeadLag:  c.RpcPoolCfg.MaxHeadLag,
+			HeadRefresh: time.Duration(c.RpcPoolCfg.HeadRefresh) * time.Second,
+			Cooldown:    time.Duration(c.RpcPoolCfg.Cooldown) * time.Second,
+		}),
+	)
+}
+
+// FetcherConfig 元数据拉取配置
+func (c *Config) FetcherConfig() nftchainservice.FetcherConfig {
+	return nftchainservice.FetcherConfig{
+		IpfsGateways:    c.MetadataFetchCfg.IpfsGateways,
+		ArweaveGateways: c.MetadataFetchCfg.ArweaveGateways,
+		HedgeDelay:      time.Duration(c.MetadataFetchCfg.HedgeDelay) * time.Millisecond,
+		Timeout:         time.Duration(c.MetadataFetchCfg.Timeout) * time.Second,
+		MaxSize:         c.MetadataFetchCfg.MaxSize,
+		CacheSize:       c.MetadataFetchCfg.CacheSize,
+	}
+}
+
+// ChainConfigs 按链拆分配置，每条链的配置只包含该链的chain_cfg/ankr_cfg/contract_cfg，其余配置共用
+func (c *Config) ChainConfigs() ([]*Config, error) {
+	if len(c.Chains) == 0 {
+		return []*Config{c}, nil
+	}
+
+	var cfgs []*Config
+	names := make(map[string]struct{})
+	for _, chain := range c.Chains {
+		if chain == nil {
+			continue
+		}
+		if chain.Name == "" || chain.ID == 0 {
+			return nil, errors.New("chain name and id are required")
+		}
+		if _, ok := names[chain.Name]; ok {
+			return nil, errors.Errorf("duplicate chain: %s", chain.Name)
+		}
+		if len(chain.RpcUrls) == 0 {
+			return nil, errors.Errorf("no rpc url for chain: %s", chain.Name)
+		}
+		names[chain.Name] = struct{}{}
+
+		chainCfg := *c
+		chainCfg.Chains = nil
+		chainCfg.ChainCfg = ChainCfg{
+			Name:          chain.Name,
+			ID:            chain.ID,
+			Confirmations: chain.Confirmations,
+		}
+		chainCfg.AnkrCfg = AnkrCfg{HttpsUrl: chain.RpcUrls[0], BackupUrls: chain.RpcUrls[1:]} // 第一个节点为主节点，其余为备用节点
+		chainCfg.ContractCfg = chain.ContractCfg
+		cfgs = append(cfgs, &chainCfg)
+	}
+
+	return cfgs, nil
+}
+
+// ChainConfig 返回指定链的配置，name为空且只配置了一条链时返回该链
+func (c *Config) ChainConfig(name string) (*Config, error) {
+	cfgs, err := c.ChainConfigs()
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		if len(cfgs) != 1 {
+			return nil, errors.New("chain name is required when multiple chains are configured")
+		}
+		return cfgs[0], nil
+	}
+	for _, cfg := range cfgs {
+		if cfg.ChainCfg.Name == name {
+			return cfg, nil
+		}
+	}
+
+	return nil, errors.Errorf("chain not configured: %s", name)
+}
+
 // UnmarshalConfig unmarshal conifg file
 // @params path: the path of config dir
 func UnmarshalConfig(configFilePath string) (*Config, error) {
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestChainConfigs(t *testing.T) {
+	cfg := &Config{
+		AnkrCfg:     AnkrCfg{HttpsUrl: "https://rpc.ankr.com/eth_sepolia"},
+		ChainCfg:    ChainCfg{Name: "sepolia", ID: 11155111},
+		ContractCfg: ContractCfg{DexAddress: "0x01"},
+		ProjectCfg:  ProjectCfg{Name: "OrderBookDex"},
+	}
+
+	// 未配置chains时使用单链配置
+	cfgs, err := cfg.ChainConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfgs) != 1 || cfgs[0] != cfg {
+		t.Fatalf("Unexpected chain configs: %+v", cfgs)
+	}
+
+	cfg.Chains = []*ChainSyncCfg{
+		{Name: "sepolia", ID: 11155111, RpcUrls: []string{"https://sepolia"}, Confirmations: 3, ContractCfg: ContractCfg{DexAddress: "0x02"}},
+		{Name: "optimism", ID: 10, RpcUrls: []string{"https://optimism", "https://optimism-backup"}, ContractCfg: ContractCfg{DexAddress: "0x03"}},
+	}
+	cfgs, err = cfg.ChainConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfgs) != 2 {
+		t.Fatalf("Unexpected chain config count: %d", len(cfgs))
+	}
+	if cfgs[0].ChainCfg.Confirmations != 3 || cfgs[0].ContractCfg.DexAddress != "0x02" || cfgs[0].AnkrCfg.HttpsUrl != "https://sepolia" {
+		t.Errorf("Unexpected sepolia config: %+v", cfgs[0])
+	}
+	if cfgs[1].ChainCfg.ID != 10 || cfgs[1].AnkrCfg.HttpsUrl != "https://optimism" || cfgs[1].ProjectCfg.Name != "OrderBookDex" {
+		t.Errorf("Unexpected optimism config: %+v", cfgs[1])
+	}
+	if len(cfgs[0].AnkrCfg.BackupUrls) != 0 || len(cfgs[0].ChainClientOptions()) != 0 {
+		t.Errorf("Unexpected sepolia backup urls: %v", cfgs[0].AnkrCfg.BackupUrls)
+	}
+	if len(cfgs[1].AnkrCfg.BackupUrls) != 1 || cfgs[1].AnkrCfg.BackupUrls[0] != "https://optimism-backup" || len(cfgs[1].ChainClientOptions()) == 0 {
+		t.Errorf("Unexpected optimism backup urls: %v", cfgs[1].AnkrCfg.BackupUrls)
+	}
+	if cfg.ContractCfg.DexAddress != "0x01" {
+		t.Errorf("Original config should not be modified")
+	}
+
+	optimism, err := cfg.ChainConfig("optimism")
+	if err != nil || optimism.ChainCfg.Name != "optimism" {
+		t.Errorf("Unexpected optimism config: %+v, %v", optimism, err)
+	}
+	if _, err := cfg.ChainConfig(""); err == nil {
+		t.Errorf("Expected error when chain name is empty with multiple chains")
+	}
+	if _, err := cfg.ChainConfig("base"); err == nil {
+		t.Errorf("Expected error on unknown chain")
+	}
+
+	cfg.Chains = append(cfg.Chains, &ChainSyncCfg{Name: "optimism", ID: 10, RpcUrls: []string{"https://optimism"}})
+	if _, err := cfg.ChainConfigs(); err == nil {
+		t.Errorf("Expected error on duplicate chain")
+	}
+	cfg.Chains = []*ChainSyncCfg{{Name: "base", ID: 8453}}
+	if _, err := cfg.ChainConfigs(); err == nil {
+		t.Errorf("Expected error on chain without rpc url")
+	}
+}
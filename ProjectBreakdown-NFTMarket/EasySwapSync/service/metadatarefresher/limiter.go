@@ -0,0 +1,61 @@
+package metadatarefresher
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// collectionLimiter 按collection限制请求间隔，多个协程共享
+type collectionLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     map[string]time.Time // collection -> 下一次允许请求的时间
+}
+
+func newCollectionLimiter(interval time.Duration) *collectionLimiter {
+	return &collectionLimiter{
+		interval: interval,
+		next:     make(map[string]time.Time),
+	}
+}
+
+// reserve 预留collection的下一个请求时间片，返回需要等待的时长
+func (l *collectionLimiter) reserve(collection string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// 清理过期的记录，防止map无限增长
+	if len(l.next) > 1024 {
+		for k, t := range l.next {
+			if t.Before(now) {
+				delete(l.next, k)
+			}
+		}
+	}
+
+	slot := l.next[collection]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[collection] = slot.Add(l.interval)
+
+	return slot.Sub(now)
+}
+
+// Wait 阻塞直到collection允许下一次请求或ctx结束
+func (l *collectionLimiter) Wait(ctx context.Context, collection string) error {
+	wait := l.reserve(collection, time.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,43 @@
+package metadatarefresher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCollectionLimiterReserve(t *testing.T) {
+	l := newCollectionLimiter(500 * time.Millisecond)
+	now := time.Now()
+
+	cases := []struct {
+		collection string
+		now        time.Time
+		expected   time.Duration
+	}{
+		{"0xa", now, 0},
+		{"0xa", now, 500 * time.Millisecond},
+		{"0xa", now, time.Second},
+		{"0xb", now, 0},                  // 不同collection互不影响
+		{"0xb", now.Add(time.Second), 0}, // 时间片过期后无需等待
+	}
+	for i, c := range cases {
+		if wait := l.reserve(c.collection, c.now); wait != c.expected {
+			t.Errorf("case %d: expected wait %v, got %v", i, c.expected, wait)
+		}
+	}
+}
+
+func TestCollectionLimiterWaitCanceled(t *testing.T) {
+	l := newCollectionLimiter(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := l.Wait(ctx, "0xa"); err != nil {
+		t.Errorf("Unexpected error on first wait: %v", err)
+	}
+
+	cancel()
+	if err := l.Wait(ctx, "0xa"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Unexpected error after cancel: expected %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,338 @@
+package metadatarefresher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProjectsTask/EasySwapBase/chain/nftchainservice"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/retry"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
+	"github.com/pkg/errors"
+	"github.com/zeromicro/go-zero/core/threading"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ProjectsTask/EasySwapSync/service/config"
+)
+
+// CacheRefreshSingleItemMetadataKey 与EasySwapBackend写入的刷新队列保持一致
+const CacheRefreshSingleItemMetadataKey = "cache:%s:%s:item:refresh:metadata"
+
+// CacheRefreshMetadataRetryKey 刷新失败待重试的item，score为可重试的时间(秒)
+const CacheRefreshMetadataRetryKey = "cache:%s:%s:item:refresh:metadata:retry"
+
+const (
+	defaultWorkers             = 4
+	defaultCollectionRateLimit = 2 // 每个collection每秒请求次数
+	fetchRetryLimit            = 3
+	IdleInterval               = 3 // in seconds
+
+	maxRefreshAttempts  = 5                // 刷新失败后重新入队，超过该次数后放弃
+	refreshRetryBackoff = 30 * time.Second // 第n次失败后等待refreshRetryBackoff*2^(n-1)再重试
+	requeueBatchSize    = 100
+)
+
+// requeueScript 将到期的重试任务移回刷新队列，多个实例同时执行时每个任务只会被移动一次
+const requeueScript = `local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, item in ipairs(items) do
+    redis.call('ZREM', KEYS[1], item)
+    redis.call('SADD', KEYS[2], item)
+end
+return #items`
+
+var fetchRetryWait = []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+
+// RefreshItem 刷新队列中的元素
+type RefreshItem struct {
+	ChainID        int64  `json:"chain_id"`
+	CollectionAddr string `json:"collection_addr"`
+	TokenID        string `json:"token_id"`
+	Attempts       int    `json:"attempts,omitempty"` // 已失败的次数
+}
+
+func GetRefreshSingleItemMetadataKey(project, chain string) string {
+	return fmt.Sprintf(CacheRefreshSingleItemMetadataKey, strings.ToLower(project), strings.ToLower(chain))
+}
+
+func GetRefreshMetadataRetryKey(project, chain string) string {
+	return fmt.Sprintf(CacheRefreshMetadataRetryKey, strings.ToLower(project), strings.ToLower(chain))
+}
+
+// Service 消费item元数据刷新队列，重新拉取链上元数据并更新item/item_external/item_trait
+type Service struct {
+	ctx     context.Context
+	db      *gorm.DB
+	kv      *xkv.Store
+	nodeSrv *nftchainservice.Service
+	chain   string
+	chainId int64
+	project string
+	workers int
+	limiter *collectionLimiter
+}
+
+func New(ctx context.Context, db *gorm.DB, kv *xkv.Store, nodeSrv *nftchainservice.Service, chain string, chainId int64,
+	project string, cfg config.MetadataRefreshCfg) *Service {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	rateLimit := cfg.CollectionRateLimit
+	if rateLimit <= 0 {
+		rateLimit = defaultCollectionRateLimit
+	}
+
+	return &Service{
+		ctx:     ctx,
+		db:      db,
+		kv:      kv,
+		nodeSrv: nodeSrv,
+		chain:   chain,
+		chainId: chainId,
+		project: project,
+		workers: workers,
+		limiter: newCollectionLimiter(time.Second / time.Duration(rateLimit)),
+	}
+}
+
+func (s *Service) Start() {
+	for i := 0; i < s.workers; i++ {
+		threading.GoSafe(s.RefreshMetadataLoop)
+	}
+	threading.GoSafe(s.RequeueRetryLoop)
+}
+
+// RequeueRetryLoop 定期将到期的重试任务移回刷新队列
+func (s *Service) RequeueRetryLoop() {
+	ticker := time.NewTicker(IdleInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			xzap.WithContext(s.ctx).Info("RequeueRetryLoop stopped due to context cancellation")
+			return
+		case <-ticker.C:
+			if _, err := s.requeueDue(time.Now()); err != nil {
+				xzap.WithContext(s.ctx).Error("failed on requeue refresh metadata retries", zap.Error(err))
+			}
+		}
+	}
+}
+
+// requeueDue 将now之前到期的重试任务移回刷新队列，返回移动的数量
+func (s *Service) requeueDue(now time.Time) (int64, error) {
+	resp, err := s.kv.Redis.Eval(requeueScript, []string{
+		GetRefreshMetadataRetryKey(s.project, s.chain),
+		GetRefreshSingleItemMetadataKey(s.project, s.chain),
+	}, now.Unix(), requeueBatchSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed on move due retries")
+	}
+	moved, _ := resp.(int64)
+	return moved, nil
+}
+
+// scheduleRetry 刷新失败的item按指数退避重新入队，超过maxRefreshAttempts次后放弃，返回是否已重新入队
+func (s *Service) scheduleRetry(item RefreshItem, now time.Time) (bool, error) {
+	item.Attempts++
+	if item.Attempts >= maxRefreshAttempts {
+		return false, nil
+	}
+
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return false, errors.Wrap(err, "failed on marshal refresh item")
+	}
+	retryAt := now.Add(refreshRetryBackoff << (item.Attempts - 1))
+	if _, err := s.kv.Redis.Zadd(GetRefreshMetadataRetryKey(s.project, s.chain), retryAt.Unix(), string(raw)); err != nil {
+		return false, errors.Wrap(err, "failed on add refresh retry")
+	}
+	return true, nil
+}
+
+// sleep 等待d或ctx取消，ctx取消时返回false
+func (s *Service) sleep(d time.Duration) bool {
+	select {
+	case <-s.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func (s *Service) RefreshMetadataLoop() {
+	key := GetRefreshSingleItemMetadataKey(s.project, s.chain)
+	for {
+		select {
+		case <-s.ctx.Done():
+			xzap.WithContext(s.ctx).Info("RefreshMetadataLoop stopped due to context cancellation")
+			return
+		default:
+		}
+
+		raw, err := s.kv.Spop(key)
+		if err != nil {
+			xzap.WithContext(s.ctx).Error("failed on pop item from refresh metadata queue", zap.Error(err))
+			if !s.sleep(IdleInterval * time.Second) {
+				xzap.WithContext(s.ctx).Info("RefreshMetadataLoop stopped due to context cancellation")
+				return
+			}
+			continue
+		}
+		if raw == "" { // 队列为空
+			if !s.sleep(IdleInterval * time.Second) {
+				xzap.WithContext(s.ctx).Info("RefreshMetadataLoop stopped due to context cancellation")
+				return
+			}
+			continue
+		}
+
+		var item RefreshItem
+		if err := json.Unmarshal([]byte(raw), &item); err != nil {
+			xzap.WithContext(s.ctx).Error("failed on unmarshal refresh item", zap.String("item", raw), zap.Error(err))
+			continue
+		}
+		if item.ChainID != s.chainId {
+			xzap.WithContext(s.ctx).Warn("refresh item chain mismatch", zap.String("item", raw))
+			continue
+		}
+
+		if err := s.RefreshItemMetadata(item.CollectionAddr, item.TokenID); err != nil {
+			requeued, requeueErr := s.scheduleRetry(item, time.Now())
+			if requeueErr != nil {
+				xzap.WithContext(s.ctx).Error("failed on requeue refresh item", zap.String("item", raw), zap.Error(requeueErr))
+			}
+			xzap.WithContext(s.ctx).Error("failed on refresh item metadata",
+				zap.String("collection_addr", item.CollectionAddr), zap.String("token_id", item.TokenID),
+				zap.Int("attempts", item.Attempts+1), zap.Bool("requeued", requeued), zap.Error(err))
+		}
+	}
+}
+
+// RefreshItemMetadata 拉取单个item的链上元数据并更新item/item_external/item_trait
+func (s *Service) RefreshItemMetadata(collectionAddr, tokenID string) error {
+	return s.refreshItemMetadata(collectionAddr, tokenID, func() (*nftchainservice.JsonMetadata, error) {
+		return s.nodeSrv.FetchOnChainMetadata(collectionAddr, tokenID)
+	})
+}
+
+// RefreshItemsMetadata 刷新同一collection下多个item的元数据，tokenURI通过multicall批量查询，返回刷新失败的token及原因
+func (s *Service) RefreshItemsMetadata(collectionAddr string, tokenIDs []string) map[string]error {
+	failed := make(map[string]error)
+	uris, errs, err := s.nodeSrv.FetchTokenURIs(collectionAddr, tokenIDs)
+	for i, tokenID := range tokenIDs {
+		var refreshErr error
+		switch {
+		case err != nil: // 批量查询失败时逐个查询
+			refreshErr = s.RefreshItemMetadata(collectionAddr, tokenID)
+		case errs[i] != nil: // tokenURI调用revert，重试也会失败
+			refreshErr = errors.Wrap(errs[i], "failed on fetch token uri")
+			if err := s.updateExternalStatus(strings.ToLower(collectionAddr), tokenID, multi.FetchMetadataFailed); err != nil {
+				xzap.WithContext(s.ctx).Error("failed on mark item fetch metadata failed", zap.Error(err))
+			}
+		default:
+			tokenUri := uris[i]
+			refreshErr = s.refreshItemMetadata(collectionAddr, tokenID, func() (*nftchainservice.JsonMetadata, error) {
+				return s.nodeSrv.FetchMetadataByTokenURI(tokenUri)
+			})
+		}
+		if refreshErr != nil {
+			failed[tokenID] = refreshErr
+		}
+	}
+
+	return failed
+}
+
+func (s *Service) refreshItemMetadata(collectionAddr, tokenID string, fetch func() (*nftchainservice.JsonMetadata, error)) error {
+	collectionAddr = strings.ToLower(collectionAddr)
+
+	if err := s.updateExternalStatus(collectionAddr, tokenID, multi.WaitingRefresh); err != nil {
+		return errors.Wrap(err, "failed on mark item waiting refresh")
+	}
+
+	var metadata *nftchainservice.JsonMetadata
+	err := retry.Retry(func(attempt uint) error {
+		if err := s.limiter.Wait(s.ctx, collectionAddr); err != nil { // 按collection限速，避免打爆项目方的元数据服务
+			return err
+		}
+
+		var err error
+		metadata, err = fetch()
+		return err
+	}, retry.Limit(fetchRetryLimit), retry.Wait(fetchRetryWait...))
+	if err != nil {
+		if err := s.updateExternalStatus(collectionAddr, tokenID, multi.FetchMetadataFailed); err != nil {
+			xzap.WithContext(s.ctx).Error("failed on mark item fetch metadata failed", zap.Error(err))
+		}
+		return errors.Wrap(err, "failed on fetch metadata")
+	}
+
+	return s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		if metadata.Name != "" {
+			if err := tx.Table(multi.ItemTableName(s.chain)).
+				Where("collection_address = ? and token_id = ?", collectionAddr, tokenID).
+				Update("name", metadata.Name).Error; err != nil {
+				return errors.Wrap(err, "failed on update item name")
+			}
+		}
+
+		// 图片地址变化后需重新上传oss
+		external := multi.ItemExternal{
+			CollectionAddress: collectionAddr,
+			TokenId:           tokenID,
+			ImageUri:          metadata.Image,
+			UploadStatus:      multi.OK,
+		}
+		if err := tx.Table(multi.ItemExternalTableName(s.chain)).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "collection_address"}, {Name: "token_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"image_uri": metadata.Image, "upload_status": multi.OK, "is_uploaded_oss": false}),
+		}).Create(&external).Error; err != nil {
+			return errors.Wrap(err, "failed on update item external")
+		}
+
+		if err := tx.Table(multi.ItemTraitTableName(s.chain)).
+			Where("collection_address = ? and token_id = ?", collectionAddr, tokenID).
+			Delete(&multi.ItemTrait{}).Error; err != nil {
+			return errors.Wrap(err, "failed on delete item traits")
+		}
+
+		var traits []multi.ItemTrait
+		for _, attribute := range metadata.Attributes {
+			if attribute == nil || attribute.TraitType == "" {
+				continue
+			}
+			traits = append(traits, multi.ItemTrait{
+				CollectionAddress: collectionAddr,
+				TokenId:           tokenID,
+				Trait:             attribute.TraitType,
+				TraitValue:        attribute.Value,
+			})
+		}
+		if len(traits) > 0 {
+			if err := tx.Table(multi.ItemTraitTableName(s.chain)).Create(&traits).Error; err != nil {
+				return errors.Wrap(err, "failed on create item traits")
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *Service) updateExternalStatus(collectionAddr, tokenID string, status int32) error {
+	external := multi.ItemExternal{
+		CollectionAddress: collectionAddr,
+		TokenId:           tokenID,
+		UploadStatus:      status,
+	}
+	return s.db.WithContext(s.ctx).Table(multi.ItemExternalTableName(s.chain)).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "collection_address"}, {Name: "token_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"upload_status": status}),
+	}).Create(&external).Error
+}
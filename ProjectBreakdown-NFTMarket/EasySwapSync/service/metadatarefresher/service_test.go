@@ -0,0 +1,67 @@
+package metadatarefresher
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+
+	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
+)
+
+func TestScheduleRetry(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := &Service{
+		kv:      &xkv.Store{Redis: redis.New(mr.Addr())},
+		chain:   "sepolia",
+		project: "OrderBookDex",
+	}
+	queueKey := GetRefreshSingleItemMetadataKey(s.project, s.chain)
+	retryKey := GetRefreshMetadataRetryKey(s.project, s.chain)
+	now := time.Unix(1700000000, 0)
+
+	item := RefreshItem{ChainID: 11155111, CollectionAddr: "0xa", TokenID: "1"}
+	requeued, err := s.scheduleRetry(item, now)
+	if err != nil || !requeued {
+		t.Fatalf("Expected item to be requeued, requeued: %v, err: %v", requeued, err)
+	}
+
+	// 未到重试时间
+	if moved, err := s.requeueDue(now.Add(refreshRetryBackoff - time.Second)); err != nil || moved != 0 {
+		t.Fatalf("Expected no item moved before backoff, moved: %d, err: %v", moved, err)
+	}
+	if moved, err := s.requeueDue(now.Add(refreshRetryBackoff)); err != nil || moved != 1 {
+		t.Fatalf("Expected 1 item moved after backoff, moved: %d, err: %v", moved, err)
+	}
+	if members, _ := mr.ZMembers(retryKey); len(members) != 0 {
+		t.Errorf("Expected retry set to be empty, got %v", members)
+	}
+	members, err := mr.Members(queueKey)
+	if err != nil || len(members) != 1 {
+		t.Fatalf("Expected 1 item in refresh queue, got %v, err: %v", members, err)
+	}
+	var queued RefreshItem
+	if err := json.Unmarshal([]byte(members[0]), &queued); err != nil {
+		t.Fatalf("Failed on unmarshal queued item: %v", err)
+	}
+	if queued.Attempts != 1 || queued.TokenID != "1" {
+		t.Errorf("Unexpected queued item: %+v", queued)
+	}
+
+	// 退避时间按失败次数翻倍
+	queued.Attempts = 2
+	if _, err := s.scheduleRetry(queued, now); err != nil {
+		t.Fatalf("Failed on schedule retry: %v", err)
+	}
+	if moved, _ := s.requeueDue(now.Add(3*refreshRetryBackoff + time.Second)); moved != 0 {
+		t.Errorf("Expected third retry to wait %v", 4*refreshRetryBackoff)
+	}
+
+	// 超过最大次数后放弃
+	queued.Attempts = maxRefreshAttempts - 1
+	if requeued, err := s.scheduleRetry(queued, now); err != nil || requeued {
+		t.Errorf("Expected item to be dropped after %d attempts, requeued: %v, err: %v", maxRefreshAttempts, requeued, err)
+	}
+}
@@ -0,0 +1,134 @@
+package orderbookindexer
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ethereum/go-ethereum/common"
+	ethereumTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 处理批量成交中被跳过的订单(订单已失效或已成交)
+func (s *Service) handleSkipOrderEvent(tx *gorm.DB, log ethereumTypes.Log) error {
+	var event struct {
+		OrderKey [32]byte
+		Salt     uint64
+	}
+	if err := s.parsedAbi.UnpackIntoInterface(&event, "LogSkipOrder", log.Data); err != nil {
+		xzap.WithContext(s.ctx).Error("Error unpacking LogSkipOrder event:", zap.Error(err))
+		return nil
+	}
+
+	return s.saveContractEvent(tx, log, &multi.ContractEvent{
+		EventType: multi.ContractEventSkipOrder,
+		OrderId:   HexPrefix + hex.EncodeToString(event.OrderKey[:]),
+	})
+}
+
+// 处理合约提现事件
+func (s *Service) handleWithdrawETHEvent(tx *gorm.DB, log ethereumTypes.Log) error {
+	var event struct {
+		Recipient common.Address
+		Amount    *big.Int
+	}
+	if err := s.parsedAbi.UnpackIntoInterface(&event, "LogWithdrawETH", log.Data); err != nil {
+		xzap.WithContext(s.ctx).Error("Error unpacking LogWithdrawETH event:", zap.Error(err))
+		return nil
+	}
+
+	return s.saveContractEvent(tx, log, &multi.ContractEvent{
+		EventType: multi.ContractEventWithdrawETH,
+		Address:   strings.ToLower(event.Recipient.String()),
+		Amount:    decimal.NewFromBigInt(event.Amount, 0),
+	})
+}
+
+// 处理协议分成变更事件，按区块保存历史分成比例用于手续费对账
+func (s *Service) handleUpdatedProtocolShareEvent(tx *gorm.DB, log ethereumTypes.Log) error {
+	if len(log.Topics) < 2 {
+		xzap.WithContext(s.ctx).Error("invalid LogUpdatedProtocolShare event", zap.String("tx_hash", log.TxHash.String()))
+		return nil
+	}
+	newProtocolShare := new(big.Int).SetBytes(log.Topics[1].Bytes()) // newProtocolShare为indexed字段
+
+	return s.saveContractEvent(tx, log, &multi.ContractEvent{
+		EventType: multi.ContractEventUpdatedProtocolShare,
+		Amount:    decimal.NewFromBigInt(newProtocolShare, 0),
+	})
+}
+
+// 处理批量成交中单笔成交失败的事件
+func (s *Service) handleBatchMatchInnerErrorEvent(tx *gorm.DB, log ethereumTypes.Log) error {
+	var event struct {
+		Offset *big.Int
+		Msg    []byte
+	}
+	if err := s.parsedAbi.UnpackIntoInterface(&event, "BatchMatchInnerError", log.Data); err != nil {
+		xzap.WithContext(s.ctx).Error("Error unpacking BatchMatchInnerError event:", zap.Error(err))
+		return nil
+	}
+
+	xzap.WithContext(s.ctx).Warn("batch match inner error",
+		zap.String("tx_hash", log.TxHash.String()),
+		zap.String("offset", event.Offset.String()),
+		zap.String("msg", HexPrefix+hex.EncodeToString(event.Msg)))
+
+	return s.saveContractEvent(tx, log, &multi.ContractEvent{
+		EventType: multi.ContractEventBatchMatchInnerError,
+		Amount:    decimal.NewFromBigInt(event.Offset, 0),
+		Data:      HexPrefix + hex.EncodeToString(event.Msg),
+	})
+}
+
+// 处理合约暂停/恢复事件，合约暂停期间订单无法成交，由API根据最新的暂停状态标记订单不可成交
+func (s *Service) handlePauseEvent(tx *gorm.DB, log ethereumTypes.Log, eventType int) error {
+	var event struct {
+		Account common.Address
+	}
+	eventName := "Paused"
+	if eventType == multi.ContractEventUnpaused {
+		eventName = "Unpaused"
+	}
+	if err := s.parsedAbi.UnpackIntoInterface(&event, eventName, log.Data); err != nil {
+		xzap.WithContext(s.ctx).Error("Error unpacking pause event:", zap.String("event", eventName), zap.Error(err))
+		return nil
+	}
+
+	xzap.WithContext(s.ctx).Warn("orderbook contract pause status changed",
+		zap.String("event", eventName),
+		zap.String("account", event.Account.String()),
+		zap.Uint64("block_number", log.BlockNumber))
+
+	return s.saveContractEvent(tx, log, &multi.ContractEvent{
+		EventType: eventType,
+		Address:   strings.ToLower(event.Account.String()),
+	})
+}
+
+// saveContractEvent 补全事件的区块信息后写入ob_contract_event，(tx_hash, log_index)唯一，重复处理时忽略
+func (s *Service) saveContractEvent(tx *gorm.DB, log ethereumTypes.Log, event *multi.ContractEvent) error {
+	blockTime, err := s.blockTime(tx, log.BlockNumber)
+	if err != nil {
+		return errors.Wrap(err, "failed to get block time")
+	}
+
+	event.BlockNumber = int64(log.BlockNumber)
+	event.TxHash = log.TxHash.String()
+	event.LogIndex = int64(log.Index)
+	event.EventTime = int64(blockTime)
+	if err := tx.Table(multi.ContractEventTableName(s.chain)).Clauses(clause.OnConflict{
+		DoNothing: true,
+	}).Create(event).Error; err != nil {
+		return errors.Wrap(err, "failed on create contract event")
+	}
+
+	return nil
+}
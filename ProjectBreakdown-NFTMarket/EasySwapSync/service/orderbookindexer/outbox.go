@@ -0,0 +1,132 @@
+package orderbookindexer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/ordermanager"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	OutboxOrderQueue  = 1 // 订单加入order manager队列
+	OutboxPriceUpdate = 2 // 地板价更新事件
+
+	outboxBatchSize = 200
+)
+
+// OutboxEvent 与订单状态在同一事务中写入的副作用事件，事务提交后再投递到redis队列
+type OutboxEvent struct {
+	Id         int64  `gorm:"column:id;AUTO_INCREMENT;primary_key" json:"id"`
+	EventType  int    `gorm:"column:event_type;NOT NULL" json:"event_type"`
+	Payload    string `gorm:"column:payload;NOT NULL" json:"payload"`
+	CreateTime int64  `json:"create_time" gorm:"column:create_time;type:bigint(20);autoCreateTime:milli;comment:创建时间"`
+	UpdateTime int64  `json:"update_time" gorm:"column:update_time;type:bigint(20);autoUpdateTime:milli;comment:更新时间"`
+}
+
+func OutboxTableName(chainName string) string {
+	return fmt.Sprintf("ob_indexer_outbox_%s", chainName)
+}
+
+// enqueueOrder 在事务内记录需要加入order manager队列的订单
+func (s *Service) enqueueOrder(tx *gorm.DB, order *multi.Order) error {
+	return s.enqueueOutbox(tx, OutboxOrderQueue, order)
+}
+
+// enqueuePriceEvent 在事务内记录地板价更新事件
+func (s *Service) enqueuePriceEvent(tx *gorm.DB, event *ordermanager.TradeEvent) error {
+	return s.enqueueOutbox(tx, OutboxPriceUpdate, event)
+}
+
+// replayKey 重放事务的ctx标记
+type replayKey struct{}
+
+// replaying 是否在重放归档日志。重放的是历史事件，范围内或之后已成交、撤销的订单不能再次投递到队列，
+// 重放结束后由ReprocessBlockRange为涉及的collection统一写入重新加载事件
+func replaying(tx *gorm.DB) bool {
+	replay, _ := tx.Statement.Context.Value(replayKey{}).(bool)
+	return replay
+}
+
+func (s *Service) enqueueOutbox(tx *gorm.DB, eventType int, payload interface{}) error {
+	if replaying(tx) {
+		return nil
+	}
+
+	return s.writeOutbox(tx, eventType, payload)
+}
+
+func (s *Service) writeOutbox(tx *gorm.DB, eventType int, payload interface{}) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed on marshal outbox payload")
+	}
+
+	if err := tx.Table(OutboxTableName(s.chain)).Create(&OutboxEvent{
+		EventType: eventType,
+		Payload:   string(raw),
+	}).Error; err != nil {
+		return errors.Wrap(err, "failed on create outbox event")
+	}
+
+	return nil
+}
+
+// flushOutbox 按写入顺序投递已提交的副作用事件，投递成功后删除。
+// 遇到投递失败时停止，剩余事件在下一次flush时重试，保证事件顺序。
+func (s *Service) flushOutbox() error {
+	// 挂单事件与转移事件在不同协程中同步，避免重复投递
+	s.outboxMux.Lock()
+	defer s.outboxMux.Unlock()
+
+	for {
+		var events []OutboxEvent
+		if err := s.db.WithContext(s.writeCtx).Table(OutboxTableName(s.chain)).
+			Order("id asc").Limit(outboxBatchSize).
+			Find(&events).Error; err != nil {
+			return errors.Wrap(err, "failed on get outbox events")
+		}
+
+		for _, event := range events {
+			if err := s.publishOutboxEvent(&event); err != nil {
+				return errors.Wrapf(err, "failed on publish outbox event %d", event.Id)
+			}
+
+			if err := s.db.WithContext(s.writeCtx).Table(OutboxTableName(s.chain)).
+				Where("id = ?", event.Id).
+				Delete(&OutboxEvent{}).Error; err != nil {
+				return errors.Wrap(err, "failed on delete outbox event")
+			}
+		}
+
+		if len(events) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+func (s *Service) publishOutboxEvent(event *OutboxEvent) error {
+	switch event.EventType {
+	case OutboxOrderQueue:
+		var order multi.Order
+		if err := json.Unmarshal([]byte(event.Payload), &order); err != nil {
+			xzap.WithContext(s.ctx).Error("drop invalid outbox order", zap.Int64("id", event.Id), zap.Error(err))
+			return nil
+		}
+		return s.orderManager.AddToOrderManagerQueue(&order)
+	case OutboxPriceUpdate:
+		var tradeEvent ordermanager.TradeEvent
+		if err := json.Unmarshal([]byte(event.Payload), &tradeEvent); err != nil {
+			xzap.WithContext(s.ctx).Error("drop invalid outbox trade event", zap.Int64("id", event.Id), zap.Error(err))
+			return nil
+		}
+		return ordermanager.AddUpdatePriceEvent(s.kv, &tradeEvent, s.chain)
+	default:
+		xzap.WithContext(s.ctx).Error("drop unsupported outbox event", zap.Int64("id", event.Id), zap.Int("event_type", event.EventType))
+		return nil
+	}
+}
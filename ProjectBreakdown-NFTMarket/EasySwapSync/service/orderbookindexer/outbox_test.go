@@ -0,0 +1,194 @@
+package orderbookindexer
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/ordermanager"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/base"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethereumTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"github.com/zeromicro/go-zero/core/stores/cache"
+	kvstore "github.com/zeromicro/go-zero/core/stores/kv"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/ProjectsTask/EasySwapSync/service/config"
+)
+
+func TestApplyBlockRangeRollback(t *testing.T) {
+	parsedAbi, err := abi.JSON(strings.NewReader(contractAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := "optimism"
+	db := newTestDB(t, chain)
+	ctx := xzap.ToContext(context.Background(), zap.NewNop())
+	s := &Service{
+		ctx:       ctx,
+		writeCtx:  ctx,
+		cfg:       &config.Config{ContractCfg: config.ContractCfg{EthAddress: ZeroAddress}},
+		db:        db,
+		chain:     chain,
+		chainId:   10,
+		parsedAbi: parsedAbi,
+	}
+	if err := s.registerEventHandlers(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Table(base.IndexedStatusTableName()).Create(&base.IndexedStatus{
+		ChainId: 10, IndexType: EventIndexType, LastIndexedBlock: 10,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := parsedAbi.Events["LogMake"].Inputs.NonIndexed().Pack([32]byte{31: 1},
+		fakeAsset{big.NewInt(1), common.HexToAddress("0x3"), big.NewInt(1)}, big.NewInt(100), uint64(1), uint64(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeLog := ethereumTypes.Log{
+		Topics: []common.Hash{parsedAbi.Events["LogMake"].ID, common.HexToHash("0x0"), common.HexToHash("0x1"),
+			common.HexToHash("0x2")},
+		Data:        data,
+		BlockNumber: 10,
+		TxHash:      common.HexToHash("0x4"),
+	}
+	cancelLog := ethereumTypes.Log{
+		Topics:      []common.Hash{parsedAbi.Events["LogCancel"].ID, common.HexToHash("0x1"), common.HexToHash("0x2")},
+		BlockNumber: 11,
+		TxHash:      common.HexToHash("0x5"),
+		Index:       1,
+	}
+	logs := []interface{}{makeLog, cancelLog}
+	blockTimes := map[uint64]uint64{10: 1700000000, 11: 1700000012}
+
+	// 后一个事件处理失败时，前面写入的订单、活动、outbox事件、归档日志及同步高度一起回滚
+	s.registry.handlers[parsedAbi.Events["LogCancel"].ID] = func(tx *gorm.DB, log ethereumTypes.Log) error {
+		return errors.New("handler failed")
+	}
+	if err := s.applyBlockRange(logs, blockTimes, 12, nil); err == nil {
+		t.Fatal("expected handler error")
+	}
+	for _, table := range []string{multi.OrderTableName(chain), multi.ActivityTableName(chain),
+		multi.RawLogTableName(chain), OutboxTableName(chain)} {
+		var count int64
+		if err := db.Table(table).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("expected %s rolled back, got %d rows", table, count)
+		}
+	}
+	var status base.IndexedStatus
+	if err := db.Table(base.IndexedStatusTableName()).Take(&status).Error; err != nil {
+		t.Fatal(err)
+	}
+	if status.LastIndexedBlock != 10 {
+		t.Errorf("expected last indexed block 10, got %d", status.LastIndexedBlock)
+	}
+
+	// 恢复处理函数后重试，所有数据一起提交
+	s.registry.handlers[parsedAbi.Events["LogCancel"].ID] = s.handleCancelEvent
+	if err := s.applyBlockRange(logs, blockTimes, 12, nil); err != nil {
+		t.Fatalf("failed on apply block range: %v", err)
+	}
+	var outboxCount int64
+	if err := db.Table(OutboxTableName(chain)).Count(&outboxCount).Error; err != nil {
+		t.Fatal(err)
+	}
+	if outboxCount == 0 {
+		t.Error("expected outbox events committed")
+	}
+	if err := db.Table(base.IndexedStatusTableName()).Take(&status).Error; err != nil {
+		t.Fatal(err)
+	}
+	if status.LastIndexedBlock != 12 {
+		t.Errorf("expected last indexed block 12, got %d", status.LastIndexedBlock)
+	}
+}
+
+func TestFlushOutbox(t *testing.T) {
+	chain := "optimism"
+	db := newTestDB(t, chain)
+	mr := miniredis.RunT(t)
+	kv := &xkv.Store{
+		Store: kvstore.NewStore(kvstore.KvConf{cache.NodeConf{RedisConf: redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType}, Weight: 100}}),
+		Redis: redis.MustNewRedis(redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType}),
+	}
+	ctx := xzap.ToContext(context.Background(), zap.NewNop())
+	s := &Service{
+		ctx:          ctx,
+		writeCtx:     ctx,
+		db:           db,
+		kv:           kv,
+		chain:        chain,
+		orderManager: ordermanager.New(ctx, db, kv, chain, "OrderBookDex"),
+	}
+
+	reload := func(collection string) interface{} {
+		return &ordermanager.TradeEvent{EventType: ordermanager.UpdateCollection, CollectionAddr: collection}
+	}
+	for _, event := range []struct {
+		eventType int
+		payload   interface{}
+	}{
+		{OutboxPriceUpdate, reload("0x1")},
+		{OutboxOrderQueue, &multi.Order{OrderID: "0x2", CollectionAddress: "0x1", TokenId: "1"}},
+		{OutboxOrderQueue, &multi.Order{OrderID: "0x3", CollectionAddress: "0x1"}}, // 缺少token id，投递失败
+		{OutboxPriceUpdate, reload("0x4")},
+	} {
+		if err := s.writeOutbox(db, event.eventType, event.payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 投递失败时停止，只删除已投递的事件，失败及之后的事件保留等待重试
+	if err := s.flushOutbox(); err == nil {
+		t.Fatal("expected publish error")
+	}
+	var events []OutboxEvent
+	if err := db.Table(OutboxTableName(chain)).Order("id asc").Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Id != 3 || events[1].Id != 4 {
+		t.Fatalf("unexpected remaining outbox events: %+v", events)
+	}
+	if orders, err := mr.List(ordermanager.GenOrdersCacheKey(chain)); err != nil || len(orders) != 1 {
+		t.Errorf("expected 1 queued order, got %v, err: %v", orders, err)
+	}
+
+	// 修复失败的事件后重新flush，剩余事件按顺序投递并删除
+	var order multi.Order
+	if err := json.Unmarshal([]byte(events[0].Payload), &order); err != nil {
+		t.Fatal(err)
+	}
+	order.TokenId = "2"
+	raw, _ := json.Marshal(&order)
+	if err := db.Table(OutboxTableName(chain)).Where("id = ?", events[0].Id).Update("payload", string(raw)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.flushOutbox(); err != nil {
+		t.Fatalf("failed on flush outbox: %v", err)
+	}
+	var count int64
+	if err := db.Table(OutboxTableName(chain)).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected empty outbox, got %d events", count)
+	}
+	if orders, err := mr.List(ordermanager.GenOrdersCacheKey(chain)); err != nil || len(orders) != 2 {
+		t.Errorf("expected 2 queued orders, got %v, err: %v", orders, err)
+	}
+}
@@ -0,0 +1,425 @@
+package orderbookindexer
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ProjectsTask/EasySwapBase/currency"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/ordermanager"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ethereum/go-ethereum/common"
+	ethereumTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ProjectsTask/EasySwapSync/service/comm"
+)
+
+// 重放时会重建的活动类型，Transfer/Mint由transfer索引写入，不在重建范围内
+var reprocessActivityTypes = []int{
+	multi.Listing,
+	multi.CollectionBid,
+	multi.ItemBid,
+	multi.CancelListing,
+	multi.CancelCollectionBid,
+	multi.CancelItemBid,
+	multi.Sale,
+}
+
+// logBlockTimes 在事务外批量查询日志所在区块的时间
+func (s *Service) logBlockTimes(logs []interface{}) (map[uint64]uint64, error) {
+	blockTimes := make(map[uint64]uint64)
+	var blockNums []*big.Int
+	for _, log := range logs {
+		blockNumber := log.(ethereumTypes.Log).BlockNumber
+		if _, ok := blockTimes[blockNumber]; ok {
+			continue
+		}
+		blockTimes[blockNumber] = 0
+		blockNums = append(blockNums, new(big.Int).SetUint64(blockNumber))
+	}
+	if len(blockNums) == 0 {
+		return blockTimes, nil
+	}
+
+	times, err := s.chainClient.BlockTimesByNumber(s.ctx, blockNums)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get block times")
+	}
+	for i, blockNum := range blockNums {
+		blockTimes[blockNum.Uint64()] = times[i]
+	}
+
+	return blockTimes, nil
+}
+
+// archiveLogs 将区块范围内拉取到的日志写入ob_raw_log，同时记录区块时间，使重放无需再请求节点。
+// blockTimes为事务外查询到的区块时间
+func (s *Service) archiveLogs(tx *gorm.DB, logs []interface{}, blockTimes map[uint64]uint64) error {
+	var rawLogs []multi.RawLog
+	for _, log := range logs {
+		ethLog := log.(ethereumTypes.Log)
+		blockTime, ok := blockTimes[ethLog.BlockNumber]
+		if !ok {
+			return errors.Errorf("missing block time of block %d", ethLog.BlockNumber)
+		}
+		rawLogs = append(rawLogs, toRawLog(ethLog, blockTime))
+	}
+
+	for start := 0; start < len(rawLogs); start += comm.DBBatchSizeLimit {
+		end := start + comm.DBBatchSizeLimit
+		if end > len(rawLogs) {
+			end = len(rawLogs)
+		}
+		if err := tx.Table(multi.RawLogTableName(s.chain)).Clauses(clause.OnConflict{
+			DoNothing: true,
+		}).Create(rawLogs[start:end]).Error; err != nil {
+			return errors.Wrap(err, "failed on archive raw logs")
+		}
+	}
+
+	return nil
+}
+
+// blockTime 优先使用归档日志中记录的区块时间，未归档时请求节点
+func (s *Service) blockTime(tx *gorm.DB, blockNumber uint64) (uint64, error) {
+	var rawLog multi.RawLog
+	err := tx.Table(multi.RawLogTableName(s.chain)).
+		Select("block_time").
+		Where("block_number = ?", blockNumber).
+		Take(&rawLog).Error
+	if err == nil {
+		return uint64(rawLog.BlockTime), nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, errors.Wrap(err, "failed on get archived block time")
+	}
+	if s.chainClient == nil {
+		return 0, errors.Errorf("block %d not archived", blockNumber)
+	}
+
+	return s.chainClient.BlockTimeByNumber(s.ctx, new(big.Int).SetUint64(blockNumber))
+}
+
+// ReprocessBlockRange 只使用ob_raw_log中归档的日志重建[fromBlock, toBlock]内的订单及活动数据，不访问节点。
+// 范围内创建的订单及产生的活动先删除再按日志顺序重放；范围之前创建、在范围内被撮合或撤销的订单先恢复到
+// fromBlock时的剩余数量及状态，重复执行结果一致
+func (s *Service) ReprocessBlockRange(fromBlock, toBlock uint64) error {
+	var rawLogs []multi.RawLog
+	if err := s.db.WithContext(s.ctx).Table(multi.RawLogTableName(s.chain)).
+		Where("block_number >= ? and block_number <= ?", fromBlock, toBlock).
+		Order("block_number asc, log_index asc").
+		Find(&rawLogs).Error; err != nil {
+		return errors.Wrap(err, "failed on get raw logs")
+	}
+	if len(rawLogs) == 0 {
+		return errors.Errorf("no archived logs between block %d and %d", fromBlock, toBlock)
+	}
+
+	logs := make([]ethereumTypes.Log, 0, len(rawLogs))
+	for i := range rawLogs {
+		log, err := fromRawLog(&rawLogs[i])
+		if err != nil {
+			return errors.Wrapf(err, "failed on decode raw log, id: %d", rawLogs[i].Id)
+		}
+		logs = append(logs, log)
+	}
+
+	replayCtx := context.WithValue(s.ctx, replayKey{}, true)
+	if err := s.db.WithContext(replayCtx).Transaction(func(tx *gorm.DB) error {
+		orderIds := s.madeOrderIds(logs)
+		restore := s.touchedOrders(logs, orderIds)
+		if err := s.restoreOrders(tx, restore); err != nil {
+			return errors.Wrap(err, "failed on restore orders")
+		}
+		if len(orderIds) > 0 {
+			if err := tx.Table(multi.OrderTableName(s.chain)).
+				Where("order_id in (?)", orderIds).
+				Delete(&multi.Order{}).Error; err != nil {
+				return errors.Wrap(err, "failed on delete orders")
+			}
+		}
+		if err := tx.Table(multi.ActivityTableName(s.chain)).
+			Where("marketplace_id = ? and activity_type in (?)", multi.MarketOrderBook, reprocessActivityTypes).
+			Where("block_number >= ? and block_number <= ?", fromBlock, toBlock).
+			Delete(&multi.Activity{}).Error; err != nil {
+			return errors.Wrap(err, "failed on delete activities")
+		}
+		if err := tx.Table(multi.ContractEventTableName(s.chain)).
+			Where("block_number >= ? and block_number <= ?", fromBlock, toBlock).
+			Delete(&multi.ContractEvent{}).Error; err != nil {
+			return errors.Wrap(err, "failed on delete contract events")
+		}
+
+		for _, log := range logs {
+			if len(log.Topics) == 0 {
+				continue
+			}
+			handler, eventName, ok := s.registry.Handler(log.Topics[0])
+			if !ok {
+				continue
+			}
+			if err := handler(tx, log); err != nil {
+				return errors.Wrapf(err, "failed on reprocess %s log, tx: %s, index: %d", eventName, log.TxHash.String(), log.Index)
+			}
+		}
+
+		if err := s.enqueueCollectionReloads(tx, append(orderIds, restore.orderIds()...)); err != nil {
+			return errors.Wrap(err, "failed on enqueue collection reloads")
+		}
+
+		xzap.WithContext(s.ctx).Info("reprocess orderbook event ...",
+			zap.Uint64("from_block", fromBlock),
+			zap.Uint64("to_block", toBlock),
+			zap.Int("logs", len(logs)))
+		return nil
+	}); err != nil {
+		return err
+	}
+	s.refreshTradeStats()
+
+	return nil
+}
+
+// madeOrderIds 返回日志中LogMake事件创建的订单id
+func (s *Service) madeOrderIds(logs []ethereumTypes.Log) []string {
+	makeTopic := s.parsedAbi.Events["LogMake"].ID
+	var orderIds []string
+	for _, log := range logs {
+		if len(log.Topics) == 0 || log.Topics[0] != makeTopic {
+			continue
+		}
+		values, err := s.parsedAbi.Unpack("LogMake", log.Data) // orderKey为第一个非indexed字段
+		if err != nil || len(values) == 0 {
+			continue
+		}
+		orderKey, ok := values[0].([32]byte)
+		if !ok {
+			continue
+		}
+		orderIds = append(orderIds, HexPrefix+hex.EncodeToString(orderKey[:]))
+	}
+
+	return orderIds
+}
+
+// orderRestore 范围之前创建的订单在范围内被撮合或撤销的情况
+type orderRestore struct {
+	sells      map[string]bool  // 被撮合的卖单，撮合时整单成交
+	bidMatches map[string]int64 // 买单在范围内的撮合次数，每次成交matchQuantity个
+	cancels    map[string]bool  // 被撤销的订单
+}
+
+// orderIds 返回范围内被撮合或撤销的订单id
+func (r *orderRestore) orderIds() []string {
+	var orderIds []string
+	for _, orders := range []map[string]bool{r.sells, r.cancels} {
+		for orderId := range orders {
+			orderIds = append(orderIds, orderId)
+		}
+	}
+	for orderId := range r.bidMatches {
+		orderIds = append(orderIds, orderId)
+	}
+
+	return orderIds
+}
+
+// enqueueCollectionReloads 重放时不投递历史事件，为涉及的每个collection写入一个UpdateCollection事件，
+// order manager按重放后的地板价及出价重新加载
+func (s *Service) enqueueCollectionReloads(tx *gorm.DB, orderIds []string) error {
+	if len(orderIds) == 0 {
+		return nil
+	}
+
+	var collections []string
+	if err := tx.Table(multi.OrderTableName(s.chain)).
+		Where("order_id in (?)", orderIds).
+		Distinct().Pluck("collection_address", &collections).Error; err != nil {
+		return errors.Wrap(err, "failed on get touched collections")
+	}
+
+	for _, collection := range collections {
+		floorPrice, err := s.collectionFloorPrice(tx, collection)
+		if err != nil {
+			return err
+		}
+		if err := s.writeOutbox(tx, OutboxPriceUpdate, &ordermanager.TradeEvent{
+			EventType:      ordermanager.UpdateCollection,
+			CollectionAddr: collection,
+			Price:          floorPrice,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectionFloorPrice 按数据库中的有效挂单计算collection的地板价，与QueryCollectionsFloorPrice的条件一致
+func (s *Service) collectionFloorPrice(tx *gorm.DB, collection string) (decimal.Decimal, error) {
+	var floorPrice decimal.NullDecimal
+	if err := tx.Raw(fmt.Sprintf(`SELECT min(co.price) FROM %s as ci
+         join %s co on co.collection_address = ci.collection_address and co.token_id = ci.token_id
+WHERE co.collection_address = ? and co.order_type = ? and co.order_status = ? and co.expire_time > ? and co.maker = ci.owner
+  and co.currency_address in (?)`, multi.ItemTableName(s.chain), multi.OrderTableName(s.chain)),
+		collection,
+		multi.ListingType,
+		multi.OrderStatusActive,
+		time.Now().Unix(),
+		[]string{"", currency.NativeAddress, strings.ToLower(s.cfg.ContractCfg.EthAddress)},
+	).Row().Scan(&floorPrice); err != nil {
+		return decimal.Zero, errors.Wrap(err, "failed on get collection floor price")
+	}
+
+	return floorPrice.Decimal, nil
+}
+
+// touchedOrders 统计日志中撮合或撤销的、不在madeOrderIds中的订单
+func (s *Service) touchedOrders(logs []ethereumTypes.Log, madeOrderIds []string) *orderRestore {
+	made := make(map[string]bool, len(madeOrderIds))
+	for _, orderId := range madeOrderIds {
+		made[orderId] = true
+	}
+
+	matchTopic := s.parsedAbi.Events["LogMatch"].ID
+	cancelTopic := s.parsedAbi.Events["LogCancel"].ID
+	restore := &orderRestore{
+		sells:      make(map[string]bool),
+		bidMatches: make(map[string]int64),
+		cancels:    make(map[string]bool),
+	}
+	for _, log := range logs {
+		if len(log.Topics) < 2 {
+			continue
+		}
+		switch log.Topics[0] {
+		case matchTopic:
+			if len(log.Topics) < 3 {
+				continue
+			}
+			var event matchEvent
+			if err := s.parsedAbi.UnpackIntoInterface(&event, "LogMatch", log.Data); err != nil {
+				continue // 重放时同样跳过
+			}
+			makeOrderId := HexPrefix + hex.EncodeToString(log.Topics[1].Bytes())
+			takeOrderId := HexPrefix + hex.EncodeToString(log.Topics[2].Bytes())
+			buyOrderId, _ := event.buyOrder(makeOrderId, takeOrderId)
+			sellOrderId := makeOrderId
+			if buyOrderId == makeOrderId {
+				sellOrderId = takeOrderId
+			}
+			if !made[sellOrderId] {
+				restore.sells[sellOrderId] = true
+			}
+			if !made[buyOrderId] {
+				restore.bidMatches[buyOrderId]++
+			}
+		case cancelTopic:
+			if orderId := HexPrefix + hex.EncodeToString(log.Topics[1].Bytes()); !made[orderId] {
+				restore.cancels[orderId] = true
+			}
+		}
+	}
+
+	return restore
+}
+
+// restoreOrders 撤销范围内日志对范围之前创建的订单的影响：卖单恢复为未成交，买单加回范围内的成交数量，
+// 撤销的订单恢复为有效。撮合及撤销后订单不会再有新的事件，只有买单可能在范围之后继续成交或被撤销，
+// 因此买单只将已成交状态恢复为有效，重放后剩余数量为0时重新置为已成交
+func (s *Service) restoreOrders(tx *gorm.DB, restore *orderRestore) error {
+	var sellOrderIds []string
+	for orderId := range restore.sells {
+		sellOrderIds = append(sellOrderIds, orderId)
+	}
+	if len(sellOrderIds) > 0 {
+		if err := tx.Table(multi.OrderTableName(s.chain)).
+			Where("order_id in (?)", sellOrderIds).
+			Updates(map[string]interface{}{
+				"quantity_remaining": gorm.Expr("size"),
+				"order_status":       multi.OrderStatusActive,
+				"taker":              ZeroAddress,
+			}).Error; err != nil {
+			return errors.Wrap(err, "failed on restore sell orders")
+		}
+	}
+
+	for orderId, matches := range restore.bidMatches {
+		if err := tx.Table(multi.OrderTableName(s.chain)).
+			Where("order_id = ?", orderId).
+			Updates(map[string]interface{}{
+				"quantity_remaining": gorm.Expr("quantity_remaining + ?", matches*matchQuantity),
+				"order_status": gorm.Expr("case when order_status = ? then ? else order_status end",
+					multi.OrderStatusFilled, multi.OrderStatusActive),
+			}).Error; err != nil {
+			return errors.Wrapf(err, "failed on restore bid order, order_id: %s", orderId)
+		}
+	}
+
+	var cancelOrderIds []string
+	for orderId := range restore.cancels {
+		cancelOrderIds = append(cancelOrderIds, orderId)
+	}
+	if len(cancelOrderIds) > 0 {
+		if err := tx.Table(multi.OrderTableName(s.chain)).
+			Where("order_id in (?)", cancelOrderIds).
+			Update("order_status", multi.OrderStatusActive).Error; err != nil {
+			return errors.Wrap(err, "failed on restore cancelled orders")
+		}
+	}
+
+	return nil
+}
+
+func toRawLog(log ethereumTypes.Log, blockTime uint64) multi.RawLog {
+	topics := make([]string, 0, len(log.Topics))
+	for _, topic := range log.Topics {
+		topics = append(topics, topic.String())
+	}
+
+	return multi.RawLog{
+		BlockNumber: int64(log.BlockNumber),
+		BlockHash:   log.BlockHash.String(),
+		BlockTime:   int64(blockTime),
+		TxHash:      log.TxHash.String(),
+		TxIndex:     int64(log.TxIndex),
+		LogIndex:    int64(log.Index),
+		Address:     strings.ToLower(log.Address.String()),
+		Topics:      strings.Join(topics, ","),
+		Data:        hex.EncodeToString(log.Data),
+	}
+}
+
+func fromRawLog(rawLog *multi.RawLog) (ethereumTypes.Log, error) {
+	data, err := hex.DecodeString(rawLog.Data)
+	if err != nil {
+		return ethereumTypes.Log{}, errors.Wrap(err, "invalid log data")
+	}
+
+	var topics []common.Hash
+	if rawLog.Topics != "" {
+		for _, topic := range strings.Split(rawLog.Topics, ",") {
+			topics = append(topics, common.HexToHash(topic))
+		}
+	}
+
+	return ethereumTypes.Log{
+		Address:     common.HexToAddress(rawLog.Address),
+		Topics:      topics,
+		Data:        data,
+		BlockNumber: uint64(rawLog.BlockNumber),
+		TxHash:      common.HexToHash(rawLog.TxHash),
+		TxIndex:     uint(rawLog.TxIndex),
+		BlockHash:   common.HexToHash(rawLog.BlockHash),
+		Index:       uint(rawLog.LogIndex),
+	}, nil
+}
@@ -0,0 +1,187 @@
+package orderbookindexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/ordermanager"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethereumTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/ProjectsTask/EasySwapSync/service/config"
+)
+
+func TestRawLogRoundTrip(t *testing.T) {
+	log := ethereumTypes.Log{
+		Address: common.HexToAddress("0x7d29d1860bD4d3A74bBD9a03C9B043d375311dCb"),
+		Topics: []common.Hash{
+			common.HexToHash("0xfc37f2ff950f95913eb7182357ba3c14df60ef354bc7d6ab1ba2815f249fffe6"),
+			common.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000001"),
+		},
+		Data:        []byte{0x01, 0x02, 0xff},
+		BlockNumber: 111819366,
+		TxHash:      common.HexToHash("0xabc"),
+		TxIndex:     3,
+		BlockHash:   common.HexToHash("0xdef"),
+		Index:       7,
+	}
+
+	rawLog := toRawLog(log, 1700000000)
+	if rawLog.BlockTime != 1700000000 {
+		t.Errorf("Unexpected block time: %d", rawLog.BlockTime)
+	}
+
+	decoded, err := fromRawLog(&rawLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Address != log.Address || decoded.BlockNumber != log.BlockNumber || decoded.TxHash != log.TxHash ||
+		decoded.TxIndex != log.TxIndex || decoded.BlockHash != log.BlockHash || decoded.Index != log.Index {
+		t.Errorf("Unexpected decoded log: %+v", decoded)
+	}
+	if len(decoded.Topics) != len(log.Topics) || decoded.Topics[0] != log.Topics[0] || decoded.Topics[1] != log.Topics[1] {
+		t.Errorf("Unexpected decoded topics: %v", decoded.Topics)
+	}
+	if !bytes.Equal(decoded.Data, log.Data) {
+		t.Errorf("Unexpected decoded data: %x", decoded.Data)
+	}
+}
+
+func TestRawLogWithoutTopics(t *testing.T) {
+	rawLog := toRawLog(ethereumTypes.Log{}, 0)
+	decoded, err := fromRawLog(&rawLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Topics) != 0 {
+		t.Errorf("Unexpected topics: %v", decoded.Topics)
+	}
+}
+
+func TestTouchedOrders(t *testing.T) {
+	parsedAbi, err := abi.JSON(strings.NewReader(contractAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{parsedAbi: parsedAbi}
+
+	order := func(side uint8) fakeOrder {
+		return fakeOrder{Side: side, Maker: common.HexToAddress("0x2"),
+			Nft: fakeAsset{big.NewInt(1), common.HexToAddress("0x3"), big.NewInt(5)}, Price: big.NewInt(100), Expiry: 1}
+	}
+	matchLog := func(makeKey, takeKey [32]byte, makeOrder, takeOrder fakeOrder) ethereumTypes.Log {
+		data, err := parsedAbi.Events["LogMatch"].Inputs.NonIndexed().Pack(makeOrder, takeOrder, big.NewInt(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ethereumTypes.Log{
+			Topics: []common.Hash{parsedAbi.Events["LogMatch"].ID, common.Hash(makeKey), common.Hash(takeKey)},
+			Data:   data,
+		}
+	}
+
+	bid, listing, newListing, cancelled := [32]byte{31: 1}, [32]byte{31: 2}, [32]byte{31: 3}, [32]byte{31: 4}
+	logs := []ethereumTypes.Log{
+		matchLog(bid, listing, order(Bid), order(List)),
+		matchLog(newListing, bid, order(List), order(Bid)),
+		{Topics: []common.Hash{parsedAbi.Events["LogCancel"].ID, common.Hash(cancelled), common.HexToHash("0x2")}},
+	}
+
+	// newListing在范围内创建，重放前会被删除，无需恢复
+	restore := s.touchedOrders(logs, []string{orderIdOf(newListing)})
+	if len(restore.sells) != 1 || !restore.sells[orderIdOf(listing)] {
+		t.Errorf("unexpected sells: %v", restore.sells)
+	}
+	if len(restore.bidMatches) != 1 || restore.bidMatches[orderIdOf(bid)] != 2 {
+		t.Errorf("unexpected bid matches: %v", restore.bidMatches)
+	}
+	if len(restore.cancels) != 1 || !restore.cancels[orderIdOf(cancelled)] {
+		t.Errorf("unexpected cancels: %v", restore.cancels)
+	}
+}
+
+func TestReprocessEnqueuesCollectionReloads(t *testing.T) {
+	parsedAbi, err := abi.JSON(strings.NewReader(contractAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := "optimism"
+	db := newTestDB(t, chain)
+	s := &Service{
+		ctx:       xzap.ToContext(context.Background(), zap.NewNop()),
+		cfg:       &config.Config{ContractCfg: config.ContractCfg{EthAddress: ZeroAddress}},
+		db:        db,
+		chain:     chain,
+		parsedAbi: parsedAbi,
+	}
+	if err := s.registerEventHandlers(); err != nil {
+		t.Fatal(err)
+	}
+
+	collection, maker := "0x3", "0x2"
+	cancelled, listing := [32]byte{31: 1}, [32]byte{31: 2}
+	for i, order := range []multi.Order{
+		{OrderID: orderIdOf(cancelled), TokenId: "1", Price: decimal.NewFromInt(100)},
+		{OrderID: orderIdOf(listing), TokenId: "2", Price: decimal.NewFromInt(50)},
+	} {
+		order.ID = int64(i + 1)
+		order.CollectionAddress = collection
+		order.Maker = maker
+		order.OrderType = multi.ListingOrder
+		order.OrderStatus = multi.OrderStatusActive
+		order.ExpireTime = time.Now().Add(time.Hour).Unix()
+		order.QuantityRemaining = 1
+		if err := db.Table(multi.OrderTableName(chain)).Create(&order).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Table(multi.ItemTableName(chain)).Create(&multi.Item{CollectionAddress: collection, TokenId: order.TokenId, Owner: maker}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	rawLog := toRawLog(ethereumTypes.Log{
+		Topics:      []common.Hash{parsedAbi.Events["LogCancel"].ID, common.Hash(cancelled), common.HexToHash(maker)},
+		BlockNumber: 10,
+		TxHash:      common.HexToHash("0x4"),
+	}, uint64(time.Now().Unix()))
+	if err := db.Table(multi.RawLogTableName(chain)).Create(&rawLog).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ReprocessBlockRange(10, 10); err != nil {
+		t.Fatalf("failed on reprocess: %v", err)
+	}
+
+	var order multi.Order
+	if err := db.Table(multi.OrderTableName(chain)).Where("order_id = ?", orderIdOf(cancelled)).Take(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.OrderStatus != multi.OrderStatusCancelled {
+		t.Errorf("expected cancelled order, got status %d", order.OrderStatus)
+	}
+
+	// 重放的撤单事件不投递，只为涉及的collection写入一个重新加载事件
+	var events []OutboxEvent
+	if err := db.Table(OutboxTableName(chain)).Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EventType != OutboxPriceUpdate {
+		t.Fatalf("unexpected outbox events: %+v", events)
+	}
+	var event ordermanager.TradeEvent
+	if err := json.Unmarshal([]byte(events[0].Payload), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.EventType != ordermanager.UpdateCollection || event.CollectionAddr != collection || !event.Price.Equal(decimal.NewFromInt(50)) {
+		t.Errorf("unexpected reload event: %+v", event)
+	}
+}
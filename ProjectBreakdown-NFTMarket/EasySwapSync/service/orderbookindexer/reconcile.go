@@ -0,0 +1,603 @@
+package orderbookindexer
+
+import (
+	"encoding/hex"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProjectsTask/EasySwapBase/chain/multicall"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/ordermanager"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/base"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DriftMissing   = "missing"   // 链上存在，数据库中不存在或未处于有效状态
+	DriftExtra     = "extra"     // 数据库中有效，链上已不存在
+	DriftMispriced = "mispriced" // 价格或剩余数量与链上不一致
+
+	snapshotRetries = 3
+	filledAmountGas = 30_000 // filledAmount的预估gas，用于multicall拆分批次
+)
+
+// ErrSnapshotStale 修复时数据库已同步到新的区块，差异可能已被新事件修正，需重新对账
+var ErrSnapshotStale = errors.New("order book advanced since reconciliation snapshot")
+
+var (
+	orderDriftGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "easyswap_sync",
+		Name:      "orderbook_drift_orders",
+		Help:      "Number of orders that differ between the indexed order book and chain state in the last reconciliation.",
+	}, []string{"chain", "kind"})
+	orderRepairCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "easyswap_sync",
+		Name:      "orderbook_repaired_orders_total",
+		Help:      "Number of orders repaired by reconciliation.",
+	}, []string{"chain", "kind"})
+	reconcileTimeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "easyswap_sync",
+		Name:      "orderbook_reconcile_timestamp_seconds",
+		Help:      "Unix time of the last successful reconciliation.",
+	}, []string{"chain"})
+)
+
+func init() {
+	prometheus.MustRegister(orderDriftGauge, orderRepairCounter, reconcileTimeGauge)
+}
+
+// chainOrder 链上订单簿中的有效订单
+type chainOrder struct {
+	OrderID           string
+	Order             Order
+	QuantityRemaining int64
+}
+
+// OrderDrift 单个订单在数据库与链上的差异
+type OrderDrift struct {
+	Kind              string          `json:"kind"`
+	CollectionAddress string          `json:"collection_address"`
+	OrderID           string          `json:"order_id"`
+	ChainPrice        decimal.Decimal `json:"chain_price"`
+	DBPrice           decimal.Decimal `json:"db_price"`
+	ChainQuantity     int64           `json:"chain_quantity"`
+	DBQuantity        int64           `json:"db_quantity"`
+}
+
+// ReconcileReport 一次对账的结果
+type ReconcileReport struct {
+	Chain       string        `json:"chain"`
+	BlockNumber uint64        `json:"block_number"` // 链上状态对应的区块，与数据库已同步的区块一致
+	Collections int           `json:"collections"`
+	ChainOrders int           `json:"chain_orders"`
+	DBOrders    int           `json:"db_orders"`
+	Drifts      []*OrderDrift `json:"drifts"`
+	Repaired    int           `json:"repaired"`
+	StartTime   int64         `json:"start_time"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// Count 返回指定类型的差异订单数
+func (r *ReconcileReport) Count(kind string) int {
+	var count int
+	for _, drift := range r.Drifts {
+		if drift.Kind == kind {
+			count++
+		}
+	}
+
+	return count
+}
+
+// ReconcileLoop 按reconcile_cfg定期对账，未配置间隔时不启用
+func (s *Service) ReconcileLoop() {
+	interval := s.cfg.ReconcileCfg.Interval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			xzap.WithContext(s.ctx).Info("ReconcileLoop stopped due to context cancellation")
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.Reconcile(s.cfg.ReconcileCfg.Repair); err != nil {
+			xzap.WithContext(s.ctx).Error("failed on reconcile order book", zap.String("chain", s.chain), zap.Error(err))
+			continue
+		}
+		// 修复产生的副作用事件
+		if err := s.flushOutbox(); err != nil {
+			xzap.WithContext(s.ctx).Error("failed on flush outbox", zap.Error(err))
+		}
+	}
+}
+
+// Reconcile 遍历每个collection在链上的价格树，与数据库中的有效订单对比。
+// repair为true时以链上状态为准修复数据库，副作用事件写入outbox
+func (s *Service) Reconcile(repair bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{Chain: s.chain, StartTime: time.Now().Unix()}
+	collections, err := s.reconcileCollections()
+	if err != nil {
+		return nil, err
+	}
+	report.Collections = len(collections)
+
+	now := time.Now().Unix()
+	for _, collection := range collections {
+		dbOrders, blockNumber, err := s.dbOrdersSnapshot(collection, now)
+		if err != nil {
+			return nil, err
+		}
+		chainOrders, err := s.chainOrders(collection, blockNumber, now)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed on walk price trees of %s", collection)
+		}
+		report.BlockNumber = blockNumber
+		report.ChainOrders += len(chainOrders)
+		report.DBOrders += len(dbOrders)
+
+		drifts := diffOrders(collection, chainOrders, dbOrders)
+		report.Drifts = append(report.Drifts, drifts...)
+		if repair && len(drifts) > 0 {
+			repaired, err := s.repairDrifts(drifts, chainOrders, blockNumber)
+			report.Repaired += repaired
+			if err != nil {
+				return report, errors.Wrapf(err, "failed on repair orders of %s", collection)
+			}
+		}
+	}
+	report.Duration = time.Since(time.Unix(report.StartTime, 0))
+
+	for _, kind := range []string{DriftMissing, DriftExtra, DriftMispriced} {
+		orderDriftGauge.WithLabelValues(s.chain, kind).Set(float64(report.Count(kind)))
+	}
+	reconcileTimeGauge.WithLabelValues(s.chain).Set(float64(time.Now().Unix()))
+	xzap.WithContext(s.ctx).Info("reconcile order book",
+		zap.String("chain", s.chain),
+		zap.Uint64("block_number", report.BlockNumber),
+		zap.Int("collections", report.Collections),
+		zap.Int("chain_orders", report.ChainOrders),
+		zap.Int("db_orders", report.DBOrders),
+		zap.Int("missing", report.Count(DriftMissing)),
+		zap.Int("extra", report.Count(DriftExtra)),
+		zap.Int("mispriced", report.Count(DriftMispriced)),
+		zap.Int("repaired", report.Repaired))
+
+	return report, nil
+}
+
+// reconcileCollections 已导入的collection及存在有效订单的collection，链上价格树无法枚举collection
+func (s *Service) reconcileCollections() ([]string, error) {
+	var imported []string
+	if err := s.db.WithContext(s.ctx).Table(multi.CollectionTableName(s.chain)).
+		Pluck("address", &imported).Error; err != nil {
+		return nil, errors.Wrap(err, "failed on get collections")
+	}
+	var ordered []string
+	if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
+		Where("order_status in (?)", []int{multi.OrderStatusActive, multi.OrderStatusInactive}).
+		Distinct("collection_address").
+		Pluck("collection_address", &ordered).Error; err != nil {
+		return nil, errors.Wrap(err, "failed on get collections of active orders")
+	}
+
+	set := make(map[string]struct{})
+	var collections []string
+	for _, addr := range append(imported, ordered...) {
+		addr = strings.ToLower(addr)
+		if _, ok := set[addr]; ok || addr == "" {
+			continue
+		}
+		set[addr] = struct{}{}
+		collections = append(collections, addr)
+	}
+	sort.Strings(collections)
+
+	return collections, nil
+}
+
+// dbOrdersSnapshot 读取collection的有效订单及对应的已同步区块。
+// 读取前后同步区块不一致时重试，保证订单与区块对应同一状态
+func (s *Service) dbOrdersSnapshot(collection string, now int64) ([]*multi.Order, uint64, error) {
+	for i := 0; i < snapshotRetries; i++ {
+		before, err := s.indexedBlock()
+		if err != nil {
+			return nil, 0, err
+		}
+		var orders []*multi.Order
+		if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
+			Where("collection_address = ? and order_status in (?) and expire_time > ? and order_type in (?)",
+				collection, []int{multi.OrderStatusActive, multi.OrderStatusInactive}, now,
+				[]int{multi.ListingOrder, multi.CollectionBidOrder, multi.ItemBidOrder}).
+			Find(&orders).Error; err != nil {
+			return nil, 0, errors.Wrap(err, "failed on get active orders")
+		}
+		after, err := s.indexedBlock()
+		if err != nil {
+			return nil, 0, err
+		}
+		if before == after {
+			return orders, before, nil
+		}
+	}
+
+	return nil, 0, errors.New("order book is syncing too fast to take a snapshot")
+}
+
+// indexedBlock 已处理完成的最新区块
+func (s *Service) indexedBlock() (uint64, error) {
+	var indexedStatus base.IndexedStatus
+	if err := s.db.WithContext(s.ctx).Table(base.IndexedStatusTableName()).
+		Where("chain_id = ? and index_type = ?", s.chainId, EventIndexType).
+		First(&indexedStatus).Error; err != nil {
+		return 0, errors.Wrap(err, "failed on get orderbook index status")
+	}
+	if indexedStatus.LastIndexedBlock <= 0 {
+		return 0, errors.New("order book is not indexed yet")
+	}
+
+	// last_indexed_block为下一个待同步的区块
+	return uint64(indexedStatus.LastIndexedBlock - 1), nil
+}
+
+// chainOrders 按价格从优到劣遍历collection挂单及出价的价格树和订单队列，返回指定区块时未过期且未完全成交的订单
+func (s *Service) chainOrders(collection string, blockNumber uint64, now int64) (map[string]*chainOrder, error) {
+	collectionAddr := common.HexToAddress(collection)
+	block := new(big.Int).SetUint64(blockNumber)
+	orders := make(map[string]*chainOrder)
+	var partial []string // 多数量的出价可能部分成交，遍历后批量查询成交数量
+	for _, side := range []uint8{List, Bid} {
+		price, err := s.callNextBestPrice(collectionAddr, side, big.NewInt(0), block)
+		if err != nil {
+			return nil, err
+		}
+		for price.Sign() > 0 {
+			var queue struct {
+				Head [32]byte
+				Tail [32]byte
+			}
+			if err := s.callOrderBook(&queue, "orderQueues", block, collectionAddr, side, price); err != nil {
+				return nil, err
+			}
+
+			orderKey := queue.Head
+			for orderKey != ([32]byte{}) {
+				var dbOrder struct {
+					Order Order
+					Next  [32]byte
+				}
+				if err := s.callOrderBook(&dbOrder, "orders", block, orderKey); err != nil {
+					return nil, err
+				}
+
+				if dbOrder.Order.Expiry > uint64(now) { // 与索引一致，expiry为0的订单按已过期处理
+					order := &chainOrder{
+						OrderID:           HexPrefix + hex.EncodeToString(orderKey[:]),
+						Order:             dbOrder.Order,
+						QuantityRemaining: 1,
+					}
+					if dbOrder.Order.Nft.Amount != nil {
+						order.QuantityRemaining = dbOrder.Order.Nft.Amount.Int64()
+					}
+					if order.QuantityRemaining > 1 {
+						partial = append(partial, order.OrderID)
+					}
+					orders[order.OrderID] = order
+				}
+				orderKey = dbOrder.Next
+			}
+
+			if price, err = s.callNextBestPrice(collectionAddr, side, price, block); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	fills, err := s.filledAmounts(partial, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	for orderId, filled := range fills {
+		if orders[orderId].QuantityRemaining -= filled; orders[orderId].QuantityRemaining <= 0 {
+			delete(orders, orderId)
+		}
+	}
+
+	return orders, nil
+}
+
+func (s *Service) callNextBestPrice(collection common.Address, side uint8, price, block *big.Int) (*big.Int, error) {
+	var next struct {
+		NextBestPrice *big.Int
+	}
+	if err := s.callOrderBook(&next, "getNextBestPrice", block, collection, side, price); err != nil {
+		return nil, err
+	}
+	if next.NextBestPrice == nil {
+		return big.NewInt(0), nil
+	}
+
+	return next.NextBestPrice, nil
+}
+
+// callOrderBook 在指定区块调用订单簿合约的只读方法，结果解析到out
+func (s *Service) callOrderBook(out interface{}, method string, block *big.Int, args ...interface{}) error {
+	data, err := s.parsedAbi.Pack(method, args...)
+	if err != nil {
+		return errors.Wrapf(err, "failed on pack %s", method)
+	}
+	dex := common.HexToAddress(s.cfg.ContractCfg.DexAddress)
+	respData, err := s.chainClient.CallContract(s.ctx, ethereum.CallMsg{To: &dex, Data: data}, block)
+	if err != nil {
+		return errors.Wrapf(err, "failed on call %s", method)
+	}
+	if err := s.parsedAbi.UnpackIntoInterface(out, method, respData); err != nil {
+		return errors.Wrapf(err, "failed on unpack %s", method)
+	}
+
+	return nil
+}
+
+// filledAmounts 通过Multicall3批量查询订单在指定区块的累计成交数量，链上未部署Multicall3时逐个查询
+func (s *Service) filledAmounts(orderIds []string, blockNumber uint64) (map[string]int64, error) {
+	fills := make(map[string]int64, len(orderIds))
+	if len(orderIds) == 0 {
+		return fills, nil
+	}
+
+	dex := common.HexToAddress(s.cfg.ContractCfg.DexAddress)
+	msgs := make([]ethereum.CallMsg, 0, len(orderIds))
+	for _, orderId := range orderIds {
+		data, err := s.packFilledAmount(orderId)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, ethereum.CallMsg{To: &dex, Gas: filledAmountGas, Data: data})
+	}
+
+	results, err := multicall.Aggregate3(s.ctx, s.chainClient, multicall.AddressOf(int(s.chainId)), msgs,
+		multicall.Options{BlockNumber: new(big.Int).SetUint64(blockNumber)})
+	if errors.Is(err, multicall.ErrNotDeployed) {
+		for _, orderId := range orderIds {
+			filled, err := s.filledAmount(orderId, blockNumber)
+			if err != nil {
+				return nil, err
+			}
+			fills[orderId] = filled
+		}
+		return fills, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed on batch call filledAmount")
+	}
+
+	for i, result := range results {
+		if err := result.Err(); err != nil {
+			return nil, errors.Wrapf(err, "failed on call filledAmount of %s", orderIds[i])
+		}
+		filled, err := s.unpackFilledAmount(result.ReturnData)
+		if err != nil {
+			return nil, err
+		}
+		fills[orderIds[i]] = filled
+	}
+
+	return fills, nil
+}
+
+// diffOrders 对比链上与数据库中的有效订单，价格及剩余数量以链上为准
+func diffOrders(collection string, chainOrders map[string]*chainOrder, dbOrders []*multi.Order) []*OrderDrift {
+	var drifts []*OrderDrift
+	dbOrderMap := make(map[string]*multi.Order, len(dbOrders))
+	for _, order := range dbOrders {
+		orderId := strings.ToLower(order.OrderID)
+		dbOrderMap[orderId] = order
+
+		onChain, ok := chainOrders[orderId]
+		if !ok {
+			drifts = append(drifts, &OrderDrift{
+				Kind:              DriftExtra,
+				CollectionAddress: collection,
+				OrderID:           order.OrderID,
+				DBPrice:           order.Price,
+				DBQuantity:        order.QuantityRemaining,
+			})
+			continue
+		}
+		chainPrice := decimal.NewFromBigInt(onChain.Order.Price, 0)
+		if !chainPrice.Equal(order.Price) || onChain.QuantityRemaining != order.QuantityRemaining {
+			drifts = append(drifts, &OrderDrift{
+				Kind:              DriftMispriced,
+				CollectionAddress: collection,
+				OrderID:           order.OrderID,
+				ChainPrice:        chainPrice,
+				DBPrice:           order.Price,
+				ChainQuantity:     onChain.QuantityRemaining,
+				DBQuantity:        order.QuantityRemaining,
+			})
+		}
+	}
+
+	for orderId, onChain := range chainOrders {
+		if _, ok := dbOrderMap[orderId]; ok {
+			continue
+		}
+		drifts = append(drifts, &OrderDrift{
+			Kind:              DriftMissing,
+			CollectionAddress: collection,
+			OrderID:           orderId,
+			ChainPrice:        decimal.NewFromBigInt(onChain.Order.Price, 0),
+			ChainQuantity:     onChain.QuantityRemaining,
+		})
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		if drifts[i].Kind != drifts[j].Kind {
+			return drifts[i].Kind < drifts[j].Kind
+		}
+		return drifts[i].OrderID < drifts[j].OrderID
+	})
+
+	return drifts
+}
+
+// repairDrifts 在一个事务内按链上状态修复订单，返回修复的订单数。
+// 事务内锁定同步状态，数据库已同步到快照之后的区块时放弃修复，避免以旧的链上状态覆盖新事件
+func (s *Service) repairDrifts(drifts []*OrderDrift, chainOrders map[string]*chainOrder, blockNumber uint64) (int, error) {
+	// 节点查询在事务外完成
+	var extraOrderIds []string
+	for _, drift := range drifts {
+		if drift.Kind == DriftExtra {
+			extraOrderIds = append(extraOrderIds, drift.OrderID)
+		}
+	}
+	fills, err := s.filledAmounts(extraOrderIds, blockNumber)
+	if err != nil {
+		return 0, err
+	}
+
+	var repaired int
+	err = s.db.WithContext(s.writeCtx).Transaction(func(tx *gorm.DB) error {
+		var indexedStatus base.IndexedStatus
+		if err := tx.Table(base.IndexedStatusTableName()).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("chain_id = ? and index_type = ?", s.chainId, EventIndexType).
+			First(&indexedStatus).Error; err != nil {
+			return errors.Wrap(err, "failed on lock orderbook index status")
+		}
+		if uint64(indexedStatus.LastIndexedBlock-1) != blockNumber {
+			return errors.Wrapf(ErrSnapshotStale, "snapshot block %d, indexed block %d", blockNumber, indexedStatus.LastIndexedBlock-1)
+		}
+
+		repaired = 0
+		for _, drift := range drifts {
+			var err error
+			switch drift.Kind {
+			case DriftMissing:
+				err = s.repairMissingOrder(tx, chainOrders[strings.ToLower(drift.OrderID)])
+			case DriftExtra:
+				err = s.repairExtraOrder(tx, drift, fills[drift.OrderID])
+			case DriftMispriced:
+				err = tx.Table(multi.OrderTableName(s.chain)).
+					Where("order_id = ?", drift.OrderID).
+					Updates(map[string]interface{}{
+						"price":              drift.ChainPrice,
+						"quantity_remaining": drift.ChainQuantity,
+					}).Error
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed on repair %s order %s", drift.Kind, drift.OrderID)
+			}
+			repaired++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, drift := range drifts {
+		orderRepairCounter.WithLabelValues(s.chain, drift.Kind).Inc()
+	}
+
+	return repaired, nil
+}
+
+// repairMissingOrder 补录或恢复链上有效的订单，并加入order manager队列
+func (s *Service) repairMissingOrder(tx *gorm.DB, onChain *chainOrder) error {
+	orderType := int64(multi.ListingOrder)
+	if onChain.Order.Side == Bid {
+		if onChain.Order.SaleKind == FixForCollection {
+			orderType = multi.CollectionBidOrder
+		} else {
+			orderType = multi.ItemBidOrder
+		}
+	}
+	size := onChain.QuantityRemaining
+	if onChain.Order.Nft.Amount != nil {
+		size = onChain.Order.Nft.Amount.Int64()
+	}
+	order := multi.Order{
+		CollectionAddress: onChain.Order.Nft.CollectionAddr.String(),
+		MarketplaceId:     multi.MarketOrderBook,
+		TokenId:           onChain.Order.Nft.TokenId.String(),
+		OrderID:           onChain.OrderID,
+		OrderStatus:       multi.OrderStatusActive,
+		EventTime:         time.Now().Unix(),
+		ExpireTime:        int64(onChain.Order.Expiry),
+		CurrencyAddress:   s.currencyAddress(),
+		Price:             decimal.NewFromBigInt(onChain.Order.Price, 0),
+		Maker:             onChain.Order.Maker.String(),
+		Taker:             ZeroAddress,
+		QuantityRemaining: onChain.QuantityRemaining,
+		Size:              size,
+		OrderType:         orderType,
+		Salt:              int64(onChain.Order.Salt),
+	}
+	if err := tx.Table(multi.OrderTableName(s.chain)).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "order_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"order_status", "price", "quantity_remaining", "expire_time",
+		}),
+	}).Create(&order).Error; err != nil {
+		return errors.Wrap(err, "failed on upsert order")
+	}
+
+	return s.enqueueOrder(tx, &multi.Order{
+		ExpireTime:        order.ExpireTime,
+		OrderID:           order.OrderID,
+		CollectionAddress: order.CollectionAddress,
+		TokenId:           order.TokenId,
+		Price:             order.Price,
+		Maker:             order.Maker,
+		CurrencyAddress:   order.CurrencyAddress,
+		OrderType:         order.OrderType,
+	})
+}
+
+// repairExtraOrder 链上已移除的订单，根据快照区块时的成交数量置为已成交或已取消，并更新地板价
+func (s *Service) repairExtraOrder(tx *gorm.DB, drift *OrderDrift, filled int64) error {
+	var order multi.Order
+	if err := tx.Table(multi.OrderTableName(s.chain)).
+		Where("order_id = ?", drift.OrderID).
+		First(&order).Error; err != nil {
+		return errors.Wrap(err, "failed on get order")
+	}
+
+	status := multi.OrderStatusCancelled
+	remaining := order.Size - filled
+	if remaining <= 0 {
+		status = multi.OrderStatusFilled
+		remaining = 0
+	}
+	if err := tx.Table(multi.OrderTableName(s.chain)).
+		Where("order_id = ?", order.OrderID).
+		Updates(map[string]interface{}{
+			"order_status":       status,
+			"quantity_remaining": remaining,
+		}).Error; err != nil {
+		return errors.Wrap(err, "failed on update order status")
+	}
+
+	if order.OrderType == multi.ItemBidOrder {
+		return nil
+	}
+	return s.enqueuePriceEvent(tx, &ordermanager.TradeEvent{
+		OrderId:        order.OrderID,
+		CollectionAddr: order.CollectionAddress,
+		TokenID:        order.TokenId,
+		EventType:      ordermanager.Cancel,
+	})
+}
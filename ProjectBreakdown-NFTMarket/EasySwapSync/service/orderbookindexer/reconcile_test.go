@@ -0,0 +1,291 @@
+package orderbookindexer
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/ProjectsTask/EasySwapBase/chain/multicall"
+	"github.com/ProjectsTask/EasySwapBase/chain/types"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/base"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+
+	"github.com/ProjectsTask/EasySwapSync/service/config"
+)
+
+type fakeAsset struct {
+	TokenId    *big.Int
+	Collection common.Address
+	Amount     *big.Int
+}
+
+type fakeOrder struct {
+	Side     uint8
+	SaleKind uint8
+	Maker    common.Address
+	Nft      fakeAsset
+	Price    *big.Int
+	Expiry   uint64
+	Salt     uint64
+}
+
+// fakeOrderBook 模拟订单簿合约的价格树、订单队列及成交数量
+type fakeOrderBook struct {
+	s      *Service
+	queues map[uint8]map[int64][][32]byte
+	orders map[[32]byte]fakeOrder
+	filled map[[32]byte]int64
+	blocks []*big.Int
+
+	multicalls int
+}
+
+func newFakeOrderBook() *fakeOrderBook {
+	return &fakeOrderBook{
+		queues: map[uint8]map[int64][][32]byte{List: {}, Bid: {}},
+		orders: make(map[[32]byte]fakeOrder),
+		filled: make(map[[32]byte]int64),
+	}
+}
+
+func (b *fakeOrderBook) add(key byte, order fakeOrder) [32]byte {
+	orderKey := [32]byte{31: key}
+	b.orders[orderKey] = order
+	price := order.Price.Int64()
+	b.queues[order.Side][price] = append(b.queues[order.Side][price], orderKey)
+	return orderKey
+}
+
+// nextBestPrice 挂单价格从低到高，出价价格从高到低
+func (b *fakeOrderBook) nextBestPrice(side uint8, price int64) int64 {
+	var prices []int64
+	for p := range b.queues[side] {
+		prices = append(prices, p)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		if side == Bid {
+			return prices[i] > prices[j]
+		}
+		return prices[i] < prices[j]
+	})
+	for _, p := range prices {
+		if price == 0 || (side == List && p > price) || (side == Bid && p < price) {
+			return p
+		}
+	}
+	return 0
+}
+
+func (b *fakeOrderBook) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if method, err := multicall.Abi.MethodById(msg.Data[:4]); err == nil { // Multicall3逐个执行aggregate3中的调用
+		b.multicalls++
+		args, err := method.Inputs.Unpack(msg.Data[4:])
+		if err != nil {
+			return nil, err
+		}
+		var results []multicall.Result
+		for _, call := range *abi.ConvertType(args[0], new([]multicall.Call)).(*[]multicall.Call) {
+			data, err := b.CallContract(ctx, ethereum.CallMsg{To: &call.Target, Data: call.CallData}, blockNumber)
+			results = append(results, multicall.Result{Success: err == nil, ReturnData: data})
+		}
+		return method.Outputs.Pack(results)
+	}
+
+	b.blocks = append(b.blocks, blockNumber)
+	method, err := b.s.parsedAbi.MethodById(msg.Data[:4])
+	if err != nil {
+		return nil, err
+	}
+	args, err := method.Inputs.Unpack(msg.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	switch method.Name {
+	case "getNextBestPrice":
+		return method.Outputs.Pack(big.NewInt(b.nextBestPrice(args[1].(uint8), args[2].(*big.Int).Int64())))
+	case "orderQueues":
+		keys := b.queues[args[1].(uint8)][args[2].(*big.Int).Int64()]
+		if len(keys) == 0 {
+			return method.Outputs.Pack([32]byte{}, [32]byte{})
+		}
+		return method.Outputs.Pack(keys[0], keys[len(keys)-1])
+	case "orders":
+		orderKey := args[0].([32]byte)
+		order := b.orders[orderKey]
+		var next [32]byte
+		keys := b.queues[order.Side][order.Price.Int64()]
+		for i, key := range keys {
+			if key == orderKey && i+1 < len(keys) {
+				next = keys[i+1]
+			}
+		}
+		return method.Outputs.Pack(order, next)
+	case "filledAmount":
+		return method.Outputs.Pack(big.NewInt(b.filled[args[0].([32]byte)]))
+	}
+	return nil, errors.Errorf("unexpected method %s", method.Name)
+}
+
+func (b *fakeOrderBook) FilterLogs(ctx context.Context, q types.FilterQuery) ([]interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (b *fakeOrderBook) BlockTimeByNumber(context.Context, *big.Int) (uint64, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (b *fakeOrderBook) BlockTimesByNumber(context.Context, []*big.Int) ([]uint64, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (b *fakeOrderBook) Client() interface{} {
+	return nil
+}
+
+func (b *fakeOrderBook) CallContractByChain(ctx context.Context, param types.CallParam) (interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (b *fakeOrderBook) BlockNumber() (uint64, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (b *fakeOrderBook) BlockWithTxs(ctx context.Context, blockNumber uint64) (interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+func orderIdOf(key [32]byte) string {
+	return HexPrefix + hex.EncodeToString(key[:])
+}
+
+func TestReconcileChainOrders(t *testing.T) {
+	book := newFakeOrderBook()
+	cfg := &config.Config{ContractCfg: config.ContractCfg{DexAddress: "0x5560e1c2E0260c2274e400d80C30CDC4B92dC8ac"}}
+	s, err := New(context.Background(), cfg, nil, nil, book, 11155111, "sepolia", nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.s = s
+
+	collection := common.HexToAddress("0xc1")
+	maker := common.HexToAddress("0xa1")
+	now := int64(1000)
+	listing := func(tokenId, price int64, expiry uint64) fakeOrder {
+		return fakeOrder{Side: List, SaleKind: FixForItem, Maker: maker,
+			Nft: fakeAsset{big.NewInt(tokenId), collection, big.NewInt(1)}, Price: big.NewInt(price), Expiry: expiry}
+	}
+	k1 := book.add(1, listing(1, 100, 2000))
+	book.add(2, listing(2, 200, 900)) // 已过期
+	k3 := book.add(3, listing(3, 200, 2000))
+	b1 := book.add(4, fakeOrder{Side: Bid, SaleKind: FixForCollection, Maker: maker,
+		Nft: fakeAsset{big.NewInt(0), collection, big.NewInt(3)}, Price: big.NewInt(50), Expiry: 2000})
+	book.filled[b1] = 1
+
+	orders, err := s.chainOrders(collection.String(), 88, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 3 {
+		t.Fatalf("expected 3 chain orders, got %d", len(orders))
+	}
+	for _, key := range [][32]byte{k1, k3, b1} {
+		if _, ok := orders[orderIdOf(key)]; !ok {
+			t.Errorf("order %s not found", orderIdOf(key))
+		}
+	}
+	if order := orders[orderIdOf(b1)]; order.QuantityRemaining != 2 || order.Order.Nft.CollectionAddr != collection {
+		t.Errorf("unexpected bid: %+v", order)
+	}
+	for _, block := range book.blocks {
+		if block.Uint64() != 88 {
+			t.Fatalf("expected calls at block 88, got %s", block)
+		}
+	}
+	// 部分成交的出价通过multicall批量查询成交数量
+	if book.multicalls != 1 {
+		t.Errorf("expected 1 multicall, got %d", book.multicalls)
+	}
+
+	dbOrders := []*multi.Order{
+		{OrderID: orderIdOf(k1), Price: decimal.NewFromInt(100), QuantityRemaining: 1},
+		{OrderID: orderIdOf(b1), Price: decimal.NewFromInt(50), QuantityRemaining: 3},
+		{OrderID: orderIdOf([32]byte{31: 9}), Price: decimal.NewFromInt(10), QuantityRemaining: 1},
+	}
+	drifts := diffOrders(collection.String(), orders, dbOrders)
+	expected := []struct {
+		kind    string
+		orderId string
+	}{
+		{DriftExtra, orderIdOf([32]byte{31: 9})},
+		{DriftMispriced, orderIdOf(b1)},
+		{DriftMissing, orderIdOf(k3)},
+	}
+	if len(drifts) != len(expected) {
+		t.Fatalf("expected %d drifts, got %d", len(expected), len(drifts))
+	}
+	for i, e := range expected {
+		if drifts[i].Kind != e.kind || drifts[i].OrderID != e.orderId {
+			t.Errorf("unexpected drift %d: %+v", i, drifts[i])
+		}
+	}
+	if drifts[1].ChainQuantity != 2 || drifts[1].DBQuantity != 3 {
+		t.Errorf("unexpected mispriced drift: %+v", drifts[1])
+	}
+	if !drifts[2].ChainPrice.Equal(decimal.NewFromInt(200)) {
+		t.Errorf("unexpected missing drift price: %s", drifts[2].ChainPrice)
+	}
+}
+
+func TestRepairDriftsStaleSnapshot(t *testing.T) {
+	book := newFakeOrderBook()
+	cfg := &config.Config{ContractCfg: config.ContractCfg{DexAddress: "0x5560e1c2E0260c2274e400d80C30CDC4B92dC8ac"}}
+	db := newTestDB(t, "sepolia")
+	s, err := New(context.Background(), cfg, db, nil, book, 11155111, "sepolia", nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.s = s
+
+	extra := [32]byte{31: 1}
+	book.filled[extra] = 1
+	if err := db.Table(multi.OrderTableName("sepolia")).Create(&multi.Order{OrderID: orderIdOf(extra),
+		OrderType: multi.ItemBidOrder, OrderStatus: multi.OrderStatusActive, QuantityRemaining: 1, Size: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+	status := base.IndexedStatus{ChainId: 11155111, IndexType: EventIndexType, LastIndexedBlock: 101}
+	if err := db.Table(base.IndexedStatusTableName()).Create(&status).Error; err != nil {
+		t.Fatal(err)
+	}
+	drifts := []*OrderDrift{{Kind: DriftExtra, OrderID: orderIdOf(extra)}}
+
+	// 快照之后已同步新的区块，放弃修复
+	if _, err := s.repairDrifts(drifts, nil, 99); !errors.Is(err, ErrSnapshotStale) {
+		t.Fatalf("expected stale snapshot, got %v", err)
+	}
+	var order multi.Order
+	if err := db.Table(multi.OrderTableName("sepolia")).Where("order_id = ?", orderIdOf(extra)).Take(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.OrderStatus != multi.OrderStatusActive {
+		t.Errorf("order repaired with stale snapshot: %+v", order)
+	}
+
+	repaired, err := s.repairDrifts(drifts, nil, 100)
+	if err != nil || repaired != 1 {
+		t.Fatalf("failed on repair: %d, %v", repaired, err)
+	}
+	if err := db.Table(multi.OrderTableName("sepolia")).Where("order_id = ?", orderIdOf(extra)).Take(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.OrderStatus != multi.OrderStatusFilled || order.QuantityRemaining != 0 {
+		t.Errorf("unexpected repaired order: %+v", order)
+	}
+}
@@ -0,0 +1,80 @@
+package orderbookindexer
+
+import (
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethereumTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// EventHandler 处理单条订单簿合约日志，在区块范围的数据库事务内执行
+type EventHandler func(tx *gorm.DB, log ethereumTypes.Log) error
+
+// eventRegistry 事件topic到处理函数的映射，topic由ABI中的事件签名计算，避免ABI变更后硬编码的topic失效
+type eventRegistry struct {
+	parsedAbi abi.ABI
+	handlers  map[common.Hash]EventHandler
+	names     map[common.Hash]string
+}
+
+func newEventRegistry(parsedAbi abi.ABI) *eventRegistry {
+	return &eventRegistry{
+		parsedAbi: parsedAbi,
+		handlers:  make(map[common.Hash]EventHandler),
+		names:     make(map[common.Hash]string),
+	}
+}
+
+// Register 注册事件处理函数，事件必须在ABI中声明且不能重复注册
+func (r *eventRegistry) Register(eventName string, handler EventHandler) error {
+	event, ok := r.parsedAbi.Events[eventName]
+	if !ok {
+		return errors.Errorf("event %s not found in abi", eventName)
+	}
+	if _, ok := r.handlers[event.ID]; ok {
+		return errors.Errorf("duplicate handler for event %s", eventName)
+	}
+
+	r.handlers[event.ID] = handler
+	r.names[event.ID] = eventName
+	return nil
+}
+
+// Handler 根据日志的topic[0]查找处理函数，未注册的事件返回false
+func (r *eventRegistry) Handler(topic common.Hash) (EventHandler, string, bool) {
+	handler, ok := r.handlers[topic]
+	return handler, r.names[topic], ok
+}
+
+// registerEventHandlers 注册订单簿合约事件的处理函数，支持新的合约版本时在此注册对应事件即可
+func (s *Service) registerEventHandlers() error {
+	handlers := []struct {
+		name    string
+		handler EventHandler
+	}{
+		{"LogMake", s.handleMakeEvent},
+		{"LogCancel", s.handleCancelEvent},
+		{"LogMatch", s.handleMatchEvent},
+		{"LogSkipOrder", s.handleSkipOrderEvent},
+		{"LogWithdrawETH", s.handleWithdrawETHEvent},
+		{"LogUpdatedProtocolShare", s.handleUpdatedProtocolShareEvent},
+		{"BatchMatchInnerError", s.handleBatchMatchInnerErrorEvent},
+		{"Paused", func(tx *gorm.DB, log ethereumTypes.Log) error {
+			return s.handlePauseEvent(tx, log, multi.ContractEventPaused)
+		}},
+		{"Unpaused", func(tx *gorm.DB, log ethereumTypes.Log) error {
+			return s.handlePauseEvent(tx, log, multi.ContractEventUnpaused)
+		}},
+	}
+
+	s.registry = newEventRegistry(s.parsedAbi)
+	for _, h := range handlers {
+		if err := s.registry.Register(h.name, h.handler); err != nil {
+			return errors.Wrap(err, "failed on register event handler")
+		}
+	}
+
+	return nil
+}
@@ -6,16 +6,20 @@ import (
 	"fmt"
 	"math/big"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ProjectsTask/EasySwapBase/chain/chainclient"
+	"github.com/ProjectsTask/EasySwapBase/chain/nftchainservice"
 	"github.com/ProjectsTask/EasySwapBase/chain/types"
+	"github.com/ProjectsTask/EasySwapBase/currency"
 	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
 	"github.com/ProjectsTask/EasySwapBase/ordermanager"
 	"github.com/ProjectsTask/EasySwapBase/stores/gdb"
 	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/base"
 	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
 	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	ethereumTypes "github.com/ethereum/go-ethereum/core/types"
@@ -26,23 +30,25 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
+	"github.com/ProjectsTask/EasySwapSync/service/collectionfilter"
 	"github.com/ProjectsTask/EasySwapSync/service/comm"
 	"github.com/ProjectsTask/EasySwapSync/service/config"
+	"github.com/ProjectsTask/EasySwapSync/service/tradestats"
 )
 
 const (
 	EventIndexType   = 6
 	SleepInterval    = 10 // in seconds
 	SyncBlockPeriod  = 10
-	LogMakeTopic     = "0xfc37f2ff950f95913eb7182357ba3c14df60ef354bc7d6ab1ba2815f249fffe6"
-	LogCancelTopic   = "0x0ac8bb53fac566d7afc05d8b4df11d7690a7b27bdc40b54e4060f9b21fb849bd"
-	LogMatchTopic    = "0xf629aecab94607bc43ce4aebd564bf6e61c7327226a797b002de724b9944b20e"
 	contractAbi      = `[{"inputs":[],"name":"CannotFindNextEmptyKey","type":"error"},{"inputs":[],"name":"CannotFindPrevEmptyKey","type":"error"},{"inputs":[{"internalType":"OrderKey","name":"orderKey","type":"bytes32"}],"name":"CannotInsertDuplicateOrder","type":"error"},{"inputs":[],"name":"CannotInsertEmptyKey","type":"error"},{"inputs":[],"name":"CannotInsertExistingKey","type":"error"},{"inputs":[],"name":"CannotRemoveEmptyKey","type":"error"},{"inputs":[],"name":"CannotRemoveMissingKey","type":"error"},{"inputs":[],"name":"EnforcedPause","type":"error"},{"inputs":[],"name":"ExpectedPause","type":"error"},{"inputs":[],"name":"InvalidInitialization","type":"error"},{"inputs":[],"name":"NotInitializing","type":"error"},{"inputs":[{"internalType":"address","name":"owner","type":"address"}],"name":"OwnableInvalidOwner","type":"error"},{"inputs":[{"internalType":"address","name":"account","type":"address"}],"name":"OwnableUnauthorizedAccount","type":"error"},{"inputs":[],"name":"ReentrancyGuardReentrantCall","type":"error"},{"anonymous":false,"inputs":[{"indexed":false,"internalType":"uint256","name":"offset","type":"uint256"},{"indexed":false,"internalType":"bytes","name":"msg","type":"bytes"}],"name":"BatchMatchInnerError","type":"event"},{"anonymous":false,"inputs":[],"name":"EIP712DomainChanged","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"internalType":"uint64","name":"version","type":"uint64"}],"name":"Initialized","type":"event"},{"anonymous":false,"inputs":[{"indexed":true,"internalType":"OrderKey","name":"orderKey","type":"bytes32"},{"indexed":true,"internalType":"address","name":"maker","type":"address"}],"name":"LogCancel","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"internalType":"OrderKey","name":"orderKey","type":"bytes32"},{"indexed":true,"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"indexed":true,"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"indexed":true,"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"indexed":false,"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"indexed":false,"internalType":"Price","name":"price","type":"uint128"},{"indexed":false,"internalType":"uint64","name":"expiry","type":"uint64"},{"indexed":false,"internalType":"uint64","name":"salt","type":"uint64"}],"name":"LogMake","type":"event"},{"anonymous":false,"inputs":[{"indexed":true,"internalType":"OrderKey","name":"makeOrderKey","type":"bytes32"},{"indexed":true,"internalType":"OrderKey","name":"takeOrderKey","type":"bytes32"},{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"indexed":false,"internalType":"structLibOrder.Order","name":"makeOrder","type":"tuple"},{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"indexed":false,"internalType":"structLibOrder.Order","name":"takeOrder","type":"tuple"},{"indexed":false,"internalType":"uint128","name":"fillPrice","type":"uint128"}],"name":"LogMatch","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"internalType":"OrderKey","name":"orderKey","type":"bytes32"},{"indexed":false,"internalType":"uint64","name":"salt","type":"uint64"}],"name":"LogSkipOrder","type":"event"},{"anonymous":false,"inputs":[{"indexed":true,"internalType":"uint128","name":"newProtocolShare","type":"uint128"}],"name":"LogUpdatedProtocolShare","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"internalType":"address","name":"recipient","type":"address"},{"indexed":false,"internalType":"uint256","name":"amount","type":"uint256"}],"name":"LogWithdrawETH","type":"event"},{"anonymous":false,"inputs":[{"indexed":true,"internalType":"address","name":"previousOwner","type":"address"},{"indexed":true,"internalType":"address","name":"newOwner","type":"address"}],"name":"OwnershipTransferred","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"internalType":"address","name":"account","type":"address"}],"name":"Paused","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"internalType":"address","name":"account","type":"address"}],"name":"Unpaused","type":"event"},{"inputs":[{"internalType":"OrderKey[]","name":"orderKeys","type":"bytes32[]"}],"name":"cancelOrders","outputs":[{"internalType":"bool[]","name":"successes","type":"bool[]"}],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"components":[{"internalType":"OrderKey","name":"oldOrderKey","type":"bytes32"},{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"internalType":"structLibOrder.Order","name":"newOrder","type":"tuple"}],"internalType":"structLibOrder.EditDetail[]","name":"editDetails","type":"tuple[]"}],"name":"editOrders","outputs":[{"internalType":"OrderKey[]","name":"newOrderKeys","type":"bytes32[]"}],"stateMutability":"payable","type":"function"},{"inputs":[],"name":"eip712Domain","outputs":[{"internalType":"bytes1","name":"fields","type":"bytes1"},{"internalType":"string","name":"name","type":"string"},{"internalType":"string","name":"version","type":"string"},{"internalType":"uint256","name":"chainId","type":"uint256"},{"internalType":"address","name":"verifyingContract","type":"address"},{"internalType":"bytes32","name":"salt","type":"bytes32"},{"internalType":"uint256[]","name":"extensions","type":"uint256[]"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"OrderKey","name":"","type":"bytes32"}],"name":"filledAmount","outputs":[{"internalType":"uint256","name":"","type":"uint256"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"}],"name":"getBestOrder","outputs":[{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"internalType":"structLibOrder.Order","name":"orderResult","type":"tuple"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"address","name":"collection","type":"address"},{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"}],"name":"getBestPrice","outputs":[{"internalType":"Price","name":"price","type":"uint128"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"address","name":"collection","type":"address"},{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"Price","name":"price","type":"uint128"}],"name":"getNextBestPrice","outputs":[{"internalType":"Price","name":"nextBestPrice","type":"uint128"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"uint256","name":"count","type":"uint256"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"OrderKey","name":"firstOrderKey","type":"bytes32"}],"name":"getOrders","outputs":[{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"internalType":"structLibOrder.Order[]","name":"resultOrders","type":"tuple[]"},{"internalType":"OrderKey","name":"nextOrderKey","type":"bytes32"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"uint128","name":"newProtocolShare","type":"uint128"},{"internalType":"address","name":"newVault","type":"address"},{"internalType":"string","name":"EIP712Name","type":"string"},{"internalType":"string","name":"EIP712Version","type":"string"}],"name":"initialize","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"internalType":"structLibOrder.Order[]","name":"newOrders","type":"tuple[]"}],"name":"makeOrders","outputs":[{"internalType":"OrderKey[]","name":"newOrderKeys","type":"bytes32[]"}],"stateMutability":"payable","type":"function"},{"inputs":[{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"internalType":"structLibOrder.Order","name":"sellOrder","type":"tuple"},{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"internalType":"structLibOrder.Order","name":"buyOrder","type":"tuple"}],"name":"matchOrder","outputs":[],"stateMutability":"payable","type":"function"},{"inputs":[{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"internalType":"structLibOrder.Order","name":"sellOrder","type":"tuple"},{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"internalType":"structLibOrder.Order","name":"buyOrder","type":"tuple"},{"internalType":"uint256","name":"msgValue","type":"uint256"}],"name":"matchOrderWithoutPayback","outputs":[{"internalType":"uint128","name":"costValue","type":"uint128"}],"stateMutability":"payable","type":"function"},{"inputs":[{"components":[{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"internalType":"structLibOrder.Order","name":"sellOrder","type":"tuple"},{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"internalType":"structLibOrder.Order","name":"buyOrder","type":"tuple"}],"internalType":"structLibOrder.MatchDetail[]","name":"matchDetails","type":"tuple[]"}],"name":"matchOrders","outputs":[{"internalType":"bool[]","name":"successes","type":"bool[]"}],"stateMutability":"payable","type":"function"},{"inputs":[{"internalType":"address","name":"","type":"address"},{"internalType":"enumLibOrder.Side","name":"","type":"uint8"},{"internalType":"Price","name":"","type":"uint128"}],"name":"orderQueues","outputs":[{"internalType":"OrderKey","name":"head","type":"bytes32"},{"internalType":"OrderKey","name":"tail","type":"bytes32"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"OrderKey","name":"","type":"bytes32"}],"name":"orders","outputs":[{"components":[{"internalType":"enumLibOrder.Side","name":"side","type":"uint8"},{"internalType":"enumLibOrder.SaleKind","name":"saleKind","type":"uint8"},{"internalType":"address","name":"maker","type":"address"},{"components":[{"internalType":"uint256","name":"tokenId","type":"uint256"},{"internalType":"address","name":"collection","type":"address"},{"internalType":"uint96","name":"amount","type":"uint96"}],"internalType":"structLibOrder.Asset","name":"nft","type":"tuple"},{"internalType":"Price","name":"price","type":"uint128"},{"internalType":"uint64","name":"expiry","type":"uint64"},{"internalType":"uint64","name":"salt","type":"uint64"}],"internalType":"structLibOrder.Order","name":"order","type":"tuple"},{"internalType":"OrderKey","name":"next","type":"bytes32"}],"stateMutability":"view","type":"function"},{"inputs":[],"name":"owner","outputs":[{"internalType":"address","name":"","type":"address"}],"stateMutability":"view","type":"function"},{"inputs":[],"name":"pause","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[],"name":"paused","outputs":[{"internalType":"bool","name":"","type":"bool"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"address","name":"","type":"address"},{"internalType":"enumLibOrder.Side","name":"","type":"uint8"}],"name":"priceTrees","outputs":[{"internalType":"Price","name":"root","type":"uint128"}],"stateMutability":"view","type":"function"},{"inputs":[],"name":"protocolShare","outputs":[{"internalType":"uint128","name":"","type":"uint128"}],"stateMutability":"view","type":"function"},{"inputs":[],"name":"renounceOwnership","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"uint128","name":"newProtocolShare","type":"uint128"}],"name":"setProtocolShare","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"address","name":"newVault","type":"address"}],"name":"setVault","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"address","name":"newOwner","type":"address"}],"name":"transferOwnership","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[],"name":"unpause","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"address","name":"recipient","type":"address"},{"internalType":"uint256","name":"amount","type":"uint256"}],"name":"withdrawETH","outputs":[],"stateMutability":"nonpayable","type":"function"},{"stateMutability":"payable","type":"receive"}]`
 	FixForCollection = 0
 	FixForItem       = 1
 	List             = 0
 	Bid              = 1
 
+	// 每次撮合成交的NFT数量
+	matchQuantity = 1
+
 	HexPrefix   = "0x"
 	ZeroAddress = "0x0000000000000000000000000000000000000000"
 )
@@ -71,6 +77,18 @@ type Service struct {
 	chainId      int64
 	chain        string
 	parsedAbi    abi.ABI
+	registry     *eventRegistry
+
+	nodeSrv          *nftchainservice.Service
+	collectionFilter *collectionfilter.Filter
+	tradeStats       *tradestats.Aggregator
+	outboxMux        sync.Mutex
+
+	// Stop时取消ctx，各同步流程退出后Stop返回
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// 区块范围的事务及outbox投递不随ctx取消而中断，保证已开始的同步完整提交
+	writeCtx context.Context
 }
 
 var MultiChainMaxBlockDifference = map[string]uint64{
@@ -82,10 +100,18 @@ var MultiChainMaxBlockDifference = map[string]uint64{
 	"zksync-era": 2,
 }
 
-func New(ctx context.Context, cfg *config.Config, db *gorm.DB, xkv *xkv.Store, chainClient chainclient.ChainClient, chainId int64, chain string, orderManager *ordermanager.OrderManager) *Service {
-	parsedAbi, _ := abi.JSON(strings.NewReader(contractAbi)) // 通过ABI实例化
-	return &Service{
-		ctx:          ctx,
+func New(ctx context.Context, cfg *config.Config, db *gorm.DB, xkv *xkv.Store, chainClient chainclient.ChainClient, chainId int64, chain string, orderManager *ordermanager.OrderManager,
+	nodeSrv *nftchainservice.Service, collectionFilter *collectionfilter.Filter, tradeStats *tradestats.Aggregator) (*Service, error) {
+	parsedAbi, err := abi.JSON(strings.NewReader(contractAbi)) // 通过ABI实例化
+	if err != nil {
+		return nil, errors.Wrap(err, "failed on parse orderbook abi")
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	s := &Service{
+		ctx:          runCtx,
+		cancel:       cancel,
+		writeCtx:     context.WithoutCancel(ctx),
 		cfg:          cfg,
 		db:           db,
 		kv:           xkv,
@@ -94,12 +120,74 @@ func New(ctx context.Context, cfg *config.Config, db *gorm.DB, xkv *xkv.Store, c
 		chain:        chain,
 		chainId:      chainId,
 		parsedAbi:    parsedAbi,
+
+		nodeSrv:          nodeSrv,
+		collectionFilter: collectionFilter,
+		tradeStats:       tradeStats,
+	}
+	// 启动时校验所有处理函数对应的事件都在ABI中声明
+	if err := s.registerEventHandlers(); err != nil {
+		return nil, err
 	}
+
+	return s, nil
+}
+
+// confirmations 同步时与最新区块保持的确认区块数，优先使用链配置
+func (s *Service) confirmations() uint64 {
+	if s.cfg.ChainCfg.Confirmations > 0 {
+		return s.cfg.ChainCfg.Confirmations
+	}
+
+	return MultiChainMaxBlockDifference[s.chain]
 }
 
 func (s *Service) Start() {
-	threading.GoSafe(s.SyncOrderBookEventLoop)
-	threading.GoSafe(s.UpKeepingCollectionFloorChangeLoop)
+	s.goSafe(s.SyncOrderBookEventLoop)
+	s.goSafe(s.SyncNFTTransferEventLoop)
+	s.goSafe(s.UpKeepingCollectionFloorChangeLoop)
+	s.goSafe(s.ReconcileLoop)
+}
+
+// goSafe 启动同步流程并计入wg，Stop时等待其退出
+func (s *Service) goSafe(fn func()) {
+	s.wg.Add(1)
+	threading.GoSafe(func() {
+		defer s.wg.Done()
+		fn()
+	})
+}
+
+// Stop 通知所有同步流程退出，等待正在提交的区块范围完成后投递outbox中剩余的事件，超时返回错误。
+// 投递失败的事件保留在outbox中，下次启动时投递
+func (s *Service) Stop(timeout time.Duration) error {
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		return errors.Errorf("orderbook indexer stop timeout after %s", timeout)
+	}
+
+	if err := s.flushOutbox(); err != nil {
+		return errors.Wrap(err, "failed on flush outbox")
+	}
+	return nil
+}
+
+// sleep 等待d或ctx取消，ctx取消时返回false
+func (s *Service) sleep(d time.Duration) bool {
+	select {
+	case <-s.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
 }
 
 func (s *Service) SyncOrderBookEventLoop() {
@@ -112,6 +200,11 @@ func (s *Service) SyncOrderBookEventLoop() {
 		return
 	}
 
+	// 投递上次退出前已提交但未投递的副作用事件
+	if err := s.flushOutbox(); err != nil {
+		xzap.WithContext(s.ctx).Error("failed on flush outbox", zap.Error(err))
+	}
+
 	lastSyncBlock := uint64(indexedStatus.LastIndexedBlock)
 	for {
 		select {
@@ -124,19 +217,19 @@ func (s *Service) SyncOrderBookEventLoop() {
 		currentBlockNum, err := s.chainClient.BlockNumber() // 以轮询的方式获取当前区块高度
 		if err != nil {
 			xzap.WithContext(s.ctx).Error("failed on get current block number", zap.Error(err))
-			time.Sleep(SleepInterval * time.Second)
+			s.sleep(SleepInterval * time.Second)
 			continue
 		}
 
-		if lastSyncBlock > currentBlockNum-MultiChainMaxBlockDifference[s.chain] { // 如果上次同步的区块高度大于当前区块高度，等待一段时间后再次轮询
-			time.Sleep(SleepInterval * time.Second)
+		if lastSyncBlock > currentBlockNum-s.confirmations() { // 如果上次同步的区块高度大于当前区块高度，等待一段时间后再次轮询
+			s.sleep(SleepInterval * time.Second)
 			continue
 		}
 
 		startBlock := lastSyncBlock
 		endBlock := startBlock + SyncBlockPeriod
-		if endBlock > currentBlockNum-MultiChainMaxBlockDifference[s.chain] { // 如果结束区块高度大于当前区块高度，将结束区块高度设置为当前区块高度
-			endBlock = currentBlockNum - MultiChainMaxBlockDifference[s.chain]
+		if endBlock > currentBlockNum-s.confirmations() { // 如果结束区块高度大于当前区块高度，将结束区块高度设置为当前区块高度
+			endBlock = currentBlockNum - s.confirmations()
 		}
 
 		query := types.FilterQuery{
@@ -148,31 +241,35 @@ func (s *Service) SyncOrderBookEventLoop() {
 		logs, err := s.chainClient.FilterLogs(s.ctx, query) //同时获取多个（SyncBlockPeriod）区块的日志
 		if err != nil {
 			xzap.WithContext(s.ctx).Error("failed on get log", zap.Error(err))
-			time.Sleep(SleepInterval * time.Second)
+			s.sleep(SleepInterval * time.Second)
 			continue
 		}
 
-		for _, log := range logs { // 遍历日志，根据不同的topic处理不同的事件
-			ethLog := log.(ethereumTypes.Log)
-			switch ethLog.Topics[0].String() {
-			case LogMakeTopic:
-				s.handleMakeEvent(ethLog)
-			case LogCancelTopic:
-				s.handleCancelEvent(ethLog)
-			case LogMatchTopic:
-				s.handleMatchEvent(ethLog)
-			default:
-			}
+		// 节点查询在事务外完成，避免RPC延迟占用数据库事务
+		blockTimes, err := s.logBlockTimes(logs)
+		if err != nil {
+			xzap.WithContext(s.ctx).Error("failed on get block times", zap.Error(err))
+			s.sleep(SleepInterval * time.Second)
+			continue
 		}
+		fills := s.buyOrderFills(logs)
 
-		lastSyncBlock = endBlock + 1 // 更新最后同步的区块高度
-		if err := s.db.WithContext(s.ctx).Table(base.IndexedStatusTableName()).
-			Where("chain_id = ? and index_type = ?", s.chainId, EventIndexType).
-			Update("last_indexed_block", lastSyncBlock).Error; err != nil {
-			xzap.WithContext(s.ctx).Error("failed on update orderbook event sync block number",
+		// 事件处理与同步高度在同一事务中提交，失败时整段区块重新同步
+		if err := s.applyBlockRange(logs, blockTimes, endBlock+1, fills); err != nil {
+			xzap.WithContext(s.ctx).Error("failed on apply orderbook events",
+				zap.Uint64("start_block", startBlock),
+				zap.Uint64("end_block", endBlock),
 				zap.Error(err))
-			return
+			s.sleep(SleepInterval * time.Second)
+			continue
+		}
+		lastSyncBlock = endBlock + 1 // 更新最后同步的区块高度
+
+		// 事务提交后再投递副作用事件
+		if err := s.flushOutbox(); err != nil {
+			xzap.WithContext(s.ctx).Error("failed on flush outbox", zap.Error(err))
 		}
+		s.refreshTradeStats()
 
 		xzap.WithContext(s.ctx).Info("sync orderbook event ...",
 			zap.Uint64("start_block", startBlock),
@@ -180,8 +277,55 @@ func (s *Service) SyncOrderBookEventLoop() {
 	}
 }
 
+// refreshTradeStats 重新计算同步事务中有新成交的collection的成交统计，失败时等待下次同步或定期刷新
+func (s *Service) refreshTradeStats() {
+	if s.tradeStats == nil {
+		return
+	}
+	if err := s.tradeStats.RefreshDirty(); err != nil {
+		xzap.WithContext(s.ctx).Error("failed on refresh collection trade stats", zap.Error(err))
+	}
+}
+
+// applyBlockRange 在一个数据库事务内处理区块范围内的所有日志，并更新ob_indexed_status。
+// blockTimes、fills为事务外查询到的区块时间及买单链上成交数量，fills在事件处理后校正买单的剩余数量
+func (s *Service) applyBlockRange(logs []interface{}, blockTimes map[uint64]uint64, nextSyncBlock uint64, fills map[string]int64) error {
+	return s.db.WithContext(s.writeCtx).Transaction(func(tx *gorm.DB) error {
+		// 先归档原始日志，处理函数从归档中读取区块时间
+		if err := s.archiveLogs(tx, logs, blockTimes); err != nil {
+			return errors.Wrap(err, "failed on archive logs")
+		}
+
+		for _, log := range logs { // 遍历日志，根据不同的topic处理不同的事件
+			ethLog := log.(ethereumTypes.Log)
+			if len(ethLog.Topics) == 0 {
+				continue
+			}
+			handler, eventName, ok := s.registry.Handler(ethLog.Topics[0])
+			if !ok { // 未注册的事件(如OwnershipTransferred)无需处理
+				continue
+			}
+			if err := handler(tx, ethLog); err != nil {
+				return errors.Wrapf(err, "failed on handle %s log, tx: %s, index: %d", eventName, ethLog.TxHash.String(), ethLog.Index)
+			}
+		}
+
+		if err := s.applyBuyOrderFills(tx, fills); err != nil {
+			return errors.Wrap(err, "failed on apply buy order fills")
+		}
+
+		if err := tx.Table(base.IndexedStatusTableName()).
+			Where("chain_id = ? and index_type = ?", s.chainId, EventIndexType).
+			Update("last_indexed_block", nextSyncBlock).Error; err != nil {
+			return errors.Wrap(err, "failed on update orderbook event sync block number")
+		}
+
+		return nil
+	})
+}
+
 // 处理挂单事件
-func (s *Service) handleMakeEvent(log ethereumTypes.Log) {
+func (s *Service) handleMakeEvent(tx *gorm.DB, log ethereumTypes.Log) error {
 	var event struct {
 		OrderKey [32]byte
 		Nft      struct {
@@ -197,8 +341,9 @@ func (s *Service) handleMakeEvent(log ethereumTypes.Log) {
 	// Unpack data
 	err := s.parsedAbi.UnpackIntoInterface(&event, "LogMake", log.Data) // 通过ABI解析日志数据
 	if err != nil {
+		// 无法解析的日志重试也无法成功，跳过
 		xzap.WithContext(s.ctx).Error("Error unpacking LogMake event:", zap.Error(err))
-		return
+		return nil
 	}
 	// Extract indexed fields from topics
 	side := uint8(new(big.Int).SetBytes(log.Topics[1].Bytes()).Uint64())
@@ -215,15 +360,19 @@ func (s *Service) handleMakeEvent(log ethereumTypes.Log) {
 	} else { // 卖单
 		orderType = multi.ListingOrder
 	}
+	blockTime, err := s.blockTime(tx, log.BlockNumber)
+	if err != nil {
+		return errors.Wrap(err, "failed to get block time")
+	}
 	newOrder := multi.Order{
 		CollectionAddress: event.Nft.CollectionAddr.String(),
 		MarketplaceId:     multi.MarketOrderBook,
 		TokenId:           event.Nft.TokenId.String(),
 		OrderID:           HexPrefix + hex.EncodeToString(event.OrderKey[:]),
 		OrderStatus:       multi.OrderStatusActive,
-		EventTime:         time.Now().Unix(),
+		EventTime:         int64(blockTime), // 使用区块时间，重放时结果一致
 		ExpireTime:        int64(event.Expiry),
-		CurrencyAddress:   s.cfg.ContractCfg.EthAddress,
+		CurrencyAddress:   s.currencyAddress(),
 		Price:             decimal.NewFromBigInt(event.Price, 0),
 		Maker:             maker.String(),
 		Taker:             ZeroAddress,
@@ -232,16 +381,10 @@ func (s *Service) handleMakeEvent(log ethereumTypes.Log) {
 		OrderType:         orderType,
 		Salt:              int64(event.Salt),
 	}
-	if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).Clauses(clause.OnConflict{
+	if err := tx.Table(multi.OrderTableName(s.chain)).Clauses(clause.OnConflict{
 		DoNothing: true,
 	}).Create(&newOrder).Error; err != nil { // 将订单信息存入数据库
-		xzap.WithContext(s.ctx).Error("failed on create order",
-			zap.Error(err))
-	}
-	blockTime, err := s.chainClient.BlockTimeByNumber(s.ctx, big.NewInt(int64(log.BlockNumber)))
-	if err != nil {
-		xzap.WithContext(s.ctx).Error("failed to get block time", zap.Error(err))
-		return
+		return errors.Wrap(err, "failed on create order")
 	}
 	var activityType int
 	if side == Bid {
@@ -260,44 +403,64 @@ func (s *Service) handleMakeEvent(log ethereumTypes.Log) {
 		MarketplaceID:     multi.MarketOrderBook,
 		CollectionAddress: event.Nft.CollectionAddr.String(),
 		TokenId:           event.Nft.TokenId.String(),
-		CurrencyAddress:   s.cfg.ContractCfg.EthAddress,
+		CurrencyAddress:   newOrder.CurrencyAddress,
 		Price:             decimal.NewFromBigInt(event.Price, 0),
+		Quantity:          event.Nft.Amount.Int64(),
 		BlockNumber:       int64(log.BlockNumber),
 		TxHash:            log.TxHash.String(),
 		EventTime:         int64(blockTime),
 	}
-	if err := s.db.WithContext(s.ctx).Table(multi.ActivityTableName(s.chain)).Clauses(clause.OnConflict{
+	if err := tx.Table(multi.ActivityTableName(s.chain)).Clauses(clause.OnConflict{
 		DoNothing: true,
 	}).Create(&newActivity).Error; err != nil {
-		xzap.WithContext(s.ctx).Warn("failed on create activity",
-			zap.Error(err))
+		return errors.Wrap(err, "failed on create activity")
 	}
 
-	if err := s.orderManager.AddToOrderManagerQueue(&multi.Order{ // 将订单信息存入订单管理队列
+	if err := s.enqueueOrder(tx, &multi.Order{ // 将订单信息存入订单管理队列
 		ExpireTime:        newOrder.ExpireTime,
 		OrderID:           newOrder.OrderID,
 		CollectionAddress: newOrder.CollectionAddress,
 		TokenId:           newOrder.TokenId,
 		Price:             newOrder.Price,
 		Maker:             newOrder.Maker,
+		CurrencyAddress:   newOrder.CurrencyAddress,
+		OrderType:         newOrder.OrderType,
 	}); err != nil {
-		xzap.WithContext(s.ctx).Error("failed on add order to manager queue",
-			zap.Error(err),
-			zap.String("order_id", newOrder.OrderID))
+		return errors.Wrap(err, "failed on add order to manager queue")
 	}
+
+	return nil
 }
 
-func (s *Service) handleMatchEvent(log ethereumTypes.Log) {
-	var event struct {
-		MakeOrder Order
-		TakeOrder Order
-		FillPrice *big.Int
+// matchEvent LogMatch事件的非indexed字段
+type matchEvent struct {
+	MakeOrder Order
+	TakeOrder Order
+	FillPrice *big.Int
+}
+
+// buyOrder 返回撮合中的买单id及买单，makeOrderId/takeOrderId分别来自topic[1]/topic[2]
+func (e *matchEvent) buyOrder(makeOrderId, takeOrderId string) (string, Order) {
+	if e.MakeOrder.Side == Bid {
+		return makeOrderId, e.MakeOrder
 	}
 
+	return takeOrderId, e.TakeOrder
+}
+
+// buyOrderRemoved 买方发起撮合时合约先发出买单(makeOrder为买单)，已存储的买单成交一个后即从订单簿移除，
+// 剩余数量不能再成交
+func (e *matchEvent) buyOrderRemoved() bool {
+	return e.MakeOrder.Side == Bid
+}
+
+func (s *Service) handleMatchEvent(tx *gorm.DB, log ethereumTypes.Log) error {
+	var event matchEvent
+
 	err := s.parsedAbi.UnpackIntoInterface(&event, "LogMatch", log.Data)
 	if err != nil {
 		xzap.WithContext(s.ctx).Error("Error unpacking LogMatch event:", zap.Error(err))
-		return
+		return nil
 	}
 
 	makeOrderId := HexPrefix + hex.EncodeToString(log.Topics[1].Bytes()) // 通过topic获取订单ID
@@ -308,179 +471,307 @@ func (s *Service) handleMatchEvent(log ethereumTypes.Log) {
 	var from string
 	var to string
 	var sellOrderId string
-	var buyOrder multi.Order
-	if event.MakeOrder.Side == Bid { // 买单， 由卖方发起交易撮合
+	var buyOrderId string
+	if event.MakeOrder.Side == Bid { // 买单， 由买方发起交易撮合
 		owner = strings.ToLower(event.MakeOrder.Maker.String())
 		collection = event.TakeOrder.Nft.CollectionAddr.String()
 		tokenId = event.TakeOrder.Nft.TokenId.String()
 		from = event.TakeOrder.Maker.String()
 		to = event.MakeOrder.Maker.String()
 		sellOrderId = takeOrderId
-
-		// 更新卖方订单状态
-		if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
-			Where("order_id = ?", takeOrderId).
-			Updates(map[string]interface{}{
-				"order_status":       multi.OrderStatusFilled,
-				"quantity_remaining": 0,
-				"taker":              to,
-			}).Error; err != nil {
-			xzap.WithContext(s.ctx).Error("failed on update order status",
-				zap.String("order_id", takeOrderId))
-			return
-		}
-
-		// 查询买方订单信息，不存在则无需更新，说明不是从平台前端发起的交易
-		if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
-			Where("order_id = ?", makeOrderId).
-			First(&buyOrder).Error; err != nil {
-			xzap.WithContext(s.ctx).Error("failed on get buy order",
-				zap.Error(err))
-			return
-		}
-		// 更新买方订单的剩余数量
-		if buyOrder.QuantityRemaining > 1 {
-			if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
-				Where("order_id = ?", makeOrderId).
-				Update("quantity_remaining", buyOrder.QuantityRemaining-1).Error; err != nil {
-				xzap.WithContext(s.ctx).Error("failed on update order quantity_remaining",
-					zap.String("order_id", makeOrderId))
-				return
-			}
-		} else {
-			if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
-				Where("order_id = ?", makeOrderId).
-				Updates(map[string]interface{}{
-					"order_status":       multi.OrderStatusFilled,
-					"quantity_remaining": 0,
-				}).Error; err != nil {
-				xzap.WithContext(s.ctx).Error("failed on update order status",
-					zap.String("order_id", makeOrderId))
-				return
-			}
-		}
-	} else { // 卖单， 由买方发起交易撮合， 同理
+		buyOrderId = makeOrderId
+	} else { // 卖单， 由卖方发起交易撮合(接受出价)， 同理
 		owner = strings.ToLower(event.TakeOrder.Maker.String())
 		collection = event.MakeOrder.Nft.CollectionAddr.String()
 		tokenId = event.MakeOrder.Nft.TokenId.String()
 		from = event.MakeOrder.Maker.String()
 		to = event.TakeOrder.Maker.String()
 		sellOrderId = makeOrderId
+		buyOrderId = takeOrderId
+	}
 
-		if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
-			Where("order_id = ?", makeOrderId).
-			Updates(map[string]interface{}{
-				"order_status":       multi.OrderStatusFilled,
-				"quantity_remaining": 0,
-				"taker":              to,
-			}).Error; err != nil {
-			xzap.WithContext(s.ctx).Error("failed on update order status",
-				zap.String("order_id", makeOrderId))
-			return
-		}
+	// 更新卖方订单的剩余数量和状态
+	if err := s.applyOrderFill(tx, sellOrderId, List, to, false); err != nil {
+		return errors.Wrapf(err, "failed on update sell order, order_id: %s", sellOrderId)
+	}
 
-		if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
-			Where("order_id = ?", takeOrderId).
-			First(&buyOrder).Error; err != nil {
-			xzap.WithContext(s.ctx).Error("failed on get buy order",
-				zap.Error(err))
-			return
-		}
-		if buyOrder.QuantityRemaining > 1 {
-			if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
-				Where("order_id = ?", takeOrderId).
-				Update("quantity_remaining", buyOrder.QuantityRemaining-1).Error; err != nil {
-				xzap.WithContext(s.ctx).Error("failed on update order quantity_remaining",
-					zap.String("order_id", takeOrderId))
-				return
-			}
-		} else {
-			if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
-				Where("order_id = ?", takeOrderId).
-				Updates(map[string]interface{}{
-					"order_status":       multi.OrderStatusFilled,
-					"quantity_remaining": 0,
-				}).Error; err != nil {
-				xzap.WithContext(s.ctx).Error("failed on update order status",
-					zap.String("order_id", takeOrderId))
-				return
-			}
-		}
+	// 更新买方订单的剩余数量和状态，买方发起撮合时买单被合约移除
+	if err := s.applyOrderFill(tx, buyOrderId, Bid, "", event.buyOrderRemoved()); err != nil {
+		return errors.Wrapf(err, "failed on update buy order, order_id: %s", buyOrderId)
 	}
 
-	blockTime, err := s.chainClient.BlockTimeByNumber(s.ctx, big.NewInt(int64(log.BlockNumber)))
+	blockTime, err := s.blockTime(tx, log.BlockNumber)
 	if err != nil {
-		xzap.WithContext(s.ctx).Error("failed to get block time", zap.Error(err))
-		return
+		return errors.Wrap(err, "failed to get block time")
 	}
-	newActivity := multi.Activity{
+	newActivity := multi.Activity{ // 与Transfer活动一致，maker为卖方，taker为买方，与撮合方向无关
 		ActivityType:      multi.Sale,
-		Maker:             event.MakeOrder.Maker.String(),
-		Taker:             event.TakeOrder.Maker.String(),
+		Maker:             from,
+		Taker:             to,
 		MarketplaceID:     multi.MarketOrderBook,
 		CollectionAddress: collection,
 		TokenId:           tokenId,
-		CurrencyAddress:   s.cfg.ContractCfg.EthAddress,
+		CurrencyAddress:   s.currencyAddress(),
 		Price:             decimal.NewFromBigInt(event.FillPrice, 0),
+		Quantity:          matchQuantity,
 		BlockNumber:       int64(log.BlockNumber),
 		TxHash:            log.TxHash.String(),
 		EventTime:         int64(blockTime),
 	}
-	if err := s.db.WithContext(s.ctx).Table(multi.ActivityTableName(s.chain)).Clauses(clause.OnConflict{
+	if err := tx.Table(multi.ActivityTableName(s.chain)).Clauses(clause.OnConflict{
 		DoNothing: true,
 	}).Create(&newActivity).Error; err != nil {
-		xzap.WithContext(s.ctx).Warn("failed on create activity",
-			zap.Error(err))
+		return errors.Wrap(err, "failed on create activity")
+	}
+
+	// 标记collection有新成交，事务提交后重新计算成交统计
+	if s.tradeStats != nil {
+		s.tradeStats.MarkDirty(collection, int64(blockTime))
 	}
 
 	// 更新NFT的所有者
-	if err := s.db.WithContext(s.ctx).Table(multi.ItemTableName(s.chain)).
+	if err := tx.Table(multi.ItemTableName(s.chain)).
 		Where("collection_address = ? and token_id = ?", strings.ToLower(collection), tokenId).
 		Update("owner", owner).Error; err != nil {
-		xzap.WithContext(s.ctx).Error("failed to update item owner",
-			zap.Error(err))
-		return
+		return errors.Wrap(err, "failed to update item owner")
 	}
 
-	if err := ordermanager.AddUpdatePriceEvent(s.kv, &ordermanager.TradeEvent{ // 将交易信息存入价格更新队列
+	if err := s.enqueuePriceEvent(tx, &ordermanager.TradeEvent{ // 将交易信息存入价格更新队列
 		OrderId:        sellOrderId,
 		CollectionAddr: collection,
 		EventType:      ordermanager.Buy,
 		TokenID:        tokenId,
 		From:           from,
 		To:             to,
-	}, s.chain); err != nil {
-		xzap.WithContext(s.ctx).Error("failed on add update price event",
-			zap.Error(err),
-			zap.String("type", "sale"),
-			zap.String("order_id", sellOrderId))
+	}); err != nil {
+		return errors.Wrap(err, "failed on add update price event")
+	}
+
+	if err := s.enqueuePriceEvent(tx, &ordermanager.TradeEvent{ // 买单成交，更新最高出价
+		OrderId:        buyOrderId,
+		CollectionAddr: collection,
+		EventType:      ordermanager.BidFilled,
+		TokenID:        tokenId,
+		From:           to,
+	}); err != nil {
+		return errors.Wrap(err, "failed on add update best bid event")
+	}
+
+	return nil
+}
+
+// currencyAddress 订单簿合约的事件及订单中不包含币种，按合约配置的结算币种记录，未配置时为原生币
+func (s *Service) currencyAddress() string {
+	if s.cfg.ContractCfg.CurrencyAddress != "" {
+		return strings.ToLower(s.cfg.ContractCfg.CurrencyAddress)
+	}
+
+	return s.cfg.ContractCfg.EthAddress
+}
+
+// applyOrderFill 按合约的撮合规则更新订单的剩余数量：卖单整单成交，买单每次撮合成交matchQuantity个，
+// removed表示订单已从合约订单簿移除，无论剩余数量均不再有效。订单不存在时说明不是从平台发起的订单，无需更新
+func (s *Service) applyOrderFill(tx *gorm.DB, orderId string, side uint8, taker string, removed bool) error {
+	var order multi.Order
+	if err := tx.Table(multi.OrderTableName(s.chain)).
+		Where("order_id = ?", orderId).
+		First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return errors.Wrap(err, "failed on get order")
+	}
+
+	remaining := remainingAfterMatch(side, order.QuantityRemaining)
+	updates := map[string]interface{}{
+		"quantity_remaining": remaining,
+	}
+	if remaining == 0 || removed {
+		updates["order_status"] = multi.OrderStatusFilled
+	}
+	if taker != "" {
+		updates["taker"] = taker
+	}
+	if err := tx.Table(multi.OrderTableName(s.chain)).
+		Where("order_id = ?", orderId).
+		Updates(updates).Error; err != nil {
+		return errors.Wrap(err, "failed on update order quantity_remaining")
+	}
+
+	return nil
+}
+
+// remainingAfterMatch 合约撮合时将卖单的filledAmount直接置为订单数量，买单的filledAmount加1
+func remainingAfterMatch(side uint8, quantityRemaining int64) int64 {
+	if side != Bid {
+		return 0
+	}
+	if quantityRemaining <= matchQuantity {
+		return 0
+	}
+
+	return quantityRemaining - matchQuantity
+}
+
+// buyOrderFills 在事务外查询区块范围内撮合的多数量买单在其最后一次撮合区块的链上累计成交数量。
+// 历史状态查询需要归档节点，查询失败的订单不在结果中，剩余数量以按事件推导的结果为准。
+// 被合约移除的买单已关闭，不需要校正
+func (s *Service) buyOrderFills(logs []interface{}) map[string]int64 {
+	matchTopic := s.parsedAbi.Events["LogMatch"].ID
+	lastMatchBlock := make(map[string]uint64)
+	removed := make(map[string]bool)
+	for _, log := range logs {
+		ethLog := log.(ethereumTypes.Log)
+		if len(ethLog.Topics) < 3 || ethLog.Topics[0] != matchTopic {
+			continue
+		}
+		var event matchEvent
+		if err := s.parsedAbi.UnpackIntoInterface(&event, "LogMatch", ethLog.Data); err != nil {
+			continue
+		}
+		buyOrderId, buyOrder := event.buyOrder(HexPrefix+hex.EncodeToString(ethLog.Topics[1].Bytes()),
+			HexPrefix+hex.EncodeToString(ethLog.Topics[2].Bytes()))
+		if event.buyOrderRemoved() {
+			removed[buyOrderId] = true
+			continue
+		}
+		if buyOrder.Nft.Amount == nil || buyOrder.Nft.Amount.Int64() <= matchQuantity { // 单数量买单撮合一次即完成
+			continue
+		}
+		lastMatchBlock[buyOrderId] = ethLog.BlockNumber
+	}
+	for orderId := range removed {
+		delete(lastMatchBlock, orderId)
+	}
+
+	fills := make(map[string]int64, len(lastMatchBlock))
+	for orderId, blockNumber := range lastMatchBlock {
+		filled, err := s.filledAmount(orderId, blockNumber)
+		if err != nil {
+			xzap.WithContext(s.ctx).Warn("failed on get order filled amount, fallback to fill derived from events",
+				zap.String("order_id", orderId), zap.Uint64("block_number", blockNumber), zap.Error(err))
+			continue
+		}
+		fills[orderId] = filled
+	}
+
+	return fills
+}
+
+// applyBuyOrderFills 以链上累计成交数量校正买单的剩余数量
+func (s *Service) applyBuyOrderFills(tx *gorm.DB, fills map[string]int64) error {
+	for orderId, filled := range fills {
+		var order multi.Order
+		if err := tx.Table(multi.OrderTableName(s.chain)).
+			Where("order_id = ?", orderId).
+			First(&order).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return errors.Wrap(err, "failed on get order")
+		}
+
+		remaining := order.Size - filled
+		if remaining < 0 {
+			remaining = 0
+		}
+		if remaining == order.QuantityRemaining {
+			continue
+		}
+		xzap.WithContext(s.ctx).Warn("buy order fill differs from chain",
+			zap.String("order_id", orderId),
+			zap.Int64("derived_remaining", order.QuantityRemaining),
+			zap.Int64("chain_remaining", remaining))
+
+		updates := map[string]interface{}{
+			"quantity_remaining": remaining,
+		}
+		if remaining == 0 {
+			updates["order_status"] = multi.OrderStatusFilled
+		} else if order.OrderStatus == multi.OrderStatusFilled {
+			updates["order_status"] = multi.OrderStatusActive
+		}
+		if err := tx.Table(multi.OrderTableName(s.chain)).
+			Where("order_id = ?", orderId).
+			Updates(updates).Error; err != nil {
+			return errors.Wrap(err, "failed on update order quantity_remaining")
+		}
+	}
+
+	return nil
+}
+
+// filledAmount 查询订单在指定区块时在合约中的累计成交数量
+func (s *Service) filledAmount(orderId string, blockNumber uint64) (int64, error) {
+	data, err := s.packFilledAmount(orderId)
+	if err != nil {
+		return 0, err
+	}
+
+	dex := common.HexToAddress(s.cfg.ContractCfg.DexAddress)
+	respData, err := s.chainClient.CallContract(s.ctx, ethereum.CallMsg{To: &dex, Data: data}, new(big.Int).SetUint64(blockNumber))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed on call filledAmount")
+	}
+
+	return s.unpackFilledAmount(respData)
+}
+
+func (s *Service) packFilledAmount(orderId string) ([]byte, error) {
+	var orderKey [32]byte
+	rawKey, err := hex.DecodeString(strings.TrimPrefix(orderId, HexPrefix))
+	if err != nil || len(rawKey) != len(orderKey) {
+		return nil, errors.Errorf("invalid order key: %s", orderId)
+	}
+	copy(orderKey[:], rawKey)
+
+	data, err := s.parsedAbi.Pack("filledAmount", orderKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed on pack filledAmount")
 	}
+
+	return data, nil
 }
 
-func (s *Service) handleCancelEvent(log ethereumTypes.Log) {
+func (s *Service) unpackFilledAmount(respData []byte) (int64, error) {
+	res, err := s.parsedAbi.Unpack("filledAmount", respData)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed on unpack filledAmount")
+	}
+	if len(res) == 0 {
+		return 0, errors.New("empty filledAmount result")
+	}
+	filled, ok := res[0].(*big.Int)
+	if !ok {
+		return 0, errors.New("invalid filledAmount result")
+	}
+
+	return filled.Int64(), nil
+}
+
+func (s *Service) handleCancelEvent(tx *gorm.DB, log ethereumTypes.Log) error {
 	orderId := HexPrefix + hex.EncodeToString(log.Topics[1].Bytes())
 	//maker := common.BytesToAddress(log.Topics[2].Bytes())
-	if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
+	if err := tx.Table(multi.OrderTableName(s.chain)).
 		Where("order_id = ?", orderId).
 		Update("order_status", multi.OrderStatusCancelled).Error; err != nil {
-		xzap.WithContext(s.ctx).Error("failed on update order status",
-			zap.String("order_id", orderId))
-		return
+		return errors.Wrapf(err, "failed on update order status, order_id: %s", orderId)
 	}
 
 	var cancelOrder multi.Order
-	if err := s.db.WithContext(s.ctx).Table(multi.OrderTableName(s.chain)).
+	if err := tx.Table(multi.OrderTableName(s.chain)).
 		Where("order_id = ?", orderId).
 		First(&cancelOrder).Error; err != nil {
-		xzap.WithContext(s.ctx).Error("failed on get cancel order",
-			zap.Error(err))
-		return
+		if errors.Is(err, gorm.ErrRecordNotFound) { // 未索引到的订单无需记录
+			xzap.WithContext(s.ctx).Warn("cancel untracked order", zap.String("order_id", orderId))
+			return nil
+		}
+		return errors.Wrap(err, "failed on get cancel order")
 	}
 
-	blockTime, err := s.chainClient.BlockTimeByNumber(s.ctx, big.NewInt(int64(log.BlockNumber)))
+	blockTime, err := s.blockTime(tx, log.BlockNumber)
 	if err != nil {
-		xzap.WithContext(s.ctx).Error("failed to get block time", zap.Error(err))
-		return
+		return errors.Wrap(err, "failed to get block time")
 	}
 	var activityType int
 	if cancelOrder.OrderType == multi.ListingOrder {
@@ -497,30 +788,29 @@ func (s *Service) handleCancelEvent(log ethereumTypes.Log) {
 		MarketplaceID:     multi.MarketOrderBook,
 		CollectionAddress: cancelOrder.CollectionAddress,
 		TokenId:           cancelOrder.TokenId,
-		CurrencyAddress:   s.cfg.ContractCfg.EthAddress,
+		CurrencyAddress:   cancelOrder.CurrencyAddress,
 		Price:             cancelOrder.Price,
+		Quantity:          cancelOrder.QuantityRemaining,
 		BlockNumber:       int64(log.BlockNumber),
 		TxHash:            log.TxHash.String(),
 		EventTime:         int64(blockTime),
 	}
-	if err := s.db.WithContext(s.ctx).Table(multi.ActivityTableName(s.chain)).Clauses(clause.OnConflict{
+	if err := tx.Table(multi.ActivityTableName(s.chain)).Clauses(clause.OnConflict{
 		DoNothing: true,
 	}).Create(&newActivity).Error; err != nil {
-		xzap.WithContext(s.ctx).Warn("failed on create activity",
-			zap.Error(err))
+		return errors.Wrap(err, "failed on create activity")
 	}
 
-	if err := ordermanager.AddUpdatePriceEvent(s.kv, &ordermanager.TradeEvent{
+	if err := s.enqueuePriceEvent(tx, &ordermanager.TradeEvent{
 		OrderId:        cancelOrder.OrderID,
 		CollectionAddr: cancelOrder.CollectionAddress,
 		TokenID:        cancelOrder.TokenId,
 		EventType:      ordermanager.Cancel,
-	}, s.chain); err != nil {
-		xzap.WithContext(s.ctx).Error("failed on add update price event",
-			zap.Error(err),
-			zap.String("type", "cancel"),
-			zap.String("order_id", cancelOrder.OrderID))
+	}); err != nil {
+		return errors.Wrap(err, "failed on add update price event")
 	}
+
+	return nil
 }
 
 func (s *Service) UpKeepingCollectionFloorChangeLoop() {
@@ -564,21 +854,34 @@ func (s *Service) UpKeepingCollectionFloorChangeLoop() {
 					continue
 				}
 			}
-		default:
 		}
 	}
 }
 
 func (s *Service) deleteExpireCollectionFloorChangeFromDatabase() error {
-	stmt := fmt.Sprintf(`DELETE FROM %s where event_time < UNIX_TIMESTAMP() - %d`, gdb.GetMultiProjectCollectionFloorPriceTableName(s.cfg.ProjectCfg.Name, s.chain), comm.CollectionFloorTimeRange)
-
+	stmt := fmt.Sprintf(`DELETE FROM %s where event_time < UNIX_TIMESTAMP() - %d`, gdb.GetMultiProjectCollectionFloorPriceTableName(s.cfg.ProjectCfg.Name, s.chain),
+		floorRetention(s.cfg.FloorPriceCfg.SnapshotRetention))
 	if err := s.db.Exec(stmt).Error; err != nil {
 		return errors.Wrap(err, "failed on delete expire collection floor price")
 	}
 
+	stmt = fmt.Sprintf(`DELETE FROM %s where event_time < UNIX_TIMESTAMP() - %d`, gdb.GetMultiProjectCollectionFloorChangeTableName(s.cfg.ProjectCfg.Name, s.chain),
+		floorRetention(s.cfg.FloorPriceCfg.ChangeRetention))
+	if err := s.db.Exec(stmt).Error; err != nil {
+		return errors.Wrap(err, "failed on delete expire collection floor change")
+	}
+
 	return nil
 }
 
+// floorRetention 地板价历史数据的保留时长，未配置时使用默认值
+func floorRetention(retention int64) int64 {
+	if retention <= 0 {
+		return comm.CollectionFloorTimeRange
+	}
+	return retention
+}
+
 func (s *Service) QueryCollectionsFloorPrice() ([]multi.CollectionFloorPrice, error) {
 	timestamp := time.Now().Unix()
 	timestampMilli := time.Now().UnixMilli()
@@ -587,12 +890,13 @@ func (s *Service) QueryCollectionsFloorPrice() ([]multi.CollectionFloorPrice, er
 FROM %s as ci
          left join %s co on co.collection_address = ci.collection_address and co.token_id = ci.token_id
 WHERE (co.order_type = ? and
-       co.order_status = ? and expire_time > ? and co.maker = ci.owner) group by co.collection_address`, gdb.GetMultiProjectItemTableName(s.cfg.ProjectCfg.Name, s.chain), gdb.GetMultiProjectOrderTableName(s.cfg.ProjectCfg.Name, s.chain))
+       co.order_status = ? and expire_time > ? and co.maker = ci.owner and co.currency_address in (?)) group by co.collection_address`, gdb.GetMultiProjectItemTableName(s.cfg.ProjectCfg.Name, s.chain), gdb.GetMultiProjectOrderTableName(s.cfg.ProjectCfg.Name, s.chain))
 	if err := s.db.WithContext(s.ctx).Raw(
 		sql,
 		multi.ListingType,
 		multi.OrderStatusActive,
 		time.Now().Unix(),
+		[]string{"", currency.NativeAddress, strings.ToLower(s.cfg.ContractCfg.EthAddress)}, // 地板价只统计原生币计价的挂单
 	).Scan(&collectionFloorPrice).Error; err != nil {
 		return nil, errors.Wrap(err, "failed on get collection floor price")
 	}
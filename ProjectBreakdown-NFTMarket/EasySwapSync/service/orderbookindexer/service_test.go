@@ -3,16 +3,30 @@ package orderbookindexer
 import (
 	"context"
 	"encoding/hex"
+	"fmt"
 	"math/big"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ProjectsTask/EasySwapBase/chain/chainclient"
 	"github.com/ProjectsTask/EasySwapBase/chain/types"
+	"github.com/ProjectsTask/EasySwapBase/currency"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
 	"github.com/ProjectsTask/EasySwapBase/stores/gdb"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/base"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	ethereumTypes "github.com/ethereum/go-ethereum/core/types"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 
 	"github.com/ProjectsTask/EasySwapSync/model"
+	"github.com/ProjectsTask/EasySwapSync/service/config"
+	"github.com/ProjectsTask/EasySwapSync/service/tradestats"
 )
 
 func TestSyncEvent(t *testing.T) {
@@ -28,7 +42,10 @@ func TestSyncEvent(t *testing.T) {
 	})
 
 	chainClient, _ := chainclient.New(10, "https://rpc.ankr.com/optimism/9c6c678ebcb56da1cb80f7632c7c02264831232c3d53453c7726a611e7ca36d7")
-	orderbookSyncer := New(ctx, nil, db, nil, chainClient, 10, "optimism", nil)
+	orderbookSyncer, err := New(ctx, nil, db, nil, chainClient, 10, "optimism", nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	query := types.FilterQuery{
 		FromBlock: new(big.Int).SetUint64(111819366),
@@ -40,15 +57,8 @@ func TestSyncEvent(t *testing.T) {
 
 	for _, log := range logs {
 		ethLog := log.(ethereumTypes.Log)
-		switch ethLog.Topics[0].String() {
-		case LogMakeTopic:
-			orderbookSyncer.handleMakeEvent(ethLog)
-		case LogCancelTopic:
-			orderbookSyncer.handleCancelEvent(ethLog)
-		case LogMatchTopic:
-			orderbookSyncer.handleMatchEvent(ethLog)
-		default:
-
+		if handler, _, ok := orderbookSyncer.registry.Handler(ethLog.Topics[0]); ok {
+			handler(db, ethLog)
 		}
 	}
 }
@@ -65,7 +75,10 @@ func TestHandleMakeEvent(t *testing.T) {
 		MaxOpenConns: 1500,
 	})
 	chainClient, _ := chainclient.New(10, "https://rpc.ankr.com/optimism/9c6c678ebcb56da1cb80f7632c7c02264831232c3d53453c7726a611e7ca36d7")
-	orderbookSyncer := New(ctx, nil, db, nil, chainClient, 10, "optimism", nil)
+	orderbookSyncer, err := New(ctx, nil, db, nil, chainClient, 10, "optimism", nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 	data, _ := hex.DecodeString("c773ae81bc9a186dc6c5d70a486730a6f734578ae1a0116acd0aaaf69250d2650000000000000000000000000000000000000000000000000000000000000000000000000000000000000000e7f1725e7734ce288f8367e1bb143e90bb3f05120000000000000000000000000000000000000000000000000000000000000001000000000000000000000000000000000000000000000000002386f26fc10000000000000000000000000000000000000000000000000000000000006558875d0000000000000000000000000000000000000000000000000000000000000001")
 	log := ethereumTypes.Log{
 		Address: common.HexToAddress("0x123"),
@@ -79,5 +92,328 @@ func TestHandleMakeEvent(t *testing.T) {
 		BlockNumber: 111482956,
 		TxHash:      common.HexToHash("0x000000000000000000000000f39fd6e51aad88f6f4ce6ab8827279cfffb92266"),
 	}
-	orderbookSyncer.handleMakeEvent(log)
+	orderbookSyncer.handleMakeEvent(db, log)
+}
+
+func TestRemainingAfterMatch(t *testing.T) {
+	cases := []struct {
+		side      uint8
+		remaining int64
+		expected  int64
+	}{
+		{List, 1, 0},
+		{List, 5, 0}, // 卖单整单成交
+		{Bid, 1, 0},
+		{Bid, 5, 4}, // 买单每次成交1个
+		{Bid, 0, 0},
+	}
+	for i, c := range cases {
+		if remaining := remainingAfterMatch(c.side, c.remaining); remaining != c.expected {
+			t.Errorf("case %d: expected %d, got %d", i, c.expected, remaining)
+		}
+	}
+}
+
+func TestBuyOrderFills(t *testing.T) {
+	parsedAbi, err := abi.JSON(strings.NewReader(contractAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := newFakeOrderBook()
+	s := &Service{
+		ctx:         context.Background(),
+		cfg:         &config.Config{ContractCfg: config.ContractCfg{DexAddress: "0x1"}},
+		parsedAbi:   parsedAbi,
+		chainClient: book,
+	}
+	book.s = s
+
+	order := func(side uint8, amount int64) fakeOrder {
+		return fakeOrder{Side: side, Maker: common.HexToAddress("0x2"),
+			Nft: fakeAsset{big.NewInt(1), common.HexToAddress("0x3"), big.NewInt(amount)}, Price: big.NewInt(100), Expiry: 1}
+	}
+	matchLog := func(block uint64, makeKey, takeKey [32]byte, makeOrder, takeOrder fakeOrder) interface{} {
+		data, err := parsedAbi.Events["LogMatch"].Inputs.NonIndexed().Pack(makeOrder, takeOrder, big.NewInt(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ethereumTypes.Log{
+			Topics:      []common.Hash{parsedAbi.Events["LogMatch"].ID, common.Hash(makeKey), common.Hash(takeKey)},
+			Data:        data,
+			BlockNumber: block,
+		}
+	}
+
+	multiBid, singleBid, removedBid := [32]byte{31: 1}, [32]byte{31: 2}, [32]byte{31: 6}
+	book.filled[multiBid] = 2
+	logs := []interface{}{
+		matchLog(10, [32]byte{31: 3}, multiBid, order(List, 1), order(Bid, 5)), // 卖方接受出价
+		matchLog(12, [32]byte{31: 4}, multiBid, order(List, 1), order(Bid, 5)),
+		matchLog(12, [32]byte{31: 5}, singleBid, order(List, 1), order(Bid, 1)),
+		matchLog(12, [32]byte{31: 7}, removedBid, order(List, 1), order(Bid, 5)),
+		matchLog(13, removedBid, [32]byte{31: 8}, order(Bid, 5), order(List, 1)), // 买方发起，买单被移除
+	}
+
+	fills := s.buyOrderFills(logs)
+	if len(fills) != 1 || fills[orderIdOf(multiBid)] != 2 {
+		t.Errorf("unexpected fills: %v", fills)
+	}
+	// 每个多数量买单只在最后一次撮合的区块查询一次
+	if len(book.blocks) != 1 || book.blocks[0].Uint64() != 12 {
+		t.Errorf("unexpected filledAmount calls: %v", book.blocks)
+	}
+}
+
+func TestMatchDirections(t *testing.T) {
+	parsedAbi, err := abi.JSON(strings.NewReader(contractAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := "optimism"
+	db := newTestDB(t, chain)
+	book := newFakeOrderBook()
+	s := &Service{
+		ctx:         xzap.ToContext(context.Background(), zap.NewNop()),
+		cfg:         &config.Config{ContractCfg: config.ContractCfg{EthAddress: ZeroAddress, DexAddress: "0x1"}},
+		db:          db,
+		chain:       chain,
+		parsedAbi:   parsedAbi,
+		chainClient: book,
+	}
+	book.s = s
+	if err := db.Table(multi.RawLogTableName(chain)).Create(&multi.RawLog{BlockNumber: 10, BlockTime: time.Now().Unix()}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	seller, buyer := common.HexToAddress("0x1"), common.HexToAddress("0x2")
+	order := func(side uint8, maker common.Address, amount int64) fakeOrder {
+		return fakeOrder{Side: side, Maker: maker, Nft: fakeAsset{big.NewInt(1), common.HexToAddress("0x3"), big.NewInt(amount)},
+			Price: big.NewInt(100), Expiry: 1}
+	}
+	var id int64
+	storeOrder := func(key [32]byte, orderType int64, size int64) {
+		id++
+		if err := db.Table(multi.OrderTableName(chain)).Create(&multi.Order{ID: id, OrderID: orderIdOf(key),
+			OrderType: orderType, OrderStatus: multi.OrderStatusActive, QuantityRemaining: size, Size: size}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	var logs []interface{}
+	match := func(makeKey, takeKey [32]byte, makeOrder, takeOrder fakeOrder) {
+		data, err := parsedAbi.Events["LogMatch"].Inputs.NonIndexed().Pack(makeOrder, takeOrder, big.NewInt(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		log := ethereumTypes.Log{
+			Topics:      []common.Hash{parsedAbi.Events["LogMatch"].ID, common.Hash(makeKey), common.Hash(takeKey)},
+			Data:        data,
+			BlockNumber: 10,
+			TxHash:      common.Hash(makeKey),
+		}
+		if err := s.handleMatchEvent(db, log); err != nil {
+			t.Fatalf("failed on handle match: %v", err)
+		}
+		logs = append(logs, log)
+	}
+	assertOrder := func(key [32]byte, remaining int64, status int) {
+		var order multi.Order
+		if err := db.Table(multi.OrderTableName(chain)).Where("order_id = ?", orderIdOf(key)).Take(&order).Error; err != nil {
+			t.Fatal(err)
+		}
+		if order.QuantityRemaining != remaining || order.OrderStatus != status {
+			t.Errorf("order %s: expected remaining %d status %d, got remaining %d status %d",
+				orderIdOf(key), remaining, status, order.QuantityRemaining, order.OrderStatus)
+		}
+	}
+
+	// 成交活动的maker/taker为卖方/买方，与撮合方向无关
+	assertSale := func(makeKey [32]byte) {
+		var activity multi.Activity
+		if err := db.Table(multi.ActivityTableName(chain)).Where("tx_hash = ?", common.Hash(makeKey).String()).
+			Take(&activity).Error; err != nil {
+			t.Fatal(err)
+		}
+		if activity.Maker != seller.String() || activity.Taker != buyer.String() {
+			t.Errorf("expected sale from seller to buyer, got maker %s taker %s", activity.Maker, activity.Taker)
+		}
+	}
+
+	// 卖方接受出价：LogMatch(sellOrder, buyOrder)，买单保留在订单簿，剩余数量继续有效
+	sellKey, bidKey := [32]byte{31: 1}, [32]byte{31: 2}
+	storeOrder(bidKey, multi.CollectionBidOrder, 5)
+	match(sellKey, bidKey, order(List, seller, 1), order(Bid, buyer, 5))
+	assertOrder(bidKey, 4, multi.OrderStatusActive)
+	assertSale(sellKey)
+
+	// 买方发起撮合：LogMatch(buyOrder, sellOrder)，卖单整单成交，已存储的买单被合约移除
+	listingKey, removedBidKey := [32]byte{31: 3}, [32]byte{31: 4}
+	storeOrder(listingKey, multi.ListingOrder, 1)
+	storeOrder(removedBidKey, multi.CollectionBidOrder, 5)
+	match(removedBidKey, listingKey, order(Bid, buyer, 5), order(List, seller, 1))
+	assertOrder(listingKey, 0, multi.OrderStatusFilled)
+	assertOrder(removedBidKey, 4, multi.OrderStatusFilled)
+	assertSale(removedBidKey)
+
+	// 被移除的买单不参与链上成交数量校正，不会被重新激活
+	book.filled[bidKey], book.filled[removedBidKey] = 1, 1
+	fills := s.buyOrderFills(logs)
+	if _, ok := fills[orderIdOf(removedBidKey)]; len(fills) != 1 || ok {
+		t.Errorf("unexpected fills: %v", fills)
+	}
+	if err := s.applyBuyOrderFills(db, fills); err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(bidKey, 4, multi.OrderStatusActive)
+	assertOrder(removedBidKey, 4, multi.OrderStatusFilled)
+}
+
+func TestEventRegistry(t *testing.T) {
+	parsedAbi, err := abi.JSON(strings.NewReader(contractAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Service{parsedAbi: parsedAbi}
+	if err := s.registerEventHandlers(); err != nil {
+		t.Fatalf("failed on register event handlers: %v", err)
+	}
+	for _, name := range []string{"LogMake", "LogCancel", "LogMatch", "LogSkipOrder", "LogWithdrawETH",
+		"LogUpdatedProtocolShare", "BatchMatchInnerError", "Paused", "Unpaused"} {
+		if _, eventName, ok := s.registry.Handler(parsedAbi.Events[name].ID); !ok || eventName != name {
+			t.Errorf("handler of %s not registered", name)
+		}
+	}
+	if _, _, ok := s.registry.Handler(parsedAbi.Events["OwnershipTransferred"].ID); ok {
+		t.Errorf("Unexpected handler of OwnershipTransferred")
+	}
+
+	handler := func(tx *gorm.DB, log ethereumTypes.Log) error { return nil }
+	if err := s.registry.Register("LogMake", handler); err == nil {
+		t.Errorf("Expected error on duplicate handler")
+	}
+	if err := s.registry.Register("LogUnknown", handler); err == nil {
+		t.Errorf("Expected error on event not in abi")
+	}
+}
+
+// newTestDB 内存sqlite数据库，创建测试用到的数据表
+func newTestDB(t *testing.T, chain string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := map[string]interface{}{
+		multi.OrderTableName(chain):           &multi.Order{},
+		multi.ActivityTableName(chain):        &multi.Activity{},
+		multi.RawLogTableName(chain):          &multi.RawLog{},
+		multi.ItemTableName(chain):            &multi.Item{},
+		multi.ItemHolderTableName(chain):      &multi.ItemHolder{},
+		multi.CollectionTradeTableName(chain): &multi.CollectionTrade{},
+		multi.ContractEventTableName(chain):   &multi.ContractEvent{},
+		OutboxTableName(chain):                &OutboxEvent{},
+		base.IndexedStatusTableName():         &base.IndexedStatus{},
+	}
+	for table, model := range tables {
+		// sqlite不识别MySQL风格的AUTO_INCREMENT标签，建表前将自增主键设为sqlite的自增主键类型
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.ParseWithSpecialTableName(model, table); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range stmt.Schema.PrimaryFields {
+			if field.AutoIncrement {
+				field.DataType = "integer PRIMARY KEY AUTOINCREMENT"
+			}
+		}
+		if err := db.Table(table).AutoMigrate(model); err != nil {
+			t.Fatalf("failed on migrate %s: %v", table, err)
+		}
+	}
+	// 与db/migrations中的唯一索引一致，供upsert使用
+	for _, index := range []string{
+		fmt.Sprintf("CREATE UNIQUE INDEX uk_collection_epoch ON %s (collection_address, epoch_number)", multi.CollectionTradeTableName(chain)),
+		fmt.Sprintf("CREATE UNIQUE INDEX index_collection_token ON %s (collection_address, token_id)", multi.ItemTableName(chain)),
+		fmt.Sprintf("CREATE UNIQUE INDEX index_collection_token_owner ON %s (collection_address, token_id, owner)", multi.ItemHolderTableName(chain)),
+	} {
+		if err := db.Exec(index).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+func TestMatchCurrency(t *testing.T) {
+	parsedAbi, err := abi.JSON(strings.NewReader(contractAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	weth := "0x4200000000000000000000000000000000000006"
+	priceSource, err := currency.NewStaticPriceSource([]*currency.Config{{Address: weth, Symbol: "WETH", Decimals: 18, EthRate: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newTestDB(t, "optimism")
+	s := &Service{
+		ctx:        context.Background(),
+		cfg:        &config.Config{ContractCfg: config.ContractCfg{EthAddress: ZeroAddress, CurrencyAddress: weth}},
+		db:         db,
+		chain:      "optimism",
+		parsedAbi:  parsedAbi,
+		tradeStats: tradestats.New(context.Background(), db, "optimism", priceSource),
+	}
+	// 未配置结算币种时为原生币
+	if currency := (&Service{cfg: &config.Config{ContractCfg: config.ContractCfg{EthAddress: ZeroAddress}}}).currencyAddress(); currency != ZeroAddress {
+		t.Errorf("expected native currency, got %s", currency)
+	}
+
+	collection := common.HexToAddress("0x2")
+	order := func(side uint8) fakeOrder {
+		return fakeOrder{Side: side, Maker: common.HexToAddress("0x1"), Nft: fakeAsset{big.NewInt(1), collection, big.NewInt(1)},
+			Price: big.NewInt(100), Expiry: 1, Salt: 2}
+	}
+	data, err := parsedAbi.Events["LogMatch"].Inputs.NonIndexed().Pack(order(List), order(Bid), big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockTime := time.Now().Unix()
+	if err := db.Table(multi.RawLogTableName("optimism")).Create(&multi.RawLog{BlockNumber: 10, BlockTime: blockTime}).Error; err != nil {
+		t.Fatal(err)
+	}
+	log := ethereumTypes.Log{
+		Topics:      []common.Hash{parsedAbi.Events["LogMatch"].ID, common.HexToHash("0x3"), common.HexToHash("0x4")},
+		Data:        data,
+		BlockNumber: 10,
+		TxHash:      common.HexToHash("0x5"),
+	}
+	if err := s.handleMatchEvent(db, log); err != nil {
+		t.Fatalf("failed on handle match: %v", err)
+	}
+
+	var activity multi.Activity
+	if err := db.Table(multi.ActivityTableName("optimism")).Take(&activity).Error; err != nil {
+		t.Fatal(err)
+	}
+	if activity.CurrencyAddress != weth {
+		t.Errorf("expected weth sale, got %s", activity.CurrencyAddress)
+	}
+
+	// 成交统计在事务提交后计算，WETH成交按汇率计入排行榜使用的成交统计
+	var count int64
+	if err := db.Table(multi.CollectionTradeTableName("optimism")).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected no trade stats before refresh, got %d", count)
+	}
+	s.refreshTradeStats()
+	var trade multi.CollectionTrade
+	if err := db.Table(multi.CollectionTradeTableName("optimism")).
+		Where("collection_address = ? and epoch_number = ?", strings.ToLower(collection.String()), multi.TradeWindows[0].EpochNumber).
+		Take(&trade).Error; err != nil {
+		t.Fatal(err)
+	}
+	if trade.SaleCount != 1 || trade.Volume.IntPart() != 100 || trade.MinPrice.IntPart() != 100 {
+		t.Errorf("unexpected trade stats: %+v", trade)
+	}
 }
@@ -0,0 +1,296 @@
+package orderbookindexer
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ProjectsTask/EasySwapBase/chain/nftchainservice"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/ordermanager"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/base"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const TransferIndexType = base.TypeNftTransferIndex
+
+// SyncNFTTransferEventLoop 同步已导入collection的NFT转移事件, 保持ob_item的owner与链上一致
+func (s *Service) SyncNFTTransferEventLoop() {
+	var indexedStatus base.IndexedStatus
+	if err := s.db.WithContext(s.ctx).Table(base.IndexedStatusTableName()).
+		Where("chain_id = ? and index_type = ?", s.chainId, TransferIndexType).
+		First(&indexedStatus).Error; err != nil {
+		xzap.WithContext(s.ctx).Error("failed on get transfer index status",
+			zap.Error(err))
+		return
+	}
+
+	lastSyncBlock := uint64(indexedStatus.LastIndexedBlock)
+	for {
+		select {
+		case <-s.ctx.Done():
+			xzap.WithContext(s.ctx).Info("SyncNFTTransferEventLoop stopped due to context cancellation")
+			return
+		default:
+		}
+
+		currentBlockNum, err := s.chainClient.BlockNumber()
+		if err != nil {
+			xzap.WithContext(s.ctx).Error("failed on get current block number", zap.Error(err))
+			s.sleep(SleepInterval * time.Second)
+			continue
+		}
+
+		if lastSyncBlock > currentBlockNum-s.confirmations() {
+			s.sleep(SleepInterval * time.Second)
+			continue
+		}
+
+		startBlock := lastSyncBlock
+		endBlock := startBlock + SyncBlockPeriod
+		if endBlock > currentBlockNum-s.confirmations() {
+			endBlock = currentBlockNum - s.confirmations()
+		}
+
+		if err := s.syncTransferBlockRange(startBlock, endBlock); err != nil {
+			xzap.WithContext(s.ctx).Error("failed on sync nft transfer events",
+				zap.Uint64("start_block", startBlock),
+				zap.Uint64("end_block", endBlock),
+				zap.Error(err))
+			s.sleep(SleepInterval * time.Second)
+			continue
+		}
+		lastSyncBlock = endBlock + 1
+
+		if err := s.flushOutbox(); err != nil {
+			xzap.WithContext(s.ctx).Error("failed on flush outbox", zap.Error(err))
+		}
+
+		xzap.WithContext(s.ctx).Info("sync nft transfer event ...",
+			zap.Uint64("start_block", startBlock),
+			zap.Uint64("end_block", endBlock))
+	}
+}
+
+// syncTransferBlockRange 拉取并处理[startBlock, endBlock]内已导入collection的转移事件。
+// 处理期间持有过滤器的同步锁，导入流程在持有锁时加入的collection从下一批区块开始处理，避免与导入重复计算
+func (s *Service) syncTransferBlockRange(startBlock, endBlock uint64) error {
+	s.collectionFilter.LockSync()
+	defer s.collectionFilter.UnlockSync()
+
+	// 只查询已导入collection的事件，避免拉取全链的Transfer日志
+	transferLogs, err := s.nodeSrv.GetCollectionsTransferEvent(s.collectionFilter.Elements(), startBlock, endBlock)
+	if err != nil {
+		return errors.Wrap(err, "failed on get nft transfer event")
+	}
+
+	return s.applyTransferLogs(transferLogs, endBlock+1)
+}
+
+// applyTransferLogs 在一个数据库事务内处理转移事件，并更新ob_indexed_status
+func (s *Service) applyTransferLogs(transferLogs []*nftchainservice.TransferLog, nextSyncBlock uint64) error {
+	return s.db.WithContext(s.writeCtx).Transaction(func(tx *gorm.DB) error {
+		for _, transferLog := range transferLogs {
+			if !s.collectionFilter.Contains(transferLog.Address) { // 只处理已导入的collection
+				continue
+			}
+
+			if err := s.handleTransferEvent(tx, transferLog); err != nil {
+				return errors.Wrapf(err, "failed on handle transfer, tx: %s, index: %d", transferLog.TransactionHash, transferLog.Index)
+			}
+		}
+
+		if err := tx.Table(base.IndexedStatusTableName()).
+			Where("chain_id = ? and index_type = ?", s.chainId, TransferIndexType).
+			Update("last_indexed_block", nextSyncBlock).Error; err != nil {
+			return errors.Wrap(err, "failed on update nft transfer sync block number")
+		}
+
+		return nil
+	})
+}
+
+// 处理NFT转移事件: 更新item的owner及持有人, 记录Transfer/Mint活动, 通知order manager更新地板价
+func (s *Service) handleTransferEvent(tx *gorm.DB, transferLog *nftchainservice.TransferLog) error {
+	collection := strings.ToLower(transferLog.Address)
+	from := strings.ToLower(transferLog.From)
+	owner := strings.ToLower(transferLog.To)
+
+	activityType := multi.Transfer
+	if from == ZeroAddress { // 从零地址转出即为铸造
+		activityType = multi.Mint
+	}
+
+	amount, err := decimal.NewFromString(transferLog.Amount)
+	if err != nil {
+		return errors.Wrapf(err, "invalid transfer amount %s", transferLog.Amount)
+	}
+
+	fromCleared := true // 转出方是否已不再持有该token
+	if transferLog.IsErc1155 {
+		if fromCleared, err = s.applyErc1155Transfer(tx, collection, transferLog.TokenID, from, owner, amount); err != nil {
+			return errors.Wrap(err, "failed on update erc1155 item holders")
+		}
+	} else {
+		// 铸造时item可能还不存在，以owner冲突更新的方式写入
+		item := multi.Item{
+			ChainId:           int(s.chainId),
+			CollectionAddress: collection,
+			TokenId:           transferLog.TokenID,
+			Owner:             owner,
+			Creator:           owner,
+			Supply:            1,
+		}
+		if err := tx.Table(multi.ItemTableName(s.chain)).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "collection_address"}, {Name: "token_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"owner", "update_time"}),
+		}).Create(&item).Error; err != nil {
+			return errors.Wrap(err, "failed to update item owner")
+		}
+
+		if err := tx.Table(multi.ItemHolderTableName(s.chain)).
+			Where("collection_address = ? and token_id = ?", collection, transferLog.TokenID).
+			Delete(&multi.ItemHolder{}).Error; err != nil {
+			return errors.Wrap(err, "failed on delete item holder")
+		}
+		if owner != ZeroAddress {
+			if err := tx.Table(multi.ItemHolderTableName(s.chain)).Create(&multi.ItemHolder{
+				CollectionAddress: collection,
+				TokenId:           transferLog.TokenID,
+				Owner:             owner,
+				Balance:           decimal.NewFromInt(1),
+			}).Error; err != nil {
+				return errors.Wrap(err, "failed on create item holder")
+			}
+		}
+	}
+
+	newActivity := multi.Activity{
+		ActivityType:      activityType,
+		Maker:             transferLog.From,
+		Taker:             transferLog.To,
+		MarketplaceID:     multi.MarketOrderBook,
+		CollectionAddress: collection,
+		TokenId:           transferLog.TokenID,
+		CurrencyAddress:   s.cfg.ContractCfg.EthAddress,
+		Quantity:          amount.IntPart(),
+		BlockNumber:       int64(transferLog.BlockNumber),
+		TxHash:            transferLog.TransactionHash,
+		EventTime:         int64(transferLog.BlockTime),
+	}
+	if err := tx.Table(multi.ActivityTableName(s.chain)).Clauses(clause.OnConflict{
+		DoNothing: true,
+	}).Create(&newActivity).Error; err != nil {
+		return errors.Wrap(err, "failed on create activity")
+	}
+
+	// ERC-1155部分转出时原持有人的挂单仍然有效
+	if !fromCleared {
+		return nil
+	}
+
+	// 原owner的挂单失效，新owner的挂单可能重新生效
+	if err := s.enqueuePriceEvent(tx, &ordermanager.TradeEvent{
+		EventType:      ordermanager.Transfer,
+		CollectionAddr: collection,
+		TokenID:        transferLog.TokenID,
+		From:           transferLog.From,
+		To:             transferLog.To,
+		TxHash:         transferLog.TransactionHash,
+	}); err != nil {
+		return errors.Wrap(err, "failed on add update price event")
+	}
+
+	return nil
+}
+
+// applyErc1155Transfer 更新ERC-1155 token的持有数量及供应量，返回转出方是否已不再持有该token
+func (s *Service) applyErc1155Transfer(tx *gorm.DB, collection, tokenID, from, to string, amount decimal.Decimal) (bool, error) {
+	// 铸造时item可能还不存在，以供应量冲突更新的方式写入
+	supply := amount.IntPart()
+	switch {
+	case from == ZeroAddress:
+	case to == ZeroAddress: // 销毁
+		supply = -supply
+	default:
+		supply = 0
+	}
+	item := multi.Item{
+		ChainId:           int(s.chainId),
+		CollectionAddress: collection,
+		TokenId:           tokenID,
+		Creator:           to,
+		Supply:            supply,
+	}
+	if err := tx.Table(multi.ItemTableName(s.chain)).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "collection_address"}, {Name: "token_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"supply":      gorm.Expr("supply + ?", supply),
+			"update_time": time.Now().UnixMilli(),
+		}),
+	}).Create(&item).Error; err != nil {
+		return false, errors.Wrap(err, "failed to update item supply")
+	}
+
+	fromCleared := true
+	if from != ZeroAddress {
+		holders := tx.Table(multi.ItemHolderTableName(s.chain)).
+			Where("collection_address = ? and token_id = ? and owner = ?", collection, tokenID, from)
+		if err := holders.Session(&gorm.Session{}).Updates(map[string]interface{}{
+			"balance":     gorm.Expr("balance - ?", amount),
+			"update_time": time.Now().UnixMilli(),
+		}).Error; err != nil {
+			return false, errors.Wrap(err, "failed on decrease holder balance")
+		}
+
+		var holder multi.ItemHolder
+		if err := holders.Session(&gorm.Session{}).Limit(1).Find(&holder).Error; err != nil {
+			return false, errors.Wrap(err, "failed on get holder balance")
+		}
+		if holder.Id > 0 && holder.Balance.IsPositive() {
+			fromCleared = false
+		} else if err := holders.Session(&gorm.Session{}).Delete(&multi.ItemHolder{}).Error; err != nil {
+			return false, errors.Wrap(err, "failed on delete item holder")
+		}
+	}
+
+	if to != ZeroAddress {
+		if err := tx.Table(multi.ItemHolderTableName(s.chain)).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "collection_address"}, {Name: "token_id"}, {Name: "owner"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"balance":     gorm.Expr("balance + ?", amount),
+				"update_time": time.Now().UnixMilli(),
+			}),
+		}).Create(&multi.ItemHolder{
+			CollectionAddress: collection,
+			TokenId:           tokenID,
+			Owner:             to,
+			Balance:           amount,
+		}).Error; err != nil {
+			return false, errors.Wrap(err, "failed on increase holder balance")
+		}
+	}
+
+	// 只有一个持有人时该持有人即为owner，多个持有人或全部销毁时清空owner
+	var owners []string
+	if err := tx.Table(multi.ItemHolderTableName(s.chain)).
+		Where("collection_address = ? and token_id = ? and balance > 0", collection, tokenID).
+		Limit(2).Pluck("owner", &owners).Error; err != nil {
+		return false, errors.Wrap(err, "failed on get item holders")
+	}
+	owner := ""
+	if len(owners) == 1 {
+		owner = owners[0]
+	}
+	if err := tx.Table(multi.ItemTableName(s.chain)).
+		Where("collection_address = ? and token_id = ?", collection, tokenID).
+		Update("owner", owner).Error; err != nil {
+		return false, errors.Wrap(err, "failed to update item owner")
+	}
+
+	return fromCleared, nil
+}
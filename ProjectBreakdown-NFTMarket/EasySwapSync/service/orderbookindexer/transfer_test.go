@@ -0,0 +1,60 @@
+package orderbookindexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/shopspring/decimal"
+)
+
+func TestApplyErc1155TransferOwner(t *testing.T) {
+	db := newTestDB(t, "optimism")
+	s := &Service{ctx: context.Background(), db: db, chain: "optimism", chainId: 10}
+	collection, tokenID := "0x0000000000000000000000000000000000000002", "7"
+	alice, bob := "0x000000000000000000000000000000000000000a", "0x000000000000000000000000000000000000000b"
+
+	transfer := func(from, to string, amount int64) bool {
+		fromCleared, err := s.applyErc1155Transfer(db, collection, tokenID, from, to, decimal.NewFromInt(amount))
+		if err != nil {
+			t.Fatalf("failed on apply transfer %s -> %s: %v", from, to, err)
+		}
+		return fromCleared
+	}
+	item := func() multi.Item {
+		var item multi.Item
+		if err := db.Table(multi.ItemTableName("optimism")).
+			Where("collection_address = ? and token_id = ?", collection, tokenID).Take(&item).Error; err != nil {
+			t.Fatal(err)
+		}
+		return item
+	}
+
+	// 铸造后唯一持有人即为owner
+	transfer(ZeroAddress, alice, 5)
+	if got := item(); got.Owner != alice || got.Supply != 5 {
+		t.Errorf("expected owner %s with supply 5, got %s/%d", alice, got.Owner, got.Supply)
+	}
+
+	// 部分转出后有多个持有人，清空owner
+	if transfer(alice, bob, 2) {
+		t.Error("expected alice to keep holding the token")
+	}
+	if got := item(); got.Owner != "" || got.Supply != 5 {
+		t.Errorf("expected no owner with supply 5, got %s/%d", got.Owner, got.Supply)
+	}
+
+	// 剩余数量全部转出后只剩一个持有人
+	if !transfer(alice, bob, 3) {
+		t.Error("expected alice to be cleared")
+	}
+	if got := item(); got.Owner != bob {
+		t.Errorf("expected owner %s, got %s", bob, got.Owner)
+	}
+
+	// 全部销毁后没有持有人
+	transfer(bob, ZeroAddress, 5)
+	if got := item(); got.Owner != "" || got.Supply != 0 {
+		t.Errorf("expected no owner with supply 0, got %s/%d", got.Owner, got.Supply)
+	}
+}
@@ -3,37 +3,40 @@ package service
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/ProjectsTask/EasySwapBase/chain"
-	"github.com/ProjectsTask/EasySwapBase/chain/chainclient"
-	"github.com/ProjectsTask/EasySwapBase/ordermanager"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
 	"github.com/ProjectsTask/EasySwapBase/stores/xkv"
 	"github.com/pkg/errors"
 	"github.com/zeromicro/go-zero/core/stores/cache"
 	"github.com/zeromicro/go-zero/core/stores/kv"
 	"github.com/zeromicro/go-zero/core/stores/redis"
+	"github.com/zeromicro/go-zero/core/threading"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 
-	"github.com/ProjectsTask/EasySwapSync/service/orderbookindexer"
-
 	"github.com/ProjectsTask/EasySwapSync/model"
-	"github.com/ProjectsTask/EasySwapSync/service/collectionfilter"
 	"github.com/ProjectsTask/EasySwapSync/service/config"
 )
 
+const (
+	StatusReportInterval = 60 // in seconds
+	StopTimeout          = 30 // in seconds
+)
+
 type Service struct {
-	ctx              context.Context
-	config           *config.Config
-	kvStore          *xkv.Store
-	db               *gorm.DB
-	wg               *sync.WaitGroup
-	collectionFilter *collectionfilter.Filter
-	orderbookIndexer *orderbookindexer.Service
-	orderManager     *ordermanager.OrderManager
+	ctx     context.Context
+	config  *config.Config
+	kvStore *xkv.Store
+	db      *gorm.DB
+	wg      *sync.WaitGroup
+	chains  []*chainService
 }
 
-func New(ctx context.Context, cfg *config.Config) (*Service, error) {
+// NewKvStore 根据配置创建redis存储
+func NewKvStore(cfg *config.Config) *xkv.Store {
 	var kvConf kv.KvConf
 	for _, con := range cfg.Kv.Redis {
 		kvConf = append(kvConf, cache.NodeConf{
@@ -46,48 +49,94 @@ func New(ctx context.Context, cfg *config.Config) (*Service, error) {
 		})
 	}
 
-	kvStore := xkv.NewStore(kvConf)
-
-	var err error
-	db := model.NewDB(cfg.DB)
-	collectionFilter := collectionfilter.New(ctx, db, cfg.ChainCfg.Name, cfg.ProjectCfg.Name)
-	orderManager := ordermanager.New(ctx, db, kvStore, cfg.ChainCfg.Name, cfg.ProjectCfg.Name)
-	var orderbookSyncer *orderbookindexer.Service
-	var chainClient chainclient.ChainClient
-	fmt.Println("chainClient url:" + cfg.AnkrCfg.HttpsUrl + cfg.AnkrCfg.ApiKey)
+	return xkv.NewStore(kvConf)
+}
 
-	chainClient, err = chainclient.New(int(cfg.ChainCfg.ID), cfg.AnkrCfg.HttpsUrl+cfg.AnkrCfg.ApiKey)
+func New(ctx context.Context, cfg *config.Config) (*Service, error) {
+	chainCfgs, err := cfg.ChainConfigs()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed on create evm client")
+		return nil, errors.Wrap(err, "failed on parse chain configs")
 	}
 
-	switch cfg.ChainCfg.ID {
-	case chain.EthChainID, chain.OptimismChainID, chain.SepoliaChainID:
-		orderbookSyncer = orderbookindexer.New(ctx, cfg, db, kvStore, chainClient, cfg.ChainCfg.ID, cfg.ChainCfg.Name, orderManager)
-	}
-	if err != nil {
-		return nil, errors.Wrap(err, "failed on create trade info server")
+	kvStore := NewKvStore(cfg)
+	db := model.NewDB(cfg.DB)
+	var chains []*chainService
+	for _, chainCfg := range chainCfgs {
+		chains = append(chains, newChainService(ctx, chainCfg, db, kvStore))
 	}
+
 	manager := Service{
-		ctx:              ctx,
-		config:           cfg,
-		db:               db,
-		kvStore:          kvStore,
-		collectionFilter: collectionFilter,
-		orderbookIndexer: orderbookSyncer,
-		orderManager:     orderManager,
-		wg:               &sync.WaitGroup{},
+		ctx:     ctx,
+		config:  cfg,
+		db:      db,
+		kvStore: kvStore,
+		chains:  chains,
+		wg:      &sync.WaitGroup{},
 	}
 	return &manager, nil
 }
 
+// Start 为每条链启动独立的同步流程，单条链失败不影响其他链
 func (s *Service) Start() error {
-	// 不要移动位置
-	if err := s.collectionFilter.PreloadCollections(); err != nil {
-		return errors.Wrap(err, "failed on preload collection to filter")
+	for _, c := range s.chains {
+		threading.GoSafe(c.run)
 	}
+	threading.GoSafe(s.ReportChainStatusLoop)
+	return nil
+}
+
+// Stop 并行停止各条链的索引及订单管理流程，等待处理中的数据写入完成，超过timeout返回错误
+func (s *Service) Stop(timeout time.Duration) error {
+	var mu sync.Mutex
+	var errs []string
+	wg := &sync.WaitGroup{}
+	for _, c := range s.chains {
+		wg.Add(1)
+		go func(c *chainService) {
+			defer wg.Done()
+			if err := c.stop(timeout); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", c.cfg.ChainCfg.Name, err))
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
 
-	s.orderbookIndexer.Start()
-	s.orderManager.Start()
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
 	return nil
 }
+
+// ChainStatuses 返回所有链的同步状态
+func (s *Service) ChainStatuses() []ChainStatus {
+	statuses := make([]ChainStatus, 0, len(s.chains))
+	for _, c := range s.chains {
+		statuses = append(statuses, c.Status())
+	}
+
+	return statuses
+}
+
+// ReportChainStatusLoop 定期输出各条链的同步状态
+func (s *Service) ReportChainStatusLoop() {
+	ticker := time.NewTicker(StatusReportInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			for _, status := range s.ChainStatuses() {
+				xzap.WithContext(s.ctx).Info("chain sync status",
+					zap.String("chain", status.Name),
+					zap.String("state", status.State),
+					zap.Uint64("head_block", status.HeadBlock),
+					zap.Uint64("indexed_block", status.IndexedBlock),
+					zap.Uint64("transfer_indexed_block", status.TransferIndexedBlock),
+					zap.String("error", status.Error))
+			}
+		}
+	}
+}
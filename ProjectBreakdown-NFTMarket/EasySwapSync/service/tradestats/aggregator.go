@@ -0,0 +1,205 @@
+package tradestats
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProjectsTask/EasySwapBase/currency"
+	"github.com/ProjectsTask/EasySwapBase/logger/xzap"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/zeromicro/go-zero/core/threading"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const RefreshInterval = 60 // in seconds
+
+// Aggregator 维护ob_collection_trade中每个collection各统计窗口的成交统计。
+// 有新成交时标记该collection，同步事务提交后再重新计算，另外定期刷新近期有成交的collection，使过期的成交移出窗口
+type Aggregator struct {
+	ctx         context.Context
+	db          *gorm.DB
+	chain       string
+	priceSource currency.PriceSource
+
+	mu    sync.Mutex
+	dirty map[string]int64 // 有新成交的collection -> 最新成交的事件时间
+}
+
+func New(ctx context.Context, db *gorm.DB, chain string, priceSource currency.PriceSource) *Aggregator {
+	return &Aggregator{
+		ctx:         ctx,
+		db:          db,
+		chain:       chain,
+		priceSource: priceSource,
+		dirty:       make(map[string]int64),
+	}
+}
+
+func (a *Aggregator) Start() {
+	threading.GoSafe(a.refreshLoop)
+}
+
+func (a *Aggregator) refreshLoop() {
+	ticker := time.NewTicker(RefreshInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			xzap.WithContext(a.ctx).Info("trade stats refreshLoop stopped due to context cancellation")
+			return
+		case <-ticker.C:
+		}
+
+		if err := a.RefreshAll(time.Now().Unix()); err != nil {
+			xzap.WithContext(a.ctx).Error("failed on refresh collection trade stats", zap.String("chain", a.chain), zap.Error(err))
+		}
+	}
+}
+
+// RefreshAll 刷新上一个最长窗口以来有成交，或统计仍不为0的collection
+func (a *Aggregator) RefreshAll(now int64) error {
+	longest := multi.TradeWindows[len(multi.TradeWindows)-1].Seconds()
+	var active []string
+	if err := a.db.WithContext(a.ctx).Table(multi.ActivityTableName(a.chain)).
+		Where("activity_type = ? and event_time >= ?", multi.Sale, now-2*longest).
+		Distinct("collection_address").
+		Pluck("collection_address", &active).Error; err != nil {
+		return errors.Wrap(err, "failed on get collections with sales")
+	}
+	var stale []string
+	if err := a.db.WithContext(a.ctx).Table(multi.CollectionTradeTableName(a.chain)).
+		Where("sale_count > 0 or prev_volume > 0").
+		Distinct("collection_address").
+		Pluck("collection_address", &stale).Error; err != nil {
+		return errors.Wrap(err, "failed on get collections with trade stats")
+	}
+
+	set := make(map[string]struct{})
+	for _, collection := range append(active, stale...) {
+		collection = strings.ToLower(collection)
+		if _, ok := set[collection]; ok {
+			continue
+		}
+		set[collection] = struct{}{}
+		if err := a.UpdateCollection(a.db.WithContext(a.ctx), collection, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarkDirty 标记collection在eventTime有新成交，由RefreshDirty在同步事务外重新计算，避免在事务内扫描成交记录
+func (a *Aggregator) MarkDirty(collection string, eventTime int64) {
+	collection = strings.ToLower(collection)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if eventTime > a.dirty[collection] {
+		a.dirty[collection] = eventTime
+	}
+}
+
+// RefreshDirty 以最新成交的事件时间为截止时间重新计算被标记的collection，失败的collection保留标记等待下次刷新
+func (a *Aggregator) RefreshDirty() error {
+	a.mu.Lock()
+	dirty := a.dirty
+	a.dirty = make(map[string]int64)
+	a.mu.Unlock()
+
+	var firstErr error
+	for collection, eventTime := range dirty {
+		if err := a.UpdateCollection(a.db.WithContext(a.ctx), collection, eventTime); err != nil {
+			a.MarkDirty(collection, eventTime)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// windowStats 单个时间段内的成交统计
+type windowStats struct {
+	SaleCount   int64
+	ItemCount   int64
+	SellerCount int64
+	BuyerCount  int64
+	Volume      decimal.Decimal
+	AvgPrice    decimal.Decimal
+	MinPrice    decimal.Decimal
+	MaxPrice    decimal.Decimal
+}
+
+// UpdateCollection 重新计算collection在所有统计窗口截至now的成交统计
+func (a *Aggregator) UpdateCollection(tx *gorm.DB, collection string, now int64) error {
+	collection = strings.ToLower(collection)
+	for _, window := range multi.TradeWindows {
+		current, err := a.windowStats(tx, collection, now-window.Seconds(), now)
+		if err != nil {
+			return errors.Wrapf(err, "failed on get %s trade stats", window.Period)
+		}
+		prev, err := a.windowStats(tx, collection, now-2*window.Seconds(), now-window.Seconds())
+		if err != nil {
+			return errors.Wrapf(err, "failed on get previous %s trade stats", window.Period)
+		}
+
+		trade := multi.CollectionTrade{
+			EpochNumber:       window.EpochNumber,
+			CollectionAddress: collection,
+			ItemCount:         current.ItemCount,
+			Volume:            current.Volume,
+			SaleCount:         current.SaleCount,
+			BuyerCount:        current.BuyerCount,
+			SellerCount:       current.SellerCount,
+			AvgPrice:          current.AvgPrice.Truncate(0),
+			MinPrice:          current.MinPrice,
+			MaxPrice:          current.MaxPrice,
+			PrevVolume:        prev.Volume,
+			PrevMinPrice:      prev.MinPrice,
+		}
+		if err := tx.Table(multi.CollectionTradeTableName(a.chain)).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "collection_address"}, {Name: "epoch_number"}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"item_count", "volume", "sale_count", "buyer_count", "seller_count",
+				"avg_price", "min_price", "max_price", "prev_volume", "prev_min_price", "update_time",
+			}),
+		}).Create(&trade).Error; err != nil {
+			return errors.Wrapf(err, "failed on save %s trade stats", window.Period)
+		}
+	}
+
+	return nil
+}
+
+// windowStats 统计(from, to]内的成交，价格换算为ETH，未配置汇率的币种只计入笔数。
+// 成交活动的maker/taker为卖方/买方，卖家数/买家数分别按maker/taker去重统计
+func (a *Aggregator) windowStats(tx *gorm.DB, collection string, from, to int64) (*windowStats, error) {
+	ethPrice, ethPriceArgs := currency.EthPriceExpr(a.priceSource, "price", "currency_address")
+	var args []interface{}
+	for i := 0; i < 4; i++ {
+		args = append(args, ethPriceArgs...)
+	}
+
+	var stats windowStats
+	if err := tx.Table(multi.ActivityTableName(a.chain)).
+		Select(fmt.Sprintf("COUNT(*) as sale_count, COALESCE(SUM(quantity), 0) as item_count, "+
+			"COUNT(DISTINCT maker) as seller_count, COUNT(DISTINCT taker) as buyer_count, "+
+			"COALESCE(SUM((%s) * quantity), 0) as volume, COALESCE(AVG(%s), 0) as avg_price, "+
+			"COALESCE(MIN(%s), 0) as min_price, COALESCE(MAX(%s), 0) as max_price",
+			ethPrice, ethPrice, ethPrice, ethPrice), args...).
+		Where("collection_address = ? and activity_type = ? and event_time > ? and event_time <= ?",
+			collection, multi.Sale, from, to).
+		Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
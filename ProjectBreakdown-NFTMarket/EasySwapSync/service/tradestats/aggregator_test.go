@@ -0,0 +1,174 @@
+package tradestats
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ProjectsTask/EasySwapBase/currency"
+	"github.com/ProjectsTask/EasySwapBase/stores/gdb/orderbookmodel/multi"
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testChain      = "optimism"
+	testCollection = "0x0000000000000000000000000000000000000002"
+	testWeth       = "0x4200000000000000000000000000000000000006"
+)
+
+func newTestAggregator(t *testing.T) (*Aggregator, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := map[string]interface{}{
+		multi.ActivityTableName(testChain):        &multi.Activity{},
+		multi.CollectionTradeTableName(testChain): &multi.CollectionTrade{},
+	}
+	for table, model := range tables {
+		// sqlite不识别MySQL风格的AUTO_INCREMENT标签，建表前将自增主键设为sqlite的自增主键类型
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.ParseWithSpecialTableName(model, table); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range stmt.Schema.PrimaryFields {
+			if field.AutoIncrement {
+				field.DataType = "integer PRIMARY KEY AUTOINCREMENT"
+			}
+		}
+		if err := db.Table(table).AutoMigrate(model); err != nil {
+			t.Fatalf("failed on migrate %s: %v", table, err)
+		}
+	}
+	if err := db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX uk_collection_epoch ON %s (collection_address, epoch_number)",
+		multi.CollectionTradeTableName(testChain))).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	priceSource, err := currency.NewStaticPriceSource([]*currency.Config{{Address: testWeth, Symbol: "WETH", Decimals: 18, EthRate: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(context.Background(), db, testChain, priceSource), db
+}
+
+type testSale struct {
+	maker     string
+	taker     string
+	currency  string
+	price     int64
+	quantity  int64
+	eventTime int64
+}
+
+func createSales(t *testing.T, db *gorm.DB, sales []testSale) {
+	for i, sale := range sales {
+		if sale.currency == "" {
+			sale.currency = testWeth
+		}
+		if err := db.Table(multi.ActivityTableName(testChain)).Create(&multi.Activity{
+			ActivityType:      multi.Sale,
+			Maker:             sale.maker,
+			Taker:             sale.taker,
+			CollectionAddress: testCollection,
+			CurrencyAddress:   sale.currency,
+			Price:             decimal.NewFromInt(sale.price),
+			Quantity:          sale.quantity,
+			TxHash:            fmt.Sprintf("0x%d", i),
+			EventTime:         sale.eventTime,
+		}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func getTrade(t *testing.T, db *gorm.DB, window multi.TradeWindow) multi.CollectionTrade {
+	var trade multi.CollectionTrade
+	if err := db.Table(multi.CollectionTradeTableName(testChain)).
+		Where("collection_address = ? and epoch_number = ?", testCollection, window.EpochNumber).
+		Take(&trade).Error; err != nil {
+		t.Fatalf("failed on get %s trade stats: %v", window.Period, err)
+	}
+
+	return trade
+}
+
+func TestUpdateCollectionWindows(t *testing.T) {
+	a, db := newTestAggregator(t)
+	window := multi.TradeWindows[0] // 15m
+	now := int64(1_000_000)
+	createSales(t, db, []testSale{
+		{maker: "0xa", taker: "0xb", price: 10, quantity: 1, eventTime: now},                         // 窗口右边界，计入
+		{maker: "0xa", taker: "0xc", price: 30, quantity: 2, eventTime: now - window.Seconds() + 1},  // 窗口内
+		{maker: "0xd", taker: "0xb", price: 100, quantity: 1, eventTime: now - window.Seconds()},     // 窗口左边界，计入上一窗口
+		{maker: "0xd", taker: "0xb", price: 7, quantity: 1, eventTime: now - 2*window.Seconds() + 1}, // 上一窗口
+		{maker: "0xd", taker: "0xb", price: 1, quantity: 1, eventTime: now - 2*window.Seconds()},     // 上一窗口左边界，不计入
+		{maker: "0xe", taker: "0xf", price: 5, quantity: 1, eventTime: now + 1},                      // 截止时间之后
+		{maker: "0xe", taker: "0xf", currency: "0x1", price: 1000, quantity: 1, eventTime: now - 1},  // 未配置汇率的币种
+	})
+
+	if err := a.UpdateCollection(db, testCollection, now); err != nil {
+		t.Fatal(err)
+	}
+
+	trade := getTrade(t, db, window)
+	if trade.SaleCount != 3 || trade.ItemCount != 4 {
+		t.Errorf("unexpected sale/item count: %d/%d", trade.SaleCount, trade.ItemCount)
+	}
+	if trade.SellerCount != 2 || trade.BuyerCount != 3 {
+		t.Errorf("unexpected seller/buyer count: %d/%d", trade.SellerCount, trade.BuyerCount)
+	}
+	// 成交额按数量加权并按汇率换算为ETH: (10*1 + 30*2) * 2
+	if !trade.Volume.Equal(decimal.NewFromInt(140)) {
+		t.Errorf("unexpected volume: %s", trade.Volume)
+	}
+	if !trade.MinPrice.Equal(decimal.NewFromInt(20)) || !trade.MaxPrice.Equal(decimal.NewFromInt(60)) {
+		t.Errorf("unexpected min/max price: %s/%s", trade.MinPrice, trade.MaxPrice)
+	}
+	if !trade.PrevVolume.Equal(decimal.NewFromInt(214)) || !trade.PrevMinPrice.Equal(decimal.NewFromInt(14)) {
+		t.Errorf("unexpected previous volume/min price: %s/%s", trade.PrevVolume, trade.PrevMinPrice)
+	}
+
+	// 较长的窗口包含所有截止时间之前的成交
+	trade = getTrade(t, db, multi.TradeWindows[1])
+	if trade.SaleCount != 6 || !trade.PrevVolume.IsZero() {
+		t.Errorf("unexpected 1h trade stats: %+v", trade)
+	}
+}
+
+func TestRefreshDirty(t *testing.T) {
+	a, db := newTestAggregator(t)
+	window := multi.TradeWindows[0]
+	eventTime := int64(1_000_000)
+	createSales(t, db, []testSale{
+		{maker: "0xa", taker: "0xb", price: 10, quantity: 1, eventTime: eventTime - window.Seconds()},
+		{maker: "0xa", taker: "0xb", price: 20, quantity: 1, eventTime: eventTime},
+	})
+
+	// 以最新成交的事件时间为截止时间
+	a.MarkDirty(testCollection, eventTime-window.Seconds())
+	a.MarkDirty(testCollection, eventTime)
+	if err := a.RefreshDirty(); err != nil {
+		t.Fatal(err)
+	}
+	trade := getTrade(t, db, window)
+	if trade.SaleCount != 1 || !trade.Volume.Equal(decimal.NewFromInt(40)) || !trade.PrevVolume.Equal(decimal.NewFromInt(20)) {
+		t.Errorf("unexpected trade stats: %+v", trade)
+	}
+	if len(a.dirty) != 0 {
+		t.Errorf("expected dirty collections to be drained, got %v", a.dirty)
+	}
+
+	// 成交移出窗口后由定期刷新清零
+	if err := a.RefreshAll(eventTime + 2*window.Seconds()); err != nil {
+		t.Fatal(err)
+	}
+	trade = getTrade(t, db, window)
+	if trade.SaleCount != 0 || !trade.Volume.IsZero() || !trade.PrevVolume.IsZero() {
+		t.Errorf("expected expired trade stats, got %+v", trade)
+	}
+}